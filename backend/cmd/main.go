@@ -1,7 +1,7 @@
 package main
 
 import (
-	// "context"
+	"context"
 	"log"
 	"os"
 	"strings"
@@ -9,14 +9,18 @@ import (
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/roblieblang/luthien/backend/internal/account"
+	"github.com/roblieblang/luthien/backend/internal/apikey"
 	"github.com/roblieblang/luthien/backend/internal/auth/auth0"
 	"github.com/roblieblang/luthien/backend/internal/auth/openai"
 	"github.com/roblieblang/luthien/backend/internal/auth/spotify"
 	"github.com/roblieblang/luthien/backend/internal/auth/youtube"
 	"github.com/roblieblang/luthien/backend/internal/config"
-
-	// "github.com/roblieblang/luthien/backend/internal/user"
+	"github.com/roblieblang/luthien/backend/internal/conversion"
+	"github.com/roblieblang/luthien/backend/internal/middleware"
+	"github.com/roblieblang/luthien/backend/internal/user"
 	"github.com/roblieblang/luthien/backend/internal/utils"
+	"go.mongodb.org/mongo-driver/mongo"
 	// "gopkg.in/natefinch/lumberjack.v2"
 )
 
@@ -44,20 +48,37 @@ func main() {
 
     redisClient := config.NewRedisClient(envConfig.RedisAddr, "", 0)
 
-    // mongoClient:= config.DBConnect(envConfig.MongoURI)
-    // defer func() {
-    //     if err := mongoClient.Disconnect(context.Background()); err != nil {
-    //         log.Fatalf("Failed to disconnect MongoDB client: %v", err)
-    //     }
-    // }()
+    // The user store is optional while it is being rolled out
+    var mongoClient *mongo.Client
+    if envConfig.MongoURI != "" {
+        mongoClient = config.DBConnect(envConfig.MongoURI)
+        defer func() {
+            if err := mongoClient.Disconnect(context.Background()); err != nil {
+                log.Printf("Failed to disconnect MongoDB client: %v", err)
+            }
+        }()
+    } else {
+        log.Printf("MONGO_URI is not set; user profiles will not be stored")
+    }
+
+    // Runs the server in degraded mode, serving caches from memory, for as long as Redis is unreachable
+    storage := utils.NewStorageMonitor(redisClient)
+    go storage.Run(context.Background(), 5*time.Second)
 
     appCtx := &utils.AppContext{
         EnvConfig:   envConfig,
         RedisClient: redisClient,
-        // MongoClient: mongoClient,
+        Storage:     storage,
+        Cache:       utils.NewCache(redisClient, storage),
+        Tokens:      config.NewTokenStore(envConfig, redisClient, storage),
+        MongoClient: mongoClient,
     }
 
     router := gin.Default()
+    // Requests without a verified user are rate limited by client IP, which must not be taken from headers anyone can send
+    if err := middleware.ConfigureClientIP(router, os.Getenv("TRUSTED_PROXIES"), os.Getenv("TRUSTED_PLATFORM")); err != nil {
+        log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+    }
 
     router.Use(LoggerMiddleware())
 
@@ -83,46 +104,119 @@ func main() {
 
     router.Use(cors.New(cors.Config{
         AllowOrigins:     validOrigins,
-        AllowMethods:     []string{"GET", "POST", "DELETE", "PATCH"},
+        AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "PATCH"},
         AllowHeaders:     []string{"Content-Type", "Authorization"},
+        ExposeHeaders:    []string{"Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"},
         AllowCredentials: true,
     }))
 
-
-    // User setup (currently disabled)
-    // userDAO := user.NewDAO(appCtx.MongoClient, appCtx.EnvConfig.DatabaseName, "users")
-    // userService := user.NewUserService(userDAO)
-    // userHandler := user.NewUserHandler(userService)
-
-    // User data endpoints (currently disabled)
-    // router.POST("/users", userHandler.CreateUser)
-    // router.GET("/users", userHandler.GetAllUsers)
-    // router.GET("/users/:id", userHandler.GetUser)
-
     // Auth0 setup
     auth0Client := auth0.NewAuth0Client(appCtx)
     auth0Service := auth0.NewAuth0Service(auth0Client, appCtx)
 
+    // Personal API keys and Auth0 session tokens authenticate through the same middleware that resolves the user for browser requests
+    apiKeyService := apikey.NewAPIKeyService(appCtx)
+    apiKeyHandler := apikey.NewAPIKeyHandler(apiKeyService)
+
+    router.Use(middleware.ResolveUser(apiKeyService, auth0Service))
+
+    // Scopes required of API key requests. Browser requests are not restricted.
+    readPlaylists := middleware.RequireScope(apikey.ScopeReadPlaylists)
+    writePlaylists := middleware.RequireScope(apikey.ScopeWritePlaylists)
+    convert := middleware.RequireScope(apikey.ScopeConvert)
+    sessionOnly := middleware.RejectAPIKeys()
+    // Account management acts on whoever is signed in, so the user must be verified from an Auth0 session token
+    requireSession := middleware.RequireSession()
+
+    rateLimits := middleware.RedisBucketStore{RedisClient: redisClient}
+    // Rate limits per route group. Each can be overridden with RATE_LIMIT_<GROUP>_USER / RATE_LIMIT_<GROUP>_GLOBAL, e.g. "30/m"
+    authLimiter := middleware.RateLimiter(rateLimits, middleware.LoadRateLimitConfig(middleware.RateLimitConfig{
+        Group: "auth",
+        PerUser: middleware.Rate{Requests: 30, Period: time.Minute},
+    }))
+    spotifyLimiter := middleware.RateLimiter(rateLimits, middleware.LoadRateLimitConfig(middleware.RateLimitConfig{
+        Group: "spotify",
+        PerUser: middleware.Rate{Requests: 120, Period: time.Minute},
+    }))
+    youTubeLimiter := middleware.RateLimiter(rateLimits, middleware.LoadRateLimitConfig(middleware.RateLimitConfig{
+        Group: "youtube",
+        PerUser: middleware.Rate{Requests: 120, Period: time.Minute},
+    }))
+    // A YouTube search costs 100 of the project's 10,000 daily quota units
+    youTubeSearchLimiter := middleware.RateLimiter(rateLimits, middleware.LoadRateLimitConfig(middleware.RateLimitConfig{
+        Group: "youtube-search",
+        PerUser: middleware.Rate{Requests: 30, Period: time.Minute},
+        Global: middleware.Rate{Requests: 600, Period: time.Hour},
+    }))
+    openAILimiter := middleware.RateLimiter(rateLimits, middleware.LoadRateLimitConfig(middleware.RateLimitConfig{
+        Group: "openai",
+        PerUser: middleware.Rate{Requests: 10, Period: time.Minute},
+        Global: middleware.Rate{Requests: 300, Period: time.Hour},
+    }))
+    // A conversion searches the destination once per source track
+    conversionLimiter := middleware.RateLimiter(rateLimits, middleware.LoadRateLimitConfig(middleware.RateLimitConfig{
+        Group: "conversions",
+        PerUser: middleware.Rate{Requests: 10, Period: time.Hour},
+    }))
+
+    // Request deadlines per route group. Each can be overridden with REQUEST_TIMEOUT_<GROUP>, e.g. "45s"
+    authTimeout := middleware.Timeout(middleware.LoadTimeout("auth", 15*time.Second))
+    spotifyTimeout := middleware.Timeout(middleware.LoadTimeout("spotify", 30*time.Second))
+    spotifyWriteTimeout := middleware.Timeout(middleware.LoadTimeout("spotify-write", 2*time.Minute))
+    youTubeTimeout := middleware.Timeout(middleware.LoadTimeout("youtube", 30*time.Second))
+    // Videos are inserted into YouTube playlists one request at a time
+    youTubeWriteTimeout := middleware.Timeout(middleware.LoadTimeout("youtube-write", 5*time.Minute))
+    openAITimeout := middleware.Timeout(middleware.LoadTimeout("openai", time.Minute))
+    conversionTimeout := middleware.Timeout(middleware.LoadTimeout("conversions", 10*time.Minute))
+
+
+    // User setup
+    var userService *user.UserService
+    if appCtx.MongoClient != nil {
+        userDAO := user.NewDAO(appCtx.MongoClient, appCtx.EnvConfig.DatabaseName, "users")
+        indexCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        if err := userDAO.EnsureIndexes(indexCtx); err != nil {
+            log.Printf("Failed to create user indexes: %v", err)
+        }
+        cancel()
+        userService = user.NewUserService(userDAO, auth0Service, appCtx)
+        auth0Service.RegisterMetadataMirror(userService)
+        userHandler := user.NewUserHandler(userService)
+
+        // User data endpoints
+        userRoutes := router.Group("/users/me", sessionOnly, authTimeout, authLimiter)
+        userRoutes.GET("", userHandler.GetCurrentUserHandler)
+        userRoutes.POST("/sync", userHandler.SyncCurrentUserHandler)
+        userRoutes.PUT("/preferences", userHandler.UpdatePreferencesHandler)
+    }
+
     // Spotify setup
     spotifyClient := spotify.NewSpotifyClient(appCtx)
     spotifyService := spotify.NewSpotifyService(spotifyClient, auth0Service, appCtx)
     spotifyHandler := spotify.NewSpotifyHandler(spotifyService)
 
     // Spotify authentication endpoints
-    router.GET("/auth/spotify/login", spotifyHandler.LoginHandler)
-    router.POST("/auth/spotify/callback", spotifyHandler.CallbackHandler)
-    router.POST("/auth/spotify/logout", spotifyHandler.LogoutHandler)
-    router.GET("/auth/spotify/check-auth", spotifyHandler.CheckAuthHandler)
+    spotifyAuthRoutes := router.Group("/auth/spotify", sessionOnly, authTimeout, authLimiter)
+    spotifyAuthRoutes.GET("/login", spotifyHandler.LoginHandler)
+    spotifyAuthRoutes.POST("/callback", spotifyHandler.CallbackHandler)
+    spotifyAuthRoutes.POST("/logout", spotifyHandler.LogoutHandler)
+    spotifyAuthRoutes.GET("/check-auth", spotifyHandler.CheckAuthHandler)
+    spotifyAuthRoutes.GET("/accounts", spotifyHandler.ListLinkedAccountsHandler)
+    spotifyAuthRoutes.POST("/accounts/default", spotifyHandler.SetDefaultAccountHandler)
 
     // Spotify user data endpoints
-    router.GET("/spotify/current-profile", spotifyHandler.GetCurrentUserProfileHandler)
-    router.GET("/spotify/current-user-playlists", spotifyHandler.GetCurrentUserPlaylistsHandler)
-    router.GET("/spotify/playlist-tracks", spotifyHandler.GetPlaylistTracksHandler)
-    router.POST("/spotify/create-playlist", spotifyHandler.CreatePlaylistHandler)
-    router.POST("/spotify/add-items-to-playlist", spotifyHandler.AddItemsToPlaylistHandler)
-    router.GET("/spotify/search-for-track", spotifyHandler.SearchTracksUsingArtistAndTrackhandler)
-    router.GET("/spotify/search-using-video", spotifyHandler.SearchTracksUsingVideoTitleHandler)
-    router.DELETE("/spotify/delete-playlist", spotifyHandler.DeletePlaylistHandler)
+    spotifyRoutes := router.Group("/spotify", spotifyTimeout, spotifyLimiter)
+    spotifyRoutes.GET("/current-profile", readPlaylists, spotifyHandler.GetCurrentUserProfileHandler)
+    spotifyRoutes.GET("/current-user-playlists", readPlaylists, spotifyHandler.GetCurrentUserPlaylistsHandler)
+    spotifyRoutes.GET("/playlist-tracks", readPlaylists, spotifyHandler.GetPlaylistTracksHandler)
+    spotifyRoutes.POST("/create-playlist", writePlaylists, spotifyHandler.CreatePlaylistHandler)
+    spotifyRoutes.POST("/add-items-to-playlist", writePlaylists, spotifyWriteTimeout, spotifyHandler.AddItemsToPlaylistHandler)
+    spotifyRoutes.PUT("/update-playlist", writePlaylists, spotifyHandler.UpdatePlaylistHandler)
+    spotifyRoutes.POST("/remove-items-from-playlist", writePlaylists, spotifyWriteTimeout, spotifyHandler.RemovePlaylistItemsHandler)
+    spotifyRoutes.PUT("/reorder-playlist-items", writePlaylists, spotifyHandler.ReorderPlaylistItemsHandler)
+    spotifyRoutes.GET("/search-for-track", convert, spotifyHandler.SearchTracksUsingArtistAndTrackhandler)
+    spotifyRoutes.GET("/search-using-video", convert, spotifyHandler.SearchTracksUsingVideoTitleHandler)
+    spotifyRoutes.DELETE("/delete-playlist", writePlaylists, spotifyHandler.DeletePlaylistHandler)
 
     // YouTube setup
     youTubeClient := youtube.NewYouTubeClient(appCtx)
@@ -130,18 +224,28 @@ func main() {
     youTubeHandler := youtube.NewYouTubeHandler(youTubeService)
 
     // Google authentication endpoints
-    router.GET("/auth/google/login", youTubeHandler.LoginHandler)
-    router.POST("/auth/google/callback", youTubeHandler.CallbackHandler)
-    router.POST("/auth/google/logout", youTubeHandler.LogoutHandler)
-    router.GET("/auth/google/check-auth", youTubeHandler.CheckAuthHandler)
+    googleAuthRoutes := router.Group("/auth/google", sessionOnly, authTimeout, authLimiter)
+    googleAuthRoutes.GET("/login", youTubeHandler.LoginHandler)
+    googleAuthRoutes.POST("/callback", youTubeHandler.CallbackHandler)
+    googleAuthRoutes.POST("/logout", youTubeHandler.LogoutHandler)
+    googleAuthRoutes.GET("/check-auth", youTubeHandler.CheckAuthHandler)
+    googleAuthRoutes.GET("/accounts", youTubeHandler.ListLinkedAccountsHandler)
+    googleAuthRoutes.POST("/accounts/default", youTubeHandler.SetDefaultAccountHandler)
 
     // YouTube data endpoints
-    router.GET("/youtube/current-user-playlists", youTubeHandler.GetCurrentUserPlaylistsHandler)
-    router.GET("/youtube/playlist-tracks", youTubeHandler.GetPlaylistItemsHandler)
-    router.POST("/youtube/create-playlist", youTubeHandler.CreatePlaylistHandler)
-    router.POST("/youtube/add-items-to-playlist", youTubeHandler.AddItemsToPlaylistHandler)
-    router.GET("/youtube/search-for-video", youTubeHandler.SearchVideosHandler)
-    router.DELETE("/youtube/delete-playlist", youTubeHandler.DeletePlaylistHandler)
+    youTubeRoutes := router.Group("/youtube", youTubeTimeout, youTubeLimiter)
+    youTubeRoutes.GET("/current-user-playlists", readPlaylists, youTubeHandler.GetCurrentUserPlaylistsHandler)
+    youTubeRoutes.GET("/playlist-tracks", readPlaylists, youTubeHandler.GetPlaylistItemsHandler)
+    youTubeRoutes.POST("/create-playlist", writePlaylists, youTubeHandler.CreatePlaylistHandler)
+    youTubeRoutes.POST("/add-items-to-playlist", writePlaylists, youTubeWriteTimeout, youTubeHandler.AddItemsToPlaylistHandler)
+    youTubeRoutes.PUT("/update-playlist", writePlaylists, youTubeHandler.UpdatePlaylistHandler)
+    youTubeRoutes.POST("/remove-items-from-playlist", writePlaylists, youTubeWriteTimeout, youTubeHandler.RemovePlaylistItemsHandler)
+    youTubeRoutes.PUT("/move-playlist-item", writePlaylists, youTubeHandler.MovePlaylistItemHandler)
+    youTubeRoutes.GET("/search-for-video", convert, youTubeSearchLimiter, youTubeHandler.SearchVideosHandler)
+    youTubeRoutes.DELETE("/delete-playlist", writePlaylists, youTubeHandler.DeletePlaylistHandler)
+    youTubeRoutes.GET("/quota", youTubeHandler.GetQuotaHandler)
+    youTubeRoutes.GET("/videos", readPlaylists, youTubeHandler.GetVideosHandler)
+    youTubeRoutes.GET("/cache-stats", youTubeHandler.GetCacheStatsHandler)
 
     // OpenAI setup
     openAIClient := openai.NewOpenAIClient(appCtx)
@@ -149,7 +253,61 @@ func main() {
     openAIHandler := openai.NewOpenAIHandler(openAIService)
 
     // OpenAI endpoints
-    router.POST("/auth/openai/extract-artist-song", openAIHandler.ExtractArtistAndSongFromVideoTitleHandler)
+    openAIRoutes := router.Group("/auth/openai", openAITimeout, openAILimiter)
+    openAIRoutes.POST("/extract-artist-song", convert, openAIHandler.ExtractArtistAndSongFromVideoTitleHandler)
+
+    // Conversion setup. History is kept in MongoDB when it is configured and in memory otherwise.
+    var conversionStore conversion.HistoryStore = conversion.NewMemoryHistoryStore()
+    if appCtx.MongoClient != nil {
+        mongoStore := conversion.NewMongoHistoryStore(appCtx.MongoClient, appCtx.EnvConfig.DatabaseName, "conversions")
+        indexCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        if err := mongoStore.EnsureIndexes(indexCtx); err != nil {
+            log.Printf("Failed to create conversion indexes: %v", err)
+        }
+        cancel()
+        conversionStore = mongoStore
+    }
+    conversionService := conversion.NewConversionService(conversionStore, map[string]conversion.Provider{
+        "spotify": conversion.SpotifyProvider{Service: spotifyService},
+        "youtube": conversion.YouTubeProvider{Service: youTubeService},
+    })
+    conversionHandler := conversion.NewConversionHandler(conversionService)
+
+    // Conversion endpoints
+    conversionRoutes := router.Group("/conversions", convert)
+    conversionRoutes.POST("", writePlaylists, conversionTimeout, conversionLimiter, conversionHandler.ConvertHandler)
+    conversionRoutes.POST("/merge", writePlaylists, conversionTimeout, conversionLimiter, conversionHandler.MergeHandler)
+    conversionRoutes.POST("/transfer", writePlaylists, conversionTimeout, conversionLimiter, conversionHandler.TransferHandler)
+    conversionRoutes.GET("/resolve", readPlaylists, authTimeout, conversionHandler.ResolvePlaylistHandler)
+    conversionRoutes.GET("/duplicates", readPlaylists, conversionTimeout, conversionHandler.FindDuplicatesHandler)
+    conversionRoutes.POST("/duplicates/remove", writePlaylists, conversionTimeout, conversionLimiter, conversionHandler.RemoveDuplicatesHandler)
+    conversionRoutes.GET("", authTimeout, conversionHandler.ListConversionsHandler)
+    conversionRoutes.GET("/:id", authTimeout, conversionHandler.GetConversionHandler)
+    conversionRoutes.POST("/:id/undo", writePlaylists, conversionTimeout, conversionLimiter, conversionHandler.UndoConversionHandler)
+
+    // Account setup
+    // YouTube goes first: revoking its grant is the unlink that can fail and stop the deletion
+    accountService := account.NewAccountService([]account.ProviderUnlinker{youTubeService, spotifyService})
+    accountService.RegisterPurger(apiKeyService)
+    // Tokens kept outside Redis are not found by the per-user Redis key scan
+    if purger, ok := appCtx.Tokens.(account.UserDataPurger); ok {
+        accountService.RegisterPurger(purger)
+    }
+    if userService != nil {
+        accountService.RegisterPurger(userService)
+    }
+    accountService.RegisterPurger(conversionService)
+    accountService.RegisterPurger(account.RedisPurger{AppContext: appCtx})
+    accountHandler := account.NewAccountHandler(accountService)
+
+    // Account endpoints
+    router.DELETE("/me", requireSession, authLimiter, accountHandler.DeleteMeHandler)
+
+    // API key management endpoints
+    apiKeyRoutes := router.Group("/api-keys", requireSession, authTimeout, authLimiter)
+    apiKeyRoutes.POST("", apiKeyHandler.CreateKeyHandler)
+    apiKeyRoutes.GET("", apiKeyHandler.ListKeysHandler)
+    apiKeyRoutes.DELETE("/:id", apiKeyHandler.RevokeKeyHandler)
 
 
     router.GET("/", func(c *gin.Context) {
@@ -158,6 +316,20 @@ func main() {
         })
     })
 
+    // Circuit breaker state of every upstream the server has called
+    router.GET("/status/upstreams", func(c *gin.Context) {
+        c.JSON(200, gin.H{
+            "upstreams": utils.BreakerStatuses(),
+        })
+    })
+
+    // Whether the server is running in degraded mode without Redis
+    router.GET("/status/storage", func(c *gin.Context) {
+        c.JSON(200, gin.H{
+            "storage": storage.Status(),
+        })
+    })
+
     port := os.Getenv("PORT")
 	if err := router.Run(":" + port); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
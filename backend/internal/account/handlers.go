@@ -0,0 +1,45 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roblieblang/luthien/backend/internal/middleware"
+)
+
+type AccountHandler struct {
+    accountService *AccountService
+}
+
+func NewAccountHandler(accountService *AccountService) *AccountHandler {
+    return &AccountHandler{
+        accountService: accountService,
+    }
+}
+
+// Handles a request to delete everything Luthien stores about the signed-in user.
+// The user is the one verified from the session token (see middleware.RequireSession), never one named in the request.
+func (h *AccountHandler) DeleteMeHandler(c *gin.Context) {
+    userID := middleware.UserID(c)
+    if userID == "" || !middleware.Authenticated(c) {
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "session_required"})
+        return
+    }
+
+    // Deletion runs to completion even if the client disconnects, so that no partial state is left behind
+    ctx := context.WithoutCancel(c.Request.Context())
+    if err := h.accountService.DeleteUserData(ctx, userID); errors.Is(err, ErrUnlinkFailed) {
+        log.Printf("Error unlinking providers of user %s, keeping their data: %v", userID, err)
+        c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to unlink provider accounts; account deletion was stopped, please try again"})
+        return
+    } else if err != nil {
+        log.Printf("Error deleting data for user %s: %v", userID, err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user data"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "All user data deleted"})
+}
@@ -0,0 +1,85 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/roblieblang/luthien/backend/internal/utils"
+)
+
+// Returned by DeleteUserData when a provider account could not be unlinked. The user's data is kept, apart from
+// the tokens of accounts unlinked before the failure.
+var ErrUnlinkFailed = errors.New("unable to unlink provider accounts")
+
+// Unlinks provider accounts, revoking the grant where the provider supports it.
+// An empty accountID unlinks every account the user linked with that provider.
+type ProviderUnlinker interface {
+    Logout(ctx context.Context, userID, accountID string) error
+}
+
+// Removes a user's data from one datastore
+type UserDataPurger interface {
+    PurgeUserData(ctx context.Context, userID string) error
+}
+
+type AccountService struct {
+    Unlinkers  []ProviderUnlinker
+    Purgers    []UserDataPurger
+}
+
+func NewAccountService(unlinkers []ProviderUnlinker) *AccountService {
+    return &AccountService{
+        Unlinkers: unlinkers,
+    }
+}
+
+// Registers a datastore to be purged when a user deletes their data. Purgers run in the order they were registered.
+func (s *AccountService) RegisterPurger(purger UserDataPurger) {
+    s.Purgers = append(s.Purgers, purger)
+}
+
+// Removes all Luthien state for a user: provider grants, tokens, caches and history
+func (s *AccountService) DeleteUserData(ctx context.Context, userID string) error {
+    if userID == "" {
+        return fmt.Errorf("user ID is required")
+    }
+
+    // Unlink providers first so that grants are revoked while the tokens still exist. Nothing is purged if that fails,
+    // since a grant whose tokens are gone can no longer be revoked from here.
+    for _, unlinker := range s.Unlinkers {
+        if err := unlinker.Logout(ctx, userID, ""); err != nil {
+            return fmt.Errorf("%w: %v", ErrUnlinkFailed, err)
+        }
+    }
+
+    for _, purger := range s.Purgers {
+        if err := purger.PurgeUserData(ctx, userID); err != nil {
+            return fmt.Errorf("error purging user data for user %s: %v", userID, err)
+        }
+    }
+    return nil
+}
+
+// Deletes every Redis key scoped to a user. It should be registered last, since other purgers may rely on
+// per-user Redis indexes to find their data.
+type RedisPurger struct {
+    AppContext *utils.AppContext
+}
+
+// Per-user keys are named `<prefix>:<userID>` or `<prefix>:<userID>:<suffix>`
+func (p RedisPurger) PurgeUserData(ctx context.Context, userID string) error {
+    escapedID := utils.EscapeGlob(userID)
+    deleted := 0
+
+    for _, pattern := range []string{"*:" + escapedID, "*:" + escapedID + ":*"} {
+        n, err := utils.DeleteKeysMatching(ctx, *p.AppContext, pattern)
+        deleted += n
+        if err != nil {
+            return fmt.Errorf("error deleting Redis data for user %s: %v", userID, err)
+        }
+    }
+    log.Printf("Deleted %d Redis keys for user %s", deleted, userID)
+    return nil
+}
@@ -0,0 +1,110 @@
+package apikey
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roblieblang/luthien/backend/internal/middleware"
+)
+
+type APIKeyHandler struct {
+    apiKeyService *APIKeyService
+}
+
+func NewAPIKeyHandler(apiKeyService *APIKeyService) *APIKeyHandler {
+    return &APIKeyHandler{
+        apiKeyService: apiKeyService,
+    }
+}
+
+// Returns the user verified from the session token (see middleware.RequireSession), or responds 401 and returns "".
+// Keys are never managed for a user merely named in the request.
+func sessionUserID(c *gin.Context) string {
+    userID := middleware.UserID(c)
+    if userID == "" || !middleware.Authenticated(c) {
+        c.JSON(http.StatusUnauthorized, gin.H{"error": "session_required"})
+        return ""
+    }
+    return userID
+}
+
+// Creates a personal API key for the signed-in user. The raw key is only ever included in this response.
+func (h *APIKeyHandler) CreateKeyHandler(c *gin.Context) {
+    userID := sessionUserID(c)
+    if userID == "" {
+        return
+    }
+
+    var req struct {
+        Name   string   `json:"name"`
+        Scopes []string `json:"scopes"`
+    }
+    if err := c.BindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+        return
+    }
+    if req.Name == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
+        return
+    }
+
+    rawKey, key, err := h.apiKeyService.CreateKey(c.Request.Context(), userID, req.Name, req.Scopes)
+    if err != nil {
+        switch {
+        case errors.Is(err, ErrInvalidScope):
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        case errors.Is(err, ErrTooManyKeys):
+            c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+        default:
+            log.Printf("Error creating API key for user %s: %v", userID, err)
+            c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+        }
+        return
+    }
+
+    c.JSON(http.StatusCreated, gin.H{"key": rawKey, "apiKey": key})
+}
+
+// Lists the signed-in user's API keys without their secrets
+func (h *APIKeyHandler) ListKeysHandler(c *gin.Context) {
+    userID := sessionUserID(c)
+    if userID == "" {
+        return
+    }
+
+    keys, err := h.apiKeyService.ListKeys(c.Request.Context(), userID)
+    if err != nil {
+        log.Printf("Error listing API keys for user %s: %v", userID, err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API keys"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"apiKeys": keys})
+}
+
+// Revokes one of the signed-in user's API keys
+func (h *APIKeyHandler) RevokeKeyHandler(c *gin.Context) {
+    userID := sessionUserID(c)
+    if userID == "" {
+        return
+    }
+    keyID := c.Param("id")
+    if keyID == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "key ID is required"})
+        return
+    }
+
+    if err := h.apiKeyService.RevokeKey(c.Request.Context(), userID, keyID); err != nil {
+        if errors.Is(err, ErrKeyNotFound) {
+            c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+            return
+        }
+        log.Printf("Error revoking API key %s for user %s: %v", keyID, userID, err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "API key revoked"})
+}
@@ -0,0 +1,236 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+)
+
+// Scopes that can be granted to a personal API key
+const (
+    ScopeReadPlaylists  = "read-playlists"
+    ScopeWritePlaylists = "write-playlists"
+    ScopeConvert        = "convert"
+)
+
+var validScopes = map[string]bool{
+    ScopeReadPlaylists: true,
+    ScopeWritePlaylists: true,
+    ScopeConvert: true,
+}
+
+// Every key starts with this prefix so that it can be told apart from other bearer tokens
+const keyPrefix = "luth_"
+
+// Number of keys a user can hold at once
+const maxKeysPerUser = 25
+
+var (
+    ErrInvalidKey     = errors.New("invalid API key")
+    ErrKeyNotFound    = errors.New("API key not found")
+    ErrInvalidScope   = errors.New("invalid API key scope")
+    ErrTooManyKeys    = errors.New("too many API keys")
+)
+
+// A personal API key as shown to its owner. The secret itself is never stored.
+type APIKey struct {
+    ID          string     `json:"id"`
+    Name        string     `json:"name"`
+    Scopes      []string   `json:"scopes"`
+    CreatedAt   time.Time  `json:"createdAt"`
+    LastUsedAt  *time.Time `json:"lastUsedAt"`
+}
+
+type APIKeyService struct {
+    AppContext *utils.AppContext
+}
+
+func NewAPIKeyService(appCtx *utils.AppContext) *APIKeyService {
+    return &APIKeyService{
+        AppContext: appCtx,
+    }
+}
+
+func keyRecordKey(keyID string) string {
+    return fmt.Sprintf("apiKey:%s", keyID)
+}
+
+func userKeysKey(userID string) string {
+    return fmt.Sprintf("apiKeys:%s", userID)
+}
+
+func hashSecret(secret string) string {
+    hash := sha256.Sum256([]byte(secret))
+    return hex.EncodeToString(hash[:])
+}
+
+func randomString(numBytes int) (string, error) {
+    b := make([]byte, numBytes)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Creates a key for a user. The returned raw key is shown once and cannot be retrieved again.
+func (s *APIKeyService) CreateKey(ctx context.Context, userID, name string, scopes []string) (string, APIKey, error) {
+    if len(scopes) == 0 {
+        return "", APIKey{}, fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+    }
+    for _, scope := range scopes {
+        if !validScopes[scope] {
+            return "", APIKey{}, fmt.Errorf("%w: %s", ErrInvalidScope, scope)
+        }
+    }
+
+    count, err := s.AppContext.RedisClient.SCard(ctx, userKeysKey(userID)).Result()
+    if err != nil {
+        return "", APIKey{}, err
+    }
+    if count >= maxKeysPerUser {
+        return "", APIKey{}, ErrTooManyKeys
+    }
+
+    keyID, err := randomString(9)
+    if err != nil {
+        return "", APIKey{}, err
+    }
+    // Underscores in the ID would be ambiguous with the separator between ID and secret
+    keyID = strings.ReplaceAll(keyID, "_", "-")
+    secret, err := randomString(32)
+    if err != nil {
+        return "", APIKey{}, err
+    }
+    rawKey := fmt.Sprintf("%s%s_%s", keyPrefix, keyID, secret)
+
+    key := APIKey{
+        ID: keyID,
+        Name: name,
+        Scopes: scopes,
+        CreatedAt: time.Now().UTC(),
+    }
+    pipe := s.AppContext.RedisClient.TxPipeline()
+    pipe.HSet(ctx, keyRecordKey(keyID), map[string]interface{}{
+        "userID": userID,
+        "name": name,
+        "hash": hashSecret(rawKey),
+        "scopes": strings.Join(scopes, ","),
+        "createdAt": key.CreatedAt.Format(time.RFC3339),
+    })
+    pipe.SAdd(ctx, userKeysKey(userID), keyID)
+    if _, err := pipe.Exec(ctx); err != nil {
+        return "", APIKey{}, fmt.Errorf("error storing API key: %v", err)
+    }
+
+    log.Printf("Created API key %s for user %s with scopes %v", keyID, userID, scopes)
+    return rawKey, key, nil
+}
+
+// Converts a stored key record into an APIKey
+func recordToKey(keyID string, record map[string]string) APIKey {
+    key := APIKey{
+        ID: keyID,
+        Name: record["name"],
+        Scopes: strings.Split(record["scopes"], ","),
+    }
+    key.CreatedAt, _ = time.Parse(time.RFC3339, record["createdAt"])
+    if lastUsed, err := time.Parse(time.RFC3339, record["lastUsedAt"]); err == nil {
+        key.LastUsedAt = &lastUsed
+    }
+    return key
+}
+
+// Lists a user's keys, newest first
+func (s *APIKeyService) ListKeys(ctx context.Context, userID string) ([]APIKey, error) {
+    keyIDs, err := s.AppContext.RedisClient.SMembers(ctx, userKeysKey(userID)).Result()
+    if err != nil {
+        return nil, err
+    }
+
+    keys := make([]APIKey, 0, len(keyIDs))
+    for _, keyID := range keyIDs {
+        record, err := s.AppContext.RedisClient.HGetAll(ctx, keyRecordKey(keyID)).Result()
+        if err != nil {
+            return nil, err
+        }
+        if len(record) == 0 {
+            continue
+        }
+        keys = append(keys, recordToKey(keyID, record))
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        return keys[i].CreatedAt.After(keys[j].CreatedAt)
+    })
+    return keys, nil
+}
+
+// Revokes one of a user's keys
+func (s *APIKeyService) RevokeKey(ctx context.Context, userID, keyID string) error {
+    owner, err := s.AppContext.RedisClient.HGet(ctx, keyRecordKey(keyID), "userID").Result()
+    if err == redis.Nil || (err == nil && owner != userID) {
+        return ErrKeyNotFound
+    } else if err != nil {
+        return err
+    }
+
+    pipe := s.AppContext.RedisClient.TxPipeline()
+    pipe.Del(ctx, keyRecordKey(keyID))
+    pipe.SRem(ctx, userKeysKey(userID), keyID)
+    if _, err := pipe.Exec(ctx); err != nil {
+        return fmt.Errorf("error revoking API key: %v", err)
+    }
+    log.Printf("Revoked API key %s of user %s", keyID, userID)
+    return nil
+}
+
+// Reports whether a bearer token is formatted as one of our API keys
+func (s *APIKeyService) IsAPIKey(token string) bool {
+    return strings.HasPrefix(token, keyPrefix)
+}
+
+// Verifies a raw key and returns the user it belongs to and the scopes it grants
+func (s *APIKeyService) Authenticate(ctx context.Context, rawKey string) (string, []string, error) {
+    keyID, _, found := strings.Cut(strings.TrimPrefix(rawKey, keyPrefix), "_")
+    if !strings.HasPrefix(rawKey, keyPrefix) || !found || keyID == "" {
+        return "", nil, ErrInvalidKey
+    }
+
+    record, err := s.AppContext.RedisClient.HGetAll(ctx, keyRecordKey(keyID)).Result()
+    if err != nil {
+        return "", nil, err
+    }
+    if len(record) == 0 || subtle.ConstantTimeCompare([]byte(record["hash"]), []byte(hashSecret(rawKey))) != 1 {
+        return "", nil, ErrInvalidKey
+    }
+
+    if err := s.AppContext.RedisClient.HSet(ctx, keyRecordKey(keyID), "lastUsedAt", time.Now().UTC().Format(time.RFC3339)).Err(); err != nil {
+        log.Printf("Error recording last use of API key %s: %v", keyID, err)
+    }
+    return record["userID"], strings.Split(record["scopes"], ","), nil
+}
+
+// Revokes all of a user's keys. Registered with the account service for user data deletion.
+func (s *APIKeyService) PurgeUserData(ctx context.Context, userID string) error {
+    keyIDs, err := s.AppContext.RedisClient.SMembers(ctx, userKeysKey(userID)).Result()
+    if err != nil {
+        return err
+    }
+    for _, keyID := range keyIDs {
+        if err := s.RevokeKey(ctx, userID, keyID); err != nil && !errors.Is(err, ErrKeyNotFound) {
+            return err
+        }
+    }
+    return nil
+}
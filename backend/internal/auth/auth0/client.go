@@ -2,8 +2,8 @@ package auth0
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -18,6 +18,7 @@ import (
 // Auth0Client manages communication with the Auth0 Management API.
 type Auth0Client struct {
     AppContext *utils.AppContext
+    HTTPClient *http.Client
 }
 
 type Auth0UserMetadata struct {
@@ -49,14 +50,21 @@ type Identity struct {
 	IsSocial   		bool   `json:"isSocial"`
 }
 
+// The profile Auth0 returns for a user's own access token
+type Auth0UserInfo struct {
+	Sub   string `json:"sub"` // the Auth0 user ID
+	Email string `json:"email"`
+}
+
 func NewAuth0Client(appCtx *utils.AppContext) *Auth0Client {
     return &Auth0Client{
         AppContext: appCtx,
+        HTTPClient: utils.NewHTTPClient("Auth0", utils.DefaultRetryPolicy),
     }
 }
 
 // Requests a new Auth0 Management API access token
-func (c *Auth0Client) RequestToken() (utils.TokenResponse, error) {
+func (c *Auth0Client) RequestToken(ctx context.Context) (utils.TokenResponse, error) {
     clientSecret := c.AppContext.EnvConfig.Auth0ManagementClientSecret
 	clientID := c.AppContext.EnvConfig.Auth0ManagementClientID
 	domain := c.AppContext.EnvConfig.Auth0Domain
@@ -72,7 +80,7 @@ func (c *Auth0Client) RequestToken() (utils.TokenResponse, error) {
 	payload := strings.NewReader(data.Encode())
 
 
-    req, err := http.NewRequest("POST", url_, payload)
+    req, err := http.NewRequestWithContext(ctx, "POST", url_, payload)
     if err != nil {
 		log.Printf("Failed to create HTTP request: %v", err)
         return utils.TokenResponse{}, err
@@ -80,13 +88,18 @@ func (c *Auth0Client) RequestToken() (utils.TokenResponse, error) {
 
 	req.Header.Add("content-type", "application/x-www-form-urlencoded")
 
-    res, err := http.DefaultClient.Do(req)
+    res, err := c.HTTPClient.Do(req)
 	if err != nil {
         log.Printf("There was an issue requesting the Auth0 Management API access token: %v", err)
         return utils.TokenResponse{}, err
     }
 	defer res.Body.Close()
 
+	if err := utils.CheckTokenResponse("Auth0", res); err != nil {
+		log.Printf("Auth0 Management API token request failed: %v", err)
+		return utils.TokenResponse{}, err
+	}
+
     body, err := io.ReadAll(res.Body)
 	if err != nil {
         log.Printf("There was an issue reading the response body: %v", err)
@@ -104,11 +117,11 @@ func (c *Auth0Client) RequestToken() (utils.TokenResponse, error) {
     return tokenResponse, nil
 }
 
-func (c *Auth0Client) GetUserMetadata(accessToken string, userID string) (Auth0UserMetadata, error) {
+func (c *Auth0Client) GetUserMetadata(ctx context.Context, accessToken string, userID string) (Auth0UserMetadata, error) {
     domain := c.AppContext.EnvConfig.Auth0Domain
 	url := fmt.Sprintf("https://%s/api/v2/users/%s", domain, userID)
     
-    req, err := http.NewRequest("GET", url, nil)
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		log.Printf("Failed to create HTTP request: %v", err)
         return Auth0UserMetadata{}, err
@@ -117,25 +130,24 @@ func (c *Auth0Client) GetUserMetadata(accessToken string, userID string) (Auth0U
 	req.Header.Add("Accept", "application/json")
     req.Header.Add("authorization", fmt.Sprintf("Bearer %s", accessToken))
 
-    res, err := http.DefaultClient.Do(req)
+    res, err := c.HTTPClient.Do(req)
 	if err != nil {
 		log.Println(err)
 		return Auth0UserMetadata{}, err
 	}
 	defer res.Body.Close()
 
+	if err := utils.CheckResponse("Auth0", res); err != nil {
+		log.Printf("Received error status from Auth0: %v", err)
+		return Auth0UserMetadata{}, err
+	}
+
     body, err := io.ReadAll(res.Body)
     if err != nil {
 		log.Printf("Failed to read response body: %v", err)
         return Auth0UserMetadata{}, err
     }
 
-	if res.StatusCode >= 400 {
-		errMsg := fmt.Sprintf("Received error status from Auth0: %s", string(body))
-    	log.Print(errMsg)
-    	return Auth0UserMetadata{}, errors.New(errMsg)
-    }
-
 	
 	var userMetadata Auth0UserMetadata
 	if err := json.Unmarshal(body, &userMetadata); err != nil {
@@ -146,8 +158,40 @@ func (c *Auth0Client) GetUserMetadata(accessToken string, userID string) (Auth0U
 	return userMetadata, nil
 }
 
+// Gets the profile of the user an access token was issued to. Fails with utils.ErrUnauthorized if Auth0 rejects the token.
+func (c *Auth0Client) GetUserInfo(ctx context.Context, accessToken string) (Auth0UserInfo, error) {
+    url := fmt.Sprintf("https://%s/userinfo", c.AppContext.EnvConfig.Auth0Domain)
+
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		log.Printf("Failed to create HTTP request: %v", err)
+        return Auth0UserInfo{}, err
+    }
+
+	req.Header.Add("Accept", "application/json")
+    req.Header.Add("authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+    res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		log.Printf("Failed to execute request: %v", err)
+		return Auth0UserInfo{}, err
+	}
+	defer res.Body.Close()
+
+	if err := utils.CheckResponse("Auth0", res); err != nil {
+		return Auth0UserInfo{}, err
+	}
+
+	var userInfo Auth0UserInfo
+	if err := json.NewDecoder(res.Body).Decode(&userInfo); err != nil {
+		log.Printf("Failed to decode user info: %v", err)
+		return Auth0UserInfo{}, err
+	}
+	return userInfo, nil
+}
+
 // Make a partial update of a user's metadata
-func (c *Auth0Client) UpdateUserMetadata(accessToken, userID string, metadata map[string]interface{}) error {
+func (c *Auth0Client) UpdateUserMetadata(ctx context.Context, accessToken, userID string, metadata map[string]interface{}) error {
     domain := c.AppContext.EnvConfig.Auth0Domain
 	url := fmt.Sprintf("https://%s/api/v2/users/%s", domain, userID)
 
@@ -163,7 +207,7 @@ func (c *Auth0Client) UpdateUserMetadata(accessToken, userID string, metadata ma
 		return err
 	}
 
-    req, err := http.NewRequest("PATCH", url, bytes.NewBuffer(payload))
+    req, err := http.NewRequestWithContext(ctx, "PATCH", url, bytes.NewBuffer(payload))
 	if err != nil {
 		log.Printf("Failed to create HTTP request: %v", err)
         return err
@@ -172,23 +216,17 @@ func (c *Auth0Client) UpdateUserMetadata(accessToken, userID string, metadata ma
     req.Header.Add("authorization", fmt.Sprintf("Bearer %s", accessToken))
     req.Header.Add("content-type", "application/json")
 
-    res, err := http.DefaultClient.Do(req)
+    res, err := c.HTTPClient.Do(req)
     if err != nil {
 		log.Printf("Failed to execute request: %v", err)
         return err
     }
     defer res.Body.Close()
 
-    body, err := io.ReadAll(res.Body)
-    if err != nil {
-		log.Printf("Failed to read response body: %v", err)
-        return err
-    }
-
-    if res.StatusCode >= 400 {
-		log.Printf("Received error status from Auth0: %s. Error: %v", string(body), err)
-        return err
-    }
+	if err := utils.CheckResponse("Auth0", res); err != nil {
+		log.Printf("Received error status from Auth0: %v", err)
+		return err
+	}
 
     log.Printf("Metadata updated successfully for user %s: %v", userID, updatedFields)
 	return nil
@@ -2,16 +2,17 @@ package auth0
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"time"
 
-	"github.com/redis/go-redis/v9"
 	"github.com/roblieblang/luthien/backend/internal/utils"
 )
 
 type Auth0Service struct {
     Auth0Client *Auth0Client
     AppContext *utils.AppContext
+    Mirrors    []utils.UserMetadataUpdater
 }
 
 func NewAuth0Service(auth0Client *Auth0Client, appCtx *utils.AppContext) *Auth0Service {
@@ -21,10 +22,16 @@ func NewAuth0Service(auth0Client *Auth0Client, appCtx *utils.AppContext) *Auth0S
     }
 }
 
-// Stores an Auth0 Management API access token in Redis
-func (s *Auth0Service) storeAuth0Token(tokenResponse utils.TokenResponse) error{
+// Name under which the Auth0 Management API access token is stored
+const auth0TokenName = "auth0ManagementAPIAccessToken"
+
+// Stores an Auth0 Management API access token in the token store
+func (s *Auth0Service) storeAuth0Token(ctx context.Context, tokenResponse utils.TokenResponse) error{
     log.Printf("Storing Auth0 token")
-    err := s.AppContext.RedisClient.Set(context.Background(), "auth0ManagementAPIAccessToken", tokenResponse.AccessToken, time.Duration(tokenResponse.ExpiresIn) * time.Second).Err()
+    err := s.AppContext.Tokens.Set(ctx, auth0TokenName, utils.StoredToken{
+        Value: tokenResponse.AccessToken,
+        ExpiresAt: time.Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second),
+    })
     if err != nil {
         log.Printf("There was an issue storing the Auth0 Management API Access Token: %v", err)
         return err
@@ -32,34 +39,34 @@ func (s *Auth0Service) storeAuth0Token(tokenResponse utils.TokenResponse) error{
     return nil
 }
 
-// Retrieves an existing Auth0 Management API access token from Redis
-func (s *Auth0Service) retrieveAuth0Token() (string, error){
+// Retrieves an existing Auth0 Management API access token from the token store
+func (s *Auth0Service) retrieveAuth0Token(ctx context.Context) (string, error){
     log.Printf("Retrieving Auth0 token")
-	accessToken, err := s.AppContext.RedisClient.Get(context.Background(), "auth0ManagementAPIAccessToken").Result()
+	stored, err := s.AppContext.Tokens.Get(ctx, auth0TokenName)
     // Token not found, not an error
-    if err == redis.Nil {
+    if err == utils.ErrTokenNotFound {
         return "", nil
     } else if err != nil {
         log.Printf("Failed to retrieve Auth0 Management API Access Token: %v", err)
         return "", err
     }
-    return accessToken, nil
+    return stored.Value, nil
 }
 
 // Helper function for getting a valid access token
-func (s *Auth0Service) getValidAccessToken() (string, error) {
+func (s *Auth0Service) getValidAccessToken(ctx context.Context) (string, error) {
     log.Printf("Trying to get valid Auth0 access token...")
-    accessToken, err := s.retrieveAuth0Token()
-    // Check for redis.Nil to determine if the key was simply not found i.e. not an actual error
-    if err == redis.Nil {
-        log.Println("Access token not found in Redis, requesting a new one.")
+    accessToken, err := s.retrieveAuth0Token(ctx)
+    // Check for ErrTokenNotFound to determine if the token was simply not found i.e. not an actual error
+    if err == utils.ErrTokenNotFound {
+        log.Println("Access token not found in the token store, requesting a new one.")
     } else if err != nil {
-        // Handle actual errors from Redis
+        // Handle actual errors from the token store
         log.Printf("Failed to retrieve Auth0 Management API Access Token: %v", err)
         return "", err
     } else {
         // If there was no error and the token exists, check for expiration
-        isExpired, err := utils.IsAccessTokenExpired(*s.AppContext, "auth0ManagementAPIAccessToken", accessToken)
+        isExpired, err := utils.IsAccessTokenExpired(ctx, *s.AppContext, auth0TokenName, accessToken)
         if err != nil {
             log.Printf("Failed to check token freshness: %v", err)
             return "", err
@@ -72,12 +79,12 @@ func (s *Auth0Service) getValidAccessToken() (string, error) {
 
     // If the code reaches here, it means the token was either not found, expired, or another error occurred
     // Attempt to request a new token.
-    tokenResponse, err := s.Auth0Client.RequestToken()
+    tokenResponse, err := s.Auth0Client.RequestToken(ctx)
     if err != nil {
         log.Printf("Failed to request new Auth0 Management API Access Token: %v", err)
         return "", err
     }
-    err = s.storeAuth0Token(tokenResponse)
+    err = s.storeAuth0Token(ctx, tokenResponse)
     if err != nil {
         log.Printf("Failed to store new Auth0 Management API Access Token: %v", err)
         return "", err
@@ -87,12 +94,12 @@ func (s *Auth0Service) getValidAccessToken() (string, error) {
 }
 
 // Wrapper service function for GetUserMetadata client function that extracts and stores Google access token from response
-func (s *Auth0Service) GetUserMetadata(userID string) (Auth0UserMetadata, error) {
-    accessToken, err := s.getValidAccessToken()
+func (s *Auth0Service) GetUserMetadata(ctx context.Context, userID string) (Auth0UserMetadata, error) {
+    accessToken, err := s.getValidAccessToken(ctx)
     if err != nil {
         return Auth0UserMetadata{}, err
     }
-    userMetadata, err := s.Auth0Client.GetUserMetadata(accessToken, userID)
+    userMetadata, err := s.Auth0Client.GetUserMetadata(ctx, accessToken, userID)
     if err != nil {
         return Auth0UserMetadata{}, err
     }
@@ -100,11 +107,49 @@ func (s *Auth0Service) GetUserMetadata(userID string) (Auth0UserMetadata, error)
     return userMetadata, nil
 }
 
-// Wrapper service function for UpdateUserMetadata client function
-func (s *Auth0Service) UpdateUserMetadata(userID string, updates map[string]interface{}) error {
-    accessToken, err := s.getValidAccessToken()
+// How long a verified session token is trusted before Auth0 is asked about it again
+const sessionCacheTTL = 5 * time.Minute
+
+// Verifies an access token the frontend received from Auth0 and returns the ID of the user it was issued to.
+// Verified tokens are cached under a hash of the token, so that Auth0 is not asked on every request.
+func (s *Auth0Service) VerifySession(ctx context.Context, accessToken string) (string, error) {
+    key := "auth0Session:" + utils.SHA256Hash(accessToken)
+    if userID, err := s.AppContext.Cache.Get(ctx, key); err == nil && len(userID) > 0 {
+        return string(userID), nil
+    }
+
+    userInfo, err := s.Auth0Client.GetUserInfo(ctx, accessToken)
+    if err != nil {
+        return "", err
+    }
+    if userInfo.Sub == "" {
+        return "", fmt.Errorf("Auth0 user info has no subject: %w", utils.ErrUnauthorized)
+    }
+
+    if err := s.AppContext.Cache.Set(ctx, key, []byte(userInfo.Sub), sessionCacheTTL); err != nil {
+        log.Printf("Error caching verified session of user %s: %v", userInfo.Sub, err)
+    }
+    return userInfo.Sub, nil
+}
+
+// Registers a store that receives every metadata update after Auth0 has accepted it
+func (s *Auth0Service) RegisterMetadataMirror(mirror utils.UserMetadataUpdater) {
+    s.Mirrors = append(s.Mirrors, mirror)
+}
+
+// Wrapper service function for UpdateUserMetadata client function. Auth0 stays authoritative, so mirror failures are only logged.
+func (s *Auth0Service) UpdateUserMetadata(ctx context.Context, userID string, updates map[string]interface{}) error {
+    accessToken, err := s.getValidAccessToken(ctx)
     if err != nil {
         return err
     }
-    return s.Auth0Client.UpdateUserMetadata(accessToken, userID, updates)
+    if err := s.Auth0Client.UpdateUserMetadata(ctx, accessToken, userID, updates); err != nil {
+        return err
+    }
+    for _, mirror := range s.Mirrors {
+        if err := mirror.UpdateUserMetadata(ctx, userID, updates); err != nil {
+            log.Printf("Error mirroring metadata update for user %s: %v", userID, err)
+        }
+    }
+    return nil
 }
\ No newline at end of file
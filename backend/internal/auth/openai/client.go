@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log"
 	"math"
+	"net/http"
+	"time"
 
 	"github.com/roblieblang/luthien/backend/internal/utils"
 	"github.com/sashabaranov/go-openai"
@@ -13,11 +15,22 @@ import (
 
 type OpenAIClient struct {
     AppContext *utils.AppContext
+    HTTPClient *http.Client
+}
+
+// Chat completions can take much longer than the other providers' calls
+var openAIRetryPolicy = utils.RetryPolicy{
+    MaxRetries: 2,
+    BaseDelay: time.Second,
+    MaxDelay: 10 * time.Second,
+    MaxRetryAfter: 20 * time.Second,
+    PerAttemptTimeout: 45 * time.Second,
 }
 
 func NewOpenAIClient(appCtx *utils.AppContext) *OpenAIClient {
     return &OpenAIClient{
         AppContext: appCtx,
+        HTTPClient: utils.NewHTTPClient("OpenAI", openAIRetryPolicy),
     }
 }
 
@@ -30,13 +43,15 @@ type ArtistSongPair struct {
 // See https://github.com/pkoukk/tiktoken-go#counting-tokens-for-chat-api-calls for token counting
 
 // Prompts the OpenAI API with a list of video titles from which it will extract artist names and song titles
-func (c *OpenAIClient) ExtractArtistAndSongFromVideoTitle(videoTitles []string) ([]ArtistSongPair, error) {
+func (c *OpenAIClient) ExtractArtistAndSongFromVideoTitle(ctx context.Context, videoTitles []string) ([]ArtistSongPair, error) {
 	key := c.AppContext.EnvConfig.OpenAIAPIKey
 	if key == "" {
 		return nil, fmt.Errorf("OpenAI API Key is empty")
 	}
 
-	client := openai.NewClient(key)
+	config := openai.DefaultConfig(key)
+	config.HTTPClient = c.HTTPClient
+	client := openai.NewClientWithConfig(config)
 
 	backtick := "`"
 	prompt := `For each of the following YouTube video titles, use your general knowledge to identify 
@@ -57,7 +72,7 @@ func (c *OpenAIClient) ExtractArtistAndSongFromVideoTitle(videoTitles []string)
 	log.Printf("Prompt sent to OpenAI: %s", prompt)
 
 	resp, err := client.CreateChatCompletion(
-		context.Background(),
+		ctx,
 		openai.ChatCompletionRequest{
 			Model: openai.GPT3Dot5Turbo,
 			Temperature: math.SmallestNonzeroFloat32,
@@ -70,7 +85,7 @@ func (c *OpenAIClient) ExtractArtistAndSongFromVideoTitle(videoTitles []string)
 		},
 	)
 	if err != nil {
-		return nil, fmt.Errorf("error received during OpenAI chat completion request: %v", err)
+		return nil, fmt.Errorf("error received during OpenAI chat completion request: %w", err)
 	}
 
 	log.Printf("Response from OpenAI: %v", resp)
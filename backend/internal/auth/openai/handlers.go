@@ -1,11 +1,14 @@
 package openai
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/roblieblang/luthien/backend/internal/utils"
 )
 
 type OpenAIHandler struct {
@@ -30,9 +33,16 @@ func (h *OpenAIHandler) ExtractArtistAndSongFromVideoTitleHandler(c *gin.Context
         return
     }
 
-    resp, err := h.openAIService.ExtractArtistAndSongFromVideoTitle(requestBody.VideoTitles)
+    resp, err := h.openAIService.ExtractArtistAndSongFromVideoTitle(c.Request.Context(), requestBody.VideoTitles)
     if err != nil {
         log.Printf("Error extracting artist and song: %v", err)
+        if errors.Is(err, utils.ErrUpstreamUnavailable) {
+            if retryAfter := utils.RetryAfterSeconds(err); retryAfter > 0 {
+                c.Header("Retry-After", strconv.Itoa(retryAfter))
+            }
+            c.JSON(http.StatusServiceUnavailable, gin.H{"error": "upstream_unavailable", "message": "OpenAI is currently unavailable. Please try again shortly."})
+            return
+        }
         c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("error extracting artist and song title: %v", err)})
         return
     }
@@ -1,5 +1,7 @@
 package openai
 
+import "context"
+
 type OpenAIService struct {
 	OpenAIClient *OpenAIClient
 }
@@ -10,6 +12,6 @@ func NewOpenAIService(openAIClient *OpenAIClient) *OpenAIService {
 	}
 }
 
-func (s *OpenAIService) ExtractArtistAndSongFromVideoTitle(videoTitles []string) ([]ArtistSongPair, error) {
-	return s.OpenAIClient.ExtractArtistAndSongFromVideoTitle(videoTitles)
+func (s *OpenAIService) ExtractArtistAndSongFromVideoTitle(ctx context.Context, videoTitles []string) ([]ArtistSongPair, error) {
+	return s.OpenAIClient.ExtractArtistAndSongFromVideoTitle(ctx, videoTitles)
 }
\ No newline at end of file
@@ -0,0 +1,184 @@
+package spotify
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/roblieblang/luthien/backend/internal/utils"
+)
+
+// Bump to invalidate every cached Spotify response, e.g. after changing what is stored
+const spotifyCacheVersion = "v2"
+
+const (
+    // Profiles and playlist pages are revalidated with If-None-Match on every use, so they may be kept a while
+    revalidatedCacheTTL = 24 * time.Hour
+    // Track lists are revalidated against the playlist's snapshot ID on every use
+    playlistTracksCacheTTL = 7 * 24 * time.Hour
+    // Search results are not revalidated, so they are kept only briefly
+    searchCacheTTL = time.Hour
+)
+
+// A Spotify response cached for one linked account
+type cachedResponse struct {
+    ETag         string          `json:"etag,omitempty"`
+    SnapshotID   string          `json:"snapshotId,omitempty"`   // for track lists, the snapshot the tracks were read at
+    SnapshotETag string          `json:"snapshotEtag,omitempty"` // for track lists, the ETag of the snapshot ID lookup
+    Body         json.RawMessage `json:"body"`
+}
+
+// Cache keys start with the user ID so that the entries are removed along with the rest of the user's data
+func responseCacheKey(userID, accountID, resource string) string {
+    if accountID == "" {
+        accountID = "_"
+    }
+    return fmt.Sprintf("spotifyCache:%s:%s:%s:%s", userID, spotifyCacheVersion, accountID, resource)
+}
+
+// Shortens a search URL to a fixed-length cache resource name
+func searchCacheResource(searchURL string) string {
+    sum := sha256.Sum256([]byte(searchURL))
+    return "search:" + hex.EncodeToString(sum[:16])
+}
+
+// Returns the cached response stored under key, or nil if there is none or the cache is unavailable
+func (s *SpotifyService) loadCachedResponse(ctx context.Context, key string) *cachedResponse {
+    data, err := s.AppContext.Cache.Get(ctx, key)
+    if err == utils.ErrCacheMiss {
+        return nil
+    } else if err != nil {
+        log.Printf("Error reading cached Spotify response %s: %v", key, err)
+        return nil
+    }
+
+    var entry cachedResponse
+    if err := json.Unmarshal(data, &entry); err != nil {
+        log.Printf("Discarding unreadable cached Spotify response %s: %v", key, err)
+        return nil
+    }
+    return &entry
+}
+
+// Caches a response. Failures are only logged, since the response can always be fetched again.
+func (s *SpotifyService) storeCachedResponse(ctx context.Context, key string, entry cachedResponse, ttl time.Duration) {
+    data, err := json.Marshal(entry)
+    if err != nil {
+        log.Printf("Error encoding Spotify response for caching: %v", err)
+        return
+    }
+    if err := s.AppContext.Cache.Set(ctx, key, data, ttl); err != nil {
+        log.Printf("Error caching Spotify response %s: %v", key, err)
+    }
+}
+
+// Fetches a resource, sending the ETag of the cached copy so that Spotify can answer 304 Not Modified when it is unchanged
+func (s *SpotifyService) getRevalidated(ctx context.Context, accessToken, key, url string) ([]byte, error) {
+    cached := s.loadCachedResponse(ctx, key)
+    etag := ""
+    if cached != nil {
+        etag = cached.ETag
+    }
+
+    res, err := s.SpotifyClient.getConditional(ctx, accessToken, url, etag)
+    if err != nil {
+        return nil, err
+    }
+    if res.NotModified {
+        s.storeCachedResponse(ctx, key, *cached, revalidatedCacheTTL)
+        return cached.Body, nil
+    }
+
+    if res.ETag != "" {
+        s.storeCachedResponse(ctx, key, cachedResponse{ETag: res.ETag, Body: res.Body}, revalidatedCacheTTL)
+    }
+    return res.Body, nil
+}
+
+// Gets all tracks of a playlist, reusing the cached list as long as the playlist's snapshot ID has not changed
+func (s *SpotifyService) getPlaylistTracksCached(ctx context.Context, accessToken, key, playlistID string) (SpotifyPlaylistTracksResponse, error) {
+    cached := s.loadCachedResponse(ctx, key)
+    snapshotETag := ""
+    if cached != nil {
+        snapshotETag = cached.SnapshotETag
+    }
+
+    res, err := s.SpotifyClient.getConditional(ctx, accessToken, playlistSnapshotURL(playlistID), snapshotETag)
+    if err != nil {
+        return SpotifyPlaylistTracksResponse{}, err
+    }
+
+    var snapshotID string
+    if res.NotModified {
+        snapshotID = cached.SnapshotID
+    } else {
+        var snapshot struct {
+            SnapshotID string `json:"snapshot_id"`
+        }
+        if err := json.Unmarshal(res.Body, &snapshot); err != nil {
+            return SpotifyPlaylistTracksResponse{}, err
+        }
+        snapshotID = snapshot.SnapshotID
+    }
+
+    if cached != nil && snapshotID != "" && cached.SnapshotID == snapshotID {
+        var tracks SpotifyPlaylistTracksResponse
+        if err := json.Unmarshal(cached.Body, &tracks); err == nil {
+            tracks.SnapshotID = snapshotID
+            cached.SnapshotETag = res.ETag
+            s.storeCachedResponse(ctx, key, *cached, playlistTracksCacheTTL)
+            return tracks, nil
+        }
+        log.Printf("Discarding unreadable cached Spotify track list %s", key)
+    }
+
+    tracks, err := s.SpotifyClient.GetPlaylistTracks(ctx, accessToken, playlistID)
+    if err != nil {
+        return SpotifyPlaylistTracksResponse{}, err
+    }
+    tracks.SnapshotID = snapshotID
+    if snapshotID != "" {
+        body, err := json.Marshal(tracks)
+        if err != nil {
+            log.Printf("Error encoding Spotify track list for caching: %v", err)
+            return tracks, nil
+        }
+        s.storeCachedResponse(ctx, key, cachedResponse{SnapshotID: snapshotID, SnapshotETag: res.ETag, Body: body}, playlistTracksCacheTTL)
+    }
+    return tracks, nil
+}
+
+// Returns cached search results, or runs the search and caches what it finds
+func (s *SpotifyService) searchCached(ctx context.Context, key string, search func() ([]utils.UnifiedTrackSearchResult, error)) ([]utils.UnifiedTrackSearchResult, error) {
+    if cached := s.loadCachedResponse(ctx, key); cached != nil {
+        var results []utils.UnifiedTrackSearchResult
+        if err := json.Unmarshal(cached.Body, &results); err == nil {
+            return results, nil
+        }
+    }
+
+    results, err := search()
+    if err != nil {
+        return nil, err
+    }
+    body, err := json.Marshal(results)
+    if err != nil {
+        log.Printf("Error encoding Spotify search results for caching: %v", err)
+        return results, nil
+    }
+    s.storeCachedResponse(ctx, key, cachedResponse{Body: body}, searchCacheTTL)
+    return results, nil
+}
+
+// Removes the cached responses of one linked account, or of all of the user's accounts when accountID is empty
+func (s *SpotifyService) clearCachedResponses(ctx context.Context, userID, accountID string) error {
+    prefix := fmt.Sprintf("spotifyCache:%s:", userID)
+    if accountID != "" {
+        prefix = responseCacheKey(userID, accountID, "")
+    }
+    return s.AppContext.Cache.DeletePrefix(ctx, prefix)
+}
@@ -2,13 +2,16 @@ package spotify
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 
 	"github.com/roblieblang/luthien/backend/internal/utils"
@@ -20,8 +23,12 @@ import (
 
 type SpotifyClient struct {
     AppContext *utils.AppContext
+    HTTPClient *http.Client
 }
 
+// Returned by the track searches when Spotify has no result for the query
+var ErrNoTracksFound = errors.New("no tracks found")
+
 type SpotifyUserProfile struct {
     Country        string            `json:"country"`
     DisplayName    string            `json:"display_name"`
@@ -57,15 +64,8 @@ type Image struct {
     // Width  int    `json:"width"`
 }
 
-type SpotifyPlaylistsResponse struct {
-    Href     string          `json:"href"`  // link to endpoint returning full result of the request
-    Limit    int             `json:"limit"`  // max number of itesm in the response
-    Next     *string          `json:"next"`  // URL to the next page of items (null if none)
-    Offset   int             `json:"offset"`
-    Previous *string         `json:"previous"`  // URL to the previous page of items (null if none)
-    Total    int             `json:"total"`  // total number of items (playlists) for the user
-    Items    []PlaylistItem  `json:"items"`
-}
+// Spotify names the fields of its own pages the same way, though its next is a URL, which the service replaces with a cursor
+type SpotifyPlaylistsResponse = utils.Page[PlaylistItem]
 
 type PlaylistItem struct {
     ID            string           `json:"id"`
@@ -99,13 +99,15 @@ type PlaylistTracks struct {
 }
 
 type SpotifyPlaylistTracksResponse struct {
-    Limit  int                 `json:"limit"`
-    Offset int                 `json:"offset"`
-    Items  []PlaylistTrackItem `json:"items"`
+    Limit      int                 `json:"limit"`
+    Offset     int                 `json:"offset"`
+    Items      []PlaylistTrackItem `json:"items"`
+    SnapshotID string              `json:"snapshot_id,omitempty"` // the playlist version the items were read at
 }
 
 type PlaylistTrackItem struct {
-    Track TrackDetails `json:"track"`
+    AddedAt string       `json:"added_at"`  // RFC 3339; null for playlists created before 2009
+    Track   TrackDetails `json:"track"`
 }
 
 type TrackDetails struct {
@@ -114,6 +116,8 @@ type TrackDetails struct {
     ExternalIDs ExternalIDs    `json:"external_ids"`
     Name        string         `json:"name"`
     URI         string         `json:"uri"`
+    DurationMs  int64          `json:"duration_ms"`
+    Popularity  int            `json:"popularity"`  // 0 to 100
 }
 
 type AlbumDetails struct {
@@ -127,10 +131,26 @@ type Artist struct {
 
 type ExternalIDs struct {
     ISRC string `json:"isrc"`
+    UPC  string `json:"upc"`
+}
+
+// An album in the user's library or in search results
+type SpotifyAlbum struct {
+    URI         string      `json:"uri"`
+    Name        string      `json:"name"`
+    Artists     []Artist    `json:"artists"`
+    ExternalIDs ExternalIDs `json:"external_ids"` // Spotify leaves these out of search results
+}
+
+// An artist the user follows or one found by a search
+type SpotifyArtist struct {
+    URI  string `json:"uri"`
+    Name string `json:"name"`
 }
 
 type CreatePlaylistBody struct {
     UserID        string                `json:"userId"`
+    AccountID     string                `json:"accountId"`
     SpotifyUserID string                `json:"spotifyUserId"`
     Payload       CreatePlaylistPayload `json:"payload"`
 }
@@ -148,13 +168,13 @@ type CreatePlaylistPayload struct {
 func NewSpotifyClient(appCtx *utils.AppContext) *SpotifyClient {
     return &SpotifyClient{
         AppContext: appCtx,
+        HTTPClient: utils.NewHTTPClient("Spotify", utils.DefaultRetryPolicy),
     }
 }
 
 // Requests a new access token from Spotify
-func (c *SpotifyClient) RequestToken(payload url.Values) (utils.TokenResponse, error) {
-    client := &http.Client{}
-    req, err := http.NewRequest("POST", "https://accounts.spotify.com/api/token", strings.NewReader(payload.Encode()))
+func (c *SpotifyClient) RequestToken(ctx context.Context, payload url.Values) (utils.TokenResponse, error) {
+    req, err := http.NewRequestWithContext(ctx, "POST", "https://accounts.spotify.com/api/token", strings.NewReader(payload.Encode()))
     if err != nil {
         log.Printf("Error creating request for Spotify token: %v\n", err)
         return utils.TokenResponse{}, err
@@ -168,27 +188,18 @@ func (c *SpotifyClient) RequestToken(payload url.Values) (utils.TokenResponse, e
 
     req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-    resp, err := client.Do(req)
-
-    if resp.StatusCode != http.StatusOK {
-        var errorResponse struct {
-            Error            string `json:"error"`
-            ErrorDescription string `json:"error_description"`
-        }
-        if decodeErr := json.NewDecoder(resp.Body).Decode(&errorResponse); decodeErr == nil {
-            log.Printf("Spotify API error: %s - %s\n", errorResponse.Error, errorResponse.ErrorDescription)
-        } else {
-            log.Printf("Failed to decode Spotify error response: %v\n", decodeErr)
-        }
-        return utils.TokenResponse{}, fmt.Errorf("spotify API request failed: %s", resp.Status)
-    }
-
+    resp, err := c.HTTPClient.Do(req)
     if err != nil {
         log.Printf("Error requesting token from Spotify: %v\n", err)
         return utils.TokenResponse{}, err
     }
     defer resp.Body.Close()
-    
+
+    if err := utils.CheckTokenResponse("Spotify", resp); err != nil {
+        log.Printf("Spotify token request failed: %v\n", err)
+        return utils.TokenResponse{}, err
+    }
+
     var tokenResponse utils.TokenResponse
     if err := json.NewDecoder(resp.Body).Decode(&tokenResponse); err != nil {
         log.Printf("Error reading Spotify token response: %v\n", err)
@@ -198,30 +209,83 @@ func (c *SpotifyClient) RequestToken(payload url.Values) (utils.TokenResponse, e
     return tokenResponse, nil
 }
 
+const currentUserProfileURL = "https://api.spotify.com/v1/me"
+
+// Most playlists Spotify returns per page
+const MaxPlaylistsPageSize = 50
+
+func currentUserPlaylistsURL(offset, limit int) string {
+    return fmt.Sprintf("https://api.spotify.com/v1/me/playlists?limit=%d&offset=%d", limit, offset)
+}
+
+// Requests only the snapshot ID of a playlist, which changes whenever its tracks do
+func playlistSnapshotURL(playlistID string) string {
+    return fmt.Sprintf("https://api.spotify.com/v1/playlists/%s?fields=snapshot_id", playlistID)
+}
+
+// The body of a conditional GET, or NotModified when the resource still matches the ETag sent
+type conditionalResponse struct {
+    Body        []byte
+    ETag        string
+    NotModified bool
+}
+
+// Sends a GET request with If-None-Match set to etag, when there is one
+func (c *SpotifyClient) getConditional(ctx context.Context, accessToken, url, etag string) (conditionalResponse, error) {
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+    if err != nil {
+        return conditionalResponse{}, err
+    }
+
+    req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+    if etag != "" {
+        req.Header.Add("If-None-Match", etag)
+    }
+
+    res, err := c.HTTPClient.Do(req)
+    if err != nil {
+        return conditionalResponse{}, err
+    }
+    defer res.Body.Close()
+
+    if res.StatusCode == http.StatusNotModified {
+        return conditionalResponse{ETag: etag, NotModified: true}, nil
+    }
+    if err := utils.CheckResponse("Spotify", res); err != nil {
+        return conditionalResponse{}, err
+    }
+
+    body, err := io.ReadAll(res.Body)
+    if err != nil {
+        return conditionalResponse{}, err
+    }
+    return conditionalResponse{Body: body, ETag: res.Header.Get("ETag")}, nil
+}
+
 // Gets the current user's profile
-func (c *SpotifyClient) GetCurrentUserProfile(accessToken string) (SpotifyUserProfile, error) {
-    url := "https://api.spotify.com/v1/me"
+func (c *SpotifyClient) GetCurrentUserProfile(ctx context.Context, accessToken string) (SpotifyUserProfile, error) {
+    url := currentUserProfileURL
 
-    req, err := http.NewRequest("GET", url, nil)
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
         return SpotifyUserProfile{}, err
     }
 
     req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 
-    res, err := http.DefaultClient.Do(req)
+    res, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return SpotifyUserProfile{}, err
 	}
 	defer res.Body.Close()
 
-    body, err := io.ReadAll(res.Body)
-    if err != nil {
+    if err := utils.CheckResponse("Spotify", res); err != nil {
         return SpotifyUserProfile{}, err
     }
 
-    if res.StatusCode >= 400 {
-        return SpotifyUserProfile{}, fmt.Errorf("spotify API request failed with status %d: %s", res.StatusCode, string(body))
+    body, err := io.ReadAll(res.Body)
+    if err != nil {
+        return SpotifyUserProfile{}, err
     }
 
 	var userProfile SpotifyUserProfile
@@ -233,29 +297,29 @@ func (c *SpotifyClient) GetCurrentUserProfile(accessToken string) (SpotifyUserPr
 }
 
 // Gets the current user's playlists
-func (c *SpotifyClient) GetCurrentUserPlaylists(accessToken string, offset int) (SpotifyPlaylistsResponse, error) {
-    url := fmt.Sprintf("https://api.spotify.com/v1/me/playlists?limit=%d&offset=%d", 20, offset) 
+func (c *SpotifyClient) GetCurrentUserPlaylists(ctx context.Context, accessToken string, offset, limit int) (SpotifyPlaylistsResponse, error) {
+    url := currentUserPlaylistsURL(offset, limit)
 
-    req, err := http.NewRequest("GET", url, nil)
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
         return SpotifyPlaylistsResponse{}, err
     }
 
     req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 
-    res, err := http.DefaultClient.Do(req)
+    res, err := c.HTTPClient.Do(req)
 	if err != nil {
 		return SpotifyPlaylistsResponse{}, err
 	}
 	defer res.Body.Close()
 
-    body, err := io.ReadAll(res.Body)
-    if err != nil {
+    if err := utils.CheckResponse("Spotify", res); err != nil {
         return SpotifyPlaylistsResponse{}, err
     }
 
-    if res.StatusCode >= 400 {
-        return SpotifyPlaylistsResponse{}, fmt.Errorf("spotify API request failed with status %d: %s", res.StatusCode, string(body))
+    body, err := io.ReadAll(res.Body)
+    if err != nil {
+        return SpotifyPlaylistsResponse{}, err
     }
 
 	var playlistsResponse SpotifyPlaylistsResponse
@@ -283,7 +347,7 @@ func (c *SpotifyClient) buildPlaylistItemsURL(playlistID string, limit, offset i
 }
 
 // Gets playlist items
-func (c *SpotifyClient) GetPlaylistTracks(accessToken, playlistID string) (SpotifyPlaylistTracksResponse, error) {
+func (c *SpotifyClient) GetPlaylistTracks(ctx context.Context, accessToken, playlistID string) (SpotifyPlaylistTracksResponse, error) {
     var allTracks []PlaylistTrackItem
     var limit = 100
     var offset = 0
@@ -291,30 +355,28 @@ func (c *SpotifyClient) GetPlaylistTracks(accessToken, playlistID string) (Spoti
     for {
         url := c.buildPlaylistItemsURL(playlistID, limit, offset)
 
-        req, err := http.NewRequest("GET", url, nil)
+        req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
         if err != nil {
             log.Printf("Error creating new request: %v", err)
             return SpotifyPlaylistTracksResponse{}, err
         }
         req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 
-        res, err := http.DefaultClient.Do(req)
+        res, err := c.HTTPClient.Do(req)
         if err != nil {
             log.Printf("Error making request to Spotify: %v", err)
             return SpotifyPlaylistTracksResponse{}, err
         }
-        defer res.Body.Close()
 
-        if res.StatusCode >= 400 {
-            body, err := io.ReadAll(res.Body) 
-            if err != nil {
-                return SpotifyPlaylistTracksResponse{}, fmt.Errorf("error reading response body: %w", err)
-            }
-            return SpotifyPlaylistTracksResponse{}, fmt.Errorf("Spotify API request failed with status %d: %s", res.StatusCode, string(body))
+        if err := utils.CheckResponse("Spotify", res); err != nil {
+            res.Body.Close()
+            return SpotifyPlaylistTracksResponse{}, err
         }
 
         var page SpotifyPlaylistTracksResponse
-        if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+        err = json.NewDecoder(res.Body).Decode(&page)
+        res.Body.Close()
+        if err != nil {
             return SpotifyPlaylistTracksResponse{}, err
         }
 
@@ -333,8 +395,280 @@ func (c *SpotifyClient) GetPlaylistTracks(accessToken, playlistID string) (Spoti
 }
 
 
+// Gets every track in the user's Liked Songs, most recently saved first. Libraries of
+// many thousands of tracks take one request per 50 tracks.
+func (c *SpotifyClient) GetSavedTracks(ctx context.Context, accessToken string) (SpotifyPlaylistTracksResponse, error) {
+    const limit = 50
+    var allTracks []PlaylistTrackItem
+
+    for offset := 0; ; offset += limit {
+        url := fmt.Sprintf("https://api.spotify.com/v1/me/tracks?limit=%d&offset=%d", limit, offset)
+        req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+        if err != nil {
+            return SpotifyPlaylistTracksResponse{}, fmt.Errorf("error creating request: %w", err)
+        }
+        req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+        res, err := c.HTTPClient.Do(req)
+        if err != nil {
+            return SpotifyPlaylistTracksResponse{}, fmt.Errorf("error executing request: %w", err)
+        }
+        if err := utils.CheckResponse("Spotify", res); err != nil {
+            res.Body.Close()
+            return SpotifyPlaylistTracksResponse{}, err
+        }
+
+        var page struct {
+            Items []PlaylistTrackItem `json:"items"`
+            Next  *string             `json:"next"`
+        }
+        err = json.NewDecoder(res.Body).Decode(&page)
+        res.Body.Close()
+        if err != nil {
+            return SpotifyPlaylistTracksResponse{}, err
+        }
+
+        allTracks = append(allTracks, page.Items...)
+        if page.Next == nil || len(page.Items) == 0 {
+            break
+        }
+    }
+
+    return SpotifyPlaylistTracksResponse{Items: allTracks}, nil
+}
+
+// Saves items to, or with remove set removes them from, the user's library at the given endpoint.
+// The endpoints take bare IDs, so the URIs are stripped of uriPrefix.
+// Returns the URIs updated, even alongside an error for the chunks updated before it.
+func (c *SpotifyClient) updateLibrary(ctx context.Context, accessToken, endpoint, uriPrefix string, uris []string, maxItemsPerRequest int, remove bool) ([]string, error) {
+    updated := []string{}
+    method := "PUT"
+    if remove {
+        method = "DELETE"
+    }
+    for i := 0; i < len(uris); i += maxItemsPerRequest {
+        if err := ctx.Err(); err != nil {
+            return updated, err
+        }
+        end := min(i + maxItemsPerRequest, len(uris))
+
+        ids := make([]string, 0, end - i)
+        for _, uri := range uris[i:end] {
+            ids = append(ids, strings.TrimPrefix(uri, uriPrefix))
+        }
+        payloadBytes, err := json.Marshal(map[string][]string{"ids": ids})
+        if err != nil {
+            return updated, fmt.Errorf("error marshaling payload: %w", err)
+        }
+
+        req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewBuffer(payloadBytes))
+        if err != nil {
+            return updated, fmt.Errorf("error creating request: %w", err)
+        }
+        req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+        req.Header.Add("Content-Type", "application/json")
+
+        res, err := c.HTTPClient.Do(req)
+        if err != nil {
+            return updated, fmt.Errorf("error executing request: %w", err)
+        }
+        err = utils.CheckResponse("Spotify", res)
+        res.Body.Close()
+        if err != nil {
+            return updated, err
+        }
+        updated = append(updated, uris[i:end]...)
+    }
+    return updated, nil
+}
+
+// Saves tracks to, or with remove set removes them from, the user's Liked Songs
+func (c *SpotifyClient) updateSavedTracks(ctx context.Context, accessToken string, trackURIs []string, remove bool) ([]string, error) {
+    return c.updateLibrary(ctx, accessToken, "https://api.spotify.com/v1/me/tracks", "spotify:track:", trackURIs, 50, remove)
+}
+
+// Saves tracks to the user's Liked Songs. Returns the URIs saved, even alongside an error.
+func (c *SpotifyClient) SaveTracks(ctx context.Context, accessToken string, trackURIs []string) ([]string, error) {
+    return c.updateSavedTracks(ctx, accessToken, trackURIs, false)
+}
+
+// Removes tracks from the user's Liked Songs
+func (c *SpotifyClient) RemoveSavedTracks(ctx context.Context, accessToken string, trackURIs []string) error {
+    _, err := c.updateSavedTracks(ctx, accessToken, trackURIs, true)
+    return err
+}
+
+// Sends a GET request to the Spotify API and decodes the JSON response into response
+func (c *SpotifyClient) getJSON(ctx context.Context, accessToken, requestURL string, response any) error {
+    req, err := http.NewRequestWithContext(ctx, "GET", requestURL, nil)
+    if err != nil {
+        return fmt.Errorf("error creating request: %w", err)
+    }
+    req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+    res, err := c.HTTPClient.Do(req)
+    if err != nil {
+        return fmt.Errorf("error executing request: %w", err)
+    }
+    defer res.Body.Close()
+    if err := utils.CheckResponse("Spotify", res); err != nil {
+        return err
+    }
+
+    if err := json.NewDecoder(res.Body).Decode(response); err != nil {
+        return fmt.Errorf("error decoding response: %w", err)
+    }
+    return nil
+}
+
+// Gets the details of any playlist the user can see, including other users' public playlists.
+// Spotify answers 404 for playlists that are private or do not exist.
+func (c *SpotifyClient) GetPlaylist(ctx context.Context, accessToken, playlistID string) (PlaylistItem, error) {
+    fields := "id,name,description,images,external_urls,owner(id,display_name),public,snapshot_id,tracks.total,uri"
+    playlistURL := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s?fields=%s", url.PathEscape(playlistID), url.QueryEscape(fields))
+
+    var playlist PlaylistItem
+    if err := c.getJSON(ctx, accessToken, playlistURL, &playlist); err != nil {
+        return PlaylistItem{}, err
+    }
+    return playlist, nil
+}
+
+// Gets every album in the user's library, most recently saved first
+func (c *SpotifyClient) GetSavedAlbums(ctx context.Context, accessToken string) ([]SpotifyAlbum, error) {
+    const limit = 50
+    var albums []SpotifyAlbum
+
+    for offset := 0; ; offset += limit {
+        var page struct {
+            Items []struct {
+                Album SpotifyAlbum `json:"album"`
+            } `json:"items"`
+            Next *string `json:"next"`
+        }
+        pageURL := fmt.Sprintf("https://api.spotify.com/v1/me/albums?limit=%d&offset=%d", limit, offset)
+        if err := c.getJSON(ctx, accessToken, pageURL, &page); err != nil {
+            return nil, err
+        }
+
+        for _, item := range page.Items {
+            albums = append(albums, item.Album)
+        }
+        if page.Next == nil || len(page.Items) == 0 {
+            break
+        }
+    }
+
+    return albums, nil
+}
+
+// Saves albums to the user's library. Returns the URIs saved, even alongside an error.
+func (c *SpotifyClient) SaveAlbums(ctx context.Context, accessToken string, albumURIs []string) ([]string, error) {
+    return c.updateLibrary(ctx, accessToken, "https://api.spotify.com/v1/me/albums", "spotify:album:", albumURIs, 20, false)
+}
+
+// Removes albums from the user's library
+func (c *SpotifyClient) RemoveSavedAlbums(ctx context.Context, accessToken string, albumURIs []string) error {
+    _, err := c.updateLibrary(ctx, accessToken, "https://api.spotify.com/v1/me/albums", "spotify:album:", albumURIs, 20, true)
+    return err
+}
+
+const followedArtistsURL = "https://api.spotify.com/v1/me/following?type=artist"
+
+// Gets every artist the user follows. Spotify pages these by cursor rather than offset.
+func (c *SpotifyClient) GetFollowedArtists(ctx context.Context, accessToken string) ([]SpotifyArtist, error) {
+    const limit = 50
+    var artists []SpotifyArtist
+
+    after := ""
+    for {
+        var page struct {
+            Artists struct {
+                Items   []SpotifyArtist `json:"items"`
+                Next    *string         `json:"next"`
+                Cursors struct {
+                    After string `json:"after"`
+                } `json:"cursors"`
+            } `json:"artists"`
+        }
+        pageURL := fmt.Sprintf("%s&limit=%d", followedArtistsURL, limit)
+        if after != "" {
+            pageURL += "&after=" + url.QueryEscape(after)
+        }
+        if err := c.getJSON(ctx, accessToken, pageURL, &page); err != nil {
+            return nil, err
+        }
+
+        artists = append(artists, page.Artists.Items...)
+        after = page.Artists.Cursors.After
+        if page.Artists.Next == nil || after == "" || len(page.Artists.Items) == 0 {
+            break
+        }
+    }
+
+    return artists, nil
+}
+
+// Follows artists on the user's behalf. Returns the URIs followed, even alongside an error.
+func (c *SpotifyClient) FollowArtists(ctx context.Context, accessToken string, artistURIs []string) ([]string, error) {
+    return c.updateLibrary(ctx, accessToken, followedArtistsURL, "spotify:artist:", artistURIs, 50, false)
+}
+
+// Unfollows artists on the user's behalf
+func (c *SpotifyClient) UnfollowArtists(ctx context.Context, accessToken string, artistURIs []string) error {
+    _, err := c.updateLibrary(ctx, accessToken, followedArtistsURL, "spotify:artist:", artistURIs, 50, true)
+    return err
+}
+
+// Number of results fetched per album or artist search. Only the best match is used.
+const collectionSearchLimit = 1
+
+// Searches for an album by its UPC, or by name and artist when the UPC is not known.
+// Finding nothing is not an error.
+func (c *SpotifyClient) SearchAlbums(ctx context.Context, accessToken, upc, albumName, artistName string) ([]SpotifyAlbum, error) {
+    query := fmt.Sprintf("upc:%s", upc)
+    if upc == "" {
+        query = fmt.Sprintf("album:%s", albumName)
+        if artistName != "" {
+            query += fmt.Sprintf(" artist:%s", artistName)
+        }
+    }
+    params := url.Values{}
+    params.Add("q", query)
+    params.Add("type", "album")
+    params.Add("limit", fmt.Sprintf("%d", collectionSearchLimit))
+
+    var response struct {
+        Albums struct {
+            Items []SpotifyAlbum `json:"items"`
+        } `json:"albums"`
+    }
+    if err := c.getJSON(ctx, accessToken, "https://api.spotify.com/v1/search?" + params.Encode(), &response); err != nil {
+        return nil, err
+    }
+    return response.Albums.Items, nil
+}
+
+// Searches for an artist by name. Finding nothing is not an error.
+func (c *SpotifyClient) SearchArtists(ctx context.Context, accessToken, artistName string) ([]SpotifyArtist, error) {
+    params := url.Values{}
+    params.Add("q", artistName)
+    params.Add("type", "artist")
+    params.Add("limit", fmt.Sprintf("%d", collectionSearchLimit))
+
+    var response struct {
+        Artists struct {
+            Items []SpotifyArtist `json:"items"`
+        } `json:"artists"`
+    }
+    if err := c.getJSON(ctx, accessToken, "https://api.spotify.com/v1/search?" + params.Encode(), &response); err != nil {
+        return nil, err
+    }
+    return response.Artists.Items, nil
+}
+
 // Creates a new playlist
-func (c *SpotifyClient) CreatePlaylist(accessToken, spotifyUserID string, playlistPayload CreatePlaylistPayload) (string, error) {
+func (c *SpotifyClient) CreatePlaylist(ctx context.Context, accessToken, spotifyUserID string, playlistPayload CreatePlaylistPayload) (string, error) {
     url := fmt.Sprintf("https://api.spotify.com/v1/users/%s/playlists", spotifyUserID)
     
     payload, err := json.Marshal(playlistPayload)
@@ -342,7 +676,7 @@ func (c *SpotifyClient) CreatePlaylist(accessToken, spotifyUserID string, playli
         return "", fmt.Errorf("error marshaling payload: %w", err)
 	}
     
-    req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
+    req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(payload))
 	if err != nil {
         return "", fmt.Errorf("error creating request: %w", err)
     }
@@ -350,28 +684,21 @@ func (c *SpotifyClient) CreatePlaylist(accessToken, spotifyUserID string, playli
     req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
     req.Header.Add("Content-Type", "application/json")
 
-    res, err := http.DefaultClient.Do(req)
+    res, err := c.HTTPClient.Do(req)
     if err != nil {
         return "", fmt.Errorf("error executing request: %w", err)
     }
     defer res.Body.Close()
 
+    if err := utils.CheckResponse("Spotify", res); err != nil {
+        log.Printf("spotify API error: %v", err)
+        return "", err
+    }
+
     body, err := io.ReadAll(res.Body)
     if err != nil {
         return "", fmt.Errorf("error reading response body: %w", err)
     }
-
-    if res.StatusCode >= 400 {
-        var spotifyError struct {
-            Error struct {
-                Status  int    `json:"status"`
-                Message string `json:"message"`
-            } `json:"error"`
-        }
-        json.Unmarshal(body, &spotifyError) 
-        log.Printf("spotify API error: %d %s", spotifyError.Error.Status, spotifyError.Error.Message)
-        return "", fmt.Errorf("spotify API error: %d %s", spotifyError.Error.Status, spotifyError.Error.Message)
-    }
     var responseBody struct {
         ID string `json:"id"`
     }
@@ -386,15 +713,26 @@ func (c *SpotifyClient) CreatePlaylist(accessToken, spotifyUserID string, playli
 
 type AddItemsToPlaylistPayload struct {
     ItemURIs []string   `json:"uris"`
-    Position int        `json:"position"`
+    Position *int       `json:"position,omitempty"` // zero-based; items are appended when omitted
+}
+
+type AddedPlaylistItems struct {
+    URIs       []string
+    SnapshotID string  // the playlist version after the last chunk added
 }
 
-// Adds items to an existing Spotify playlist
-func (c *SpotifyClient) AddItemsToPlaylist(accessToken, playlistID string, addItemsPayload AddItemsToPlaylistPayload) error {
+// Adds items to an existing Spotify playlist, keeping their order when they are inserted at a position.
+// Returns the URIs of the items added, even alongside an error for the chunks added before it.
+func (c *SpotifyClient) AddItemsToPlaylist(ctx context.Context, accessToken, playlistID string, addItemsPayload AddItemsToPlaylistPayload) (AddedPlaylistItems, error) {
+    added := AddedPlaylistItems{URIs: []string{}}
     const maxItemsPerRequest = 100
 
     // Split ItemURIs into chunks of up to 100
     for i := 0; i < len(addItemsPayload.ItemURIs); i += maxItemsPerRequest {
+        // Stop between chunks once the request is cancelled
+        if err := ctx.Err(); err != nil {
+            return added, err
+        }
         end := i + maxItemsPerRequest
         if end > len(addItemsPayload.ItemURIs) {
             end = len(addItemsPayload.ItemURIs)
@@ -402,47 +740,166 @@ func (c *SpotifyClient) AddItemsToPlaylist(accessToken, playlistID string, addIt
         
         chunk := AddItemsToPlaylistPayload{
             ItemURIs: addItemsPayload.ItemURIs[i:end],
-            Position: addItemsPayload.Position,
+        }
+        if addItemsPayload.Position != nil {
+            position := *addItemsPayload.Position + i
+            chunk.Position = &position
         }
         
-        if err := c.addItemsChunkToPlaylist(accessToken, playlistID, chunk); err != nil {
-            return err
+        snapshotID, err := c.addItemsChunkToPlaylist(ctx, accessToken, playlistID, chunk)
+        if err != nil {
+            return added, err
         }
+        added.URIs = append(added.URIs, chunk.ItemURIs...)
+        added.SnapshotID = snapshotID
     }
 
-    return nil
+    return added, nil
 }
 
-// Helper function to add a chunk of items to the playlist
-func (c *SpotifyClient) addItemsChunkToPlaylist(accessToken, playlistID string, payload AddItemsToPlaylistPayload) error {
+// Helper function to add a chunk of items to the playlist. Returns the playlist's snapshot ID after the addition.
+func (c *SpotifyClient) addItemsChunkToPlaylist(ctx context.Context, accessToken, playlistID string, payload AddItemsToPlaylistPayload) (string, error) {
     url := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/tracks", playlistID)
+    var response struct {
+        SnapshotID string `json:"snapshot_id"`
+    }
+    if err := c.sendJSON(ctx, accessToken, "POST", url, payload, &response); err != nil {
+        return "", err
+    }
+    return response.SnapshotID, nil
+}
 
+type RemoveItemsFromPlaylistPayload struct {
+    Tracks     []PlaylistItemURI `json:"tracks"`
+    SnapshotID string            `json:"snapshot_id,omitempty"` // the playlist version the removal applies to
+}
+
+type PlaylistItemURI struct {
+    URI       string `json:"uri"`
+    Positions []int  `json:"positions,omitempty"` // only these occurrences, counted in the snapshot given; every one when empty
+}
+
+// Removes every occurrence of the given items from a Spotify playlist
+func (c *SpotifyClient) RemoveItemsFromPlaylist(ctx context.Context, accessToken, playlistID string, itemURIs []string) error {
+    items := make([]PlaylistItemURI, 0, len(itemURIs))
+    for _, uri := range itemURIs {
+        items = append(items, PlaylistItemURI{URI: uri})
+    }
+    _, err := c.RemovePlaylistItems(ctx, accessToken, playlistID, "", items)
+    return err
+}
+
+// Removes the given items from a Spotify playlist as of the given snapshot, or the latest one when snapshotID
+// is empty. Items with positions lose only the occurrences at those positions; the others lose every occurrence.
+// Returns the playlist's snapshot ID after the removal.
+func (c *SpotifyClient) RemovePlaylistItems(ctx context.Context, accessToken, playlistID, snapshotID string, items []PlaylistItemURI) (string, error) {
+    const maxItemsPerRequest = 100
+
+    items = splitPositions(items)
+    url := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/tracks", playlistID)
+    for i := 0; i < len(items); i += maxItemsPerRequest {
+        end := i + maxItemsPerRequest
+        if end > len(items) {
+            end = len(items)
+        }
+
+        // Each chunk applies to the snapshot the previous one produced
+        payload := RemoveItemsFromPlaylistPayload{Tracks: items[i:end], SnapshotID: snapshotID}
+        var response struct {
+            SnapshotID string `json:"snapshot_id"`
+        }
+        if err := c.sendJSON(ctx, accessToken, "DELETE", url, payload, &response); err != nil {
+            return "", err
+        }
+        snapshotID = response.SnapshotID
+    }
+
+    return snapshotID, nil
+}
+
+// Gives each position its own item, highest first, so removing one chunk never shifts the positions of the next
+func splitPositions(items []PlaylistItemURI) []PlaylistItemURI {
+    var whole, positioned []PlaylistItemURI
+    for _, item := range items {
+        if len(item.Positions) == 0 {
+            whole = append(whole, item)
+            continue
+        }
+        for _, position := range item.Positions {
+            positioned = append(positioned, PlaylistItemURI{URI: item.URI, Positions: []int{position}})
+        }
+    }
+    sort.SliceStable(positioned, func(i, j int) bool {
+        return positioned[i].Positions[0] > positioned[j].Positions[0]
+    })
+    return append(positioned, whole...)
+}
+
+// Sends a JSON payload and decodes the JSON response into response, unless it is nil
+func (c *SpotifyClient) sendJSON(ctx context.Context, accessToken, method, requestURL string, payload, response any) error {
     payloadBytes, err := json.Marshal(payload)
     if err != nil {
         return fmt.Errorf("error marshaling payload: %w", err)
     }
 
-    req, err := http.NewRequest("POST", url, bytes.NewBuffer(payloadBytes))
+    req, err := http.NewRequestWithContext(ctx, method, requestURL, bytes.NewBuffer(payloadBytes))
     if err != nil {
         return fmt.Errorf("error creating request: %w", err)
     }
     req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
     req.Header.Add("Content-Type", "application/json")
 
-    res, err := http.DefaultClient.Do(req)
+    res, err := c.HTTPClient.Do(req)
     if err != nil {
         return fmt.Errorf("error executing request: %w", err)
     }
     defer res.Body.Close()
 
-    if res.StatusCode >= 400 {
-        body, _ := io.ReadAll(res.Body)
-        return fmt.Errorf("spotify API error (status code %d): %s", res.StatusCode, string(body))
+    if err := utils.CheckResponse("Spotify", res); err != nil {
+        return err
+    }
+    if response == nil {
+        return nil
+    }
+    if err := json.NewDecoder(res.Body).Decode(response); err != nil {
+        return fmt.Errorf("error unmarshaling response body: %w", err)
     }
-
     return nil
 }
 
+// Fields left out are not changed
+type UpdatePlaylistPayload struct {
+    Name          string  `json:"name,omitempty"`
+    Public        *bool   `json:"public,omitempty"`
+    Collaborative *bool   `json:"collaborative,omitempty"`  // to be true public must be false
+    Description   *string `json:"description,omitempty"`  // an empty description clears it
+}
+
+// Changes a playlist's name, description or privacy
+func (c *SpotifyClient) UpdatePlaylist(ctx context.Context, accessToken, playlistID string, payload UpdatePlaylistPayload) error {
+    url := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s", playlistID)
+    return c.sendJSON(ctx, accessToken, "PUT", url, payload, nil)
+}
+
+type ReorderPlaylistItemsPayload struct {
+    RangeStart   int    `json:"range_start"`  // position of the first item to move
+    RangeLength  int    `json:"range_length"`  // number of consecutive items to move
+    InsertBefore int    `json:"insert_before"`  // position the items are moved in front of, as counted before the move
+    SnapshotID   string `json:"snapshot_id,omitempty"`
+}
+
+// Moves a range of items within a playlist. Returns the playlist's snapshot ID after the move.
+func (c *SpotifyClient) ReorderPlaylistItems(ctx context.Context, accessToken, playlistID string, payload ReorderPlaylistItemsPayload) (string, error) {
+    url := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/tracks", playlistID)
+    var response struct {
+        SnapshotID string `json:"snapshot_id"`
+    }
+    if err := c.sendJSON(ctx, accessToken, "PUT", url, payload, &response); err != nil {
+        return "", err
+    }
+    return response.SnapshotID, nil
+}
+
 type SpotifySearchResponse struct {
     Tracks struct {
         Items []struct {
@@ -527,9 +984,9 @@ func (c *SpotifyClient) buildSearchURL(artistName, trackTitle, videoTitle string
 
 
 // Retrieves Spotify tracks that match the provided artist name and track title
-func (c *SpotifyClient) SearchTracksUsingArtistAndTrack(accessToken, artistName, trackTitle string, limit, offset int) ([]utils.UnifiedTrackSearchResult, error) {    
+func (c *SpotifyClient) SearchTracksUsingArtistAndTrack(ctx context.Context, accessToken, artistName, trackTitle string, limit, offset int) ([]utils.UnifiedTrackSearchResult, error) {    
     url := c.buildSearchURL(artistName, trackTitle, "",  limit, offset)    
-    req, err := http.NewRequest("GET", url, nil)
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
     if err != nil {
         return nil, fmt.Errorf("error creating request %w", err)
     }
@@ -538,20 +995,18 @@ func (c *SpotifyClient) SearchTracksUsingArtistAndTrack(accessToken, artistName,
 
     req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 
-    res, err := http.DefaultClient.Do(req)
+    res, err := c.HTTPClient.Do(req)
     if err != nil {
         return nil, fmt.Errorf("error executing request: %w", err)
     }
 
+    defer res.Body.Close()
+
     log.Printf("Received Spotify API response with status code: %d", res.StatusCode)
-    if res.StatusCode != http.StatusOK {
-        bodyBytes, err := io.ReadAll(res.Body)
-        if err == nil {
-            log.Printf("Spotify API response body: %s", string(bodyBytes))
-        }
+    if err := utils.CheckResponse("Spotify", res); err != nil {
+        log.Printf("Spotify search failed: %v", err)
+        return nil, err
     }
-    
-    defer res.Body.Close()
 
     var response SpotifySearchResponse
     if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
@@ -559,7 +1014,7 @@ func (c *SpotifyClient) SearchTracksUsingArtistAndTrack(accessToken, artistName,
     }
 
     if len(response.Tracks.Items) == 0 {
-        return nil, fmt.Errorf("no tracks found for '%s' by '%s'", trackTitle, artistName)
+        return nil, fmt.Errorf("%w for '%s' by '%s'", ErrNoTracksFound, trackTitle, artistName)
     }
 
     tracksFound := processSpotifySearchResponse(response)
@@ -569,10 +1024,10 @@ func (c *SpotifyClient) SearchTracksUsingArtistAndTrack(accessToken, artistName,
 
 // Retrieves a Spotify track that matches the given YouTube video title.
 // Sacrifices precision for higher chances of returning a result. 
-func (c *SpotifyClient) SearchTracksUsingVideoTitle(accessToken, videoTitle string) ([]utils.UnifiedTrackSearchResult, error) {    
+func (c *SpotifyClient) SearchTracksUsingVideoTitle(ctx context.Context, accessToken, videoTitle string) ([]utils.UnifiedTrackSearchResult, error) {    
     url := c.buildSearchURL("", "", videoTitle, 1, 0)
 
-    req, err := http.NewRequest("GET", url, nil)
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
     if err != nil {
         return nil, fmt.Errorf("error creating request %w", err)
     }
@@ -581,20 +1036,18 @@ func (c *SpotifyClient) SearchTracksUsingVideoTitle(accessToken, videoTitle stri
 
     req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 
-    res, err := http.DefaultClient.Do(req)
+    res, err := c.HTTPClient.Do(req)
     if err != nil {
         return nil, fmt.Errorf("error executing request: %w", err)
     }
 
+    defer res.Body.Close()
+
     log.Printf("Received Spotify API response with status code: %d", res.StatusCode)
-    if res.StatusCode != http.StatusOK {
-        bodyBytes, err := io.ReadAll(res.Body)
-        if err == nil {
-            log.Printf("Spotify API response body: %s", string(bodyBytes))
-        }
+    if err := utils.CheckResponse("Spotify", res); err != nil {
+        log.Printf("Spotify search failed: %v", err)
+        return nil, err
     }
-    
-    defer res.Body.Close()
 
     var response SpotifySearchResponse
     if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
@@ -602,7 +1055,7 @@ func (c *SpotifyClient) SearchTracksUsingVideoTitle(accessToken, videoTitle stri
     }
 
     if len(response.Tracks.Items) == 0 {
-        return nil, fmt.Errorf("no tracks found for YouTube video title %s", videoTitle)
+        return nil, fmt.Errorf("%w for YouTube video title %s", ErrNoTracksFound, videoTitle)
     }
 
     tracksFound := processSpotifySearchResponse(response)
@@ -611,26 +1064,48 @@ func (c *SpotifyClient) SearchTracksUsingVideoTitle(accessToken, videoTitle stri
 }
 
 // Deletes (unfollows) a playlist in the target user's account
-func (c *SpotifyClient) DeletePlaylist(accessToken, playlistID string) error {
+func (c *SpotifyClient) DeletePlaylist(ctx context.Context, accessToken, playlistID string) error {
     url := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/followers", playlistID)
 
-    req, err := http.NewRequest("DELETE", url, nil)
+    req, err := http.NewRequestWithContext(ctx, "DELETE", url, nil)
     if err != nil {
         return fmt.Errorf("error creating request for DeletePlaylist: %w", err)
     }
 
     req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
 
-    res, err := http.DefaultClient.Do(req)
+    res, err := c.HTTPClient.Do(req)
     if err != nil {
         return fmt.Errorf("error executing DeletePlaylist request: %w", err)
     }
     defer res.Body.Close()
 
-    if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent {
-        body, _ := io.ReadAll(res.Body)
-        return fmt.Errorf("spotify API error (status code %d): %s", res.StatusCode, string(body))
+    return utils.CheckResponse("Spotify", res)
+}
+
+// Largest cover image Spotify accepts, measured after base64 encoding
+const MaxPlaylistImageSize = 256 << 10
+
+// Replaces a playlist's cover image with a JPEG image
+func (c *SpotifyClient) UploadPlaylistImage(ctx context.Context, accessToken, playlistID string, jpegData []byte) error {
+    encoded := base64.StdEncoding.EncodeToString(jpegData)
+    if len(encoded) > MaxPlaylistImageSize {
+        return fmt.Errorf("playlist image of %d bytes exceeds Spotify's limit of %d", len(encoded), MaxPlaylistImageSize)
     }
 
-    return nil
-}
\ No newline at end of file
+    url := fmt.Sprintf("https://api.spotify.com/v1/playlists/%s/images", playlistID)
+    req, err := http.NewRequestWithContext(ctx, "PUT", url, strings.NewReader(encoded))
+    if err != nil {
+        return fmt.Errorf("error creating request: %w", err)
+    }
+    req.Header.Add("Authorization", fmt.Sprintf("Bearer %s", accessToken))
+    req.Header.Add("Content-Type", "image/jpeg")
+
+    res, err := c.HTTPClient.Do(req)
+    if err != nil {
+        return fmt.Errorf("error executing request: %w", err)
+    }
+    defer res.Body.Close()
+
+    return utils.CheckResponse("Spotify", res)
+}
@@ -1,6 +1,7 @@
 package spotify
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -28,7 +29,7 @@ func NewSpotifyHandler(spotifyService SpotifyServiceInterface) *SpotifyHandler {
 
 // Sends the session ID and redirect auth URL to the frontend
 func (h *SpotifyHandler) LoginHandler(c *gin.Context) {
-    authURL, sessionID, err := h.SpotifyService.StartLoginFlow()
+    authURL, sessionID, err := h.SpotifyService.StartLoginFlow(c.Request.Context())
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
         return
@@ -54,9 +55,12 @@ func (h *SpotifyHandler) CallbackHandler(c *gin.Context) {
         return
     }
 
-    err := h.SpotifyService.HandleCallback(req.Code, req.UserID, req.SessionID)
+    err := h.SpotifyService.HandleCallback(c.Request.Context(), req.Code, req.UserID, req.SessionID)
     if err != nil {
         log.Printf("Error handling callback: %v\n", err)
+        if (errors.Is(err, utils.ErrUpstreamUnavailable) || errors.Is(err, utils.ErrStorageUnavailable)) && upstreamError(c, err) {
+            return
+        }
         statusCode := http.StatusInternalServerError
         if strings.Contains(err.Error(), "empty access token") {
             statusCode = http.StatusBadRequest
@@ -81,9 +85,12 @@ func (h *SpotifyHandler) CheckAuthHandler(c *gin.Context) {
         return
     }
 
-    userMetadata, err := h.SpotifyService.GetAuth0Service().GetUserMetadata(userID) 
+    userMetadata, err := h.SpotifyService.GetAuth0Service().GetUserMetadata(c.Request.Context(), userID) 
     if err != nil {
         log.Printf("Error getting Auth0 user metadata: %v", err)
+        if (errors.Is(err, utils.ErrUpstreamUnavailable) || errors.Is(err, utils.ErrStorageUnavailable)) && upstreamError(c, err) {
+            return
+        }
         c.JSON(http.StatusInternalServerError, gin.H{"error": err})
 		return
     }
@@ -94,7 +101,8 @@ func (h *SpotifyHandler) CheckAuthHandler(c *gin.Context) {
 // Handles a Spotify logout(de-authentication)
 func (h *SpotifyHandler) LogoutHandler(c *gin.Context) {
     var req struct {
-        UserID string `json:"userID"`
+        UserID    string `json:"userID"`
+        AccountID string `json:"accountID"`  // optional; logs out of every linked account when empty
     }
     if err := c.BindJSON(&req); err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
@@ -107,12 +115,10 @@ func (h *SpotifyHandler) LogoutHandler(c *gin.Context) {
         return
     }
 
-    clearTokenParams := utils.ClearTokensParams{
-        Party: "spotify", 
-        UserID: userID,
-        AppCtx: *h.SpotifyService.GetAppContext(),
-    }
-    if err := utils.HandleLogout(h.SpotifyService.GetAuth0Service(), clearTokenParams); err != nil {
+    if err := h.SpotifyService.Logout(c.Request.Context(), userID, req.AccountID); err != nil {
+        if (errors.Is(err, utils.ErrUpstreamUnavailable) || errors.Is(err, utils.ErrStorageUnavailable)) && upstreamError(c, err) {
+            return
+        }
         c.JSON(http.StatusInternalServerError, gin.H{"error": err})
         return
     }
@@ -128,9 +134,12 @@ func (h *SpotifyHandler) GetCurrentUserProfileHandler(c *gin.Context) {
         return
     }
 
-    userProfile, err := h.SpotifyService.GetCurrentUserProfile(userID) 
+    userProfile, err := h.SpotifyService.GetCurrentUserProfile(c.Request.Context(), userID, c.Query("accountID")) 
     if err != nil {
         log.Printf("error retrieving Spotify profile: %v", err)
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
         if strings.Contains(err.Error(), "reauthentication required") {
             c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication_required", "message": "Please reauthenticate with Spotify."})
             return
@@ -142,14 +151,19 @@ func (h *SpotifyHandler) GetCurrentUserProfileHandler(c *gin.Context) {
     c.JSON(http.StatusOK, userProfile)
 }
 
-// Handles the retrieval of the current user's Spotify playlists
+// Handles the retrieval of the current user's Spotify playlists.
+// Pages of `limit` playlists (20 by default) follow the `cursor` given as `next` by the previous page,
+// and `all=true` returns every playlist at once. The older `offset` parameter is still accepted.
 func(h *SpotifyHandler) GetCurrentUserPlaylistsHandler(c *gin.Context) {
-    defaultOffset := 0
-
-    offsetStr := c.DefaultQuery("offset", strconv.Itoa(defaultOffset))
-    offset, err := strconv.Atoi(offsetStr)
+    page, err := utils.ParsePageRequest(c.Query("cursor"), c.Query("limit"), c.Query("all"), 20, MaxPlaylistsPageSize)
     if err != nil {
-        offset = defaultOffset
+        c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+        return
+    }
+    if page.Cursor == "" {
+        if offset, err := strconv.Atoi(c.Query("offset")); err == nil && offset > 0 {
+            page.Cursor = utils.OffsetCursor(offset)
+        }
     }
 
     userID := c.Query("userID")
@@ -158,8 +172,15 @@ func(h *SpotifyHandler) GetCurrentUserPlaylistsHandler(c *gin.Context) {
         return
     }
     
-    userPlaylists, err := h.SpotifyService.GetCurrentUserPlaylists(userID, offset)
+    userPlaylists, err := h.SpotifyService.GetCurrentUserPlaylists(c.Request.Context(), userID, c.Query("accountID"), page)
     if err != nil {
+        if errors.Is(err, utils.ErrInvalidPage) {
+            c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+            return
+        }
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
         if strings.Contains(err.Error(), "reauthentication required") {
             c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication_required", "message": "Please reauthenticate with Spotify."})
             return
@@ -185,8 +206,11 @@ func(h *SpotifyHandler) GetPlaylistTracksHandler(c *gin.Context) {
         return
     }
     
-    playlistTracks, err := h.SpotifyService.GetPlaylistTracks(userID, playlistID)
+    playlistTracks, err := h.SpotifyService.GetPlaylistTracks(c.Request.Context(), userID, c.Query("accountID"), playlistID)
     if err != nil {
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
         if strings.Contains(err.Error(), "reauthentication required") {
             c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication_required", "message": "Please reauthenticate with Spotify."})
             return
@@ -206,8 +230,11 @@ func(h *SpotifyHandler) CreatePlaylistHandler(c *gin.Context) {
         return
     }
 
-    newPlaylistID, err := h.SpotifyService.CreatePlaylist(playlistData.UserID, playlistData.SpotifyUserID, playlistData.Payload)
+    newPlaylistID, err := h.SpotifyService.CreatePlaylist(c.Request.Context(), playlistData.UserID, playlistData.AccountID, playlistData.SpotifyUserID, playlistData.Payload)
     if err != nil {
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
         if strings.Contains(err.Error(), "reauthentication required") {
             c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication_required", "message": "Please reauthenticate with Spotify."})
             return
@@ -221,6 +248,7 @@ func(h *SpotifyHandler) CreatePlaylistHandler(c *gin.Context) {
 
 type AddItemsToPlaylistBody struct {
     UserID     string                    `json:"userId"`
+    AccountID  string                    `json:"accountId"`
     PlaylistID string                    `json:"spotifyPlaylistId"`
     Payload    AddItemsToPlaylistPayload `json:"payload"`
 }
@@ -233,8 +261,11 @@ func(h *SpotifyHandler) AddItemsToPlaylistHandler(c *gin.Context) {
         return
     }
 
-    err := h.SpotifyService.AddItemsToPlaylist(playlistItemsData.UserID, playlistItemsData.PlaylistID, playlistItemsData.Payload)
+    _, err := h.SpotifyService.AddItemsToPlaylist(c.Request.Context(), playlistItemsData.UserID, playlistItemsData.AccountID, playlistItemsData.PlaylistID, playlistItemsData.Payload)
     if err != nil {
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
         if strings.Contains(err.Error(), "reauthentication required") {
             c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication_required", "message": "Please reauthenticate with Spotify."})
             return
@@ -246,6 +277,155 @@ func(h *SpotifyHandler) AddItemsToPlaylistHandler(c *gin.Context) {
     c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Successfully add items to playlist with ID: %s", playlistItemsData.PlaylistID)})
 }
 
+type UpdatePlaylistBody struct {
+    UserID     string                `json:"userId"`
+    AccountID  string                `json:"accountId"`
+    PlaylistID string                `json:"spotifyPlaylistId"`
+    Payload    UpdatePlaylistPayload `json:"payload"`
+}
+
+// Handles changing a playlist's name, description or privacy
+func(h *SpotifyHandler) UpdatePlaylistHandler(c *gin.Context) {
+    var updateData UpdatePlaylistBody
+    if err := c.BindJSON(&updateData); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+        return
+    }
+    if updateData.UserID == "" || updateData.PlaylistID == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
+        return
+    }
+    payload := updateData.Payload
+    if payload.Name == "" && payload.Description == nil && payload.Public == nil && payload.Collaborative == nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "payload must change the name, description, public or collaborative"})
+        return
+    }
+    if payload.Collaborative != nil && *payload.Collaborative && (payload.Public == nil || *payload.Public) {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "a collaborative playlist must also set public to false"})
+        return
+    }
+
+    err := h.SpotifyService.UpdatePlaylist(c.Request.Context(), updateData.UserID, updateData.AccountID, updateData.PlaylistID, payload)
+    if err != nil {
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
+        if strings.Contains(err.Error(), "reauthentication required") {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication_required", "message": "Please reauthenticate with Spotify."})
+            return
+        }
+        c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("error updating playlist: %v", err)})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "playlist updated successfully"})
+}
+
+type RemovePlaylistItemsBody struct {
+    UserID     string            `json:"userId"`
+    AccountID  string            `json:"accountId"`
+    PlaylistID string            `json:"spotifyPlaylistId"`
+    SnapshotID string            `json:"snapshotId"`  // the playlist version the items were listed at; required with items
+    ItemURIs   []string          `json:"uris"`        // every occurrence of these tracks is removed
+    Items      []PlaylistItemURI `json:"items"`       // only the occurrences at these positions are removed
+}
+
+// Handles the removal of tracks from a playlist. Tracks given by URI lose every occurrence; tracks given
+// with positions lose only the occurrences at those positions in the snapshot given.
+func(h *SpotifyHandler) RemovePlaylistItemsHandler(c *gin.Context) {
+    var removeData RemovePlaylistItemsBody
+    if err := c.BindJSON(&removeData); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+        return
+    }
+    if removeData.UserID == "" || removeData.PlaylistID == "" || len(removeData.ItemURIs) + len(removeData.Items) == 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
+        return
+    }
+    if len(removeData.ItemURIs) > 0 && len(removeData.Items) > 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "give either uris or items, not both"})
+        return
+    }
+    if len(removeData.Items) > 0 && removeData.SnapshotID == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "snapshotId is required to remove items by position"})
+        return
+    }
+    for _, item := range removeData.Items {
+        if item.URI == "" || len(item.Positions) == 0 {
+            c.JSON(http.StatusBadRequest, gin.H{"error": "each item needs a uri and at least one position"})
+            return
+        }
+        for _, position := range item.Positions {
+            if position < 0 {
+                c.JSON(http.StatusBadRequest, gin.H{"error": "positions must not be negative"})
+                return
+            }
+        }
+    }
+
+    items := removeData.Items
+    for _, uri := range removeData.ItemURIs {
+        items = append(items, PlaylistItemURI{URI: uri})
+    }
+    snapshotID, err := h.SpotifyService.RemovePlaylistItems(c.Request.Context(), removeData.UserID, removeData.AccountID, removeData.PlaylistID, removeData.SnapshotID, items)
+    if err != nil {
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
+        if strings.Contains(err.Error(), "reauthentication required") {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication_required", "message": "Please reauthenticate with Spotify."})
+            return
+        }
+        c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("error removing items from playlist: %v", err)})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Successfully removed items from playlist", "snapshotId": snapshotID})
+}
+
+type ReorderPlaylistItemsBody struct {
+    UserID     string                      `json:"userId"`
+    AccountID  string                      `json:"accountId"`
+    PlaylistID string                      `json:"spotifyPlaylistId"`
+    Payload    ReorderPlaylistItemsPayload `json:"payload"`
+}
+
+// Handles moving a range of items within a playlist. A range length of 0 moves a single item.
+func(h *SpotifyHandler) ReorderPlaylistItemsHandler(c *gin.Context) {
+    var reorderData ReorderPlaylistItemsBody
+    if err := c.BindJSON(&reorderData); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+        return
+    }
+    if reorderData.UserID == "" || reorderData.PlaylistID == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
+        return
+    }
+    payload := reorderData.Payload
+    if payload.RangeLength == 0 {
+        payload.RangeLength = 1
+    }
+    if payload.RangeStart < 0 || payload.RangeLength < 0 || payload.InsertBefore < 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "range_start, range_length and insert_before must not be negative"})
+        return
+    }
+
+    snapshotID, err := h.SpotifyService.ReorderPlaylistItems(c.Request.Context(), reorderData.UserID, reorderData.AccountID, reorderData.PlaylistID, payload)
+    if err != nil {
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
+        if strings.Contains(err.Error(), "reauthentication required") {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication_required", "message": "Please reauthenticate with Spotify."})
+            return
+        }
+        c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("error reordering playlist: %v", err)})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Successfully reordered playlist", "snapshotId": snapshotID})
+}
+
 // Handles the retrieval of track URI given an artist name and track title
 func(h *SpotifyHandler) SearchTracksUsingArtistAndTrackhandler(c *gin.Context) {
     defaultLimit := 20
@@ -279,9 +459,12 @@ func(h *SpotifyHandler) SearchTracksUsingArtistAndTrackhandler(c *gin.Context) {
         return
     }
     
-    tracksFound, err := h.SpotifyService.SearchTracksUsingArtistAndTrack(userID, artistName, trackTitle, limit, offset)
+    tracksFound, err := h.SpotifyService.SearchTracksUsingArtistAndTrack(c.Request.Context(), userID, c.Query("accountID"), artistName, trackTitle, limit, offset)
     if err != nil {
         log.Printf("Search error: %v", err)
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
         if strings.Contains(err.Error(), "reauthentication required") {
             c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication_required", "message": "Please reauthenticate with Spotify."})
             return
@@ -313,9 +496,12 @@ func(h *SpotifyHandler) SearchTracksUsingVideoTitleHandler(c *gin.Context) {
         return
     }
     
-    tracksFound, err := h.SpotifyService.SearchTracksUsingVideoTitle(userID, videoTitle)
+    tracksFound, err := h.SpotifyService.SearchTracksUsingVideoTitle(c.Request.Context(), userID, c.Query("accountID"), videoTitle)
     if err != nil {
         log.Printf("Search error: %v", err)
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
         if strings.Contains(err.Error(), "reauthentication required") {
             c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication_required", "message": "Please reauthenticate with Spotify."})
             return
@@ -346,7 +532,10 @@ func(h *SpotifyHandler) DeletePlaylistHandler(c *gin.Context) {
         return
     }
 
-    if err := h.SpotifyService.DeletePlaylist(userID, playlistID); err != nil {
+    if err := h.SpotifyService.DeletePlaylist(c.Request.Context(), userID, c.Query("accountID"), playlistID); err != nil {
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
         if strings.Contains(err.Error(), "reauthentication required") {
             c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication_required", "message": "Please reauthenticate with Spotify."})
             return
@@ -356,4 +545,94 @@ func(h *SpotifyHandler) DeletePlaylistHandler(c *gin.Context) {
     }
 
     c.JSON(http.StatusOK, gin.H{"message": "playlist deleted successfully"})
-}
\ No newline at end of file
+}
+
+// Responds with 404 if the request selected an account the user has not linked
+func linkedAccountNotFound(c *gin.Context, err error) bool {
+    if !errors.Is(err, utils.ErrLinkedAccountNotFound) {
+        return false
+    }
+    c.JSON(http.StatusNotFound, gin.H{"error": "account_not_found", "message": "No linked Spotify account matches the given accountID."})
+    return true
+}
+
+// Handles the retrieval of the Spotify accounts linked by a user
+func (h *SpotifyHandler) ListLinkedAccountsHandler(c *gin.Context) {
+    userID := c.Query("userID")
+    if userID == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "userID query parameter is required"})
+        return
+    }
+
+    accounts, err := h.SpotifyService.ListLinkedAccounts(c.Request.Context(), userID)
+    if err != nil {
+        log.Printf("Error listing linked Spotify accounts: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list linked accounts"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"accounts": accounts})
+}
+
+// Handles changing which linked Spotify account is used when a request does not select one
+func (h *SpotifyHandler) SetDefaultAccountHandler(c *gin.Context) {
+    var req struct {
+        UserID    string `json:"userId"`
+        AccountID string `json:"accountId"`
+    }
+    if err := c.BindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+        return
+    }
+    if req.UserID == "" || req.AccountID == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
+        return
+    }
+
+    if err := h.SpotifyService.SetDefaultAccount(c.Request.Context(), req.UserID, req.AccountID); err != nil {
+        if linkedAccountNotFound(c, err) {
+            return
+        }
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set default account"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Default account updated"})
+}
+
+// Responds with the status matching a failed Spotify API request, e.g. 429 with Retry-After when Spotify rate limited us
+func upstreamError(c *gin.Context, err error) bool {
+    status, ok := utils.UpstreamErrorStatus(err)
+    if !ok {
+        return false
+    }
+    switch status {
+    case http.StatusTooManyRequests:
+        if retryAfter := utils.RetryAfterSeconds(err); retryAfter > 0 {
+            c.Header("Retry-After", strconv.Itoa(retryAfter))
+        }
+        c.JSON(status, gin.H{"error": "rate_limited", "message": "Spotify is rate limiting requests. Please try again shortly."})
+    case http.StatusUnauthorized:
+        c.JSON(status, gin.H{"error": "authentication_required", "message": "Please reauthenticate with Spotify."})
+    case http.StatusForbidden:
+        c.JSON(status, gin.H{"error": "forbidden", "message": err.Error()})
+    case http.StatusNotFound:
+        c.JSON(status, gin.H{"error": "not_found", "message": err.Error()})
+    case http.StatusGatewayTimeout:
+        c.JSON(status, gin.H{"error": "timeout", "message": "The request took too long to complete."})
+    case http.StatusServiceUnavailable:
+        if retryAfter := utils.RetryAfterSeconds(err); retryAfter > 0 {
+            c.Header("Retry-After", strconv.Itoa(retryAfter))
+        }
+        if errors.Is(err, utils.ErrStorageUnavailable) {
+            c.JSON(status, gin.H{"error": "storage_unavailable", "message": "The server is running in degraded mode. Please try again shortly."})
+            return true
+        }
+        c.JSON(status, gin.H{"error": "upstream_unavailable", "message": err.Error()})
+    case utils.StatusClientClosedRequest:
+        c.AbortWithStatus(status)
+    default:
+        c.JSON(status, gin.H{"error": "upstream_error", "message": err.Error()})
+    }
+    return true
+}
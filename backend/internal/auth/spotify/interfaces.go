@@ -1,21 +1,29 @@
 package spotify
 
 import (
+	"context"
+
 	"github.com/roblieblang/luthien/backend/internal/auth/auth0"
 	"github.com/roblieblang/luthien/backend/internal/utils"
 )
 
 type SpotifyServiceInterface interface {
-	StartLoginFlow() (string, string, error)
-	HandleCallback(code, userID, sessionID string) error
-	GetCurrentUserProfile(userID string) (SpotifyUserProfile, error)
-	GetCurrentUserPlaylists(userID string, offset int) (SpotifyPlaylistsResponse, error)
-	GetPlaylistTracks(userID, playlistID string) (SpotifyPlaylistTracksResponse, error)
-	CreatePlaylist(userID, spotifyUserID string, payload CreatePlaylistPayload) (string, error)
-	AddItemsToPlaylist(userID, playlistID string, payload AddItemsToPlaylistPayload) error
-	SearchTracksUsingArtistAndTrack(userID, artistName, trackTitle string, limit, offset int) ([]utils.UnifiedTrackSearchResult, error)
-	SearchTracksUsingVideoTitle(userID, videoTitle string) ([]utils.UnifiedTrackSearchResult, error)
-	DeletePlaylist(userID, playlistID string) error
+	StartLoginFlow(ctx context.Context) (string, string, error)
+	HandleCallback(ctx context.Context, code, userID, sessionID string) error
+	Logout(ctx context.Context, userID, accountID string) error
+	ListLinkedAccounts(ctx context.Context, userID string) ([]utils.LinkedAccount, error)
+	SetDefaultAccount(ctx context.Context, userID, accountID string) error
+	GetCurrentUserProfile(ctx context.Context, userID, accountID string) (SpotifyUserProfile, error)
+	GetCurrentUserPlaylists(ctx context.Context, userID, accountID string, page utils.PageRequest) (SpotifyPlaylistsResponse, error)
+	GetPlaylistTracks(ctx context.Context, userID, accountID, playlistID string) (SpotifyPlaylistTracksResponse, error)
+	CreatePlaylist(ctx context.Context, userID, accountID, spotifyUserID string, payload CreatePlaylistPayload) (string, error)
+	AddItemsToPlaylist(ctx context.Context, userID, accountID, playlistID string, payload AddItemsToPlaylistPayload) (AddedPlaylistItems, error)
+	RemovePlaylistItems(ctx context.Context, userID, accountID, playlistID, snapshotID string, items []PlaylistItemURI) (string, error)
+	UpdatePlaylist(ctx context.Context, userID, accountID, playlistID string, payload UpdatePlaylistPayload) error
+	ReorderPlaylistItems(ctx context.Context, userID, accountID, playlistID string, payload ReorderPlaylistItemsPayload) (string, error)
+	SearchTracksUsingArtistAndTrack(ctx context.Context, userID, accountID, artistName, trackTitle string, limit, offset int) ([]utils.UnifiedTrackSearchResult, error)
+	SearchTracksUsingVideoTitle(ctx context.Context, userID, accountID, videoTitle string) ([]utils.UnifiedTrackSearchResult, error)
+	DeletePlaylist(ctx context.Context, userID, accountID, playlistID string) error
 	GetAuth0Service() *auth0.Auth0Service
 	GetAppContext() *utils.AppContext
 }
@@ -2,6 +2,7 @@ package spotify
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
@@ -34,14 +35,14 @@ func NewSpotifyService(spotifyClient *SpotifyClient, auth0Service *auth0.Auth0Se
 }
 
 // Completes the initial steps of the authorization code flow with PKCE
-func (s *SpotifyService) StartLoginFlow() (string, string, error) {
+func (s *SpotifyService) StartLoginFlow(ctx context.Context) (string, string, error) {
     sessionID := utils.GenerateSessionID()
     codeVerifier, err := utils.GenerateCodeVerifier(64)
     if err != nil {
         return "","", err
     }
 
-    err = s.AppContext.RedisClient.Set(context.Background(), "spotifyCodeVerifier:" + sessionID, codeVerifier, time.Minute * 10).Err()
+    err = s.AppContext.RedisClient.Set(ctx, "spotifyCodeVerifier:" + sessionID, codeVerifier, time.Minute * 10).Err()
     if err != nil {
         return "","", err
     }
@@ -49,7 +50,9 @@ func (s *SpotifyService) StartLoginFlow() (string, string, error) {
     codeChallenge := utils.SHA256Hash(codeVerifier)
 
     // Request user authorization
-    scope := "user-read-private user-read-email playlist-read-private playlist-read-collaborative playlist-modify-public playlist-modify-private"
+    // Accounts linked before the library, follow and image upload scopes were requested must be relinked to
+    // convert Liked Songs, saved albums or followed artists, or to get cover art copied
+    scope := "user-read-private user-read-email playlist-read-private playlist-read-collaborative playlist-modify-public playlist-modify-private user-library-read user-library-modify user-follow-read user-follow-modify ugc-image-upload"
     params := url.Values{}
     params.Add("client_id", s.AppContext.EnvConfig.SpotifyClientID)
     params.Add("response_type", "code")
@@ -65,8 +68,8 @@ func (s *SpotifyService) StartLoginFlow() (string, string, error) {
 } 
 
 // Handles the callback after user has successfully authorized our app on Spotify's auth page
-func (s *SpotifyService) HandleCallback(code, userID, sessionID string) error {
-    codeVerifier, err := s.AppContext.RedisClient.Get(context.Background(), "spotifyCodeVerifier:"+sessionID).Result()
+func (s *SpotifyService) HandleCallback(ctx context.Context, code, userID, sessionID string) error {
+    codeVerifier, err := s.AppContext.RedisClient.Get(ctx, "spotifyCodeVerifier:"+sessionID).Result()
     if err != nil {
         return fmt.Errorf("error retrieving the code verifier: %v", err)
     }
@@ -79,27 +82,34 @@ func (s *SpotifyService) HandleCallback(code, userID, sessionID string) error {
     payload.Set("client_id", s.AppContext.EnvConfig.SpotifyClientID)
     payload.Set("code_verifier", codeVerifier)
 
-    tokenResponse, err := s.SpotifyClient.RequestToken(payload)
+    tokenResponse, err := s.SpotifyClient.RequestToken(ctx, payload)
     if err != nil {
-        return fmt.Errorf("error requesting access token from Spotify: %v", err)
+        return fmt.Errorf("error requesting access token from Spotify: %w", err)
     }
 
     if tokenResponse.AccessToken == "" {
         return errors.New("empty access token")
     }
 
+    // The Spotify account that was just authorized identifies where its tokens are stored
+    profile, err := s.SpotifyClient.GetCurrentUserProfile(ctx, tokenResponse.AccessToken)
+    if err != nil {
+        return fmt.Errorf("error retrieving profile of the linked Spotify account: %w", err)
+    }
+
     // Store the access token
     params := utils.SetTokenParams{
         TokenKind: "access",
         Party: "spotify",
         UserID: userID, 
+        AccountID: profile.ID,
         Token: tokenResponse.AccessToken,
         ExpiresIn: tokenResponse.ExpiresIn,
         AppCtx: *s.AppContext,
     }
-    err = utils.SetToken(params)
+    err = utils.SetToken(ctx, params)
     if err != nil {
-        return fmt.Errorf("error storing the access token: %v", err)
+        return fmt.Errorf("error storing the access token: %w", err)
     }
 
     // Now store the access token
@@ -107,9 +117,28 @@ func (s *SpotifyService) HandleCallback(code, userID, sessionID string) error {
     params.Token = tokenResponse.RefreshToken
     // This is an arbitrary expiry. SetToken() handles refresh token expiration time
     params.ExpiresIn = 0
-    err = utils.SetToken(params)
+    err = utils.SetToken(ctx, params)
     if err != nil {
-        return fmt.Errorf("error storing the refresh token: %v", err)
+        return fmt.Errorf("error storing the refresh token: %w", err)
+    }
+
+    linkedAccount := utils.LinkedAccount{
+        ID: profile.ID,
+        Label: profile.DisplayName,
+    }
+    if linkedAccount.Label == "" {
+        linkedAccount.Label = profile.ID
+    }
+    if len(profile.Images) > 0 {
+        linkedAccount.ImageURL = profile.Images[0].URL
+    }
+    if err := utils.SaveLinkedAccount(ctx, *s.AppContext, userID, "spotify", linkedAccount); err != nil {
+        return fmt.Errorf("error saving linked Spotify account: %v", err)
+    }
+
+    // Tokens stored before accounts were tracked are superseded by the linked account
+    if err := utils.ClearTokens(ctx, utils.ClearTokensParams{Party: "spotify", UserID: userID, AppCtx: *s.AppContext}); err != nil {
+        log.Printf("Error clearing legacy Spotify tokens for user %s: %v", userID, err)
     }
 
     // Change user's Spotify authentication status to `true` 
@@ -118,143 +147,379 @@ func (s *SpotifyService) HandleCallback(code, userID, sessionID string) error {
             "authenticated_with_spotify": true,
         },
     }
-    if err := s.Auth0Service.UpdateUserMetadata(userID, updatedAuthStatus); err != nil {
-        return fmt.Errorf("error updating user metadata: %v", err)
+    if err := s.Auth0Service.UpdateUserMetadata(ctx, userID, updatedAuthStatus); err != nil {
+        return fmt.Errorf("error updating user metadata: %w", err)
     }
     return nil
 }
 
-// Wrapper service function for GetCurrentUserProfile client function
-func (s *SpotifyService) GetCurrentUserProfile(userID string) (SpotifyUserProfile, error) {
+// Clears the tokens of one linked Spotify account, or of all of them when accountID is empty, and records the unlink.
+// Spotify has no token revocation endpoint; users remove the app grant at spotify.com/account/apps.
+func (s *SpotifyService) Logout(ctx context.Context, userID, accountID string) error {
+    accountIDs := []string{accountID}
+    if accountID == "" {
+        linkedAccounts, err := utils.ListLinkedAccounts(ctx, *s.AppContext, userID, "spotify")
+        if err != nil {
+            return err
+        }
+        // Also clear tokens stored before accounts were tracked
+        accountIDs = []string{""}
+        for _, linkedAccount := range linkedAccounts {
+            accountIDs = append(accountIDs, linkedAccount.ID)
+        }
+    }
+
+    for _, id := range accountIDs {
+        clearTokenParams := utils.ClearTokensParams{
+            Party: "spotify", 
+            UserID: userID,
+            AccountID: id,
+            AppCtx: *s.AppContext,
+        }
+        if err := utils.HandleLogout(ctx, s.Auth0Service, clearTokenParams); err != nil {
+            return err
+        }
+    }
+
+    if err := s.clearCachedResponses(ctx, userID, accountID); err != nil {
+        log.Printf("Error clearing cached Spotify responses: %v", err)
+    }
+
+    if err := utils.RecordUnlink(ctx, *s.AppContext, userID, "spotify", false); err != nil {
+        log.Printf("Error recording Spotify unlink: %v", err)
+    }
+    return nil
+}
+
+// Lists the Spotify accounts the user has linked
+func (s *SpotifyService) ListLinkedAccounts(ctx context.Context, userID string) ([]utils.LinkedAccount, error) {
+    return utils.ListLinkedAccounts(ctx, *s.AppContext, userID, "spotify")
+}
+
+// Sets the Spotify account used when a request does not select one
+func (s *SpotifyService) SetDefaultAccount(ctx context.Context, userID, accountID string) error {
+    return utils.SetDefaultAccount(ctx, *s.AppContext, userID, "spotify", accountID)
+}
+
+// Returns the ID of the linked account the selector picks, the default account if it is empty
+func (s *SpotifyService) ResolveAccountID(ctx context.Context, userID, selector string) (string, error) {
+    return utils.ResolveAccountID(ctx, *s.AppContext, userID, "spotify", selector)
+}
+
+// Gets a valid access token for the selected linked account, or the default account if none is selected
+func (s *SpotifyService) getValidAccessToken(ctx context.Context, userID, accountSelector string) (string, error) {
+    accessToken, _, err := s.getValidAccessTokenForAccount(ctx, userID, accountSelector)
+    return accessToken, err
+}
+
+// Like getValidAccessToken, but also returns the ID of the linked account the token belongs to
+func (s *SpotifyService) getValidAccessTokenForAccount(ctx context.Context, userID, accountSelector string) (string, string, error) {
+    accountID, err := utils.ResolveAccountID(ctx, *s.AppContext, userID, "spotify", accountSelector)
+    if err != nil {
+        return "", "", err
+    }
     params := utils.GetValidAccessTokenParams{
         UserID: userID, 
+        AccountID: accountID,
         Party: "spotify", 
         Service: s.SpotifyClient,
         AppCtx: *s.AppContext,
         Updater: s.Auth0Service,
     }
-    accessToken, err := utils.GetValidAccessToken(params)
+    accessToken, err := utils.GetValidAccessToken(ctx, params)
+    return accessToken, accountID, err
+}
+
+// Gets the current user's profile, revalidating the cached copy with its ETag
+func (s *SpotifyService) GetCurrentUserProfile(ctx context.Context, userID, accountSelector string) (SpotifyUserProfile, error) {
+    accessToken, accountID, err := s.getValidAccessTokenForAccount(ctx, userID, accountSelector)
     if err != nil {
         log.Printf("error getting a valid spotify access token: %v", err)
         return SpotifyUserProfile{}, err
     }
-    return s.SpotifyClient.GetCurrentUserProfile(accessToken)
-}
 
-// Wrapper service function for GetCurrentUserPlaylists client function
-func (s *SpotifyService) GetCurrentUserPlaylists(userID string, offset int) (SpotifyPlaylistsResponse, error) {
-    params := utils.GetValidAccessTokenParams{
-        UserID: userID, 
-        Party: "spotify", 
-        Service: s.SpotifyClient,
-        AppCtx: *s.AppContext,
-        Updater: s.Auth0Service,
+    body, err := s.getRevalidated(ctx, accessToken, responseCacheKey(userID, accountID, "profile"), currentUserProfileURL)
+    if err != nil {
+        return SpotifyUserProfile{}, err
+    }
+    var userProfile SpotifyUserProfile
+    if err := json.Unmarshal(body, &userProfile); err != nil {
+        return SpotifyUserProfile{}, err
     }
-    accessToken, err := utils.GetValidAccessToken(params)
+    return userProfile, nil
+}
+
+// Gets a page of the user's playlists, or all of them in pages of 50. Each page is revalidated with its ETag.
+// Next is set to the cursor of the following page.
+func (s *SpotifyService) GetCurrentUserPlaylists(ctx context.Context, userID, accountSelector string, page utils.PageRequest) (SpotifyPlaylistsResponse, error) {
+    accessToken, accountID, err := s.getValidAccessTokenForAccount(ctx, userID, accountSelector)
     if err != nil {
         return SpotifyPlaylistsResponse{}, err
     }
-    return s.SpotifyClient.GetCurrentUserPlaylists(accessToken, offset)
+
+    if !page.All {
+        offset, err := utils.OffsetFromCursor(page.Cursor)
+        if err != nil {
+            return SpotifyPlaylistsResponse{}, err
+        }
+        return s.getPlaylistsPage(ctx, accessToken, userID, accountID, offset, page.Limit)
+    }
+
+    var all SpotifyPlaylistsResponse
+    for offset := 0; ; offset += MaxPlaylistsPageSize {
+        playlists, err := s.getPlaylistsPage(ctx, accessToken, userID, accountID, offset, MaxPlaylistsPageSize)
+        if err != nil {
+            return SpotifyPlaylistsResponse{}, err
+        }
+        all.Items = append(all.Items, playlists.Items...)
+        all.Total = playlists.Total
+        if playlists.Next == nil || len(playlists.Items) == 0 {
+            break
+        }
+    }
+    return all, nil
 }
 
-// Wrapper service function for GetPlaylistTracks client function
-func (s *SpotifyService) GetPlaylistTracks(userID, playlistID string) (SpotifyPlaylistTracksResponse, error) {
-    params := utils.GetValidAccessTokenParams{
-        UserID: userID, 
-        Party: "spotify", 
-        Service: s.SpotifyClient,
-        AppCtx: *s.AppContext,
-        Updater: s.Auth0Service,
+func (s *SpotifyService) getPlaylistsPage(ctx context.Context, accessToken, userID, accountID string, offset, limit int) (SpotifyPlaylistsResponse, error) {
+    key := responseCacheKey(userID, accountID, fmt.Sprintf("playlists:%d:%d", offset, limit))
+    body, err := s.getRevalidated(ctx, accessToken, key, currentUserPlaylistsURL(offset, limit))
+    if err != nil {
+        return SpotifyPlaylistsResponse{}, err
     }
-    accessToken, err := utils.GetValidAccessToken(params)
+    var playlistsResponse SpotifyPlaylistsResponse
+    if err := json.Unmarshal(body, &playlistsResponse); err != nil {
+        return SpotifyPlaylistsResponse{}, err
+    }
+    if playlistsResponse.Next != nil {
+        next := utils.OffsetCursor(offset + len(playlistsResponse.Items))
+        playlistsResponse.Next = &next
+    }
+    return playlistsResponse, nil
+}
+
+// Gets all tracks of a playlist. The cached list is reused while the playlist's snapshot ID is unchanged.
+func (s *SpotifyService) GetPlaylistTracks(ctx context.Context, userID, accountSelector, playlistID string) (SpotifyPlaylistTracksResponse, error) {
+    accessToken, accountID, err := s.getValidAccessTokenForAccount(ctx, userID, accountSelector)
     if err != nil {
         return SpotifyPlaylistTracksResponse{}, err
     }
-    return s.SpotifyClient.GetPlaylistTracks(accessToken, playlistID)
+    return s.getPlaylistTracksCached(ctx, accessToken, responseCacheKey(userID, accountID, "tracks:"+playlistID), playlistID)
+}
+
+// Wrapper service function for GetPlaylist client function
+func (s *SpotifyService) GetPlaylist(ctx context.Context, userID, accountSelector, playlistID string) (PlaylistItem, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountSelector)
+    if err != nil {
+        return PlaylistItem{}, err
+    }
+    return s.SpotifyClient.GetPlaylist(ctx, accessToken, playlistID)
 }
 
 // Wrapper service function for CreatePlaylist client function
-func (s *SpotifyService) CreatePlaylist(userID, spotifyUserID string, payload CreatePlaylistPayload) (string, error) {
-    params := utils.GetValidAccessTokenParams{
-        UserID: userID, 
-        Party: "spotify", 
-        Service: s.SpotifyClient,
-        AppCtx: *s.AppContext,
-        Updater: s.Auth0Service,
+func (s *SpotifyService) CreatePlaylist(ctx context.Context, userID, accountID, spotifyUserID string, payload CreatePlaylistPayload) (string, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return "", err
+    }
+    return s.SpotifyClient.CreatePlaylist(ctx, accessToken, spotifyUserID, payload)
+}
+
+// Wrapper service function for UploadPlaylistImage client function
+func (s *SpotifyService) UploadPlaylistImage(ctx context.Context, userID, accountID, playlistID string, jpegData []byte) error {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return err
+    }
+    return s.SpotifyClient.UploadPlaylistImage(ctx, accessToken, playlistID, jpegData)
+}
+
+// Wrapper service function for AddItemsToPlaylist client function. Returns the URIs of the items added.
+func (s *SpotifyService) AddItemsToPlaylist(ctx context.Context, userID, accountID, playlistID string, payload AddItemsToPlaylistPayload) (AddedPlaylistItems, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return AddedPlaylistItems{}, err
+    }
+    return s.SpotifyClient.AddItemsToPlaylist(ctx, accessToken, playlistID, payload)
+}
+
+// Wrapper service function for RemoveItemsFromPlaylist client function
+func (s *SpotifyService) RemoveItemsFromPlaylist(ctx context.Context, userID, accountID, playlistID string, itemURIs []string) error {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return err
+    }
+    return s.SpotifyClient.RemoveItemsFromPlaylist(ctx, accessToken, playlistID, itemURIs)
+}
+
+// Wrapper service function for RemovePlaylistItems client function
+func (s *SpotifyService) RemovePlaylistItems(ctx context.Context, userID, accountID, playlistID, snapshotID string, items []PlaylistItemURI) (string, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return "", err
+    }
+    return s.SpotifyClient.RemovePlaylistItems(ctx, accessToken, playlistID, snapshotID, items)
+}
+
+// Wrapper service function for UpdatePlaylist client function
+func (s *SpotifyService) UpdatePlaylist(ctx context.Context, userID, accountID, playlistID string, payload UpdatePlaylistPayload) error {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return err
     }
-    accessToken, err := utils.GetValidAccessToken(params)
+    return s.SpotifyClient.UpdatePlaylist(ctx, accessToken, playlistID, payload)
+}
+
+// Wrapper service function for ReorderPlaylistItems client function
+func (s *SpotifyService) ReorderPlaylistItems(ctx context.Context, userID, accountID, playlistID string, payload ReorderPlaylistItemsPayload) (string, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
     if err != nil {
         return "", err
     }
-    return s.SpotifyClient.CreatePlaylist(accessToken, spotifyUserID, payload)
+    return s.SpotifyClient.ReorderPlaylistItems(ctx, accessToken, playlistID, payload)
 }
 
-// Wrapper service function for AddItemsToPlaylist client function
-func (s *SpotifyService) AddItemsToPlaylist(userID, playlistID string, payload AddItemsToPlaylistPayload) error {
-    params := utils.GetValidAccessTokenParams{
-        UserID: userID, 
-        Party: "spotify", 
-        Service: s.SpotifyClient,
-        AppCtx: *s.AppContext,
-        Updater: s.Auth0Service,
+// Wrapper service function for GetSavedTracks client function
+func (s *SpotifyService) GetSavedTracks(ctx context.Context, userID, accountID string) (SpotifyPlaylistTracksResponse, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return SpotifyPlaylistTracksResponse{}, err
     }
-    accessToken, err := utils.GetValidAccessToken(params)
+    return s.SpotifyClient.GetSavedTracks(ctx, accessToken)
+}
+
+// Wrapper service function for SaveTracks client function
+func (s *SpotifyService) SaveTracks(ctx context.Context, userID, accountID string, trackURIs []string) ([]string, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return nil, err
+    }
+    return s.SpotifyClient.SaveTracks(ctx, accessToken, trackURIs)
+}
+
+// Wrapper service function for RemoveSavedTracks client function
+func (s *SpotifyService) RemoveSavedTracks(ctx context.Context, userID, accountID string, trackURIs []string) error {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
     if err != nil {
         return err
     }
-    return s.SpotifyClient.AddItemsToPlaylist(accessToken, playlistID, payload)
+    return s.SpotifyClient.RemoveSavedTracks(ctx, accessToken, trackURIs)
 }
 
-// Wrapper service function for SearchTracksUsingArtistAndTrack client function
-func (s *SpotifyService) SearchTracksUsingArtistAndTrack(userID, artistName, trackTitle string, limit, offset int) ([]utils.UnifiedTrackSearchResult, error) {
-    params := utils.GetValidAccessTokenParams{
-        UserID: userID, 
-        Party: "spotify", 
-        Service: s.SpotifyClient,
-        AppCtx: *s.AppContext,
-        Updater: s.Auth0Service,
+// Wrapper service function for GetSavedAlbums client function
+func (s *SpotifyService) GetSavedAlbums(ctx context.Context, userID, accountID string) ([]SpotifyAlbum, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return nil, err
     }
-    accessToken, err := utils.GetValidAccessToken(params)
+    return s.SpotifyClient.GetSavedAlbums(ctx, accessToken)
+}
+
+// Wrapper service function for SaveAlbums client function
+func (s *SpotifyService) SaveAlbums(ctx context.Context, userID, accountID string, albumURIs []string) ([]string, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
     if err != nil {
-        log.Printf("Error getting valid access token: %v", err)
         return nil, err
     }
-    return s.SpotifyClient.SearchTracksUsingArtistAndTrack(accessToken, artistName, trackTitle, limit, offset)
+    return s.SpotifyClient.SaveAlbums(ctx, accessToken, albumURIs)
 }
 
-// Wrapper service function for SearchTracksUsingVideoTitle client function
-func (s *SpotifyService) SearchTracksUsingVideoTitle(userID, videoTitle string) ([]utils.UnifiedTrackSearchResult, error) {
-    params := utils.GetValidAccessTokenParams{
-        UserID: userID, 
-        Party: "spotify", 
-        Service: s.SpotifyClient,
-        AppCtx: *s.AppContext,
-        Updater: s.Auth0Service,
+// Wrapper service function for RemoveSavedAlbums client function
+func (s *SpotifyService) RemoveSavedAlbums(ctx context.Context, userID, accountID string, albumURIs []string) error {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return err
+    }
+    return s.SpotifyClient.RemoveSavedAlbums(ctx, accessToken, albumURIs)
+}
+
+// Wrapper service function for GetFollowedArtists client function
+func (s *SpotifyService) GetFollowedArtists(ctx context.Context, userID, accountID string) ([]SpotifyArtist, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return nil, err
+    }
+    return s.SpotifyClient.GetFollowedArtists(ctx, accessToken)
+}
+
+// Wrapper service function for FollowArtists client function
+func (s *SpotifyService) FollowArtists(ctx context.Context, userID, accountID string, artistURIs []string) ([]string, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return nil, err
     }
-    accessToken, err := utils.GetValidAccessToken(params)
+    return s.SpotifyClient.FollowArtists(ctx, accessToken, artistURIs)
+}
+
+// Wrapper service function for UnfollowArtists client function
+func (s *SpotifyService) UnfollowArtists(ctx context.Context, userID, accountID string, artistURIs []string) error {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return err
+    }
+    return s.SpotifyClient.UnfollowArtists(ctx, accessToken, artistURIs)
+}
+
+// Wrapper service function for SearchAlbums client function
+func (s *SpotifyService) SearchAlbums(ctx context.Context, userID, accountSelector, upc, albumName, artistName string) ([]SpotifyAlbum, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountSelector)
+    if err != nil {
+        return nil, err
+    }
+    return s.SpotifyClient.SearchAlbums(ctx, accessToken, upc, albumName, artistName)
+}
+
+// Wrapper service function for SearchArtists client function
+func (s *SpotifyService) SearchArtists(ctx context.Context, userID, accountSelector, artistName string) ([]SpotifyArtist, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountSelector)
+    if err != nil {
+        return nil, err
+    }
+    return s.SpotifyClient.SearchArtists(ctx, accessToken, artistName)
+}
+
+// Wrapper service function for SearchTracksUsingArtistAndTrack client function. Results are cached briefly.
+func (s *SpotifyService) SearchTracksUsingArtistAndTrack(ctx context.Context, userID, accountSelector, artistName, trackTitle string, limit, offset int) ([]utils.UnifiedTrackSearchResult, error) {
+    accessToken, accountID, err := s.getValidAccessTokenForAccount(ctx, userID, accountSelector)
     if err != nil {
         log.Printf("Error getting valid access token: %v", err)
         return nil, err
     }
-    return s.SpotifyClient.SearchTracksUsingVideoTitle(accessToken, videoTitle)
+
+    key := responseCacheKey(userID, accountID, searchCacheResource(s.SpotifyClient.buildSearchURL(artistName, trackTitle, "", limit, offset)))
+    return s.searchCached(ctx, key, func() ([]utils.UnifiedTrackSearchResult, error) {
+        return s.SpotifyClient.SearchTracksUsingArtistAndTrack(ctx, accessToken, artistName, trackTitle, limit, offset)
+    })
 }
 
-// Wrapper service function for DeletePlaylist client function
-func (s *SpotifyService) DeletePlaylist(userID, playlistID string) error {
-    params := utils.GetValidAccessTokenParams{
-        UserID: userID, 
-        Party: "spotify", 
-        Service: s.SpotifyClient,
-        AppCtx: *s.AppContext,
-        Updater: s.Auth0Service,
+// Wrapper service function for SearchTracksUsingVideoTitle client function. Results are cached briefly.
+func (s *SpotifyService) SearchTracksUsingVideoTitle(ctx context.Context, userID, accountSelector, videoTitle string) ([]utils.UnifiedTrackSearchResult, error) {
+    accessToken, accountID, err := s.getValidAccessTokenForAccount(ctx, userID, accountSelector)
+    if err != nil {
+        log.Printf("Error getting valid access token: %v", err)
+        return nil, err
     }
-    accessToken, err := utils.GetValidAccessToken(params)
+
+    key := responseCacheKey(userID, accountID, searchCacheResource(s.SpotifyClient.buildSearchURL("", "", videoTitle, 1, 0)))
+    return s.searchCached(ctx, key, func() ([]utils.UnifiedTrackSearchResult, error) {
+        return s.SpotifyClient.SearchTracksUsingVideoTitle(ctx, accessToken, videoTitle)
+    })
+}
+
+// Wrapper service function for DeletePlaylist client function
+func (s *SpotifyService) DeletePlaylist(ctx context.Context, userID, accountSelector, playlistID string) error {
+    accessToken, accountID, err := s.getValidAccessTokenForAccount(ctx, userID, accountSelector)
     if err != nil {
         log.Printf("Error getting valid access token: %v", err)
         return err
     }
 
-    return s.SpotifyClient.DeletePlaylist(accessToken, playlistID)    
+    if err := s.SpotifyClient.DeletePlaylist(ctx, accessToken, playlistID); err != nil {
+        return err
+    }
+    if err := s.AppContext.Cache.Delete(ctx, responseCacheKey(userID, accountID, "tracks:"+playlistID)); err != nil {
+        log.Printf("Error removing cached tracks of deleted Spotify playlist %s: %v", playlistID, err)
+    }
+    return nil
 }
 
 func (s *SpotifyService) GetAuth0Service() *auth0.Auth0Service {
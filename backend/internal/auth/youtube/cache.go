@@ -0,0 +1,253 @@
+package youtube
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/roblieblang/luthien/backend/internal/utils"
+)
+
+// Bump to invalidate every cached YouTube entry, e.g. after changing what is stored
+const youTubeCacheVersion = "v2"
+
+const (
+    defaultSearchCacheTTL   = 7 * 24 * time.Hour
+    defaultNegativeCacheTTL = 6 * time.Hour
+    defaultVideoCacheTTL    = 30 * 24 * time.Hour
+)
+
+// Stored in place of a video that videos.list did not return, i.e. one that was deleted or made private
+const videoNotFoundMarker = "null"
+
+// Video metadata as returned by videos.list
+type Video struct {
+    ID           string `json:"id"`
+    Title        string `json:"title"`
+    ChannelTitle string `json:"channelTitle"`
+    ThumbnailURL string `json:"thumbnailUrl"`
+    DurationMs   int64  `json:"durationMs,omitempty"`
+    ViewCount    uint64 `json:"viewCount,omitempty"`
+}
+
+type CacheCounts struct {
+    Hits         int64   `json:"hits"`
+    NegativeHits int64   `json:"negativeHits"` // hits on a cached "no result"
+    Misses       int64   `json:"misses"`
+    HitRate      float64 `json:"hitRate"`
+}
+
+type CacheStats struct {
+    Version string      `json:"version"`
+    Search  CacheCounts `json:"search"`
+    Videos  CacheCounts `json:"videos"`
+}
+
+// Caches YouTube search results and video metadata under versioned keys.
+// Hit and miss counts are kept in Redis so that they cover every server instance; they are not counted while Redis is unavailable.
+type YouTubeCache struct {
+    AppContext  *utils.AppContext
+    SearchTTL   time.Duration
+    NegativeTTL time.Duration // how long a search or video lookup with no result is remembered
+    VideoTTL    time.Duration
+}
+
+// Creates a cache using YOUTUBE_SEARCH_CACHE_TTL, YOUTUBE_NEGATIVE_CACHE_TTL and YOUTUBE_VIDEO_CACHE_TTL, when set
+func NewYouTubeCache(appCtx *utils.AppContext) *YouTubeCache {
+    return &YouTubeCache{
+        AppContext: appCtx,
+        SearchTTL: envDuration("YOUTUBE_SEARCH_CACHE_TTL", defaultSearchCacheTTL),
+        NegativeTTL: envDuration("YOUTUBE_NEGATIVE_CACHE_TTL", defaultNegativeCacheTTL),
+        VideoTTL: envDuration("YOUTUBE_VIDEO_CACHE_TTL", defaultVideoCacheTTL),
+    }
+}
+
+func envDuration(name string, defaultValue time.Duration) time.Duration {
+    value := os.Getenv(name)
+    if value == "" {
+        return defaultValue
+    }
+    d, err := time.ParseDuration(value)
+    if err != nil || d <= 0 {
+        log.Printf("Ignoring %s: invalid duration %q", name, value)
+        return defaultValue
+    }
+    return d
+}
+
+// Reduces an artist name and song title to a search query that differs only when the search would.
+// Case, punctuation and spacing are dropped so that "AC/DC - Back In Black" and "ac dc back in black" share a cache entry.
+func NormalizeSearchQuery(artistName, songTitle string) string {
+    raw := strings.ToLower(artistName + " " + songTitle)
+    var b strings.Builder
+    for _, r := range raw {
+        switch {
+        case r == '\'' || r == '’':
+            // "don't" and "dont" are the same search
+        case unicode.IsLetter(r) || unicode.IsDigit(r):
+            b.WriteRune(r)
+        default:
+            b.WriteRune(' ')
+        }
+    }
+    normalized := strings.Join(strings.Fields(b.String()), " ")
+    if normalized == "" {
+        // Nothing but punctuation, which YouTube does search on
+        return strings.Join(strings.Fields(raw), " ")
+    }
+    return normalized
+}
+
+func searchCacheKey(query string, maxResults int64) string {
+    return fmt.Sprintf("youTubeCache:%s:search:%d:%s", youTubeCacheVersion, maxResults, query)
+}
+
+func videoCacheKey(videoID string) string {
+    return fmt.Sprintf("youTubeCache:%s:video:%s", youTubeCacheVersion, videoID)
+}
+
+func cacheStatsKey() string {
+    return fmt.Sprintf("youTubeCache:%s:stats", youTubeCacheVersion)
+}
+
+// Looks up cached search results for a normalized query. found is true for a cached "no result" as well,
+// in which case results is empty.
+func (c *YouTubeCache) GetSearch(ctx context.Context, query string, maxResults int64) (results []utils.UnifiedTrackSearchResult, found bool, err error) {
+    cachedData, err := c.AppContext.Cache.Get(ctx, searchCacheKey(query, maxResults))
+    if err == utils.ErrCacheMiss {
+        c.count(ctx, "search:miss", 1)
+        return nil, false, nil
+    } else if err != nil {
+        return nil, false, err
+    }
+
+    if err := json.Unmarshal(cachedData, &results); err != nil {
+        return nil, false, err
+    }
+    if len(results) == 0 {
+        c.count(ctx, "search:negativeHit", 1)
+        return []utils.UnifiedTrackSearchResult{}, true, nil
+    }
+    c.count(ctx, "search:hit", 1)
+    return results, true, nil
+}
+
+// Caches search results for a normalized query. An empty result is kept for NegativeTTL only,
+// so that a song YouTube has since gained is found again before long.
+func (c *YouTubeCache) SetSearch(ctx context.Context, query string, maxResults int64, results []utils.UnifiedTrackSearchResult) error {
+    ttl := c.SearchTTL
+    if len(results) == 0 {
+        results = []utils.UnifiedTrackSearchResult{}
+        ttl = c.NegativeTTL
+    }
+    jsonData, err := json.Marshal(results)
+    if err != nil {
+        return err
+    }
+    return c.AppContext.Cache.Set(ctx, searchCacheKey(query, maxResults), jsonData, ttl)
+}
+
+// Looks up cached metadata for the given videos. Returns the videos found, keyed by ID,
+// and the IDs that are not cached at all. IDs cached as not found are in neither.
+func (c *YouTubeCache) GetVideos(ctx context.Context, videoIDs []string) (map[string]Video, []string, error) {
+    videos := make(map[string]Video)
+    if len(videoIDs) == 0 {
+        return videos, nil, nil
+    }
+
+    keys := make([]string, len(videoIDs))
+    for i, videoID := range videoIDs {
+        keys[i] = videoCacheKey(videoID)
+    }
+    values, err := c.AppContext.Cache.GetMany(ctx, keys)
+    if err != nil {
+        return nil, videoIDs, err
+    }
+
+    var missing []string
+    var negativeHits int64
+    for i, data := range values {
+        if data == nil {
+            missing = append(missing, videoIDs[i])
+            continue
+        }
+        if string(data) == videoNotFoundMarker {
+            negativeHits++
+            continue
+        }
+        var video Video
+        if err := json.Unmarshal(data, &video); err != nil {
+            log.Printf("Discarding unreadable cached YouTube video %s: %v", videoIDs[i], err)
+            missing = append(missing, videoIDs[i])
+            continue
+        }
+        videos[videoIDs[i]] = video
+    }
+
+    c.count(ctx, "videos:hit", int64(len(videos)))
+    c.count(ctx, "videos:negativeHit", negativeHits)
+    c.count(ctx, "videos:miss", int64(len(missing)))
+    return videos, missing, nil
+}
+
+// Caches video metadata, and remembers the requested IDs that were not returned as not found
+func (c *YouTubeCache) SetVideos(ctx context.Context, requestedIDs []string, videos []Video) error {
+    returned := make(map[string]bool, len(videos))
+    for _, video := range videos {
+        jsonData, err := json.Marshal(video)
+        if err != nil {
+            return err
+        }
+        if err := c.AppContext.Cache.Set(ctx, videoCacheKey(video.ID), jsonData, c.VideoTTL); err != nil {
+            return err
+        }
+        returned[video.ID] = true
+    }
+    for _, videoID := range requestedIDs {
+        if !returned[videoID] {
+            if err := c.AppContext.Cache.Set(ctx, videoCacheKey(videoID), []byte(videoNotFoundMarker), c.NegativeTTL); err != nil {
+                return err
+            }
+        }
+    }
+    return nil
+}
+
+// Reports the hit and miss counts since the cache version was last bumped
+func (c *YouTubeCache) Stats(ctx context.Context) (CacheStats, error) {
+    counts, err := c.AppContext.RedisClient.HGetAll(ctx, cacheStatsKey()).Result()
+    if err != nil {
+        return CacheStats{}, err
+    }
+    return CacheStats{
+        Version: youTubeCacheVersion,
+        Search: cacheCounts(counts, "search"),
+        Videos: cacheCounts(counts, "videos"),
+    }, nil
+}
+
+func cacheCounts(counts map[string]string, kind string) CacheCounts {
+    var result CacheCounts
+    fmt.Sscan(counts[kind+":hit"], &result.Hits)
+    fmt.Sscan(counts[kind+":negativeHit"], &result.NegativeHits)
+    fmt.Sscan(counts[kind+":miss"], &result.Misses)
+    if total := result.Hits + result.NegativeHits + result.Misses; total > 0 {
+        result.HitRate = float64(result.Hits + result.NegativeHits) / float64(total)
+    }
+    return result
+}
+
+// Increments a hit or miss counter. Failures are only logged, since the counts are informational.
+func (c *YouTubeCache) count(ctx context.Context, field string, n int64) {
+    if n == 0 || c.AppContext.Storage.Degraded() {
+        return
+    }
+    if err := c.AppContext.RedisClient.HIncrBy(ctx, cacheStatsKey(), field, n).Err(); err != nil {
+        log.Printf("Error counting YouTube cache %s: %v", field, err)
+    }
+}
@@ -8,6 +8,9 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/roblieblang/luthien/backend/internal/utils"
 	"golang.org/x/oauth2"
@@ -18,14 +21,15 @@ import (
 
 type YouTubeClient struct {
     AppContext *utils.AppContext
+    HTTPClient *http.Client
+    Quota      *QuotaLedger
+    Cache      *YouTubeCache
 }
 
-type YouTubePlaylistsResponse struct {
-    TotalCount      int        `json:"totalCount"`
-    // PrevPageToken   string     `json:"prevPageToken"`
-    // NextPageToken   string     `json:"nextPageToken"`
-    Playlists       []Playlist `json:"playlists"`
-}
+type YouTubePlaylistsResponse = utils.Page[Playlist]
+
+// Most playlists YouTube returns per page
+const MaxPlaylistsPageSize = 50
 
 type Playlist struct {
     ID              string `json:"id"`
@@ -44,6 +48,7 @@ type PlaylistItem struct {
     ThumbnailURL              string `json:"thumbnailUrl"`
     VideoID                   string `json:"videoId"`
     VideoOwnerChannelTitle    string `json:"videoOwnerChannelTitle"`
+    AddedAt                   string `json:"addedAt"`  // RFC 3339
 }
 
 type YouTubePlaylistItemsResponse struct {
@@ -53,28 +58,48 @@ type YouTubePlaylistItemsResponse struct {
 func NewYouTubeClient(appCtx *utils.AppContext) *YouTubeClient {
     return &YouTubeClient{
         AppContext: appCtx,
+        HTTPClient: utils.NewHTTPClient("Google", utils.DefaultRetryPolicy),
+        Quota: NewQuotaLedger(appCtx),
+        Cache: NewYouTubeCache(appCtx),
     }
 }
 
+// Creates a YouTube Data API service that authorizes with the access token and sends requests through the shared transport
+func (c *YouTubeClient) newService(ctx context.Context, accessToken string) (*youtube.Service, error) {
+    ctx = context.WithValue(ctx, oauth2.HTTPClient, c.HTTPClient)
+    tokenSource := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: accessToken})
+    return youtube.NewService(ctx, option.WithHTTPClient(oauth2.NewClient(ctx, tokenSource)))
+}
+
+// Posts a form to a Google OAuth endpoint, stopping when ctx is done
+func (c *YouTubeClient) postForm(ctx context.Context, endpoint string, payload url.Values) (*http.Response, error) {
+    req, err := http.NewRequestWithContext(ctx, "POST", endpoint, strings.NewReader(payload.Encode()))
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+    return c.HTTPClient.Do(req)
+}
+
 // Requests a new access token from Google
-func (c *YouTubeClient) RequestToken(payload url.Values) (utils.TokenResponse, error) {
-    resp, err := http.PostForm("https://oauth2.googleapis.com/token", payload)
+func (c *YouTubeClient) RequestToken(ctx context.Context, payload url.Values) (utils.TokenResponse, error) {
+    resp, err := c.postForm(ctx, "https://oauth2.googleapis.com/token", payload)
     if err != nil {
         log.Printf("error making request for new Google access token: %v", err)
         return utils.TokenResponse{}, err
     }
     defer resp.Body.Close()
 
+    if err := utils.CheckTokenResponse("Google", resp); err != nil {
+        log.Printf("google access token request failed: %v", err)
+        return utils.TokenResponse{}, err
+    }
+
     bodyBytes, err := io.ReadAll(resp.Body)
     if err != nil {
         log.Printf("error reading google token response body: %v", err)
         return utils.TokenResponse{}, err
     }
-    bodyContent := string(bodyBytes)
-    
-    if resp.StatusCode >= 400 {
-        log.Printf("google access token request failed: %s, response body: %s", resp.Status, bodyContent)
-    }
 
     var tokenResponse utils.TokenResponse
     if err := json.Unmarshal(bodyBytes, &tokenResponse); err != nil {
@@ -85,28 +110,84 @@ func (c *YouTubeClient) RequestToken(payload url.Values) (utils.TokenResponse, e
     return tokenResponse, nil
 }
 
-// Gets the current user's playlists
-func (c *YouTubeClient) GetCurrentUserPlaylists(accessToken string) (YouTubePlaylistsResponse, error) {
-    token := &oauth2.Token{AccessToken: accessToken}
-    tokenSource := oauth2.StaticTokenSource(token)
-    httpClient := oauth2.NewClient(context.Background(), tokenSource)
-    service, err := youtube.NewService(context.Background(), option.WithHTTPClient(httpClient))
+// Revokes a Google access or refresh token. Revoking a refresh token also invalidates the whole grant
+func (c *YouTubeClient) RevokeToken(ctx context.Context, token string) error {
+    resp, err := c.postForm(ctx, "https://oauth2.googleapis.com/revoke", url.Values{"token": {token}})
+    if err != nil {
+        log.Printf("error making request to revoke Google token: %v", err)
+        return err
+    }
+    defer resp.Body.Close()
+
+    // Google answers 400 invalid_token for tokens that are already expired or revoked, which is the outcome we want
+    if resp.StatusCode == http.StatusBadRequest {
+        return nil
+    }
+    return utils.CheckResponse("Google", resp)
+}
+
+type Channel struct {
+    ID           string `json:"id"`
+    Title        string `json:"title"`
+    ThumbnailURL string `json:"thumbnailUrl"`
+}
+
+// Gets the channel owned by the authorized Google account
+func (c *YouTubeClient) GetCurrentChannel(ctx context.Context, accessToken string) (Channel, error) {
+    service, err := c.newService(ctx, accessToken)
+    if err != nil {
+        return Channel{}, fmt.Errorf("error creating YouTube service: %v", err)
+    }
+
+    if err := c.Quota.Charge(ctx, QuotaCostList); err != nil {
+        return Channel{}, err
+    }
+    resp, err := service.Channels.List([]string{"snippet"}).Mine(true).Context(ctx).Do()
+    if err != nil {
+        if apiErr := c.apiError(ctx, err); apiErr != nil {
+            return Channel{}, apiErr
+        }
+        return Channel{}, fmt.Errorf("error making API call: %w", err)
+    }
+    if len(resp.Items) == 0 {
+        return Channel{}, fmt.Errorf("no YouTube channel found for the authorized account")
+    }
+
+    item := resp.Items[0]
+    return Channel{
+        ID:             item.Id,
+        Title:          item.Snippet.Title,
+        ThumbnailURL:   getBestAvailableThumbnailURL(item.Snippet.Thumbnails),
+    }, nil
+}
+
+// Gets a page of the current user's playlists, or all of them in pages of 50. The cursor is YouTube's page token.
+func (c *YouTubeClient) GetCurrentUserPlaylists(ctx context.Context, accessToken string, page utils.PageRequest) (YouTubePlaylistsResponse, error) {
+    service, err := c.newService(ctx, accessToken)
     if err != nil {
         return YouTubePlaylistsResponse{}, fmt.Errorf("error creating YouTube service: %v", err)
     }
 
+    limit := int64(MaxPlaylistsPageSize)
+    nextPageToken := ""
+    if !page.All {
+        limit = int64(page.Limit)
+        nextPageToken = page.Cursor
+    }
+
     var playlists []Playlist
-    var nextPageToken string
     var totalCount int
     for {
-        call := service.Playlists.List([]string{"snippet", "contentDetails", "status"}).Mine(true).MaxResults(50).PageToken(nextPageToken)
-        resp, err := call.Do()
+        if err := c.Quota.Charge(ctx, QuotaCostList); err != nil {
+            return YouTubePlaylistsResponse{}, err
+        }
+        call := service.Playlists.List([]string{"snippet", "contentDetails", "status"}).Mine(true).MaxResults(limit).PageToken(nextPageToken)
+        resp, err := call.Context(ctx).Do()
         if err != nil {
-            googleAPIError, ok := err.(*googleapi.Error)
-            if ok && googleAPIError.Code == 403 {
-                return YouTubePlaylistsResponse{}, fmt.Errorf("YouTube API quota exceeded: %v", err)
+            if apiErr := c.apiError(ctx, err); apiErr != nil {
+                return YouTubePlaylistsResponse{}, apiErr
             }
-            return YouTubePlaylistsResponse{}, fmt.Errorf("error making API call: %v", err)
+            return YouTubePlaylistsResponse{}, fmt.Errorf("error making API call: %w", err)
         }
 
         for _, item := range resp.Items {
@@ -132,25 +213,63 @@ func (c *YouTubeClient) GetCurrentUserPlaylists(accessToken string) (YouTubePlay
         }
 
         nextPageToken = resp.NextPageToken
-        if nextPageToken == "" {
-            totalCount = int(resp.PageInfo.TotalResults)
+        totalCount = int(resp.PageInfo.TotalResults)
+        if nextPageToken == "" || !page.All {
             break
         }
     }
 
-    return YouTubePlaylistsResponse{
-        TotalCount: totalCount,
-        Playlists:  playlists,
+    response := YouTubePlaylistsResponse{
+        Items: playlists,
+        Total: totalCount,
+    }
+    if nextPageToken != "" {
+        response.Next = &nextPageToken
+    }
+    return response, nil
+}
+
+// Gets the details of any playlist the user can see, including other channels' public and unlisted playlists.
+// YouTube leaves private and deleted playlists out of the response, which is reported as utils.ErrNotFound.
+func (c *YouTubeClient) GetPlaylist(ctx context.Context, accessToken, playlistID string) (Playlist, error) {
+    service, err := c.newService(ctx, accessToken)
+    if err != nil {
+        return Playlist{}, fmt.Errorf("error creating YouTube service: %v", err)
+    }
+
+    if err := c.Quota.Charge(ctx, QuotaCostList); err != nil {
+        return Playlist{}, err
+    }
+    resp, err := service.Playlists.List([]string{"snippet", "contentDetails", "status"}).Id(playlistID).Context(ctx).Do()
+    if err != nil {
+        if apiErr := c.apiError(ctx, err); apiErr != nil {
+            return Playlist{}, apiErr
+        }
+        return Playlist{}, fmt.Errorf("error making API call: %w", err)
+    }
+    if len(resp.Items) == 0 {
+        return Playlist{}, fmt.Errorf("%w: YouTube playlist %s is private or does not exist", utils.ErrNotFound, playlistID)
+    }
+
+    item := resp.Items[0]
+    var privacyStatus string
+    if item.Status != nil {
+        privacyStatus = item.Status.PrivacyStatus
+    }
+    return Playlist{
+        ID:            item.Id,
+        Title:         item.Snippet.Title,
+        Description:   item.Snippet.Description,
+        ImageURL:      getBestAvailableThumbnailURL(item.Snippet.Thumbnails),
+        VideosCount:   item.ContentDetails.ItemCount,
+        ChannelTitle:  item.Snippet.ChannelTitle,
+        PrivacyStatus: privacyStatus,
     }, nil
 }
 
 // Gets a playlist's items
-func (c *YouTubeClient) GetPlaylistItems(playlistID, accessToken string) (YouTubePlaylistItemsResponse, error) {
-    token := &oauth2.Token{AccessToken: accessToken}
-    tokenSource := oauth2.StaticTokenSource(token)
-    httpClient := oauth2.NewClient(context.Background(), tokenSource)
-
-    service, err := youtube.NewService(context.Background(), option.WithHTTPClient(httpClient))
+func (c *YouTubeClient) GetPlaylistItems(ctx context.Context, playlistID, accessToken string) (YouTubePlaylistItemsResponse, error) {
+    service, err := c.newService(ctx, accessToken)
     if err != nil {
         return YouTubePlaylistItemsResponse{}, fmt.Errorf("error creating YouTube service: %v", err)
     }
@@ -162,13 +281,20 @@ func (c *YouTubeClient) GetPlaylistItems(playlistID, accessToken string) (YouTub
         call := service.PlaylistItems.List([]string{"snippet", "contentDetails"}).
             PlaylistId(playlistID).MaxResults(50).PageToken(nextPageToken)
 
-        resp, err := call.Do()
+        if err := c.Quota.Charge(ctx, QuotaCostList); err != nil {
+            return YouTubePlaylistItemsResponse{}, err
+        }
+        resp, err := call.Context(ctx).Do()
         if err != nil {
+            // Another user's private playlist is forbidden rather than missing
             googleAPIError, ok := err.(*googleapi.Error)
-            if ok && googleAPIError.Code == 403 {
-                return YouTubePlaylistItemsResponse{}, fmt.Errorf("YouTube API quota exceeded: %v", err)
+            if ok && !isQuotaError(googleAPIError) && (googleAPIError.Code == 403 || googleAPIError.Code == 404) {
+                return YouTubePlaylistItemsResponse{}, fmt.Errorf("%w: YouTube playlist %s is private or does not exist", utils.ErrNotFound, playlistID)
+            }
+            if apiErr := c.apiError(ctx, err); apiErr != nil {
+                return YouTubePlaylistItemsResponse{}, apiErr
             }
-            return YouTubePlaylistItemsResponse{}, fmt.Errorf("error making API call: %v", err)
+            return YouTubePlaylistItemsResponse{}, fmt.Errorf("error making API call: %w", err)
         }
 
         for _, item := range resp.Items {
@@ -181,6 +307,7 @@ func (c *YouTubeClient) GetPlaylistItems(playlistID, accessToken string) (YouTub
                 ThumbnailURL:           thumbnailURL,
                 VideoID:                item.ContentDetails.VideoId,
                 VideoOwnerChannelTitle: item.Snippet.VideoOwnerChannelTitle,
+                AddedAt:                item.Snippet.PublishedAt,
             })
         }
 
@@ -220,17 +347,13 @@ type CreatePlaylistPayload struct {
 }
 
 // Creates a new YouTube playlist
-func (c *YouTubeClient) CreatePlaylist(accessToken string, payload CreatePlaylistPayload) (*youtube.Playlist, error) {
+func (c *YouTubeClient) CreatePlaylist(ctx context.Context, accessToken string, payload CreatePlaylistPayload) (*youtube.Playlist, error) {
     if payload.Title == ""{
         log.Printf("Missing playlist title in payload for CreatePlaylist YouTube client")
         return nil, fmt.Errorf("missing playlist title")
     }
     
-    token := &oauth2.Token{AccessToken: accessToken}
-    tokenSource := oauth2.StaticTokenSource(token)
-    httpClient := oauth2.NewClient(context.Background(), tokenSource)
-
-    service, err := youtube.NewService(context.Background(), option.WithHTTPClient(httpClient))
+    service, err := c.newService(ctx, accessToken)
     if err != nil {
         log.Printf("Error creating new YouTube service: %v", err)
         return nil, fmt.Errorf("error creating YouTube service: %v", err)
@@ -248,15 +371,17 @@ func (c *YouTubeClient) CreatePlaylist(accessToken string, payload CreatePlaylis
     }
 
     // Call the YouTube Data API to insert the playlist
+    if err := c.Quota.Charge(ctx, QuotaCostInsert); err != nil {
+        return nil, err
+    }
     call := service.Playlists.Insert([]string{"snippet", "status"}, playlist)
-    createdPlaylist, err := call.Do()
+    createdPlaylist, err := call.Context(ctx).Do()
     if err != nil {
         log.Printf("Error creating YouTube playlist: %v", err)
-        googleAPIError, ok := err.(*googleapi.Error)
-            if ok && googleAPIError.Code == 403 {
-                return nil, fmt.Errorf("YouTube API quota exceeded: %v", err)
-            }
-        return nil, fmt.Errorf("error creating YouTube playlist: %v", err)
+        if apiErr := c.apiError(ctx, err); apiErr != nil {
+            return nil, apiErr
+        }
+        return nil, fmt.Errorf("error creating YouTube playlist: %w", err)
     }
 
     return createdPlaylist, nil
@@ -265,20 +390,23 @@ func (c *YouTubeClient) CreatePlaylist(accessToken string, payload CreatePlaylis
 type AddItemsToPlaylistPayload struct {
     PlaylistID string   `json:"playlistId"`
     VideoIDs   []string `json:"videoIds"`
+    Position   *int64   `json:"position,omitempty"` // zero-based; items are appended when omitted
 }
 
-// Adds items to an existing YouTube playlist
-func (c *YouTubeClient) AddItemsToPlaylist(accessToken string, payload AddItemsToPlaylistPayload) error {
-    token := &oauth2.Token{AccessToken: accessToken}
-    tokenSource := oauth2.StaticTokenSource(token)
-    httpClient := oauth2.NewClient(context.Background(), tokenSource)
-    
-    service, err := youtube.NewService(context.Background(), option.WithHTTPClient(httpClient))
+// Adds items to an existing YouTube playlist. Returns the IDs of the inserted playlist items,
+// including those inserted before an error stopped the rest.
+func (c *YouTubeClient) AddItemsToPlaylist(ctx context.Context, accessToken string, payload AddItemsToPlaylistPayload) ([]string, error) {
+    service, err := c.newService(ctx, accessToken)
     if err != nil {
-        return fmt.Errorf("error creating YouTube service: %v", err)
+        return nil, fmt.Errorf("error creating YouTube service: %v", err)
     }
 
-    for _, videoID := range payload.VideoIDs {
+    itemIDs := make([]string, 0, len(payload.VideoIDs))
+    for i, videoID := range payload.VideoIDs {
+        // Each insert costs quota, so stop as soon as the request is cancelled
+        if err := ctx.Err(); err != nil {
+            return itemIDs, err
+        }
         playlistItem := &youtube.PlaylistItem{
             Snippet: &youtube.PlaylistItemSnippet{
                 PlaylistId: payload.PlaylistID,
@@ -288,20 +416,174 @@ func (c *YouTubeClient) AddItemsToPlaylist(accessToken string, payload AddItemsT
                 },
             },
         }
+        if payload.Position != nil {
+            playlistItem.Snippet.Position = *payload.Position + int64(i)
+            // Position 0 would otherwise be left out of the request as a zero value
+            playlistItem.Snippet.ForceSendFields = []string{"Position"}
+        }
+        if err := c.Quota.Charge(ctx, QuotaCostInsert); err != nil {
+            return itemIDs, err
+        }
         call := service.PlaylistItems.Insert([]string{"snippet"}, playlistItem)
-        _, err := call.Do()
+        inserted, err := call.Context(ctx).Do()
+        if err != nil {
+            if apiErr := c.apiError(ctx, err); apiErr != nil {
+                return itemIDs, apiErr
+            }
+            return itemIDs, fmt.Errorf("error adding item to YouTube playlist: %w", err)
+        }
+        itemIDs = append(itemIDs, inserted.Id)
+    }
+
+    return itemIDs, nil
+}
+
+// Removes items from a YouTube playlist by their playlist item IDs. Items that no longer exist are skipped.
+func (c *YouTubeClient) DeletePlaylistItems(ctx context.Context, accessToken string, itemIDs []string) error {
+    service, err := c.newService(ctx, accessToken)
+    if err != nil {
+        return fmt.Errorf("error creating YouTube service: %v", err)
+    }
+
+    for _, itemID := range itemIDs {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        if err := c.Quota.Charge(ctx, QuotaCostDelete); err != nil {
+            return err
+        }
+        err := service.PlaylistItems.Delete(itemID).Context(ctx).Do()
         if err != nil {
             googleAPIError, ok := err.(*googleapi.Error)
-            if ok && googleAPIError.Code == 403 {
-                return fmt.Errorf("YouTube API quota exceeded: %v", err)
+            if ok && googleAPIError.Code == 404 {
+                continue
+            }
+            if apiErr := c.apiError(ctx, err); apiErr != nil {
+                return apiErr
             }
-            return fmt.Errorf("error adding item to YouTube playlist: %v", err)
+            return fmt.Errorf("error removing item from YouTube playlist: %w", err)
         }
     }
 
     return nil
 }
 
+// Fields left empty are not changed
+type UpdatePlaylistPayload struct {
+    PlaylistID    string  `json:"playlistId"`
+    Title         string  `json:"title,omitempty"`
+    Description   *string `json:"description,omitempty"`  // an empty description clears it
+    PrivacyStatus string  `json:"privacyStatus,omitempty"` // "public", "private", or "unlisted"
+}
+
+// Changes a playlist's title, description or privacy. YouTube replaces the whole snippet and status on
+// update, so the playlist is read first and the fields left out of the payload are sent back unchanged.
+func (c *YouTubeClient) UpdatePlaylist(ctx context.Context, accessToken string, payload UpdatePlaylistPayload) (*youtube.Playlist, error) {
+    service, err := c.newService(ctx, accessToken)
+    if err != nil {
+        return nil, fmt.Errorf("error creating YouTube service: %v", err)
+    }
+
+    if err := c.Quota.Charge(ctx, QuotaCostList); err != nil {
+        return nil, err
+    }
+    resp, err := service.Playlists.List([]string{"snippet", "status"}).Id(payload.PlaylistID).Context(ctx).Do()
+    if err != nil {
+        if apiErr := c.apiError(ctx, err); apiErr != nil {
+            return nil, apiErr
+        }
+        return nil, fmt.Errorf("error making API call: %w", err)
+    }
+    if len(resp.Items) == 0 {
+        return nil, fmt.Errorf("%w: YouTube playlist %s is private or does not exist", utils.ErrNotFound, payload.PlaylistID)
+    }
+
+    current := resp.Items[0]
+    playlist := &youtube.Playlist{
+        Id: current.Id,
+        Snippet: &youtube.PlaylistSnippet{
+            Title:           current.Snippet.Title,
+            Description:     current.Snippet.Description,
+            DefaultLanguage: current.Snippet.DefaultLanguage,
+        },
+        Status: &youtube.PlaylistStatus{},
+    }
+    if current.Status != nil {
+        playlist.Status.PrivacyStatus = current.Status.PrivacyStatus
+    }
+    if payload.Title != "" {
+        playlist.Snippet.Title = payload.Title
+    }
+    if payload.Description != nil {
+        playlist.Snippet.Description = *payload.Description
+        // An empty description would otherwise be left out of the request and kept
+        playlist.Snippet.ForceSendFields = []string{"Description"}
+    }
+    if payload.PrivacyStatus != "" {
+        playlist.Status.PrivacyStatus = payload.PrivacyStatus
+    }
+
+    if err := c.Quota.Charge(ctx, QuotaCostUpdate); err != nil {
+        return nil, err
+    }
+    updated, err := service.Playlists.Update([]string{"snippet", "status"}, playlist).Context(ctx).Do()
+    if err != nil {
+        if apiErr := c.apiError(ctx, err); apiErr != nil {
+            return nil, apiErr
+        }
+        return nil, fmt.Errorf("error updating YouTube playlist: %w", err)
+    }
+
+    return updated, nil
+}
+
+// Moves a playlist item to a zero-based position. Positions can only be set on playlists ordered manually.
+func (c *YouTubeClient) MovePlaylistItem(ctx context.Context, accessToken, itemID string, position int64) error {
+    service, err := c.newService(ctx, accessToken)
+    if err != nil {
+        return fmt.Errorf("error creating YouTube service: %v", err)
+    }
+
+    // The update must repeat the item's playlist and video, which only a lookup tells
+    if err := c.Quota.Charge(ctx, QuotaCostList); err != nil {
+        return err
+    }
+    resp, err := service.PlaylistItems.List([]string{"snippet"}).Id(itemID).Context(ctx).Do()
+    if err != nil {
+        if apiErr := c.apiError(ctx, err); apiErr != nil {
+            return apiErr
+        }
+        return fmt.Errorf("error making API call: %w", err)
+    }
+    if len(resp.Items) == 0 {
+        return fmt.Errorf("%w: YouTube playlist item %s does not exist", utils.ErrNotFound, itemID)
+    }
+
+    current := resp.Items[0]
+    playlistItem := &youtube.PlaylistItem{
+        Id: current.Id,
+        Snippet: &youtube.PlaylistItemSnippet{
+            PlaylistId: current.Snippet.PlaylistId,
+            ResourceId: current.Snippet.ResourceId,
+            Position:   position,
+            // Position 0 would otherwise be left out of the request as a zero value
+            ForceSendFields: []string{"Position"},
+        },
+    }
+    if err := c.Quota.Charge(ctx, QuotaCostUpdate); err != nil {
+        return err
+    }
+    _, err = service.PlaylistItems.Update([]string{"snippet"}, playlistItem).Context(ctx).Do()
+    if err != nil {
+        if apiErr := c.apiError(ctx, err); apiErr != nil {
+            return apiErr
+        }
+        return fmt.Errorf("error moving YouTube playlist item: %w", err)
+    }
+
+    return nil
+}
+
 type YouTubeVideoSearchResponse struct {
     Items []VideoSearchResult `json:"items"`
 }
@@ -319,26 +601,24 @@ type VideoID struct {
 }
 
 // Searches for videos on YouTube based on a query
-func (c *YouTubeClient) SearchVideos(accessToken, query string,  maxResults int64) ([]utils.UnifiedTrackSearchResult, error) {
-    token := &oauth2.Token{AccessToken: accessToken}
-    tokenSource := oauth2.StaticTokenSource(token)
-    httpClient := oauth2.NewClient(context.Background(), tokenSource)
-
-    service, err := youtube.NewService(context.Background(), option.WithHTTPClient(httpClient))
+func (c *YouTubeClient) SearchVideos(ctx context.Context, accessToken, query string,  maxResults int64) ([]utils.UnifiedTrackSearchResult, error) {
+    service, err := c.newService(ctx, accessToken)
     if err != nil {
         log.Printf("error creating new YouTube service: %v", err)
         return nil, fmt.Errorf("error creating YouTube service: %v", err)
     }
 
+    if err := c.Quota.Charge(ctx, QuotaCostSearch); err != nil {
+        return nil, err
+    }
     call := service.Search.List([]string{"id", "snippet"}).Q(query).MaxResults(maxResults).Type("video")
-    resp, err := call.Do()
+    resp, err := call.Context(ctx).Do()
     if err != nil {
         log.Printf("error searching for YouTube video: %v", err)
-        googleAPIError, ok := err.(*googleapi.Error)
-        if ok && googleAPIError.Code == 403 {
-            return nil, fmt.Errorf("YouTube API quota exceeded: %v", err)
+        if apiErr := c.apiError(ctx, err); apiErr != nil {
+            return nil, apiErr
         }
-        return nil, fmt.Errorf("error making API call: %v", err)
+        return nil, fmt.Errorf("error making API call: %w", err)
     }
 
     var results []utils.UnifiedTrackSearchResult
@@ -357,24 +637,314 @@ func (c *YouTubeClient) SearchVideos(accessToken, query string,  maxResults int6
     return results, nil
 }
 
-// Deletes the specified YouTube playlist
-func(c *YouTubeClient) DeletePlaylist(accessToken, playlistID string) error {
-    token := &oauth2.Token{AccessToken: accessToken}
-    tokenSource := oauth2.StaticTokenSource(token)
-    httpClient := oauth2.NewClient(context.Background(), tokenSource)
+// Maximum number of IDs videos.list accepts per call
+const videosPerListCall = 50
+
+// Parts of a video read by videos.list; each costs the same single unit
+var videoParts = []string{"snippet", "contentDetails", "statistics"}
+
+func newVideo(item *youtube.Video) Video {
+    video := Video{
+        ID:           item.Id,
+        Title:        item.Snippet.Title,
+        ChannelTitle: item.Snippet.ChannelTitle,
+        ThumbnailURL: getBestAvailableThumbnailURL(item.Snippet.Thumbnails),
+    }
+    if item.ContentDetails != nil {
+        video.DurationMs = parseVideoDuration(item.ContentDetails.Duration)
+    }
+    if item.Statistics != nil {
+        video.ViewCount = item.Statistics.ViewCount
+    }
+    return video
+}
 
-    service, err := youtube.NewService(context.Background(), option.WithHTTPClient(httpClient))
+// Video durations are ISO 8601 durations such as PT4M13S, or P1DT2H for very long streams
+var videoDuration = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// Converts a video duration to milliseconds. Returns 0 for live streams and durations it cannot read.
+func parseVideoDuration(duration string) int64 {
+    parts := videoDuration.FindStringSubmatch(duration)
+    if parts == nil {
+        return 0
+    }
+    var seconds int64
+    for i, unit := range []int64{24 * 60 * 60, 60 * 60, 60, 1} {
+        if parts[i+1] == "" {
+            continue
+        }
+        n, err := strconv.ParseInt(parts[i+1], 10, 64)
+        if err != nil {
+            return 0
+        }
+        seconds += n * unit
+    }
+    return seconds * 1000
+}
+
+// Gets the metadata of the given videos with videos.list, which costs one quota unit per 50 videos.
+// Videos that do not exist or are private are left out of the result.
+func (c *YouTubeClient) GetVideos(ctx context.Context, accessToken string, videoIDs []string) ([]Video, error) {
+    service, err := c.newService(ctx, accessToken)
+    if err != nil {
+        log.Printf("error creating new YouTube service: %v", err)
+        return nil, fmt.Errorf("error creating YouTube service: %v", err)
+    }
+
+    var videos []Video
+    for start := 0; start < len(videoIDs); start += videosPerListCall {
+        end := min(start + videosPerListCall, len(videoIDs))
+
+        if err := c.Quota.Charge(ctx, QuotaCostList); err != nil {
+            return nil, err
+        }
+        call := service.Videos.List(videoParts).Id(videoIDs[start:end]...).MaxResults(videosPerListCall)
+        resp, err := call.Context(ctx).Do()
+        if err != nil {
+            log.Printf("error listing YouTube videos: %v", err)
+            if apiErr := c.apiError(ctx, err); apiErr != nil {
+                return nil, apiErr
+            }
+            return nil, fmt.Errorf("error making API call: %w", err)
+        }
+
+        for _, item := range resp.Items {
+            videos = append(videos, newVideo(item))
+        }
+    }
+
+    return videos, nil
+}
+
+// Gets every video the user has liked, most recently liked first
+func (c *YouTubeClient) GetLikedVideos(ctx context.Context, accessToken string) ([]Video, error) {
+    service, err := c.newService(ctx, accessToken)
+    if err != nil {
+        return nil, fmt.Errorf("error creating YouTube service: %v", err)
+    }
+
+    var videos []Video
+    var nextPageToken string
+    for {
+        if err := c.Quota.Charge(ctx, QuotaCostList); err != nil {
+            return nil, err
+        }
+        call := service.Videos.List(videoParts).MyRating("like").MaxResults(videosPerListCall).PageToken(nextPageToken)
+        resp, err := call.Context(ctx).Do()
+        if err != nil {
+            if apiErr := c.apiError(ctx, err); apiErr != nil {
+                return nil, apiErr
+            }
+            return nil, fmt.Errorf("error making API call: %w", err)
+        }
+
+        for _, item := range resp.Items {
+            videos = append(videos, newVideo(item))
+        }
+
+        nextPageToken = resp.NextPageToken
+        if nextPageToken == "" {
+            break
+        }
+    }
+
+    return videos, nil
+}
+
+// Rates videos "like" or "none", the latter removing an earlier like. Returns the IDs of the videos
+// rated, including those rated before an error stopped the rest.
+func (c *YouTubeClient) RateVideos(ctx context.Context, accessToken string, videoIDs []string, rating string) ([]string, error) {
+    service, err := c.newService(ctx, accessToken)
+    if err != nil {
+        return nil, fmt.Errorf("error creating YouTube service: %v", err)
+    }
+
+    rated := make([]string, 0, len(videoIDs))
+    for _, videoID := range videoIDs {
+        if err := ctx.Err(); err != nil {
+            return rated, err
+        }
+        if err := c.Quota.Charge(ctx, QuotaCostUpdate); err != nil {
+            return rated, err
+        }
+        if err := service.Videos.Rate(videoID, rating).Context(ctx).Do(); err != nil {
+            if apiErr := c.apiError(ctx, err); apiErr != nil {
+                return rated, apiErr
+            }
+            return rated, fmt.Errorf("error rating YouTube video: %w", err)
+        }
+        rated = append(rated, videoID)
+    }
+
+    return rated, nil
+}
+
+// Gets every channel the user subscribes to
+func (c *YouTubeClient) GetSubscriptions(ctx context.Context, accessToken string) ([]Channel, error) {
+    service, err := c.newService(ctx, accessToken)
+    if err != nil {
+        return nil, fmt.Errorf("error creating YouTube service: %v", err)
+    }
+
+    var channels []Channel
+    var nextPageToken string
+    for {
+        if err := c.Quota.Charge(ctx, QuotaCostList); err != nil {
+            return nil, err
+        }
+        call := service.Subscriptions.List([]string{"snippet"}).Mine(true).MaxResults(50).PageToken(nextPageToken)
+        resp, err := call.Context(ctx).Do()
+        if err != nil {
+            if apiErr := c.apiError(ctx, err); apiErr != nil {
+                return nil, apiErr
+            }
+            return nil, fmt.Errorf("error making API call: %w", err)
+        }
+
+        for _, item := range resp.Items {
+            channels = append(channels, Channel{
+                ID:           item.Snippet.ResourceId.ChannelId,
+                Title:        item.Snippet.Title,
+                ThumbnailURL: getBestAvailableThumbnailURL(item.Snippet.Thumbnails),
+            })
+        }
+
+        nextPageToken = resp.NextPageToken
+        if nextPageToken == "" {
+            break
+        }
+    }
+
+    return channels, nil
+}
+
+// Searches for channels on YouTube based on a query
+func (c *YouTubeClient) SearchChannels(ctx context.Context, accessToken, query string, maxResults int64) ([]Channel, error) {
+    service, err := c.newService(ctx, accessToken)
+    if err != nil {
+        return nil, fmt.Errorf("error creating YouTube service: %v", err)
+    }
+
+    if err := c.Quota.Charge(ctx, QuotaCostSearch); err != nil {
+        return nil, err
+    }
+    call := service.Search.List([]string{"id", "snippet"}).Q(query).MaxResults(maxResults).Type("channel")
+    resp, err := call.Context(ctx).Do()
+    if err != nil {
+        if apiErr := c.apiError(ctx, err); apiErr != nil {
+            return nil, apiErr
+        }
+        return nil, fmt.Errorf("error making API call: %w", err)
+    }
+
+    var channels []Channel
+    for _, item := range resp.Items {
+        channels = append(channels, Channel{
+            ID:           item.Id.ChannelId,
+            Title:        item.Snippet.Title,
+            ThumbnailURL: getBestAvailableThumbnailURL(item.Snippet.Thumbnails),
+        })
+    }
+
+    return channels, nil
+}
+
+// Subscribes the user to channels. Returns the IDs of the subscriptions created, including those created
+// before an error stopped the rest. Channels the user already subscribes to are skipped.
+func (c *YouTubeClient) Subscribe(ctx context.Context, accessToken string, channelIDs []string) ([]string, error) {
+    service, err := c.newService(ctx, accessToken)
+    if err != nil {
+        return nil, fmt.Errorf("error creating YouTube service: %v", err)
+    }
+
+    subscriptionIDs := make([]string, 0, len(channelIDs))
+    for _, channelID := range channelIDs {
+        if err := ctx.Err(); err != nil {
+            return subscriptionIDs, err
+        }
+        if err := c.Quota.Charge(ctx, QuotaCostInsert); err != nil {
+            return subscriptionIDs, err
+        }
+        subscription := &youtube.Subscription{
+            Snippet: &youtube.SubscriptionSnippet{
+                ResourceId: &youtube.ResourceId{
+                    Kind:      "youtube#channel",
+                    ChannelId: channelID,
+                },
+            },
+        }
+        created, err := service.Subscriptions.Insert([]string{"snippet"}, subscription).Context(ctx).Do()
+        if err != nil {
+            googleAPIError, ok := err.(*googleapi.Error)
+            if ok && isSubscriptionDuplicate(googleAPIError) {
+                continue
+            }
+            if apiErr := c.apiError(ctx, err); apiErr != nil {
+                return subscriptionIDs, apiErr
+            }
+            return subscriptionIDs, fmt.Errorf("error subscribing to YouTube channel: %w", err)
+        }
+        subscriptionIDs = append(subscriptionIDs, created.Id)
+    }
+
+    return subscriptionIDs, nil
+}
+
+func isSubscriptionDuplicate(err *googleapi.Error) bool {
+    for _, item := range err.Errors {
+        if item.Reason == "subscriptionDuplicate" {
+            return true
+        }
+    }
+    return false
+}
+
+// Deletes subscriptions by their subscription IDs. Subscriptions that no longer exist are skipped.
+func (c *YouTubeClient) Unsubscribe(ctx context.Context, accessToken string, subscriptionIDs []string) error {
+    service, err := c.newService(ctx, accessToken)
+    if err != nil {
+        return fmt.Errorf("error creating YouTube service: %v", err)
+    }
+
+    for _, subscriptionID := range subscriptionIDs {
+        if err := ctx.Err(); err != nil {
+            return err
+        }
+        if err := c.Quota.Charge(ctx, QuotaCostDelete); err != nil {
+            return err
+        }
+        err := service.Subscriptions.Delete(subscriptionID).Context(ctx).Do()
+        if err != nil {
+            googleAPIError, ok := err.(*googleapi.Error)
+            if ok && googleAPIError.Code == 404 {
+                continue
+            }
+            if apiErr := c.apiError(ctx, err); apiErr != nil {
+                return apiErr
+            }
+            return fmt.Errorf("error unsubscribing from YouTube channel: %w", err)
+        }
+    }
+
+    return nil
+}
+
+// Deletes the specified YouTube playlist
+func(c *YouTubeClient) DeletePlaylist(ctx context.Context, accessToken, playlistID string) error {
+    service, err := c.newService(ctx, accessToken)
     if err != nil {
         log.Printf("error creating new YouTube service: %v", err)
         return fmt.Errorf("error creating YouTube service: %v", err)
     }
 
+    if err := c.Quota.Charge(ctx, QuotaCostDelete); err != nil {
+        return err
+    }
     call := service.Playlists.Delete(playlistID)
-    err = call.Do()
+    err = call.Context(ctx).Do()
     if err != nil {
-        googleAPIError, ok := err.(*googleapi.Error)
-        if ok && googleAPIError.Code == 403 {
-            return fmt.Errorf("YouTube API quota exceeded: %v", err)
+        if apiErr := c.apiError(ctx, err); apiErr != nil {
+            return apiErr
         }
         return fmt.Errorf("deleting YouTube playlist: %w", err)
     }
@@ -1,9 +1,11 @@
 package youtube
 
 import (
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -22,7 +24,7 @@ func NewYouTubeHandler(youTubeService *YouTubeService) *YouTubeHandler {
 
 // Sends the session ID and redirect auth URL to the frontend
 func (h *YouTubeHandler) LoginHandler(c *gin.Context) {
-    authURL, sessionID, err := h.youTubeService.StartLoginFlow()
+    authURL, sessionID, err := h.youTubeService.StartLoginFlow(c.Request.Context())
     if err != nil {
         c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
         return
@@ -34,7 +36,8 @@ func (h *YouTubeHandler) LoginHandler(c *gin.Context) {
 func (h *YouTubeHandler) LogoutHandler(c *gin.Context) {
     log.Printf("Inside LogoutHandler")
     var req struct {
-        UserID string `json:"userID"`
+        UserID    string `json:"userID"`
+        AccountID string `json:"accountID"`  // optional; logs out of every linked channel when empty
     }
     if err := c.BindJSON(&req); err != nil {
         c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
@@ -47,12 +50,10 @@ func (h *YouTubeHandler) LogoutHandler(c *gin.Context) {
         return
     }
 
-    clearParams := utils.ClearTokensParams{
-        Party: "google", 
-        UserID: userID, 
-        AppCtx: *h.youTubeService.YouTubeClient.AppContext,
-    }
-    if err := utils.HandleLogout(h.youTubeService.Auth0Service, clearParams); err != nil {
+    if err := h.youTubeService.Logout(c.Request.Context(), userID, req.AccountID); err != nil {
+        if (errors.Is(err, utils.ErrUpstreamUnavailable) || errors.Is(err, utils.ErrStorageUnavailable)) && upstreamError(c, err) {
+            return
+        }
         c.JSON(http.StatusInternalServerError, gin.H{"error": err})
         return
     }
@@ -78,9 +79,12 @@ func (h *YouTubeHandler) CallbackHandler(c *gin.Context) {
         return
     }
 
-    err := h.youTubeService.HandleCallback(req.Code, req.UserID, req.SessionID)
+    err := h.youTubeService.HandleCallback(c.Request.Context(), req.Code, req.UserID, req.SessionID)
     if err != nil {
         log.Printf("Error handling callback: %v\n", err)
+        if (errors.Is(err, utils.ErrUpstreamUnavailable) || errors.Is(err, utils.ErrStorageUnavailable)) && upstreamError(c, err) {
+            return
+        }
         statusCode := http.StatusInternalServerError
         if strings.Contains(err.Error(), "empty access token") {
             statusCode = http.StatusBadRequest
@@ -105,9 +109,12 @@ func (h *YouTubeHandler) CheckAuthHandler(c *gin.Context) {
         return
     }
 
-    userMetadata, err := h.youTubeService.Auth0Service.GetUserMetadata(userID) 
+    userMetadata, err := h.youTubeService.Auth0Service.GetUserMetadata(c.Request.Context(), userID) 
     if err != nil {
         log.Printf("Error getting Auth0 user metadata: %v", err)
+        if (errors.Is(err, utils.ErrUpstreamUnavailable) || errors.Is(err, utils.ErrStorageUnavailable)) && upstreamError(c, err) {
+            return
+        }
         c.JSON(http.StatusInternalServerError, gin.H{"error": err})
 		return
     }
@@ -115,21 +122,32 @@ func (h *YouTubeHandler) CheckAuthHandler(c *gin.Context) {
     c.JSON(http.StatusOK, gin.H{"isAuthenticated": userMetadata.AppMetadata.AuthenticatedWithGoogle})
 }
 
-// Handles the retrieval of the current user's YouTube playlists
+// Handles the retrieval of the current user's YouTube playlists.
+// Pages of `limit` playlists (20 by default) follow the `cursor` given as `next` by the previous page,
+// and `all=true` returns every playlist at once, like the Spotify endpoint.
 func (h *YouTubeHandler) GetCurrentUserPlaylistsHandler(c *gin.Context) {
 	userID := c.Query("userID")
-    // pageToken := c.DefaultQuery("pageToken", "")
 
 	if userID == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "userID query parameter is required"})
 		return
 	}
 
-	userPlaylists, err := h.youTubeService.GetCurrentUserPlaylists(userID)
+	page, err := utils.ParsePageRequest(c.Query("cursor"), c.Query("limit"), c.Query("all"), 20, MaxPlaylistsPageSize)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userPlaylists, err := h.youTubeService.GetCurrentUserPlaylists(c.Request.Context(), userID, c.Query("accountID"), page)
 	if err != nil {
 		log.Printf("Error retrieving YouTube playlists: %v", err)
         
-        if strings.Contains(err.Error(), "YouTube API quota exceeded") {
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
+
+        if errors.Is(err, ErrQuotaExceeded) {
             c.JSON(http.StatusForbidden, gin.H{
                 "error": "quota_exceeded",
                 "message": "You have exceeded your YouTube API quota.",
@@ -163,11 +181,15 @@ func (h *YouTubeHandler) GetPlaylistItemsHandler(c *gin.Context) {
         return
     }
 
-	userPlaylists, err := h.youTubeService.GetPlaylistItems(userID, playlistID)
+	userPlaylists, err := h.youTubeService.GetPlaylistItems(c.Request.Context(), userID, c.Query("accountID"), playlistID)
 	if err != nil {
 		log.Printf("Error retrieving YouTube playlist items: %v", err)
 
-        if strings.Contains(err.Error(), "YouTube API quota exceeded") {
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
+
+        if errors.Is(err, ErrQuotaExceeded) {
             c.JSON(http.StatusForbidden, gin.H{
                 "error": "quota_exceeded",
                 "message": "You have exceeded your YouTube API quota.",
@@ -188,6 +210,7 @@ func (h *YouTubeHandler) GetPlaylistItemsHandler(c *gin.Context) {
 
 type CreatePlaylistBody struct {
     UserID        string                `json:"userId"`
+    AccountID     string                `json:"accountId"`
     Payload       CreatePlaylistPayload `json:"payload"`
 }
 
@@ -203,10 +226,14 @@ func(h *YouTubeHandler) CreatePlaylistHandler(c *gin.Context) {
         c.JSON(http.StatusBadRequest, gin.H{"error": "user Id is required to create a new playlist"})
         return
     }
-    createdPlaylist, err := h.youTubeService.CreatePlaylist(playlistData.UserID, playlistData.Payload)
+    createdPlaylist, err := h.youTubeService.CreatePlaylist(c.Request.Context(), playlistData.UserID, playlistData.AccountID, playlistData.Payload)
     if err != nil {
 
-        if strings.Contains(err.Error(), "YouTube API quota exceeded") {
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
+
+        if errors.Is(err, ErrQuotaExceeded) {
             c.JSON(http.StatusForbidden, gin.H{
                 "error": "quota_exceeded",
                 "message": "You have exceeded your YouTube API quota.",
@@ -226,7 +253,8 @@ func(h *YouTubeHandler) CreatePlaylistHandler(c *gin.Context) {
 }
 
 type AddItemsToPlaylistBody struct {
-    UserID  string                     `json:"userId"`
+    UserID    string                     `json:"userId"`
+    AccountID string                     `json:"accountId"`
     Payload AddItemsToPlaylistPayload  `json:"payload"`
 }
 
@@ -242,13 +270,19 @@ func(h *YouTubeHandler) AddItemsToPlaylistHandler(c *gin.Context) {
         return
     }
 
-    if err := h.youTubeService.AddItemsToPlaylist(addItemsData.UserID, addItemsData.Payload); err != nil {
+    itemIDs, err := h.youTubeService.AddItemsToPlaylist(c.Request.Context(), addItemsData.UserID, addItemsData.AccountID, addItemsData.Payload)
+    if err != nil {
         errMsg := err.Error()
 
-        if strings.Contains(errMsg, "YouTube API quota exceeded") {
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
+
+        if errors.Is(err, ErrQuotaExceeded) {
             c.JSON(http.StatusForbidden, gin.H{
                 "error": "quota_exceeded",
                 "message": "You have exceeded your YouTube API quota.",
+                "details": errMsg,
             })
             return
         }
@@ -262,7 +296,160 @@ func(h *YouTubeHandler) AddItemsToPlaylistHandler(c *gin.Context) {
         return
     }
 
-    c.JSON(http.StatusOK, gin.H{"message": "Successfully added items to playlist"})
+    c.JSON(http.StatusOK, gin.H{"message": "Successfully added items to playlist", "itemIds": itemIDs})
+}
+
+type UpdatePlaylistBody struct {
+    UserID    string                `json:"userId"`
+    AccountID string                `json:"accountId"`
+    Payload   UpdatePlaylistPayload `json:"payload"`
+}
+
+// Handles changing a playlist's title, description or privacy
+func (h *YouTubeHandler) UpdatePlaylistHandler(c *gin.Context) {
+    var updateData UpdatePlaylistBody
+    if err := c.BindJSON(&updateData); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+        return
+    }
+    payload := updateData.Payload
+    if updateData.UserID == "" || payload.PlaylistID == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
+        return
+    }
+    if payload.Title == "" && payload.Description == nil && payload.PrivacyStatus == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "payload must change the title, description or privacyStatus"})
+        return
+    }
+    switch payload.PrivacyStatus {
+    case "", "public", "private", "unlisted":
+    default:
+        c.JSON(http.StatusBadRequest, gin.H{"error": "privacyStatus must be public, private or unlisted"})
+        return
+    }
+
+    updatedPlaylist, err := h.youTubeService.UpdatePlaylist(c.Request.Context(), updateData.UserID, updateData.AccountID, payload)
+    if err != nil {
+        errMsg := err.Error()
+
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
+
+        if errors.Is(err, ErrQuotaExceeded) {
+            c.JSON(http.StatusForbidden, gin.H{
+                "error": "quota_exceeded",
+                "message": "You have exceeded your YouTube API quota.",
+            })
+            return
+        }
+
+        if strings.Contains(errMsg, "reauthentication required") {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication_required", "message": "Please reauthenticate with YouTube (Google)."})
+            return
+        }
+        log.Printf("Error updating YouTube playlist: %s", errMsg)
+        c.JSON(http.StatusBadRequest, gin.H{"error": errMsg, "message": "Error updating YouTube playlist"})
+        return
+    }
+
+    c.JSON(http.StatusOK, updatedPlaylist)
+}
+
+type RemovePlaylistItemsBody struct {
+    UserID    string   `json:"userId"`
+    AccountID string   `json:"accountId"`
+    ItemIDs   []string `json:"itemIds"` // playlist item IDs, not video IDs
+}
+
+// Handles the removal of items from a YouTube playlist
+func (h *YouTubeHandler) RemovePlaylistItemsHandler(c *gin.Context) {
+    var removeData RemovePlaylistItemsBody
+    if err := c.BindJSON(&removeData); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+        return
+    }
+    if removeData.UserID == "" || len(removeData.ItemIDs) == 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
+        return
+    }
+
+    if err := h.youTubeService.DeletePlaylistItems(c.Request.Context(), removeData.UserID, removeData.AccountID, removeData.ItemIDs); err != nil {
+        errMsg := err.Error()
+
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
+
+        if errors.Is(err, ErrQuotaExceeded) {
+            c.JSON(http.StatusForbidden, gin.H{
+                "error": "quota_exceeded",
+                "message": "You have exceeded your YouTube API quota.",
+            })
+            return
+        }
+
+        if strings.Contains(errMsg, "reauthentication required") {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication_required", "message": "Please reauthenticate with YouTube (Google)."})
+            return
+        }
+        log.Printf("Error removing items from YouTube playlist: %s", errMsg)
+        c.JSON(http.StatusBadRequest, gin.H{"error": errMsg, "message": "Error removing items from YouTube playlist"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Successfully removed items from playlist"})
+}
+
+type MovePlaylistItemBody struct {
+    UserID    string `json:"userId"`
+    AccountID string `json:"accountId"`
+    ItemID    string `json:"itemId"`
+    Position  *int64 `json:"position"` // zero-based
+}
+
+// Handles moving an item to another position in its YouTube playlist
+func (h *YouTubeHandler) MovePlaylistItemHandler(c *gin.Context) {
+    var moveData MovePlaylistItemBody
+    if err := c.BindJSON(&moveData); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+        return
+    }
+    if moveData.UserID == "" || moveData.ItemID == "" || moveData.Position == nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
+        return
+    }
+    if *moveData.Position < 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "position must not be negative"})
+        return
+    }
+
+    if err := h.youTubeService.MovePlaylistItem(c.Request.Context(), moveData.UserID, moveData.AccountID, moveData.ItemID, *moveData.Position); err != nil {
+        errMsg := err.Error()
+
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
+
+        if errors.Is(err, ErrQuotaExceeded) {
+            c.JSON(http.StatusForbidden, gin.H{
+                "error": "quota_exceeded",
+                "message": "You have exceeded your YouTube API quota.",
+            })
+            return
+        }
+
+        if strings.Contains(errMsg, "reauthentication required") {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication_required", "message": "Please reauthenticate with YouTube (Google)."})
+            return
+        }
+        // Also returned for playlists sorted by something other than manual order
+        log.Printf("Error moving YouTube playlist item: %s", errMsg)
+        c.JSON(http.StatusBadRequest, gin.H{"error": errMsg, "message": "Error moving YouTube playlist item"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Successfully moved playlist item"})
 }
 
 // Handles the retrieval of videos that match the given artist name and song title
@@ -285,11 +472,15 @@ func (h *YouTubeHandler) SearchVideosHandler(c *gin.Context) {
     }
 
     // Assuming `SearchVideos` method has been adjusted to handle queries with either artistName, songTitle, or both.
-    searchResponse, err := h.youTubeService.SearchVideos(userID, artistName, songTitle)
+    searchResponse, err := h.youTubeService.SearchVideos(c.Request.Context(), userID, c.Query("accountID"), artistName, songTitle)
     if err != nil {
         errMsg := err.Error()
 
-        if strings.Contains(errMsg, "YouTube API quota exceeded") {
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
+
+        if errors.Is(err, ErrQuotaExceeded) {
             c.JSON(http.StatusForbidden, gin.H{
                 "error": "quota_exceeded",
                 "message": "You have exceeded your YouTube API quota.",
@@ -324,10 +515,14 @@ func (h *YouTubeHandler) DeletePlaylistHandler(c *gin.Context) {
         return
     }
 
-    if err := h.youTubeService.DeletePlaylist(userID, playlistID); err != nil {
+    if err := h.youTubeService.DeletePlaylist(c.Request.Context(), userID, c.Query("accountID"), playlistID); err != nil {
         errMsg := err.Error()
 
-        if strings.Contains(errMsg, "YouTube API quota exceeded") {
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
+
+        if errors.Is(err, ErrQuotaExceeded) {
             c.JSON(http.StatusForbidden, gin.H{
                 "error": "quota_exceeded",
                 "message": "You have exceeded your YouTube API quota.",
@@ -344,4 +539,174 @@ func (h *YouTubeHandler) DeletePlaylistHandler(c *gin.Context) {
     }
 
     c.JSON(http.StatusOK, gin.H{"message": "playlist deleted successfully"})
-}
\ No newline at end of file
+}
+
+// Responds with 404 if the request selected a channel the user has not linked
+func linkedAccountNotFound(c *gin.Context, err error) bool {
+    if !errors.Is(err, utils.ErrLinkedAccountNotFound) {
+        return false
+    }
+    c.JSON(http.StatusNotFound, gin.H{"error": "account_not_found", "message": "No linked YouTube channel matches the given accountID."})
+    return true
+}
+
+// Handles the retrieval of the YouTube channels linked by a user
+func (h *YouTubeHandler) ListLinkedAccountsHandler(c *gin.Context) {
+    userID := c.Query("userID")
+    if userID == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "userID query parameter is required"})
+        return
+    }
+
+    accounts, err := h.youTubeService.ListLinkedAccounts(c.Request.Context(), userID)
+    if err != nil {
+        log.Printf("Error listing linked YouTube channels: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list linked accounts"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"accounts": accounts})
+}
+
+// Handles changing which linked YouTube channel is used when a request does not select one
+func (h *YouTubeHandler) SetDefaultAccountHandler(c *gin.Context) {
+    var req struct {
+        UserID    string `json:"userId"`
+        AccountID string `json:"accountId"`
+    }
+    if err := c.BindJSON(&req); err != nil {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+        return
+    }
+    if req.UserID == "" || req.AccountID == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "Missing required fields"})
+        return
+    }
+
+    if err := h.youTubeService.SetDefaultAccount(c.Request.Context(), req.UserID, req.AccountID); err != nil {
+        if linkedAccountNotFound(c, err) {
+            return
+        }
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set default account"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"message": "Default account updated"})
+}
+
+// Responds with the status matching a failed YouTube API request, e.g. 429 with Retry-After when YouTube rate limited us
+func upstreamError(c *gin.Context, err error) bool {
+    status, ok := utils.UpstreamErrorStatus(err)
+    if !ok {
+        return false
+    }
+    switch status {
+    case http.StatusTooManyRequests:
+        if retryAfter := utils.RetryAfterSeconds(err); retryAfter > 0 {
+            c.Header("Retry-After", strconv.Itoa(retryAfter))
+        }
+        c.JSON(status, gin.H{"error": "rate_limited", "message": "YouTube is rate limiting requests. Please try again shortly."})
+    case http.StatusUnauthorized:
+        c.JSON(status, gin.H{"error": "authentication_required", "message": "Please reauthenticate with Google."})
+    case http.StatusForbidden:
+        c.JSON(status, gin.H{"error": "forbidden", "message": err.Error()})
+    case http.StatusNotFound:
+        c.JSON(status, gin.H{"error": "not_found", "message": err.Error()})
+    case http.StatusGatewayTimeout:
+        c.JSON(status, gin.H{"error": "timeout", "message": "The request took too long to complete."})
+    case http.StatusServiceUnavailable:
+        if retryAfter := utils.RetryAfterSeconds(err); retryAfter > 0 {
+            c.Header("Retry-After", strconv.Itoa(retryAfter))
+        }
+        if errors.Is(err, utils.ErrStorageUnavailable) {
+            c.JSON(status, gin.H{"error": "storage_unavailable", "message": "The server is running in degraded mode. Please try again shortly."})
+            return true
+        }
+        c.JSON(status, gin.H{"error": "upstream_unavailable", "message": err.Error()})
+    case utils.StatusClientClosedRequest:
+        c.AbortWithStatus(status)
+    default:
+        c.JSON(status, gin.H{"error": "upstream_error", "message": err.Error()})
+    }
+    return true
+}
+
+// Handles the retrieval of today's YouTube Data API quota usage
+func (h *YouTubeHandler) GetQuotaHandler(c *gin.Context) {
+    status, err := h.youTubeService.GetQuotaStatus(c.Request.Context())
+    if err != nil {
+        log.Printf("Error retrieving YouTube quota status: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve YouTube quota status"})
+        return
+    }
+
+    c.JSON(http.StatusOK, status)
+}
+
+// Maximum number of video IDs accepted by GetVideosHandler
+const maxVideoIDsPerRequest = 200
+
+// Handles the retrieval of video metadata by video ID, e.g. GET /youtube/videos?ids=a,b,c
+func (h *YouTubeHandler) GetVideosHandler(c *gin.Context) {
+    userID := c.Query("userID")
+    if userID == "" {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "userID query parameter is required"})
+        return
+    }
+
+    var videoIDs []string
+    seen := make(map[string]bool)
+    for _, videoID := range strings.Split(c.Query("ids"), ",") {
+        videoID = strings.TrimSpace(videoID)
+        if videoID != "" && !seen[videoID] {
+            seen[videoID] = true
+            videoIDs = append(videoIDs, videoID)
+        }
+    }
+    if len(videoIDs) == 0 {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "ids query parameter is required"})
+        return
+    }
+    if len(videoIDs) > maxVideoIDsPerRequest {
+        c.JSON(http.StatusBadRequest, gin.H{"error": "at most 200 video IDs may be requested at once"})
+        return
+    }
+
+    videos, err := h.youTubeService.GetVideos(c.Request.Context(), userID, c.Query("accountID"), videoIDs)
+    if err != nil {
+        log.Printf("Error retrieving YouTube videos: %v", err)
+
+        if linkedAccountNotFound(c, err) || upstreamError(c, err) {
+            return
+        }
+
+        if errors.Is(err, ErrQuotaExceeded) {
+            c.JSON(http.StatusForbidden, gin.H{
+                "error": "quota_exceeded",
+                "message": "You have exceeded your YouTube API quota.",
+            })
+            return
+        }
+
+        if strings.Contains(err.Error(), "reauthentication required") {
+            c.JSON(http.StatusUnauthorized, gin.H{"error": "authentication_required", "message": "Please reauthenticate with YouTube (Google)."})
+            return
+        }
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve videos"})
+        return
+    }
+
+    c.JSON(http.StatusOK, gin.H{"videos": videos})
+}
+
+// Handles the retrieval of YouTube cache hit and miss counts
+func (h *YouTubeHandler) GetCacheStatsHandler(c *gin.Context) {
+    stats, err := h.youTubeService.GetCacheStats(c.Request.Context())
+    if err != nil {
+        log.Printf("Error retrieving YouTube cache stats: %v", err)
+        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve YouTube cache stats"})
+        return
+    }
+
+    c.JSON(http.StatusOK, stats)
+}
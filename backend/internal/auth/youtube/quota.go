@@ -0,0 +1,284 @@
+package youtube
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	_ "time/tzdata" // the production image has no zoneinfo database
+
+	"github.com/redis/go-redis/v9"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+	"google.golang.org/api/googleapi"
+)
+
+// Quota units charged by the YouTube Data API per call
+const (
+    QuotaCostList   = 1
+    QuotaCostSearch = 100
+    QuotaCostInsert = 50
+    QuotaCostUpdate = 50
+    QuotaCostDelete = 50
+)
+
+const (
+    defaultDailyQuota         = 10000
+    defaultInteractiveReserve = 1000
+)
+
+// Whether a call serves a user waiting on the page or a batch job such as a playlist conversion.
+// Batch calls cannot spend the part of the budget reserved for interactive use.
+type QuotaPriority int
+
+const (
+    QuotaInteractive QuotaPriority = iota
+    QuotaBatch
+)
+
+type quotaPriorityKey struct{}
+
+// Marks the calls made with the returned context as having the given priority
+func WithQuotaPriority(ctx context.Context, priority QuotaPriority) context.Context {
+    return context.WithValue(ctx, quotaPriorityKey{}, priority)
+}
+
+func quotaPriority(ctx context.Context) QuotaPriority {
+    if priority, ok := ctx.Value(quotaPriorityKey{}).(QuotaPriority); ok {
+        return priority
+    }
+    return QuotaInteractive
+}
+
+// Returned when a call would exceed the remaining YouTube quota, or YouTube reports the quota exhausted
+var ErrQuotaExceeded = errors.New("YouTube API quota exceeded")
+
+// Charges units against the budget only if the result stays within the given ceiling.
+// Returns {charged, units used today}.
+var chargeQuotaScript = redis.NewScript(`
+local used = tonumber(redis.call("GET", KEYS[1]) or "0")
+local cost = tonumber(ARGV[1])
+if used + cost > tonumber(ARGV[2]) then
+    return {0, used}
+end
+used = redis.call("INCRBY", KEYS[1], cost)
+redis.call("EXPIRE", KEYS[1], ARGV[3])
+return {1, used}
+`)
+
+// Where the ledger keeps the units used each day
+type QuotaCounter interface {
+    // Adds cost to the usage under key unless that would bring it above ceiling. Returns whether it was added and the usage after.
+    Add(ctx context.Context, key string, cost, ceiling int, ttl time.Duration) (bool, int, error)
+    // Returns the usage under key, 0 if there is none
+    Get(ctx context.Context, key string) (int, error)
+    Set(ctx context.Context, key string, used int, ttl time.Duration) error
+}
+
+// Keeps the usage in Redis, so that every instance spends from the same budget
+type RedisQuotaCounter struct {
+    RedisClient *redis.Client
+}
+
+func (c RedisQuotaCounter) Add(ctx context.Context, key string, cost, ceiling int, ttl time.Duration) (bool, int, error) {
+    result, err := chargeQuotaScript.Run(ctx, c.RedisClient, []string{key}, cost, ceiling, int(ttl.Seconds())).Int64Slice()
+    if err != nil {
+        return false, 0, err
+    }
+    return result[0] == 1, int(result[1]), nil
+}
+
+func (c RedisQuotaCounter) Get(ctx context.Context, key string) (int, error) {
+    used, err := c.RedisClient.Get(ctx, key).Int()
+    if err == redis.Nil {
+        return 0, nil
+    }
+    return used, err
+}
+
+func (c RedisQuotaCounter) Set(ctx context.Context, key string, used int, ttl time.Duration) error {
+    return c.RedisClient.Set(ctx, key, used, ttl).Err()
+}
+
+// Keeps the usage in process memory. Entries are never expired, since each day has its own key.
+type MemoryQuotaCounter struct {
+    mu   sync.Mutex
+    used map[string]int
+}
+
+func NewMemoryQuotaCounter() *MemoryQuotaCounter {
+    return &MemoryQuotaCounter{used: make(map[string]int)}
+}
+
+func (c *MemoryQuotaCounter) Add(ctx context.Context, key string, cost, ceiling int, ttl time.Duration) (bool, int, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.used[key] + cost > ceiling {
+        return false, c.used[key], nil
+    }
+    c.used[key] += cost
+    return true, c.used[key], nil
+}
+
+func (c *MemoryQuotaCounter) Get(ctx context.Context, key string) (int, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.used[key], nil
+}
+
+func (c *MemoryQuotaCounter) Set(ctx context.Context, key string, used int, ttl time.Duration) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.used[key] = used
+    return nil
+}
+
+// Tracks the project's daily YouTube Data API quota, in Redis unless another counter is set. The quota resets at midnight Pacific time.
+type QuotaLedger struct {
+    Counter             QuotaCounter
+    DailyLimit          int
+    InteractiveReserve  int
+    location            *time.Location
+}
+
+type QuotaStatus struct {
+    Used                int       `json:"used"`
+    Limit               int       `json:"limit"`
+    Remaining           int       `json:"remaining"`
+    InteractiveReserve  int       `json:"interactiveReserve"`
+    BatchRemaining      int       `json:"batchRemaining"`  // what conversions may still spend today
+    ResetsAt            time.Time `json:"resetsAt"`
+}
+
+// Creates a ledger using YOUTUBE_DAILY_QUOTA and YOUTUBE_INTERACTIVE_RESERVE, when set
+func NewQuotaLedger(appCtx *utils.AppContext) *QuotaLedger {
+    location, err := time.LoadLocation("America/Los_Angeles")
+    if err != nil {
+        log.Printf("Error loading Pacific time zone, YouTube quota will reset at midnight UTC: %v", err)
+        location = time.UTC
+    }
+    return &QuotaLedger{
+        Counter: RedisQuotaCounter{RedisClient: appCtx.RedisClient},
+        DailyLimit: envInt("YOUTUBE_DAILY_QUOTA", defaultDailyQuota),
+        InteractiveReserve: envInt("YOUTUBE_INTERACTIVE_RESERVE", defaultInteractiveReserve),
+        location: location,
+    }
+}
+
+func envInt(name string, defaultValue int) int {
+    value := os.Getenv(name)
+    if value == "" {
+        return defaultValue
+    }
+    n, err := strconv.Atoi(value)
+    if err != nil || n < 0 {
+        log.Printf("Ignoring %s: invalid value %q", name, value)
+        return defaultValue
+    }
+    return n
+}
+
+// Returns the counter key of the current quota day and when that day ends
+func (l *QuotaLedger) currentDay() (string, time.Time) {
+    now := time.Now().In(l.location)
+    year, month, day := now.Date()
+    resetsAt := time.Date(year, month, day+1, 0, 0, 0, 0, l.location)
+    return fmt.Sprintf("youTubeQuota:%s", now.Format("2006-01-02")), resetsAt
+}
+
+// The most that calls of the given priority may bring today's usage to
+func (l *QuotaLedger) ceiling(priority QuotaPriority) int {
+    if priority == QuotaBatch {
+        return l.DailyLimit - l.InteractiveReserve
+    }
+    return l.DailyLimit
+}
+
+// Charges the cost of a call before it is made, refusing it if the budget for its priority is spent.
+// Calls are let through if the counter is unavailable, since Google enforces the quota regardless.
+func (l *QuotaLedger) Charge(ctx context.Context, cost int) error {
+    key, resetsAt := l.currentDay()
+    priority := quotaPriority(ctx)
+    charged, used, err := l.Counter.Add(ctx, key, cost, l.ceiling(priority), time.Until(resetsAt) + time.Hour)
+    if err != nil {
+        log.Printf("YouTube quota ledger unavailable, letting call through: %v", err)
+        return nil
+    }
+    if !charged {
+        return fmt.Errorf("%w: %d of %d units used today, call needs %d", ErrQuotaExceeded, used, l.ceiling(priority), cost)
+    }
+    return nil
+}
+
+// Checks whether a batch job of the estimated cost fits in what remains of today's batch budget
+func (l *QuotaLedger) CheckBudget(ctx context.Context, estimatedCost int) error {
+    status, err := l.Status(ctx)
+    if err != nil {
+        log.Printf("YouTube quota ledger unavailable, skipping budget check: %v", err)
+        return nil
+    }
+    if estimatedCost > status.BatchRemaining {
+        return fmt.Errorf("%w: this needs an estimated %d units but only %d remain for conversions until %s",
+            ErrQuotaExceeded, estimatedCost, status.BatchRemaining, status.ResetsAt.Format(time.RFC3339))
+    }
+    return nil
+}
+
+// Records that Google rejected a call for exceeding the quota, so that the ledger stops further calls until the reset
+func (l *QuotaLedger) RecordExhausted(ctx context.Context, apiErr *googleapi.Error) {
+    if !isQuotaError(apiErr) {
+        return
+    }
+    key, resetsAt := l.currentDay()
+    if err := l.Counter.Set(ctx, key, l.DailyLimit, time.Until(resetsAt) + time.Hour); err != nil {
+        log.Printf("Error recording exhausted YouTube quota: %v", err)
+    }
+}
+
+// Reports today's quota usage
+func (l *QuotaLedger) Status(ctx context.Context) (QuotaStatus, error) {
+    key, resetsAt := l.currentDay()
+    used, err := l.Counter.Get(ctx, key)
+    if err != nil {
+        return QuotaStatus{}, err
+    }
+    return QuotaStatus{
+        Used: used,
+        Limit: l.DailyLimit,
+        Remaining: max(0, l.DailyLimit - used),
+        InteractiveReserve: l.InteractiveReserve,
+        BatchRemaining: max(0, l.ceiling(QuotaBatch) - used),
+        ResetsAt: resetsAt,
+    }, nil
+}
+
+// Classifies a failed YouTube Data API call for the handlers, which match it with errors.Is. Exhausted quota is recorded
+// and reported as ErrQuotaExceeded; other 403s, such as writing to a playlist the user does not own, as utils.ErrForbidden.
+// Returns nil for any other error, which the caller wraps itself.
+func (c *YouTubeClient) apiError(ctx context.Context, err error) error {
+    googleAPIError, ok := err.(*googleapi.Error)
+    if !ok || googleAPIError.Code != 403 {
+        return nil
+    }
+    if isQuotaError(googleAPIError) {
+        c.Quota.RecordExhausted(ctx, googleAPIError)
+        return fmt.Errorf("%w: %v", ErrQuotaExceeded, err)
+    }
+    return fmt.Errorf("%w: %v", utils.ErrForbidden, err)
+}
+
+// Whether a 403 from Google is about quota rather than permissions
+func isQuotaError(apiErr *googleapi.Error) bool {
+    if apiErr == nil || apiErr.Code != 403 {
+        return false
+    }
+    for _, item := range apiErr.Errors {
+        if item.Reason == "quotaExceeded" || item.Reason == "dailyLimitExceeded" {
+            return true
+        }
+    }
+    return false
+}
@@ -2,13 +2,11 @@ package youtube
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"net/url"
 	"time"
 
-	"github.com/redis/go-redis/v9"
 	"github.com/roblieblang/luthien/backend/internal/auth/auth0"
 	"github.com/roblieblang/luthien/backend/internal/utils"
 	"google.golang.org/api/youtube/v3"
@@ -27,7 +25,7 @@ func NewYouTubeService(youTubeClient *YouTubeClient, auth0Service *auth0.Auth0Se
 }
 
 // Completes the initial steps of the authorization code flow with PKCE
-func (s *YouTubeService) StartLoginFlow() (string, string, error) {
+func (s *YouTubeService) StartLoginFlow(ctx context.Context) (string, string, error) {
     log.Printf("Inside StartLoginFlow service")
     sessionID := utils.GenerateSessionID()
     codeVerifier, err := utils.GenerateCodeVerifier(64)
@@ -35,7 +33,7 @@ func (s *YouTubeService) StartLoginFlow() (string, string, error) {
         return "","", err
     }
 
-    err = s.YouTubeClient.AppContext.RedisClient.Set(context.Background(), "googleCodeVerifier:" + sessionID, codeVerifier, time.Minute * 10).Err()
+    err = s.YouTubeClient.AppContext.RedisClient.Set(ctx, "googleCodeVerifier:" + sessionID, codeVerifier, time.Minute * 10).Err()
     if err != nil {
         return "","", err
     }
@@ -59,7 +57,7 @@ func (s *YouTubeService) StartLoginFlow() (string, string, error) {
 
 
 // Handles the callback after user has successfully authorized our app on Google's auth page
-func (s *YouTubeService) HandleCallback(code, userID, sessionID string) error {
+func (s *YouTubeService) HandleCallback(ctx context.Context, code, userID, sessionID string) error {
     log.Printf("Inside HandleCallback service")
     payload := url.Values{}
     payload.Set("client_id", s.YouTubeClient.AppContext.EnvConfig.GoogleClientID)
@@ -68,26 +66,33 @@ func (s *YouTubeService) HandleCallback(code, userID, sessionID string) error {
     payload.Set("grant_type", "authorization_code")
     payload.Set("redirect_uri", s.YouTubeClient.AppContext.EnvConfig.GoogleRedirectURI)
 
-    tokenResponse, err := s.YouTubeClient.RequestToken(payload)
+    tokenResponse, err := s.YouTubeClient.RequestToken(ctx, payload)
     if err != nil {
-        return fmt.Errorf("error requesting access token from Google: %v", err)
+        return fmt.Errorf("error requesting access token from Google: %w", err)
     }
 
     if tokenResponse.AccessToken == "" {
         return fmt.Errorf("empty access token")
     }
 
+    // The channel that was just authorized identifies where its tokens are stored
+    channel, err := s.YouTubeClient.GetCurrentChannel(ctx, tokenResponse.AccessToken)
+    if err != nil {
+        return fmt.Errorf("error retrieving channel of the linked Google account: %w", err)
+    }
+
     params := utils.SetTokenParams{
         TokenKind: "access",
         Party: "google",
         UserID: userID, 
+        AccountID: channel.ID,
         Token: tokenResponse.AccessToken,
         ExpiresIn: tokenResponse.ExpiresIn,
         AppCtx: *s.YouTubeClient.AppContext,
     }
-    err = utils.SetToken(params)
+    err = utils.SetToken(ctx, params)
     if err != nil {
-        return fmt.Errorf("error storing the access token: %v", err)
+        return fmt.Errorf("error storing the access token: %w", err)
     }
 
     // This is an arbitrary expiry. utils.SetToken() handles refresh token expiration time
@@ -95,175 +100,374 @@ func (s *YouTubeService) HandleCallback(code, userID, sessionID string) error {
     params.Token = tokenResponse.RefreshToken
     log.Printf("Received Google refresh token: '%s'", tokenResponse.RefreshToken)
     params.ExpiresIn = 0
-    err = utils.SetToken(params) 
+    err = utils.SetToken(ctx, params) 
     if err != nil {
-        return fmt.Errorf("error storing the Google refresh token: %v", err)
+        return fmt.Errorf("error storing the Google refresh token: %w", err)
     }
     log.Printf("Google refresh token stored successfully: %s", tokenResponse.RefreshToken)
 
+    linkedAccount := utils.LinkedAccount{
+        ID: channel.ID,
+        Label: channel.Title,
+        ImageURL: channel.ThumbnailURL,
+    }
+    if err := utils.SaveLinkedAccount(ctx, *s.YouTubeClient.AppContext, userID, "google", linkedAccount); err != nil {
+        return fmt.Errorf("error saving linked Google account: %v", err)
+    }
+
+    // Tokens stored before accounts were tracked are superseded by the linked account
+    if err := utils.ClearTokens(ctx, utils.ClearTokensParams{Party: "google", UserID: userID, AppCtx: *s.YouTubeClient.AppContext}); err != nil {
+        log.Printf("Error clearing legacy Google tokens for user %s: %v", userID, err)
+    }
+
     // Change user's Google authentication status to `true` 
     updatedAuthStatus := map[string]interface{}{
         "app_metadata": map[string]bool{
             "authenticated_with_google": true,
         },
     }
-    if err := s.Auth0Service.UpdateUserMetadata(userID, updatedAuthStatus); err != nil {
-        return fmt.Errorf("error updating user metadata: %v", err)
+    if err := s.Auth0Service.UpdateUserMetadata(ctx, userID, updatedAuthStatus); err != nil {
+        return fmt.Errorf("error updating user metadata: %w", err)
     }
     return nil
 }
 
-// Wrapper service function for GetCurrentUserPlaylists client function
-func (s *YouTubeService) GetCurrentUserPlaylists(userID string)  (YouTubePlaylistsResponse, error) {
-    log.Printf("Inside GetCurrentUserPlaylists service")
+// Revokes the Google grant of one linked account, or of all of them when accountID is empty,
+// clears the corresponding tokens and records the unlink
+func (s *YouTubeService) Logout(ctx context.Context, userID, accountID string) error {
+    appCtx := *s.YouTubeClient.AppContext
+
+    accountIDs := []string{accountID}
+    if accountID == "" {
+        linkedAccounts, err := utils.ListLinkedAccounts(ctx, appCtx, userID, "google")
+        if err != nil {
+            return err
+        }
+        // Also revoke tokens stored before accounts were tracked
+        accountIDs = []string{""}
+        for _, linkedAccount := range linkedAccounts {
+            accountIDs = append(accountIDs, linkedAccount.ID)
+        }
+    }
+
+    revoked := false
+    for _, id := range accountIDs {
+        wasRevoked, err := s.revokeGrant(ctx, userID, id)
+        if err != nil {
+            // The tokens are kept so that unlinking again can still revoke the grant
+            return fmt.Errorf("error revoking Google grant for user %s: %w", userID, err)
+        }
+        revoked = revoked || wasRevoked
+
+        clearParams := utils.ClearTokensParams{
+            Party: "google", 
+            UserID: userID, 
+            AccountID: id,
+            AppCtx: appCtx,
+        }
+        if err := utils.HandleLogout(ctx, s.Auth0Service, clearParams); err != nil {
+            return err
+        }
+    }
+
+    if err := utils.RecordUnlink(ctx, appCtx, userID, "google", revoked); err != nil {
+        log.Printf("Error recording Google unlink: %v", err)
+    }
+    return nil
+}
+
+// Revokes the Google grant behind a linked account's tokens. Reports whether there was a grant to revoke,
+// and fails if Google could not be asked, since clearing the tokens then would leave the grant in place.
+func (s *YouTubeService) revokeGrant(ctx context.Context, userID, accountID string) (bool, error) {
+    // Revoking the refresh token invalidates every token issued under the grant.
+    // The access token is only used when no refresh token is stored.
+    for _, tokenKind := range []string{"refresh", "access"} {
+        token, err := utils.RetrieveToken(ctx, utils.RetrieveTokenParams{
+            Party: "google",
+            TokenKind: tokenKind,
+            UserID: userID,
+            AccountID: accountID,
+            AppCtx: *s.YouTubeClient.AppContext,
+        })
+        if err == utils.ErrTokenNotFound || (err == nil && token == "") {
+            continue
+        } else if err != nil {
+            return false, err
+        }
+        if err := s.YouTubeClient.RevokeToken(ctx, token); err != nil {
+            log.Printf("Error revoking Google %s token for user %s: %v", tokenKind, userID, err)
+            return false, err
+        }
+        return true, nil
+    }
+    return false, nil
+}
+
+// Lists the YouTube channels the user has linked
+func (s *YouTubeService) ListLinkedAccounts(ctx context.Context, userID string) ([]utils.LinkedAccount, error) {
+    return utils.ListLinkedAccounts(ctx, *s.YouTubeClient.AppContext, userID, "google")
+}
+
+// Sets the YouTube channel used when a request does not select one
+func (s *YouTubeService) SetDefaultAccount(ctx context.Context, userID, accountID string) error {
+    return utils.SetDefaultAccount(ctx, *s.YouTubeClient.AppContext, userID, "google", accountID)
+}
+
+// Returns the ID of the linked channel the selector picks, the default channel if it is empty
+func (s *YouTubeService) ResolveAccountID(ctx context.Context, userID, selector string) (string, error) {
+    return utils.ResolveAccountID(ctx, *s.YouTubeClient.AppContext, userID, "google", selector)
+}
+
+// Gets a valid access token for the selected linked channel, or the default channel if none is selected
+func (s *YouTubeService) getValidAccessToken(ctx context.Context, userID, accountSelector string) (string, error) {
+    accountID, err := utils.ResolveAccountID(ctx, *s.YouTubeClient.AppContext, userID, "google", accountSelector)
+    if err != nil {
+        return "", err
+    }
     params := utils.GetValidAccessTokenParams{
         UserID: userID, 
+        AccountID: accountID,
         Party: "google", 
         Service: s.YouTubeClient,
         AppCtx: *s.YouTubeClient.AppContext,
         Updater: s.Auth0Service,
     }
-    accessToken, err := utils.GetValidAccessToken(params)
+    return utils.GetValidAccessToken(ctx, params)
+}
+
+// Wrapper service function for GetCurrentUserPlaylists client function
+func (s *YouTubeService) GetCurrentUserPlaylists(ctx context.Context, userID, accountID string, page utils.PageRequest)  (YouTubePlaylistsResponse, error) {
+    log.Printf("Inside GetCurrentUserPlaylists service")
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
     if err != nil {
         return YouTubePlaylistsResponse{}, err
     }
-    return s.YouTubeClient.GetCurrentUserPlaylists(accessToken)
+    return s.YouTubeClient.GetCurrentUserPlaylists(ctx, accessToken, page)
 }
 
 // Wrapper service function for GetPlaylistItems client function
-func (s *YouTubeService) GetPlaylistItems(userID, playlistID string)  (YouTubePlaylistItemsResponse, error) {
+func (s *YouTubeService) GetPlaylistItems(ctx context.Context, userID, accountID, playlistID string)  (YouTubePlaylistItemsResponse, error) {
     log.Printf("Inside GetPlaylistItems service")
-    params := utils.GetValidAccessTokenParams{
-        UserID: userID, 
-        Party: "google", 
-        Service: s.YouTubeClient,
-        AppCtx: *s.YouTubeClient.AppContext,
-        Updater: s.Auth0Service,
-    }
-    accessToken, err := utils.GetValidAccessToken(params)
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
     if err != nil {
         return YouTubePlaylistItemsResponse{}, err
     }
-    return s.YouTubeClient.GetPlaylistItems(playlistID, accessToken)
+    return s.YouTubeClient.GetPlaylistItems(ctx, playlistID, accessToken)
+}
+
+// Wrapper service function for GetPlaylist client function
+func (s *YouTubeService) GetPlaylist(ctx context.Context, userID, accountID, playlistID string) (Playlist, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return Playlist{}, err
+    }
+    return s.YouTubeClient.GetPlaylist(ctx, accessToken, playlistID)
 }
 
 // Wrapper service function for CreatePlaylist client function
-func (s *YouTubeService) CreatePlaylist(userID string, payload CreatePlaylistPayload) (*youtube.Playlist, error) {
-    params := utils.GetValidAccessTokenParams{
-        UserID: userID, 
-        Party: "google", 
-        Service: s.YouTubeClient,
-        AppCtx: *s.YouTubeClient.AppContext,
-        Updater: s.Auth0Service,
+func (s *YouTubeService) CreatePlaylist(ctx context.Context, userID, accountID string, payload CreatePlaylistPayload) (*youtube.Playlist, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return nil, err
     }
-    accessToken, err := utils.GetValidAccessToken(params)
+    return s.YouTubeClient.CreatePlaylist(ctx, accessToken, payload)
+}
+
+// Checks that a batch job of the given number of searches and writes fits in what remains of today's batch budget.
+// Every search is counted at full cost even though some may be answered from the cache.
+func (s *YouTubeService) CheckBatchBudget(ctx context.Context, searches, writes int) error {
+    return s.YouTubeClient.Quota.CheckBudget(ctx, QuotaCostSearch * searches + QuotaCostInsert * writes)
+}
+
+// Wrapper service function for AddItemsToPlaylist client function. Returns the IDs of the inserted playlist items.
+// Adding items is how conversions fill a playlist, so it runs on the batch budget and is refused
+// up front rather than failing halfway when the remaining quota cannot cover every insert.
+func (s *YouTubeService) AddItemsToPlaylist(ctx context.Context, userID, accountID string, payload AddItemsToPlaylistPayload) ([]string, error) {
+    if err := s.YouTubeClient.Quota.CheckBudget(ctx, QuotaCostInsert * len(payload.VideoIDs)); err != nil {
+        return nil, err
+    }
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
     if err != nil {
         return nil, err
     }
-    return s.YouTubeClient.CreatePlaylist(accessToken, payload)
+    return s.YouTubeClient.AddItemsToPlaylist(WithQuotaPriority(ctx, QuotaBatch), accessToken, payload)
 }
 
-// Wrapper service function for AddItemsToPlaylist client function
-func (s *YouTubeService) AddItemsToPlaylist(userID string, payload AddItemsToPlaylistPayload) error {
-    params := utils.GetValidAccessTokenParams{
-        UserID: userID, 
-        Party: "google", 
-        Service: s.YouTubeClient,
-        AppCtx: *s.YouTubeClient.AppContext,
-        Updater: s.Auth0Service,
+// Wrapper service function for DeletePlaylistItems client function. Like adding items, it runs on the batch budget.
+func (s *YouTubeService) DeletePlaylistItems(ctx context.Context, userID, accountID string, itemIDs []string) error {
+    if err := s.YouTubeClient.Quota.CheckBudget(ctx, QuotaCostDelete * len(itemIDs)); err != nil {
+        return err
     }
-    accessToken, err := utils.GetValidAccessToken(params)
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
     if err != nil {
         return err
     }
-    return s.YouTubeClient.AddItemsToPlaylist(accessToken, payload)
+    return s.YouTubeClient.DeletePlaylistItems(WithQuotaPriority(ctx, QuotaBatch), accessToken, itemIDs)
 }
 
-// Caches a YouTube search response in Redis
-func (s *YouTubeService) cacheSearchResponse(query string, response []utils.UnifiedTrackSearchResult) error {
-    jsonData, err := json.Marshal(response)
+// Wrapper service function for UpdatePlaylist client function
+func (s *YouTubeService) UpdatePlaylist(ctx context.Context, userID, accountID string, payload UpdatePlaylistPayload) (*youtube.Playlist, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
     if err != nil {
-        return err
+        return nil, err
     }
+    return s.YouTubeClient.UpdatePlaylist(ctx, accessToken, payload)
+}
 
-    if err := s.YouTubeClient.AppContext.RedisClient.Set(context.Background(), query, jsonData, 24*time.Hour).Err(); err != nil {
+// Wrapper service function for MovePlaylistItem client function
+func (s *YouTubeService) MovePlaylistItem(ctx context.Context, userID, accountID, itemID string, position int64) error {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
         return err
     }
+    return s.YouTubeClient.MovePlaylistItem(ctx, accessToken, itemID, position)
+}
 
-    return nil
+// Wrapper service function for GetLikedVideos client function
+func (s *YouTubeService) GetLikedVideos(ctx context.Context, userID, accountID string) ([]Video, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return nil, err
+    }
+    return s.YouTubeClient.GetLikedVideos(ctx, accessToken)
 }
 
-// Retrieves a cached search response from Redis
-func (s *YouTubeService) retrieveSearchResponse(query string) ([]utils.UnifiedTrackSearchResult, error){
-    cachedData, err := s.YouTubeClient.AppContext.RedisClient.Get(context.Background(), query).Result()
-    if err == redis.Nil {
-        log.Printf("Redis cache miss for query: %s", query)
-        return nil, nil
-    } else if err != nil {
+// Wrapper service function for RateVideos client function. Like adding items, it runs on the batch budget.
+func (s *YouTubeService) RateVideos(ctx context.Context, userID, accountID string, videoIDs []string, rating string) ([]string, error) {
+    if err := s.YouTubeClient.Quota.CheckBudget(ctx, QuotaCostUpdate * len(videoIDs)); err != nil {
+        return nil, err
+    }
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
         return nil, err
     }
+    return s.YouTubeClient.RateVideos(WithQuotaPriority(ctx, QuotaBatch), accessToken, videoIDs, rating)
+}
 
-    var results []utils.UnifiedTrackSearchResult
-    if err := json.Unmarshal([]byte(cachedData), &results); err != nil {
+// Wrapper service function for GetSubscriptions client function
+func (s *YouTubeService) GetSubscriptions(ctx context.Context, userID, accountID string) ([]Channel, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
         return nil, err
     }
+    return s.YouTubeClient.GetSubscriptions(ctx, accessToken)
+}
 
-    return results, nil
+// Wrapper service function for SearchChannels client function. Returns the best match, if any.
+func (s *YouTubeService) SearchChannels(ctx context.Context, userID, accountID, query string) ([]Channel, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return nil, err
+    }
+    return s.YouTubeClient.SearchChannels(ctx, accessToken, query, searchMaxResults)
 }
 
-// Wrapper service function for SearchVideos client function
-func (s *YouTubeService) SearchVideos(userID, artistName, songTitle string) ([]utils.UnifiedTrackSearchResult, error) {
-    params := utils.GetValidAccessTokenParams{
-        UserID: userID, 
-        Party: "google", 
-        Service: s.YouTubeClient,
-        AppCtx: *s.YouTubeClient.AppContext,
-        Updater: s.Auth0Service,
+// Wrapper service function for Subscribe client function. Like adding items, it runs on the batch budget.
+func (s *YouTubeService) Subscribe(ctx context.Context, userID, accountID string, channelIDs []string) ([]string, error) {
+    if err := s.YouTubeClient.Quota.CheckBudget(ctx, QuotaCostInsert * len(channelIDs)); err != nil {
+        return nil, err
+    }
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return nil, err
+    }
+    return s.YouTubeClient.Subscribe(WithQuotaPriority(ctx, QuotaBatch), accessToken, channelIDs)
+}
+
+// Wrapper service function for Unsubscribe client function. Like adding items, it runs on the batch budget.
+func (s *YouTubeService) Unsubscribe(ctx context.Context, userID, accountID string, subscriptionIDs []string) error {
+    if err := s.YouTubeClient.Quota.CheckBudget(ctx, QuotaCostDelete * len(subscriptionIDs)); err != nil {
+        return err
+    }
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+    if err != nil {
+        return err
     }
-    accessToken, err := utils.GetValidAccessToken(params)
+    return s.YouTubeClient.Unsubscribe(WithQuotaPriority(ctx, QuotaBatch), accessToken, subscriptionIDs)
+}
+
+// Reports how much of today's YouTube Data API quota has been used
+func (s *YouTubeService) GetQuotaStatus(ctx context.Context) (QuotaStatus, error) {
+    return s.YouTubeClient.Quota.Status(ctx)
+}
+
+// Number of results fetched per search. The frontend only uses the best match.
+const searchMaxResults = 1
+
+// Wrapper service function for SearchVideos client function. Results, including finding nothing, are cached
+// under the normalized query, since every search costs 100 quota units.
+func (s *YouTubeService) SearchVideos(ctx context.Context, userID, accountID, artistName, songTitle string) ([]utils.UnifiedTrackSearchResult, error) {
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
     if err != nil {
         return nil, err
     }
 
-    query := fmt.Sprintf("%s %s", artistName, songTitle)
-    log.Printf("Trying to get cached results from Redis for query: %s", query)
-    cachedResults, err := s.retrieveSearchResponse(query)
+    query := NormalizeSearchQuery(artistName, songTitle)
+    cachedResults, found, err := s.YouTubeClient.Cache.GetSearch(ctx, query, searchMaxResults)
     if err != nil {
         log.Printf("Error retrieving cached search response from Redis with query %s. error: %v", query, err)
     }
-    if cachedResults != nil {
-        log.Printf("Retrieved cached results from Redis: %v", cachedResults)
+    if found {
         return cachedResults, nil
     }
 
-    log.Printf("No results in cache, fetching new ones for: %s", query)
-    newResults, err := s.YouTubeClient.SearchVideos(accessToken, query, 1) // maxResults currently hardcoded
+    newResults, err := s.YouTubeClient.SearchVideos(ctx, accessToken, query, searchMaxResults)
     if err != nil {
         log.Printf("Error searching for videos: %v", err)
         return []utils.UnifiedTrackSearchResult{}, err
     }
-    
-    log.Printf("Caching new search results %v for query %s", newResults, query)
-    err = s.cacheSearchResponse(query, newResults)
+
+    if err := s.YouTubeClient.Cache.SetSearch(ctx, query, searchMaxResults, newResults); err != nil {
+        log.Printf("Error caching new search results for query %s: %v", query, err)
+    }
+    return newResults, nil
+}
+
+// Gets the metadata of the given videos, calling videos.list only for those not already cached.
+// Returns the videos in the order requested; IDs of videos that do not exist are left out.
+func (s *YouTubeService) GetVideos(ctx context.Context, userID, accountID string, videoIDs []string) ([]Video, error) {
+    cached, missing, err := s.YouTubeClient.Cache.GetVideos(ctx, videoIDs)
     if err != nil {
-        log.Printf("Error caching new search results: %v", err)
-        return []utils.UnifiedTrackSearchResult{}, err
+        log.Printf("Error retrieving cached YouTube videos: %v", err)
+        cached = make(map[string]Video)
+    }
+
+    if len(missing) > 0 {
+        accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
+        if err != nil {
+            return nil, err
+        }
+        fetched, err := s.YouTubeClient.GetVideos(ctx, accessToken, missing)
+        if err != nil {
+            return nil, err
+        }
+        if err := s.YouTubeClient.Cache.SetVideos(ctx, missing, fetched); err != nil {
+            log.Printf("Error caching YouTube videos: %v", err)
+        }
+        for _, video := range fetched {
+            cached[video.ID] = video
+        }
     }
-    return newResults, nil 
+
+    videos := make([]Video, 0, len(videoIDs))
+    for _, videoID := range videoIDs {
+        if video, ok := cached[videoID]; ok {
+            videos = append(videos, video)
+        }
+    }
+    return videos, nil
+}
+
+// Reports YouTube cache hit and miss counts
+func (s *YouTubeService) GetCacheStats(ctx context.Context) (CacheStats, error) {
+    return s.YouTubeClient.Cache.Stats(ctx)
 }
 
 // Wrapper service function for DeletePlaylist client function
-func(s *YouTubeService) DeletePlaylist(userID, playlistID string) error{
-    params := utils.GetValidAccessTokenParams{
-        UserID: userID, 
-        Party: "google", 
-        Service: s.YouTubeClient,
-        AppCtx: *s.YouTubeClient.AppContext,
-        Updater: s.Auth0Service,
-    }
-    accessToken, err := utils.GetValidAccessToken(params)
+func(s *YouTubeService) DeletePlaylist(ctx context.Context, userID, accountID, playlistID string) error{
+    accessToken, err := s.getValidAccessToken(ctx, userID, accountID)
     if err != nil {
         return err
     }
-    return s.YouTubeClient.DeletePlaylist(accessToken, playlistID)
+    return s.YouTubeClient.DeletePlaylist(ctx, accessToken, playlistID)
 }
\ No newline at end of file
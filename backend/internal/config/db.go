@@ -16,7 +16,8 @@ func DBConnect(uri string) *mongo.Client {
 
     client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
     if err != nil {
-        log.Printf("Failed to connect to MongoDb: %v", err)
+        // Only an unusable URI fails here; the driver keeps reconnecting to an unreachable server
+        log.Fatalf("Failed to connect to MongoDb: %v", err)
     }
 
     if err := client.Ping(ctx, readpref.Primary()); err != nil {
@@ -29,7 +29,7 @@ func NewRedisClient(addr, password string, db int) *redis.Client {
 
 	_, err = client.Ping(context.Background()).Result()
 	if err != nil {
-		log.Printf("Failed to connect to Redis: %v", err)
+		log.Printf("Failed to connect to Redis, starting in degraded mode: %v", err)
 	}
 	
 	return client
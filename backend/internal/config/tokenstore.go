@@ -0,0 +1,60 @@
+package config
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/redis/go-redis/v9"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+	_ "modernc.org/sqlite"
+)
+
+// Creates the token store selected by TOKEN_STORE. The postgres and sqlite stores connect to TOKEN_STORE_DSN
+// and create their tables on startup.
+func NewTokenStore(envConfig *utils.EnvConfig, redisClient *redis.Client, storage *utils.StorageMonitor) utils.TokenStore {
+    switch kind := strings.ToLower(envConfig.TokenStore); kind {
+    case "", "redis":
+        return utils.NewRedisTokenStore(redisClient, storage)
+    case "memory":
+        log.Printf("Using the in-memory token store; tokens will be lost on restart")
+        return utils.NewMemoryTokenStore()
+    case utils.DialectPostgres, utils.DialectSQLite:
+        return newSQLTokenStore(kind, envConfig.TokenStoreDSN)
+    default:
+        log.Fatalf("Unknown TOKEN_STORE %q, expected redis, postgres, sqlite or memory", envConfig.TokenStore)
+        return nil
+    }
+}
+
+func newSQLTokenStore(dialect, dsn string) *utils.SQLTokenStore {
+    if dsn == "" {
+        log.Fatalf("TOKEN_STORE_DSN is required for the %s token store", dialect)
+    }
+    driverName := "pgx"
+    if dialect == utils.DialectSQLite {
+        driverName = "sqlite"
+    }
+    db, err := sql.Open(driverName, dsn)
+    if err != nil {
+        log.Fatalf("Error opening %s token store: %v", dialect, err)
+    }
+    if dialect == utils.DialectSQLite {
+        // SQLite allows a single writer; queueing writes here avoids "database is locked" errors
+        db.SetMaxOpenConns(1)
+    }
+
+    store, err := utils.NewSQLTokenStore(db, dialect)
+    if err != nil {
+        log.Fatalf("Error creating token store: %v", err)
+    }
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+    if err := store.Migrate(ctx); err != nil {
+        log.Fatalf("Error migrating %s token store: %v", dialect, err)
+    }
+    return store
+}
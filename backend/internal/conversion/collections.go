@@ -0,0 +1,336 @@
+package conversion
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/roblieblang/luthien/backend/internal/auth/spotify"
+	"github.com/roblieblang/luthien/backend/internal/auth/youtube"
+)
+
+// A provider that saved albums and followed artists are transferred from and to.
+// Items are returned as Tracks titled with the album or artist name.
+type CollectionProvider interface {
+	SupportsCollection(kind string) bool
+	GetCollection(ctx context.Context, userID, accountID, kind string) ([]Track, error)
+	FindItem(ctx context.Context, userID, accountID, kind string, item Track) (string, bool, error)
+	// Returns the IDs RemoveFromCollection takes, even alongside an error for as many items as were added before it
+	AddToCollection(ctx context.Context, userID, accountID, kind string, itemIDs []string) ([]string, error)
+	RemoveFromCollection(ctx context.Context, userID, accountID, kind string, itemIDs []string) error
+	CollectionURL(kind string) string
+}
+
+type TransferRequest struct {
+	UserID      string            `json:"userId"`
+	Kind        string            `json:"kind"` // albums or artists
+	Source      CollectionRequest `json:"source"`
+	Destination CollectionRequest `json:"destination"`
+	TriggeredBy string            `json:"-"`
+}
+
+type CollectionRequest struct {
+	Provider  string `json:"provider"`
+	AccountID string `json:"accountId"`
+}
+
+// Returns the named provider if it supports collections of the kind
+func (s *ConversionService) collectionProvider(name, kind string) (CollectionProvider, error) {
+	provider, ok := s.Providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown provider %q", ErrInvalidConversion, name)
+	}
+	collections, ok := provider.(CollectionProvider)
+	if !ok || !collections.SupportsCollection(kind) {
+		return nil, fmt.Errorf("%w: %s does not support %s", ErrInvalidConversion, name, kind)
+	}
+	return collections, nil
+}
+
+func (s *ConversionService) validateTransfer(req TransferRequest) error {
+	if req.UserID == "" {
+		return fmt.Errorf("%w: userId is required", ErrInvalidConversion)
+	}
+	if req.Kind != KindAlbums && req.Kind != KindArtists {
+		return fmt.Errorf("%w: kind must be albums or artists", ErrInvalidConversion)
+	}
+	if _, err := s.collectionProvider(req.Source.Provider, req.Kind); err != nil {
+		return err
+	}
+	if _, err := s.collectionProvider(req.Destination.Provider, req.Kind); err != nil {
+		return err
+	}
+	// Within one provider, the collection is copied from one linked account to another
+	if req.Source.Provider == req.Destination.Provider && (req.Source.AccountID == "" || req.Source.AccountID == req.Destination.AccountID) {
+		return fmt.Errorf("%w: a transfer within one provider needs two different accountIds", ErrInvalidConversion)
+	}
+	return nil
+}
+
+// Reduces an album or artist to a key shared by its listings on either provider
+func collectionKey(kind string, item Track) string {
+	if kind == KindArtists {
+		return TrackKey(item.Title, "")
+	}
+	return TrackKey(item.Title, item.Artist)
+}
+
+// Saves the source account's albums, or follows its artists, on the destination and records the transfer
+// in the user's history. Items already in the destination's collection are skipped, and items that cannot
+// be found are reported like unmatched tracks.
+func (s *ConversionService) Transfer(ctx context.Context, req TransferRequest) (*Conversion, error) {
+	if err := s.validateTransfer(req); err != nil {
+		return nil, err
+	}
+	source, _ := s.collectionProvider(req.Source.Provider, req.Kind)
+	target, _ := s.collectionProvider(req.Destination.Provider, req.Kind)
+	destination := DestinationRequest{Provider: req.Destination.Provider, AccountID: req.Destination.AccountID}
+	if err := s.resolveDestinationAccount(ctx, req.UserID, &destination); err != nil {
+		return nil, err
+	}
+	req.Destination.AccountID = destination.AccountID
+
+	conversion := newConversion(req.UserID, req.TriggeredBy, destination)
+	conversion.Kind = req.Kind
+	conversion.Source = PlaylistRef{Provider: req.Source.Provider, AccountID: req.Source.AccountID}
+	conversion.Destination.URL = target.CollectionURL(req.Kind)
+	return s.execute(ctx, conversion, func() error {
+		items, err := source.GetCollection(ctx, req.UserID, req.Source.AccountID, req.Kind)
+		if err != nil {
+			return fmt.Errorf("error getting %s %s: %w", req.Source.Provider, req.Kind, err)
+		}
+		items = markProvider(items, req.Source.Provider)
+
+		existing, err := target.GetCollection(ctx, req.UserID, req.Destination.AccountID, req.Kind)
+		if err != nil {
+			return fmt.Errorf("error getting destination %s: %w", req.Kind, err)
+		}
+		presentIDs, presentKeys, presentUPCs := make(map[string]bool), make(map[string]bool), make(map[string]bool)
+		for _, item := range existing {
+			presentIDs[item.ID] = true
+			presentKeys[collectionKey(req.Kind, item)] = true
+			if item.UPC != "" {
+				presentUPCs[item.UPC] = true
+			}
+		}
+		// Known duplicates are skipped before searching, which saves the search quota
+		known := func(item Track) bool {
+			return (item.UPC != "" && presentUPCs[item.UPC]) || presentKeys[collectionKey(req.Kind, item)]
+		}
+
+		// Refuses the transfer up front if the searches and writes it may take do not fit in the remaining quota
+		if metered, ok := target.(QuotaProvider); ok {
+			searches, writes := 0, 0
+			for _, item := range items {
+				if known(item) {
+					continue
+				}
+				if item.Provider != req.Destination.Provider {
+					searches++
+				}
+				writes++
+			}
+			if err := metered.CheckBatchBudget(ctx, searches, writes); err != nil {
+				return err
+			}
+		}
+
+		matchedIDs := make([]string, 0, len(items))
+		for _, item := range items {
+			if known(item) {
+				conversion.Matched++
+				conversion.Skipped++
+				continue
+			}
+			id, found := item.ID, true
+			if item.Provider != req.Destination.Provider {
+				id, found, err = target.FindItem(ctx, req.UserID, req.Destination.AccountID, req.Kind, item)
+				if err != nil {
+					return fmt.Errorf("error searching for %q: %w", item.Title, err)
+				}
+			}
+			if !found {
+				conversion.UnmatchedTracks = append(conversion.UnmatchedTracks, item)
+				continue
+			}
+			conversion.Matched++
+			if presentIDs[id] {
+				conversion.Skipped++
+				continue
+			}
+			presentIDs[id] = true
+			matchedIDs = append(matchedIDs, id)
+		}
+		conversion.Unmatched = len(conversion.UnmatchedTracks)
+
+		if len(matchedIDs) == 0 {
+			return nil
+		}
+		addedIDs, err := target.AddToCollection(ctx, req.UserID, req.Destination.AccountID, req.Kind, matchedIDs)
+		conversion.AddedItems = append(conversion.AddedItems, addedIDs...)
+		if err != nil {
+			return fmt.Errorf("error adding %s to destination: %w", req.Kind, err)
+		}
+		return nil
+	})
+}
+
+// Unsaves the albums, or unfollows the artists, a transfer added
+func (s *ConversionService) undoTransfer(ctx context.Context, conversion *Conversion) (*Conversion, error) {
+	target, err := s.collectionProvider(conversion.Destination.Provider, conversion.Kind)
+	if err != nil {
+		return conversion, fmt.Errorf("%w: %s does not support %s", ErrNotUndoable, conversion.Destination.Provider, conversion.Kind)
+	}
+	if len(conversion.AddedItems) > 0 {
+		err = target.RemoveFromCollection(ctx, conversion.UserID, conversion.Destination.AccountID, conversion.Kind, conversion.AddedItems)
+		if err != nil {
+			return conversion, fmt.Errorf("error undoing transfer: %w", err)
+		}
+	}
+
+	conversion.Status = StatusUndone
+	conversion.UndoneAt = time.Now().UTC()
+	s.record(ctx, conversion)
+	return conversion, nil
+}
+
+// Reports whether an artist's name and a search result's name are the same artist
+func sameArtist(name, result string) bool {
+	return TrackKey(name, "") == TrackKey(strings.TrimSuffix(result, " - Topic"), "")
+}
+
+func (p SpotifyProvider) SupportsCollection(kind string) bool {
+	return kind == KindAlbums || kind == KindArtists
+}
+
+func (p SpotifyProvider) GetCollection(ctx context.Context, userID, accountID, kind string) ([]Track, error) {
+	if kind == KindArtists {
+		artists, err := p.Service.GetFollowedArtists(ctx, userID, accountID)
+		if err != nil {
+			return nil, err
+		}
+		items := make([]Track, 0, len(artists))
+		for _, artist := range artists {
+			items = append(items, Track{ID: artist.URI, Title: artist.Name})
+		}
+		return items, nil
+	}
+	albums, err := p.Service.GetSavedAlbums(ctx, userID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]Track, 0, len(albums))
+	for _, album := range albums {
+		items = append(items, spotifyAlbum(album))
+	}
+	return items, nil
+}
+
+func spotifyAlbum(album spotify.SpotifyAlbum) Track {
+	artistNames := make([]string, len(album.Artists))
+	for i, artist := range album.Artists {
+		artistNames[i] = artist.Name
+	}
+	return Track{
+		ID:     album.URI,
+		Title:  album.Name,
+		Artist: strings.Join(artistNames, ", "),
+		UPC:    album.ExternalIDs.UPC,
+	}
+}
+
+// Albums are found by UPC when the source knows it, and by name and artist otherwise.
+// An artist only matches a search result of the same name, and an album found by name one of the same name and artists.
+func (p SpotifyProvider) FindItem(ctx context.Context, userID, accountID, kind string, item Track) (string, bool, error) {
+	if kind == KindArtists {
+		artists, err := p.Service.SearchArtists(ctx, userID, accountID, item.Title)
+		if err != nil || len(artists) == 0 || !sameArtist(item.Title, artists[0].Name) {
+			return "", false, err
+		}
+		return artists[0].URI, true, nil
+	}
+	if item.UPC != "" {
+		albums, err := p.Service.SearchAlbums(ctx, userID, accountID, item.UPC, "", "")
+		if err != nil || len(albums) > 0 {
+			return firstAlbumURI(albums), len(albums) > 0, err
+		}
+	}
+	albums, err := p.Service.SearchAlbums(ctx, userID, accountID, "", item.Title, item.Artist)
+	if err != nil {
+		return "", false, err
+	}
+	for _, album := range albums {
+		if collectionKey(kind, spotifyAlbum(album)) == collectionKey(kind, item) {
+			return album.URI, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+func firstAlbumURI(albums []spotify.SpotifyAlbum) string {
+	if len(albums) == 0 {
+		return ""
+	}
+	return albums[0].URI
+}
+
+// Albums and artists are identified by their URIs
+func (p SpotifyProvider) AddToCollection(ctx context.Context, userID, accountID, kind string, itemIDs []string) ([]string, error) {
+	if kind == KindArtists {
+		return p.Service.FollowArtists(ctx, userID, accountID, itemIDs)
+	}
+	return p.Service.SaveAlbums(ctx, userID, accountID, itemIDs)
+}
+
+func (p SpotifyProvider) RemoveFromCollection(ctx context.Context, userID, accountID, kind string, itemIDs []string) error {
+	if kind == KindArtists {
+		return p.Service.UnfollowArtists(ctx, userID, accountID, itemIDs)
+	}
+	return p.Service.RemoveSavedAlbums(ctx, userID, accountID, itemIDs)
+}
+
+func (p SpotifyProvider) CollectionURL(kind string) string {
+	return "https://open.spotify.com/collection/" + kind
+}
+
+// YouTube has no saved albums. Artists are the channels the user subscribes to.
+func (p YouTubeProvider) SupportsCollection(kind string) bool {
+	return kind == KindArtists
+}
+
+// Auto-generated "<artist> - Topic" channels are listed by the artist's name
+func (p YouTubeProvider) GetCollection(ctx context.Context, userID, accountID, kind string) ([]Track, error) {
+	channels, err := p.Service.GetSubscriptions(ctx, userID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]Track, 0, len(channels))
+	for _, channel := range channels {
+		items = append(items, Track{ID: channel.ID, Title: strings.TrimSuffix(channel.Title, " - Topic")})
+	}
+	return items, nil
+}
+
+// Searches only on behalf of transfers, so they spend the batch budget and leave the interactive reserve alone
+func (p YouTubeProvider) FindItem(ctx context.Context, userID, accountID, kind string, item Track) (string, bool, error) {
+	ctx = youtube.WithQuotaPriority(ctx, youtube.QuotaBatch)
+	channels, err := p.Service.SearchChannels(ctx, userID, accountID, item.Title)
+	if err != nil || len(channels) == 0 || !sameArtist(item.Title, channels[0].Title) {
+		return "", false, err
+	}
+	return channels[0].ID, true, nil
+}
+
+// Returns the IDs of the subscriptions created, which RemoveFromCollection takes
+func (p YouTubeProvider) AddToCollection(ctx context.Context, userID, accountID, kind string, itemIDs []string) ([]string, error) {
+	return p.Service.Subscribe(ctx, userID, accountID, itemIDs)
+}
+
+func (p YouTubeProvider) RemoveFromCollection(ctx context.Context, userID, accountID, kind string, itemIDs []string) error {
+	return p.Service.Unsubscribe(ctx, userID, accountID, itemIDs)
+}
+
+func (p YouTubeProvider) CollectionURL(kind string) string {
+	return "https://www.youtube.com/feed/channels"
+}
@@ -0,0 +1,402 @@
+package conversion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/roblieblang/luthien/backend/internal/auth/spotify"
+)
+
+// Returned when the playlist no longer has the entries a removal names, e.g. after it was edited elsewhere
+var ErrDuplicatesChanged = errors.New("playlist changed since it was checked for duplicates")
+
+// Which copy of a duplicated track is kept
+const (
+	KeepEarliest = "earliest" // the copy added first
+	KeepPopular  = "popular"  // the copy with the highest Spotify popularity or YouTube view count
+)
+
+// How the tracks of a duplicate group were found to be the same
+const (
+	MatchExact = "exact" // the same Spotify track or YouTube video
+	MatchISRC  = "isrc"  // different releases of the same recording
+	MatchFuzzy = "fuzzy" // the same normalized title and artist, and a similar duration
+)
+
+// Largest difference in duration between fuzzy duplicates
+const fuzzyDurationTolerance = 5 * time.Second
+
+// A provider whose playlists can be cleaned of duplicate tracks
+type DuplicateProvider interface {
+	// Returns the readable entries of a playlist in order, and the version of the playlist they were read at
+	// if the provider keeps versions. Positions count unreadable items as well.
+	GetPlaylistEntries(ctx context.Context, userID, accountID, playlistID string) ([]PlaylistEntry, string, error)
+	// Removes the given entries, which were read by GetPlaylistEntries at the given version
+	RemoveEntries(ctx context.Context, userID, accountID, playlistID, snapshotID string, remove []PlaylistEntry) error
+}
+
+// A track at a position of a playlist
+type PlaylistEntry struct {
+	Position   int       `json:"position"` // zero-based
+	ItemID     string    `json:"itemId"`   // Spotify track URI or YouTube playlist item ID
+	Track      Track     `json:"track"`
+	DurationMs int64     `json:"durationMs"`
+	Popularity int64     `json:"popularity"` // Spotify popularity, or YouTube view count
+	AddedAt    time.Time `json:"addedAt"`
+}
+
+// Copies of the same track within a playlist
+type DuplicateGroup struct {
+	Match      string          `json:"match"` // exact, isrc or fuzzy
+	Keep       PlaylistEntry   `json:"keep"`
+	Duplicates []PlaylistEntry `json:"duplicates"` // in playlist order
+}
+
+type DuplicatesRequest struct {
+	UserID     string `json:"userId"`
+	Provider   string `json:"provider"`
+	AccountID  string `json:"accountId"`
+	PlaylistID string `json:"playlistId"`
+	Keep       string `json:"keep"` // earliest or popular; defaults to earliest
+	// The duplicates to remove, as reported by FindDuplicates. Every duplicate is removed when empty.
+	Remove []DuplicateRef `json:"remove"`
+}
+
+type DuplicateRef struct {
+	Position int    `json:"position"`
+	ItemID   string `json:"itemId"`
+}
+
+type DuplicateReport struct {
+	Provider   string           `json:"provider"`
+	PlaylistID string           `json:"playlistId"`
+	Keep       string           `json:"keep"`
+	TrackCount int              `json:"trackCount"`
+	Groups     []DuplicateGroup `json:"groups"`
+	Removed    []PlaylistEntry  `json:"removed,omitempty"`
+}
+
+func (s *ConversionService) validateDuplicates(req *DuplicatesRequest) (DuplicateProvider, error) {
+	if req.UserID == "" {
+		return nil, fmt.Errorf("%w: userId is required", ErrInvalidConversion)
+	}
+	if req.PlaylistID == "" {
+		return nil, fmt.Errorf("%w: playlistId is required", ErrInvalidConversion)
+	}
+	provider, ok := s.Providers[req.Provider]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown provider %q", ErrInvalidConversion, req.Provider)
+	}
+	duplicates, ok := provider.(DuplicateProvider)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s playlists cannot be checked for duplicates", ErrInvalidConversion, req.Provider)
+	}
+	switch req.Keep {
+	case "":
+		req.Keep = KeepEarliest
+	case KeepEarliest, KeepPopular:
+	default:
+		return nil, fmt.Errorf("%w: keep must be earliest or popular", ErrInvalidConversion)
+	}
+	return duplicates, nil
+}
+
+// Finds the tracks that appear more than once in a playlist: the same track, other releases of the same
+// recording, and re-uploads of the same song. Each group names the copy that removing its duplicates keeps.
+func (s *ConversionService) FindDuplicates(ctx context.Context, req DuplicatesRequest) (*DuplicateReport, error) {
+	provider, err := s.validateDuplicates(&req)
+	if err != nil {
+		return nil, err
+	}
+	report, _, _, err := s.findDuplicates(ctx, provider, req)
+	return report, err
+}
+
+func (s *ConversionService) findDuplicates(ctx context.Context, provider DuplicateProvider, req DuplicatesRequest) (*DuplicateReport, []PlaylistEntry, string, error) {
+	entries, snapshotID, err := provider.GetPlaylistEntries(ctx, req.UserID, req.AccountID, req.PlaylistID)
+	if err != nil {
+		return nil, nil, "", playlistUnavailable(fmt.Errorf("error getting tracks of %s playlist %s: %w", req.Provider, req.PlaylistID, err), req.Provider, req.PlaylistID)
+	}
+	for i := range entries {
+		entries[i].Track.Provider = req.Provider
+	}
+	return &DuplicateReport{
+		Provider:   req.Provider,
+		PlaylistID: req.PlaylistID,
+		Keep:       req.Keep,
+		TrackCount: len(entries),
+		Groups:     GroupDuplicates(entries, req.Keep),
+	}, entries, snapshotID, nil
+}
+
+// Removes duplicates found by FindDuplicates, keeping one copy of each track. Returns the report the
+// removal was checked against, with the entries removed.
+func (s *ConversionService) RemoveDuplicates(ctx context.Context, req DuplicatesRequest) (*DuplicateReport, error) {
+	provider, err := s.validateDuplicates(&req)
+	if err != nil {
+		return nil, err
+	}
+	report, entries, snapshotID, err := s.findDuplicates(ctx, provider, req)
+	if err != nil {
+		return nil, err
+	}
+
+	removable := make(map[int]PlaylistEntry)
+	for _, group := range report.Groups {
+		for _, duplicate := range group.Duplicates {
+			removable[duplicate.Position] = duplicate
+		}
+	}
+	var remove []PlaylistEntry
+	if len(req.Remove) == 0 {
+		for _, group := range report.Groups {
+			remove = append(remove, group.Duplicates...)
+		}
+	}
+	positions := make(map[int]PlaylistEntry, len(entries))
+	for _, entry := range entries {
+		positions[entry.Position] = entry
+	}
+	chosen := make(map[int]bool)
+	for _, ref := range req.Remove {
+		if entry, ok := positions[ref.Position]; !ok || entry.ItemID != ref.ItemID {
+			return report, fmt.Errorf("%w: no item %s at position %d", ErrDuplicatesChanged, ref.ItemID, ref.Position)
+		}
+		duplicate, ok := removable[ref.Position]
+		if !ok {
+			return report, fmt.Errorf("%w: the track at position %d is not a duplicate that can be removed", ErrInvalidConversion, ref.Position)
+		}
+		if !chosen[ref.Position] {
+			chosen[ref.Position] = true
+			remove = append(remove, duplicate)
+		}
+	}
+	if len(remove) == 0 {
+		return report, nil
+	}
+
+	sort.Slice(remove, func(i, j int) bool { return remove[i].Position < remove[j].Position })
+	if err := provider.RemoveEntries(ctx, req.UserID, req.AccountID, req.PlaylistID, snapshotID, remove); err != nil {
+		return report, fmt.Errorf("error removing duplicates: %w", err)
+	}
+	report.Removed = remove
+	return report, nil
+}
+
+// Groups the entries that are copies of the same track. Entries are the same track when they share an ID
+// or an ISRC, or when they share a TrackKey and their durations differ by at most a few seconds.
+func GroupDuplicates(entries []PlaylistEntry, keep string) []DuplicateGroup {
+	parent := make([]int, len(entries))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	union := func(i, j int) {
+		if ri, rj := find(i), find(j); ri != rj {
+			parent[max(ri, rj)] = min(ri, rj)
+		}
+	}
+	unionBy := func(key func(PlaylistEntry) string) {
+		first := make(map[string]int)
+		for i, entry := range entries {
+			k := key(entry)
+			if k == "" {
+				continue
+			}
+			if j, ok := first[k]; ok {
+				union(i, j)
+			} else {
+				first[k] = i
+			}
+		}
+	}
+
+	unionBy(func(entry PlaylistEntry) string { return entry.Track.ID })
+	unionBy(func(entry PlaylistEntry) string { return entry.Track.ISRC })
+	// Groups found so far are the same recording; fuzzy matches may join them
+	recording := make([]int, len(entries))
+	for i := range entries {
+		recording[i] = find(i)
+	}
+
+	byName := make(map[string][]int)
+	for i, entry := range entries {
+		if name := TrackKey(entry.Track.Title, entry.Track.Artist); name != "" {
+			byName[name] = append(byName[name], i)
+		}
+	}
+	for _, indexes := range byName {
+		for a := 0; a < len(indexes); a++ {
+			for b := a + 1; b < len(indexes); b++ {
+				if similarDuration(entries[indexes[a]], entries[indexes[b]]) {
+					union(indexes[a], indexes[b])
+				}
+			}
+		}
+	}
+
+	members := make(map[int][]int)
+	var roots []int
+	for i := range entries {
+		root := find(i)
+		if _, ok := members[root]; !ok {
+			roots = append(roots, root)
+		}
+		members[root] = append(members[root], i)
+	}
+
+	var groups []DuplicateGroup
+	for _, root := range roots {
+		indexes := members[root]
+		if len(indexes) < 2 {
+			continue
+		}
+		group := DuplicateGroup{Match: MatchExact}
+		kept := indexes[0]
+		for _, i := range indexes[1:] {
+			if entries[i].Track.ID != entries[indexes[0]].Track.ID && group.Match == MatchExact {
+				group.Match = MatchISRC
+			}
+			if recording[i] != recording[indexes[0]] {
+				group.Match = MatchFuzzy
+			}
+			if keeps(entries[i], entries[kept], keep) {
+				kept = i
+			}
+		}
+		group.Keep = entries[kept]
+		for _, i := range indexes {
+			if i != kept {
+				group.Duplicates = append(group.Duplicates, entries[i])
+			}
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// Durations that are unknown do not rule out a match
+func similarDuration(a, b PlaylistEntry) bool {
+	if a.DurationMs == 0 || b.DurationMs == 0 {
+		return true
+	}
+	difference := time.Duration(a.DurationMs-b.DurationMs) * time.Millisecond
+	return difference.Abs() <= fuzzyDurationTolerance
+}
+
+// Reports whether the candidate should be kept instead of the current pick. Ties fall back to the
+// copy added first, then to the copy higher in the playlist.
+func keeps(candidate, current PlaylistEntry, keep string) bool {
+	if keep == KeepPopular && candidate.Popularity != current.Popularity {
+		return candidate.Popularity > current.Popularity
+	}
+	if !candidate.AddedAt.IsZero() && !current.AddedAt.IsZero() && !candidate.AddedAt.Equal(current.AddedAt) {
+		return candidate.AddedAt.Before(current.AddedAt)
+	}
+	return candidate.Position < current.Position
+}
+
+func (p SpotifyProvider) GetPlaylistEntries(ctx context.Context, userID, accountID, playlistID string) ([]PlaylistEntry, string, error) {
+	res, err := p.Service.GetPlaylistTracks(ctx, userID, accountID, playlistID)
+	if err != nil {
+		return nil, "", err
+	}
+	entries := make([]PlaylistEntry, 0, len(res.Items))
+	for position, item := range res.Items {
+		// Local files and removed tracks have no URI
+		if item.Track.URI == "" {
+			continue
+		}
+		addedAt, _ := time.Parse(time.RFC3339, item.AddedAt)
+		entries = append(entries, PlaylistEntry{
+			Position:   position,
+			ItemID:     item.Track.URI,
+			Track:      spotifyTrack(item),
+			DurationMs: item.Track.DurationMs,
+			Popularity: int64(item.Track.Popularity),
+			AddedAt:    addedAt,
+		})
+	}
+	return entries, res.SnapshotID, nil
+}
+
+// Each copy is removed by its position in the snapshot the entries were read at, leaving other copies of
+// the track in place. Spotify refuses the removal if the playlist has changed since then.
+func (p SpotifyProvider) RemoveEntries(ctx context.Context, userID, accountID, playlistID, snapshotID string, remove []PlaylistEntry) error {
+	if snapshotID == "" {
+		return fmt.Errorf("%w: the snapshot of Spotify playlist %s is unknown, so its copies cannot be told apart", ErrDuplicatesChanged, playlistID)
+	}
+	var items []spotify.PlaylistItemURI
+	index := make(map[string]int)
+	for _, entry := range remove {
+		i, ok := index[entry.ItemID]
+		if !ok {
+			i = len(items)
+			index[entry.ItemID] = i
+			items = append(items, spotify.PlaylistItemURI{URI: entry.ItemID})
+		}
+		items[i].Positions = append(items[i].Positions, entry.Position)
+	}
+	_, err := p.Service.RemovePlaylistItems(ctx, userID, accountID, playlistID, snapshotID, items)
+	return err
+}
+
+// Durations and view counts come from the cached video metadata
+func (p YouTubeProvider) GetPlaylistEntries(ctx context.Context, userID, accountID, playlistID string) ([]PlaylistEntry, string, error) {
+	res, err := p.Service.GetPlaylistItems(ctx, userID, accountID, playlistID)
+	if err != nil {
+		return nil, "", err
+	}
+	var videoIDs []string
+	for _, item := range res.Items {
+		if item.VideoID != "" {
+			videoIDs = append(videoIDs, item.VideoID)
+		}
+	}
+	videos, err := p.Service.GetVideos(ctx, userID, accountID, videoIDs)
+	if err != nil {
+		return nil, "", err
+	}
+	videosByID := make(map[string]int, len(videos))
+	for i, video := range videos {
+		videosByID[video.ID] = i
+	}
+
+	entries := make([]PlaylistEntry, 0, len(res.Items))
+	for position, item := range res.Items {
+		// Deleted and private videos have no owner channel
+		if item.VideoID == "" || item.VideoOwnerChannelTitle == "" {
+			continue
+		}
+		entry := PlaylistEntry{
+			Position: position,
+			ItemID:   item.ID,
+			Track:    youTubeTrack(item.VideoID, item.Title, item.VideoOwnerChannelTitle),
+		}
+		entry.AddedAt, _ = time.Parse(time.RFC3339, item.AddedAt)
+		if i, ok := videosByID[item.VideoID]; ok {
+			entry.DurationMs = videos[i].DurationMs
+			entry.Popularity = int64(videos[i].ViewCount)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, "", nil
+}
+
+// Each YouTube playlist item is removed on its own, leaving other copies of the video in place
+func (p YouTubeProvider) RemoveEntries(ctx context.Context, userID, accountID, playlistID, snapshotID string, remove []PlaylistEntry) error {
+	itemIDs := make([]string, len(remove))
+	for i, entry := range remove {
+		itemIDs[i] = entry.ItemID
+	}
+	return p.Service.DeletePlaylistItems(ctx, userID, accountID, itemIDs)
+}
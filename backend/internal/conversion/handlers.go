@@ -0,0 +1,352 @@
+package conversion
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roblieblang/luthien/backend/internal/middleware"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+)
+
+// Page size of the conversion history
+const (
+	defaultListLimit = 20
+	maxListLimit     = 100
+)
+
+type ConversionServiceInterface interface {
+	Convert(ctx context.Context, req ConvertRequest) (*Conversion, error)
+	Merge(ctx context.Context, req MergeRequest) (*Conversion, error)
+	Transfer(ctx context.Context, req TransferRequest) (*Conversion, error)
+	ResolvePlaylist(ctx context.Context, userID, accountID, rawURL string) (*PlaylistInfo, error)
+	FindDuplicates(ctx context.Context, req DuplicatesRequest) (*DuplicateReport, error)
+	RemoveDuplicates(ctx context.Context, req DuplicatesRequest) (*DuplicateReport, error)
+	ListConversions(ctx context.Context, userID string, filter ListFilter) ([]Conversion, int64, error)
+	GetConversion(ctx context.Context, userID, id string) (*Conversion, error)
+	UndoConversion(ctx context.Context, userID, id string) (*Conversion, error)
+}
+
+type ConversionHandler struct {
+	conversionService ConversionServiceInterface
+}
+
+func NewConversionHandler(conversionService ConversionServiceInterface) *ConversionHandler {
+	return &ConversionHandler{conversionService: conversionService}
+}
+
+// Responds with 404 if err means the conversion is not in the user's history. Reports whether it did.
+func conversionNotFound(c *gin.Context, err error) bool {
+	if !errors.Is(err, ErrConversionNotFound) {
+		return false
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "conversion_not_found", "message": "No conversion with this ID was found."})
+	return true
+}
+
+// Converts a playlist into a new or existing playlist on another provider
+func (h *ConversionHandler) ConvertHandler(c *gin.Context) {
+	var req ConvertRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	req.TriggeredBy = triggeredBy(c)
+
+	conversion, err := h.conversionService.Convert(c.Request.Context(), req)
+	if err != nil {
+		log.Printf("Error converting playlist for user %s: %v", req.UserID, err)
+		conversionFailed(c, err, conversion, "Failed to convert playlist")
+		return
+	}
+
+	c.JSON(http.StatusCreated, conversion)
+}
+
+// Merges several playlists from any linked provider into one playlist
+func (h *ConversionHandler) MergeHandler(c *gin.Context) {
+	var req MergeRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	req.TriggeredBy = triggeredBy(c)
+
+	conversion, err := h.conversionService.Merge(c.Request.Context(), req)
+	if err != nil {
+		log.Printf("Error merging playlists for user %s: %v", req.UserID, err)
+		conversionFailed(c, err, conversion, "Failed to merge playlists")
+		return
+	}
+
+	c.JSON(http.StatusCreated, conversion)
+}
+
+// Transfers saved albums or followed artists from one linked account to another
+func (h *ConversionHandler) TransferHandler(c *gin.Context) {
+	var req TransferRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+	req.TriggeredBy = triggeredBy(c)
+
+	conversion, err := h.conversionService.Transfer(c.Request.Context(), req)
+	if err != nil {
+		log.Printf("Error transferring %s for user %s: %v", req.Kind, req.UserID, err)
+		conversionFailed(c, err, conversion, "Failed to transfer "+req.Kind)
+		return
+	}
+
+	c.JSON(http.StatusCreated, conversion)
+}
+
+func triggeredBy(c *gin.Context) string {
+	if middleware.UsesAPIKey(c) {
+		return TriggerAPIKey
+	}
+	return TriggerSession
+}
+
+// Responds to a conversion or merge that failed, including its history record if it got that far
+func conversionFailed(c *gin.Context, err error, conversion *Conversion, message string) {
+	switch {
+	case errors.Is(err, ErrInvalidConversion):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrPlaylistUnavailable):
+		playlistUnavailableResponse(c, conversion)
+	case errors.Is(err, utils.ErrLinkedAccountNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "account_not_found", "message": "No linked account matches the given accountId.", "conversion": conversion})
+	default:
+		status, ok := utils.UpstreamErrorStatus(err)
+		if !ok {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": message, "message": err.Error(), "conversion": conversion})
+	}
+}
+
+func playlistUnavailableResponse(c *gin.Context, conversion *Conversion) {
+	response := gin.H{"error": "playlist_unavailable", "message": "The playlist is private, was deleted or cannot be read with this account."}
+	if conversion != nil {
+		response["conversion"] = conversion
+	}
+	c.JSON(http.StatusNotFound, response)
+}
+
+// Looks up the playlist a pasted Spotify or YouTube link points to, so that it can be converted
+// without being in the user's own library
+func (h *ConversionHandler) ResolvePlaylistHandler(c *gin.Context) {
+	userID := c.Query("userID")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID query parameter is required"})
+		return
+	}
+	rawURL := c.Query("url")
+	if rawURL == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "url query parameter is required"})
+		return
+	}
+
+	playlist, err := h.conversionService.ResolvePlaylist(c.Request.Context(), userID, c.Query("accountID"), rawURL)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidPlaylistURL):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_playlist_url", "message": err.Error()})
+		case errors.Is(err, ErrPlaylistUnavailable):
+			playlistUnavailableResponse(c, nil)
+		case errors.Is(err, utils.ErrLinkedAccountNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "account_not_found", "message": "No linked account matches the given accountId."})
+		default:
+			log.Printf("Error resolving playlist URL for user %s: %v", userID, err)
+			status, ok := utils.UpstreamErrorStatus(err)
+			if !ok {
+				status = http.StatusInternalServerError
+			}
+			c.JSON(status, gin.H{"error": "Failed to resolve playlist", "message": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, playlist)
+}
+
+// Finds the duplicate tracks in a playlist. Query parameters are `userID`, `provider`, `playlistID`,
+// `accountID` and `keep`, which picks the copy of each track that a removal keeps.
+func (h *ConversionHandler) FindDuplicatesHandler(c *gin.Context) {
+	req := DuplicatesRequest{
+		UserID:     c.Query("userID"),
+		Provider:   c.Query("provider"),
+		AccountID:  c.Query("accountID"),
+		PlaylistID: c.Query("playlistID"),
+		Keep:       c.Query("keep"),
+	}
+	if req.UserID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID query parameter is required"})
+		return
+	}
+
+	report, err := h.conversionService.FindDuplicates(c.Request.Context(), req)
+	if err != nil {
+		log.Printf("Error finding duplicates in %s playlist %s for user %s: %v", req.Provider, req.PlaylistID, req.UserID, err)
+		duplicatesFailed(c, err, nil, "Failed to find duplicates")
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// Removes the chosen duplicates from a playlist, or all of them when none are chosen
+func (h *ConversionHandler) RemoveDuplicatesHandler(c *gin.Context) {
+	var req DuplicatesRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	report, err := h.conversionService.RemoveDuplicates(c.Request.Context(), req)
+	if err != nil {
+		log.Printf("Error removing duplicates from %s playlist %s for user %s: %v", req.Provider, req.PlaylistID, req.UserID, err)
+		duplicatesFailed(c, err, report, "Failed to remove duplicates")
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// Responds to a failed duplicate check or removal, including the report it was checked against if there is one
+func duplicatesFailed(c *gin.Context, err error, report *DuplicateReport, message string) {
+	switch {
+	case errors.Is(err, ErrInvalidConversion):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, ErrDuplicatesChanged):
+		c.JSON(http.StatusConflict, gin.H{"error": "playlist_changed", "message": err.Error(), "report": report})
+	case errors.Is(err, ErrPlaylistUnavailable):
+		playlistUnavailableResponse(c, nil)
+	case errors.Is(err, utils.ErrLinkedAccountNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "account_not_found", "message": "No linked account matches the given accountId."})
+	default:
+		status, ok := utils.UpstreamErrorStatus(err)
+		if !ok {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": message, "message": err.Error()})
+	}
+}
+
+// Lists the user's conversions, newest first.
+// Filters by `source` and `destination` provider, `status`, and a `since`/`until` RFC 3339 time range.
+func (h *ConversionHandler) ListConversionsHandler(c *gin.Context) {
+	userID := c.Query("userID")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID query parameter is required"})
+		return
+	}
+
+	filter := ListFilter{
+		Source:      c.Query("source"),
+		Destination: c.Query("destination"),
+		Status:      c.Query("status"),
+		Limit:       defaultListLimit,
+	}
+	if limitStr := c.Query("limit"); limitStr != "" {
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxListLimit)})
+			return
+		}
+		filter.Limit = limit
+	}
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		offset, err := strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "offset must be a non-negative integer"})
+			return
+		}
+		filter.Offset = offset
+	}
+	for name, bound := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		value := c.Query(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": name + " must be an RFC 3339 time"})
+			return
+		}
+		*bound = parsed
+	}
+
+	conversions, total, err := h.conversionService.ListConversions(c.Request.Context(), userID, filter)
+	if err != nil {
+		log.Printf("Error listing conversions of user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list conversions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"conversions": conversions,
+		"total":       total,
+		"limit":       filter.Limit,
+		"offset":      filter.Offset,
+	})
+}
+
+// Returns one conversion from the user's history
+func (h *ConversionHandler) GetConversionHandler(c *gin.Context) {
+	userID := c.Query("userID")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID query parameter is required"})
+		return
+	}
+
+	conversion, err := h.conversionService.GetConversion(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		if conversionNotFound(c, err) {
+			return
+		}
+		log.Printf("Error getting conversion %s of user %s: %v", c.Param("id"), userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get conversion"})
+		return
+	}
+
+	c.JSON(http.StatusOK, conversion)
+}
+
+// Deletes the playlist a conversion created, or removes the items it added to an existing playlist or collection
+func (h *ConversionHandler) UndoConversionHandler(c *gin.Context) {
+	userID := c.Query("userID")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID query parameter is required"})
+		return
+	}
+
+	conversion, err := h.conversionService.UndoConversion(c.Request.Context(), userID, c.Param("id"))
+	if err != nil {
+		if conversionNotFound(c, err) {
+			return
+		}
+		if errors.Is(err, ErrNotUndoable) {
+			c.JSON(http.StatusConflict, gin.H{"error": "not_undoable", "message": err.Error(), "conversion": conversion})
+			return
+		}
+		log.Printf("Error undoing conversion %s of user %s: %v", c.Param("id"), userID, err)
+		if errors.Is(err, utils.ErrLinkedAccountNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "account_not_found", "message": "The account the conversion wrote to is no longer linked."})
+			return
+		}
+		status, ok := utils.UpstreamErrorStatus(err)
+		if !ok {
+			status = http.StatusInternalServerError
+		}
+		c.JSON(status, gin.H{"error": "Failed to undo conversion", "message": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, conversion)
+}
@@ -0,0 +1,170 @@
+package conversion
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/roblieblang/luthien/backend/internal/auth/youtube"
+)
+
+// Ways a merge orders the tracks of its sources
+const (
+	OrderConcatenate = "concatenate" // each source's tracks in turn
+	OrderInterleave  = "interleave"  // one track from each source in turn
+	OrderShuffle     = "shuffle"
+)
+
+// Most playlists a single merge reads
+const maxMergeSources = 20
+
+type MergeRequest struct {
+	UserID      string             `json:"userId"`
+	Sources     []SourceRequest    `json:"sources"`
+	Destination DestinationRequest `json:"destination"`
+	Order       string             `json:"order"` // concatenate, interleave or shuffle; defaults to concatenate
+	TriggeredBy string             `json:"-"`     // set by the handler from how the request was authenticated
+}
+
+func (s *ConversionService) validateMerge(req *MergeRequest) error {
+	if req.UserID == "" {
+		return fmt.Errorf("%w: userId is required", ErrInvalidConversion)
+	}
+	if len(req.Sources) == 0 || len(req.Sources) > maxMergeSources {
+		return fmt.Errorf("%w: between 1 and %d sources are required", ErrInvalidConversion, maxMergeSources)
+	}
+	for i := range req.Sources {
+		if err := s.validateSource(&req.Sources[i]); err != nil {
+			return err
+		}
+	}
+	switch req.Order {
+	case "":
+		req.Order = OrderConcatenate
+	case OrderConcatenate, OrderInterleave, OrderShuffle:
+	default:
+		return fmt.Errorf("%w: order must be concatenate, interleave or shuffle", ErrInvalidConversion)
+	}
+	return s.validateDestination(&req.Destination, "")
+}
+
+// Merges playlists from any linked provider into one destination playlist, leaving out tracks that appear
+// in more than one of them. Recorded in the user's history like a conversion.
+func (s *ConversionService) Merge(ctx context.Context, req MergeRequest) (*Conversion, error) {
+	if err := s.validateMerge(&req); err != nil {
+		return nil, err
+	}
+	if err := s.resolveDestinationAccount(ctx, req.UserID, &req.Destination); err != nil {
+		return nil, err
+	}
+
+	conversion := newConversion(req.UserID, req.TriggeredBy, req.Destination)
+	conversion.Order = req.Order
+	for _, source := range req.Sources {
+		conversion.Sources = append(conversion.Sources, sourceRef(source))
+	}
+	return s.execute(ctx, conversion, func() error {
+		sourceTracks := make([][]Track, len(req.Sources))
+		for i, source := range req.Sources {
+			tracks, err := s.getTracks(ctx, req.UserID, source)
+			if err != nil {
+				return err
+			}
+			sourceTracks[i] = tracks
+		}
+
+		tracks := orderTracks(sourceTracks, req.Order)
+		unique := dedupeTracks(tracks)
+		conversion.Matched += len(tracks) - len(unique)
+		conversion.Skipped += len(tracks) - len(unique)
+		return s.write(ctx, conversion, unique, req.Destination, true)
+	})
+}
+
+// Combines the tracks of several sources into one list
+func orderTracks(sourceTracks [][]Track, order string) []Track {
+	var tracks []Track
+	switch order {
+	case OrderInterleave:
+		for i := 0; ; i++ {
+			added := false
+			for _, source := range sourceTracks {
+				if i < len(source) {
+					tracks = append(tracks, source[i])
+					added = true
+				}
+			}
+			if !added {
+				break
+			}
+		}
+	default:
+		for _, source := range sourceTracks {
+			tracks = append(tracks, source...)
+		}
+		if order == OrderShuffle {
+			rand.Shuffle(len(tracks), func(i, j int) {
+				tracks[i], tracks[j] = tracks[j], tracks[i]
+			})
+		}
+	}
+	return tracks
+}
+
+// Keeps the first of tracks that share an ISRC, a track ID or a normalized title and artist
+func dedupeTracks(tracks []Track) []Track {
+	seen := make(map[string]bool)
+	unique := make([]Track, 0, len(tracks))
+	for _, track := range tracks {
+		keys := []string{"id:" + track.Provider + ":" + track.ID}
+		if name := TrackKey(track.Title, track.Artist); name != "" {
+			keys = append(keys, "name:"+name)
+		}
+		if track.ISRC != "" {
+			keys = append(keys, "isrc:"+track.ISRC)
+		}
+		duplicate := false
+		for _, key := range keys {
+			if seen[key] {
+				duplicate = true
+			}
+			seen[key] = true
+		}
+		if !duplicate {
+			unique = append(unique, track)
+		}
+	}
+	return unique
+}
+
+// Reduces a track's title and artist to a key shared by releases of the same recording.
+// Bracketed parts such as "(Remastered 2011)" or "[Official Video]" are dropped, and a YouTube title of the
+// form "Artist - Title" without a separate artist yields the same key as the artist and title apart.
+func TrackKey(title, artist string) string {
+	return youtube.NormalizeSearchQuery(stripBracketed(artist), stripBracketed(title))
+}
+
+// Removes parenthesized and bracketed parts of a title, unless nothing else is left
+func stripBracketed(s string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range s {
+		switch r {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			if depth > 0 {
+				depth--
+			}
+		default:
+			if depth == 0 {
+				b.WriteRune(r)
+			}
+		}
+	}
+	if strings.TrimSpace(b.String()) == "" {
+		return s
+	}
+	return b.String()
+}
@@ -0,0 +1,211 @@
+package conversion
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/jpeg"
+	_ "image/png" // decodes PNG artwork
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/roblieblang/luthien/backend/internal/auth/spotify"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+)
+
+// A provider that playlist cover art can be uploaded to
+type ArtworkProvider interface {
+	SetPlaylistImage(ctx context.Context, userID, accountID, playlistID string, jpegData []byte) error
+	// Largest image accepted, measured after base64 encoding
+	MaxPlaylistImageSize() int
+}
+
+func (p SpotifyProvider) SetPlaylistImage(ctx context.Context, userID, accountID, playlistID string, jpegData []byte) error {
+	return p.Service.UploadPlaylistImage(ctx, userID, accountID, playlistID, jpegData)
+}
+
+func (p SpotifyProvider) MaxPlaylistImageSize() int {
+	return spotify.MaxPlaylistImageSize
+}
+
+const (
+	// Largest artwork downloaded from a source
+	maxArtworkDownload = 10 << 20
+	// Longest side of artwork that is decoded. A small file can declare huge dimensions, and decoding allocates for all of them.
+	maxArtworkSide = 4096
+	// Side of the square cover art uploaded, which is what Spotify shows its largest covers at
+	coverArtSize = 640
+	// Smallest size cover art is scaled down to in order to fit
+	minCoverArtSize = 64
+)
+
+// JPEG qualities tried, best first, until the image fits the size limit
+var coverArtQualities = []int{90, 80, 70, 60, 50}
+
+// Downloads the source playlist's artwork and uploads it as the cover of the created playlist.
+// The conversion does not fail over artwork, so errors are only logged.
+func (s *ConversionService) copyArtwork(ctx context.Context, conversion *Conversion, imageURL string) {
+	target, ok := s.Providers[conversion.Destination.Provider].(ArtworkProvider)
+	if !ok || imageURL == "" {
+		return
+	}
+
+	img, err := s.fetchArtwork(ctx, imageURL)
+	if err != nil {
+		log.Printf("Error downloading artwork for conversion %s: %v", conversion.ID, err)
+		return
+	}
+	jpegData, err := EncodeCoverArt(img, target.MaxPlaylistImageSize())
+	if err != nil {
+		log.Printf("Error encoding artwork for conversion %s: %v", conversion.ID, err)
+		return
+	}
+	destination := conversion.Destination
+	if err := target.SetPlaylistImage(ctx, conversion.UserID, destination.AccountID, destination.PlaylistID, jpegData); err != nil {
+		log.Printf("Error uploading artwork for conversion %s: %v", conversion.ID, err)
+		return
+	}
+	conversion.CopiedArtwork = true
+}
+
+func (s *ConversionService) fetchArtwork(ctx context.Context, imageURL string) (image.Image, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", imageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	res, err := s.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error executing request: %w", err)
+	}
+	defer res.Body.Close()
+	if err := utils.CheckResponse("Artwork", res); err != nil {
+		return nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxArtworkDownload))
+	if err != nil {
+		return nil, fmt.Errorf("error reading artwork: %w", err)
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding artwork: %w", err)
+	}
+	if config.Width > maxArtworkSide || config.Height > maxArtworkSide {
+		return nil, fmt.Errorf("artwork of %dx%d pixels is too large", config.Width, config.Height)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding artwork: %w", err)
+	}
+	return img, nil
+}
+
+// Crops the image to a centered square and encodes it as a JPEG whose base64 encoding is at most maxSize bytes.
+// The image is scaled down to 640 pixels, and further when lowering the quality alone does not make it fit,
+// though never below 64 pixels. Images smaller than that are encoded at their own size.
+func EncodeCoverArt(img image.Image, maxSize int) ([]byte, error) {
+	bounds := img.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	if side == 0 {
+		return nil, fmt.Errorf("artwork is empty")
+	}
+	crop := image.Rect(0, 0, side, side).Add(image.Pt(
+		bounds.Min.X+(bounds.Dx()-side)/2,
+		bounds.Min.Y+(bounds.Dy()-side)/2,
+	))
+
+	for size := min(side, coverArtSize); size > 0; size /= 2 {
+		scaled := scaleSquare(img, crop, size)
+		for _, quality := range coverArtQualities {
+			var buf bytes.Buffer
+			if err := jpeg.Encode(&buf, scaled, &jpeg.Options{Quality: quality}); err != nil {
+				return nil, fmt.Errorf("error encoding JPEG: %w", err)
+			}
+			if base64.StdEncoding.EncodedLen(buf.Len()) <= maxSize {
+				return buf.Bytes(), nil
+			}
+		}
+		if size/2 < minCoverArtSize {
+			break
+		}
+	}
+	return nil, fmt.Errorf("artwork does not fit in %d bytes", maxSize)
+}
+
+// Scales the square region of the image to size×size, averaging the source pixels each target pixel covers
+func scaleSquare(img image.Image, region image.Rectangle, size int) *image.RGBA {
+	scaled := image.NewRGBA(image.Rect(0, 0, size, size))
+	side := region.Dx()
+	for y := 0; y < size; y++ {
+		y0, y1 := region.Min.Y+y*side/size, region.Min.Y+(y+1)*side/size
+		y1 = max(y1, y0+1)
+		for x := 0; x < size; x++ {
+			x0, x1 := region.Min.X+x*side/size, region.Min.X+(x+1)*side/size
+			x1 = max(x1, x0+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			scaled.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(b / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return scaled
+}
+
+// Description limits of each provider, in characters
+const (
+	spotifyDescriptionLimit = 300
+	youTubeDescriptionLimit = 5000
+)
+
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// Adapts a playlist description to the destination provider's rules. Spotify returns descriptions
+// HTML-escaped with links as tags, so markup is removed first. Spotify descriptions are a single line
+// of at most 300 characters; YouTube allows 5000 characters but no angle brackets.
+func SanitizeDescription(provider, description string) string {
+	description = html.UnescapeString(htmlTag.ReplaceAllString(description, ""))
+	description = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || !unicode.IsControl(r) {
+			return r
+		}
+		return -1
+	}, description)
+
+	limit := youTubeDescriptionLimit
+	switch provider {
+	case "spotify":
+		description = strings.Join(strings.Fields(description), " ")
+		limit = spotifyDescriptionLimit
+	case "youtube":
+		description = strings.NewReplacer("<", "", ">", "").Replace(description)
+	}
+	return truncate(strings.TrimSpace(description), limit)
+}
+
+// Shortens s to at most limit characters, ending it with an ellipsis when anything was cut
+func truncate(s string, limit int) string {
+	runes := []rune(s)
+	if len(runes) <= limit {
+		return s
+	}
+	return strings.TrimRightFunc(string(runes[:limit-1]), unicode.IsSpace) + "…"
+}
@@ -0,0 +1,119 @@
+package conversion
+
+import (
+	"time"
+)
+
+// Conversion statuses
+const (
+	StatusRunning   = "running"
+	StatusCompleted = "completed"
+	StatusFailed    = "failed"
+	StatusUndone    = "undone"
+)
+
+// How a conversion was triggered
+const (
+	TriggerSession = "session"
+	TriggerAPIKey  = "apiKey"
+)
+
+// Kinds of collections transferred between accounts
+const (
+	KindAlbums  = "albums"  // saved albums
+	KindArtists = "artists" // followed artists or subscribed channels
+)
+
+// A conversion of a playlist from one provider into a new or existing playlist on another,
+// a merge of several playlists from any provider into one, or a transfer of saved albums or followed artists
+type Conversion struct {
+	ID              string        `json:"id" bson:"_id"`
+	UserID          string        `json:"userId" bson:"userId"`
+	TriggeredBy     string        `json:"triggeredBy" bson:"triggeredBy"`       // session or apiKey
+	Kind            string        `json:"kind,omitempty" bson:"kind,omitempty"` // albums or artists for a transfer; empty for tracks
+	Status          string        `json:"status" bson:"status"`
+	Error           string        `json:"error,omitempty" bson:"error,omitempty"`
+	Source          PlaylistRef   `json:"source" bson:"source"`
+	Sources         []PlaylistRef `json:"sources,omitempty" bson:"sources,omitempty"` // the playlists a merge read from
+	Order           string        `json:"order,omitempty" bson:"order,omitempty"`     // how a merge ordered its sources' tracks
+	Destination     PlaylistRef   `json:"destination" bson:"destination"`
+	Matched         int           `json:"matched" bson:"matched"`
+	Unmatched       int           `json:"unmatched" bson:"unmatched"`
+	Skipped         int           `json:"skipped" bson:"skipped"` // tracks left out as duplicates; counted as matched
+	UnmatchedTracks []Track       `json:"unmatchedTracks" bson:"unmatchedTracks"`
+	// What the conversion wrote, so that it can be undone
+	CreatedPlaylist bool      `json:"createdPlaylist" bson:"createdPlaylist"`
+	CopiedArtwork   bool      `json:"copiedArtwork,omitempty" bson:"copiedArtwork,omitempty"`   // the source's cover art was uploaded to the created playlist
+	AddedItems      []string  `json:"addedItems" bson:"addedItems"`                             // Spotify URIs, or YouTube playlist item or subscription IDs
+	AddedPositions  []int     `json:"addedPositions,omitempty" bson:"addedPositions,omitempty"` // where AddedItems are in the playlist version AddedSnapshot, for Spotify
+	AddedSnapshot   string    `json:"addedSnapshot,omitempty" bson:"addedSnapshot,omitempty"`
+	StartedAt       time.Time `json:"startedAt" bson:"startedAt"`
+	FinishedAt      time.Time `json:"finishedAt" bson:"finishedAt,omitempty"`
+	DurationMs      int64     `json:"durationMs" bson:"durationMs"`
+	UndoneAt        time.Time `json:"undoneAt" bson:"undoneAt,omitempty"`
+}
+
+// A playlist or library on a provider
+type PlaylistRef struct {
+	Provider   string `json:"provider" bson:"provider"` // spotify or youtube
+	AccountID  string `json:"accountId,omitempty" bson:"accountId,omitempty"`
+	PlaylistID string `json:"playlistId,omitempty" bson:"playlistId,omitempty"`
+	Name       string `json:"name,omitempty" bson:"name,omitempty"`
+	URL        string `json:"url,omitempty" bson:"url,omitempty"`
+	Library    bool   `json:"library,omitempty" bson:"library,omitempty"` // the user's Liked Songs or liked videos rather than a playlist
+}
+
+// A track as read from a source playlist, or an album or artist read from a source collection
+type Track struct {
+	Provider string `json:"provider,omitempty" bson:"provider,omitempty"` // where the track was read from
+	ID       string `json:"id" bson:"id"`                                 // Spotify track URI or YouTube video ID
+	Title    string `json:"title" bson:"title"`
+	Artist   string `json:"artist,omitempty" bson:"artist,omitempty"`
+	Album    string `json:"album,omitempty" bson:"album,omitempty"`
+	ISRC     string `json:"isrc,omitempty" bson:"isrc,omitempty"`
+	UPC      string `json:"upc,omitempty" bson:"upc,omitempty"` // albums only
+}
+
+// Narrows down a user's conversion history. Zero fields do not filter.
+type ListFilter struct {
+	Source      string // source provider
+	Destination string // destination provider
+	Status      string
+	Since       time.Time
+	Until       time.Time
+	Limit       int
+	Offset      int
+}
+
+// Reports whether the conversion passes the filter, ignoring pagination
+func (f ListFilter) matches(c Conversion) bool {
+	if f.Source != "" && !c.readFrom(f.Source) {
+		return false
+	}
+	if f.Destination != "" && c.Destination.Provider != f.Destination {
+		return false
+	}
+	if f.Status != "" && c.Status != f.Status {
+		return false
+	}
+	if !f.Since.IsZero() && c.StartedAt.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !c.StartedAt.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Reports whether the conversion read from the provider
+func (c Conversion) readFrom(provider string) bool {
+	if c.Source.Provider == provider {
+		return true
+	}
+	for _, source := range c.Sources {
+		if source.Provider == provider {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,160 @@
+package conversion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/roblieblang/luthien/backend/internal/utils"
+)
+
+var (
+	ErrInvalidPlaylistURL  = errors.New("invalid playlist URL")
+	ErrPlaylistUnavailable = errors.New("playlist is private or unavailable")
+)
+
+var (
+	// Spotify IDs are 22 base-62 characters
+	spotifyPlaylistID = regexp.MustCompile(`^[0-9A-Za-z]{22}$`)
+	// YouTube playlist IDs are a two-letter prefix such as PL, OL or UU followed by URL-safe base-64
+	youTubePlaylistID = regexp.MustCompile(`^[0-9A-Za-z_-]{12,64}$`)
+)
+
+// A playlist's details, as resolved from its URL
+type PlaylistInfo struct {
+	Provider    string `json:"provider"`
+	PlaylistID  string `json:"playlistId"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	TrackCount  int    `json:"trackCount"`
+	ImageURL    string `json:"imageUrl,omitempty"`
+	URL         string `json:"url"`
+}
+
+// Extracts the provider and playlist ID from a pasted playlist link. Accepts open.spotify.com/playlist/<id>
+// and spotify:playlist:<id> links, and youtube.com, music.youtube.com and youtu.be links with a list parameter.
+func ParsePlaylistURL(raw string) (string, string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", "", fmt.Errorf("%w: url is required", ErrInvalidPlaylistURL)
+	}
+
+	if strings.HasPrefix(raw, "spotify:") {
+		// spotify:playlist:<id>, or the older spotify:user:<user>:playlist:<id>
+		parts := strings.Split(raw, ":")
+		if len(parts) < 3 || parts[len(parts)-2] != "playlist" {
+			return "", "", fmt.Errorf("%w: not a Spotify playlist URI", ErrInvalidPlaylistURL)
+		}
+		return validPlaylistID("spotify", parts[len(parts)-1])
+	}
+
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidPlaylistURL, err)
+	}
+
+	switch strings.TrimPrefix(strings.ToLower(parsed.Hostname()), "www.") {
+	case "open.spotify.com", "play.spotify.com":
+		// Links shared from some regions are prefixed, e.g. /intl-de/playlist/<id>
+		segments := strings.Split(strings.Trim(parsed.Path, "/"), "/")
+		for i := 0; i+1 < len(segments); i++ {
+			if segments[i] == "playlist" {
+				return validPlaylistID("spotify", segments[i+1])
+			}
+		}
+		return "", "", fmt.Errorf("%w: not a Spotify playlist link", ErrInvalidPlaylistURL)
+	case "youtube.com", "m.youtube.com", "music.youtube.com", "youtu.be":
+		list := parsed.Query().Get("list")
+		if list == "" {
+			return "", "", fmt.Errorf("%w: YouTube link has no list parameter", ErrInvalidPlaylistURL)
+		}
+		return validPlaylistID("youtube", list)
+	}
+	return "", "", fmt.Errorf("%w: not a Spotify or YouTube link", ErrInvalidPlaylistURL)
+}
+
+func validPlaylistID(provider, id string) (string, string, error) {
+	switch provider {
+	case "spotify":
+		if !spotifyPlaylistID.MatchString(id) {
+			return "", "", fmt.Errorf("%w: malformed Spotify playlist ID %q", ErrInvalidPlaylistURL, id)
+		}
+	case "youtube":
+		if !youTubePlaylistID.MatchString(id) {
+			return "", "", fmt.Errorf("%w: malformed YouTube playlist ID %q", ErrInvalidPlaylistURL, id)
+		}
+		// Mixes are generated per viewer and cannot be read through the API
+		if strings.HasPrefix(id, "RD") {
+			return "", "", fmt.Errorf("%w: YouTube mixes cannot be converted", ErrInvalidPlaylistURL)
+		}
+	}
+	return provider, id, nil
+}
+
+// Reports a playlist that cannot be read as ErrPlaylistUnavailable
+func playlistUnavailable(err error, provider, playlistID string) error {
+	if errors.Is(err, utils.ErrNotFound) {
+		return fmt.Errorf("%w: %s playlist %s: %v", ErrPlaylistUnavailable, provider, playlistID, err)
+	}
+	return err
+}
+
+// Looks up the playlist a link points to, read through the user's linked account of its provider.
+// Returns ErrInvalidPlaylistURL for links that are not playlist links, and ErrPlaylistUnavailable for
+// playlists that are private or no longer exist.
+func (s *ConversionService) ResolvePlaylist(ctx context.Context, userID, accountID, rawURL string) (*PlaylistInfo, error) {
+	providerName, playlistID, err := ParsePlaylistURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	provider, ok := s.Providers[providerName]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown provider %q", ErrInvalidPlaylistURL, providerName)
+	}
+
+	info, err := provider.GetPlaylist(ctx, userID, accountID, playlistID)
+	if err != nil {
+		return nil, playlistUnavailable(err, providerName, playlistID)
+	}
+	info.Provider = providerName
+	return &info, nil
+}
+
+// Fills in the provider and playlist ID of a source given by URL
+func parseSourceURL(source *SourceRequest) error {
+	if source.URL == "" {
+		return nil
+	}
+	providerName, playlistID, err := ParsePlaylistURL(source.URL)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidConversion, err)
+	}
+	if source.Provider != "" && source.Provider != providerName {
+		return fmt.Errorf("%w: source url is a %s link but provider is %s", ErrInvalidConversion, providerName, source.Provider)
+	}
+	source.Provider, source.PlaylistID = providerName, playlistID
+	return nil
+}
+
+// Names a source given by URL after the playlist it points to, unless a name is given
+func (s *ConversionService) resolveSourceName(ctx context.Context, userID string, source *SourceRequest) error {
+	if source.URL == "" || source.Name != "" {
+		return nil
+	}
+	if err := parseSourceURL(source); err != nil {
+		return err
+	}
+	info, err := s.ResolvePlaylist(ctx, userID, source.AccountID, source.URL)
+	if err != nil {
+		return err
+	}
+	source.Name = info.Name
+	return nil
+}
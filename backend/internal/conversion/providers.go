@@ -0,0 +1,366 @@
+package conversion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/roblieblang/luthien/backend/internal/auth/spotify"
+	"github.com/roblieblang/luthien/backend/internal/auth/youtube"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+)
+
+// A playlist to be created by a conversion
+type NewPlaylist struct {
+	Name        string
+	Description string
+	Visibility  string // public, private or unlisted
+}
+
+// A provider conversions read playlists and libraries from and write them to.
+// An empty accountID selects the user's default linked account.
+type Provider interface {
+	// Returns the ID of the linked account the selector picks. An empty selector picks the default account.
+	ResolveAccountID(ctx context.Context, userID, selector string) (string, error)
+	GetPlaylistTracks(ctx context.Context, userID, accountID, playlistID string) ([]Track, error)
+	// Works for any playlist the account can see, which includes other users' public playlists
+	GetPlaylist(ctx context.Context, userID, accountID, playlistID string) (PlaylistInfo, error)
+	// Finds the provider's best match for a track read from another provider. Reports false if there is none.
+	FindTrack(ctx context.Context, userID, accountID string, track Track) (string, bool, error)
+	// Returns the ID of the created playlist
+	CreatePlaylist(ctx context.Context, userID, accountID string, playlist NewPlaylist) (string, error)
+	// Returns the IDs of the added playlist items, which RemoveItems takes. These are returned even
+	// alongside an error for as many items as were added before it.
+	// A nil position appends the tracks.
+	AddTracks(ctx context.Context, userID, accountID, playlistID string, trackIDs []string, position *int) ([]string, error)
+	RemoveItems(ctx context.Context, userID, accountID, playlistID string, itemIDs []string) error
+	DeletePlaylist(ctx context.Context, userID, accountID, playlistID string) error
+	PlaylistURL(playlistID string) string
+	// The library is the user's saved or liked tracks, most recent first
+	GetLibraryTracks(ctx context.Context, userID, accountID string) ([]Track, error)
+	// Returns the IDs of the tracks saved, even alongside an error for as many as were saved before it
+	SaveTracks(ctx context.Context, userID, accountID string, trackIDs []string) ([]string, error)
+	UnsaveTracks(ctx context.Context, userID, accountID string, trackIDs []string) error
+	LibraryURL() string
+}
+
+// A provider whose API is metered by a daily quota, so that a conversion can be refused before it starts
+// instead of running out of quota halfway
+type QuotaProvider interface {
+	// Checks that the given number of searches and writes fits in what remains of the quota for batch jobs
+	CheckBatchBudget(ctx context.Context, searches, writes int) error
+}
+
+// A provider that tells the copies of a track in a playlist apart only by their position in a version of the
+// playlist. Undoing a conversion into an existing playlist then removes the copies it added, not those added since.
+type PositionalProvider interface {
+	// Like AddTracks, also returning the positions the tracks were added at and the playlist version they are at
+	AddTracksAt(ctx context.Context, userID, accountID, playlistID string, trackIDs []string, position *int) (PositionedItems, error)
+	// Removes items added by AddTracksAt. Refuses with ErrNotUndoable once the playlist has changed since.
+	RemoveTracksAt(ctx context.Context, userID, accountID, playlistID string, items PositionedItems) error
+}
+
+type PositionedItems struct {
+	IDs        []string
+	Positions  []int  // zero-based, in the same order as IDs
+	SnapshotID string // the playlist version the positions refer to
+}
+
+// Adapts spotify.SpotifyService to Provider
+type SpotifyProvider struct {
+	Service *spotify.SpotifyService
+}
+
+func (p SpotifyProvider) ResolveAccountID(ctx context.Context, userID, selector string) (string, error) {
+	return p.Service.ResolveAccountID(ctx, userID, selector)
+}
+
+func (p SpotifyProvider) GetPlaylistTracks(ctx context.Context, userID, accountID, playlistID string) ([]Track, error) {
+	res, err := p.Service.GetPlaylistTracks(ctx, userID, accountID, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	return spotifyTracks(res.Items), nil
+}
+
+func spotifyTracks(items []spotify.PlaylistTrackItem) []Track {
+	tracks := make([]Track, 0, len(items))
+	for _, item := range items {
+		// Local files and removed tracks have no URI and cannot be converted
+		if item.Track.URI == "" {
+			continue
+		}
+		tracks = append(tracks, spotifyTrack(item))
+	}
+	return tracks
+}
+
+func spotifyTrack(item spotify.PlaylistTrackItem) Track {
+	artists := make([]string, len(item.Track.Artists))
+	for i, artist := range item.Track.Artists {
+		artists[i] = artist.Name
+	}
+	return Track{
+		ID:     item.Track.URI,
+		Title:  item.Track.Name,
+		Artist: strings.Join(artists, ", "),
+		Album:  item.Track.Album.Name,
+		ISRC:   item.Track.ExternalIDs.ISRC,
+	}
+}
+
+func (p SpotifyProvider) GetPlaylist(ctx context.Context, userID, accountID, playlistID string) (PlaylistInfo, error) {
+	playlist, err := p.Service.GetPlaylist(ctx, userID, accountID, playlistID)
+	if err != nil {
+		return PlaylistInfo{}, err
+	}
+	var imageURL string
+	if len(playlist.Images) > 0 {
+		imageURL = playlist.Images[0].URL
+	}
+	return PlaylistInfo{
+		PlaylistID:  playlist.ID,
+		Name:        playlist.Name,
+		Description: playlist.Description,
+		Owner:       playlist.Owner.DisplayName,
+		TrackCount:  playlist.Tracks.Total,
+		ImageURL:    imageURL,
+		URL:         p.PlaylistURL(playlist.ID),
+	}, nil
+}
+
+// Tracks without an artist come from YouTube videos whose title has to be searched as a whole
+func (p SpotifyProvider) FindTrack(ctx context.Context, userID, accountID string, track Track) (string, bool, error) {
+	var results []utils.UnifiedTrackSearchResult
+	var err error
+	if track.Artist != "" {
+		results, err = p.Service.SearchTracksUsingArtistAndTrack(ctx, userID, accountID, track.Artist, track.Title, 1, 0)
+	} else {
+		results, err = p.Service.SearchTracksUsingVideoTitle(ctx, userID, accountID, track.Title)
+	}
+	if errors.Is(err, spotify.ErrNoTracksFound) {
+		return "", false, nil
+	}
+	if err != nil || len(results) == 0 {
+		return "", false, err
+	}
+	return results[0].ID, true, nil
+}
+
+func (p SpotifyProvider) CreatePlaylist(ctx context.Context, userID, accountID string, playlist NewPlaylist) (string, error) {
+	profile, err := p.Service.GetCurrentUserProfile(ctx, userID, accountID)
+	if err != nil {
+		return "", err
+	}
+	// Spotify has no unlisted playlists
+	public := playlist.Visibility == "public"
+	return p.Service.CreatePlaylist(ctx, userID, accountID, profile.ID, spotify.CreatePlaylistPayload{
+		Name:        playlist.Name,
+		Public:      &public,
+		Description: playlist.Description,
+	})
+}
+
+// Spotify playlist items are identified by their track URIs. Tracks are added in chunks, and the URIs of
+// the chunks added before a failure are returned with the error.
+func (p SpotifyProvider) AddTracks(ctx context.Context, userID, accountID, playlistID string, trackIDs []string, position *int) ([]string, error) {
+	added, err := p.Service.AddItemsToPlaylist(ctx, userID, accountID, playlistID, spotify.AddItemsToPlaylistPayload{ItemURIs: trackIDs, Position: position})
+	return added.URIs, err
+}
+
+// Removes every occurrence of the tracks, including copies added after the conversion. Undo uses
+// RemoveTracksAt instead for conversions into existing playlists.
+func (p SpotifyProvider) RemoveItems(ctx context.Context, userID, accountID, playlistID string, itemIDs []string) error {
+	return p.Service.RemoveItemsFromPlaylist(ctx, userID, accountID, playlistID, itemIDs)
+}
+
+// Appended tracks are inserted at the playlist's current length, so that their positions are known even if
+// other tracks are added meanwhile
+func (p SpotifyProvider) AddTracksAt(ctx context.Context, userID, accountID, playlistID string, trackIDs []string, position *int) (PositionedItems, error) {
+	if position == nil {
+		playlist, err := p.Service.GetPlaylist(ctx, userID, accountID, playlistID)
+		if err != nil {
+			return PositionedItems{}, err
+		}
+		position = &playlist.Tracks.Total
+	}
+	added, err := p.Service.AddItemsToPlaylist(ctx, userID, accountID, playlistID, spotify.AddItemsToPlaylistPayload{ItemURIs: trackIDs, Position: position})
+	items := PositionedItems{IDs: added.URIs, SnapshotID: added.SnapshotID}
+	for i := range added.URIs {
+		items.Positions = append(items.Positions, *position+i)
+	}
+	return items, err
+}
+
+func (p SpotifyProvider) RemoveTracksAt(ctx context.Context, userID, accountID, playlistID string, items PositionedItems) error {
+	playlist, err := p.Service.GetPlaylist(ctx, userID, accountID, playlistID)
+	if err != nil {
+		return err
+	}
+	if playlist.SnapshotID != items.SnapshotID {
+		return fmt.Errorf("%w: the playlist has changed since the tracks were added", ErrNotUndoable)
+	}
+	removals := make([]spotify.PlaylistItemURI, len(items.IDs))
+	for i, uri := range items.IDs {
+		removals[i] = spotify.PlaylistItemURI{URI: uri, Positions: []int{items.Positions[i]}}
+	}
+	_, err = p.Service.RemovePlaylistItems(ctx, userID, accountID, playlistID, items.SnapshotID, removals)
+	return err
+}
+
+func (p SpotifyProvider) DeletePlaylist(ctx context.Context, userID, accountID, playlistID string) error {
+	return p.Service.DeletePlaylist(ctx, userID, accountID, playlistID)
+}
+
+func (p SpotifyProvider) PlaylistURL(playlistID string) string {
+	return "https://open.spotify.com/playlist/" + playlistID
+}
+
+// Reads Liked Songs
+func (p SpotifyProvider) GetLibraryTracks(ctx context.Context, userID, accountID string) ([]Track, error) {
+	res, err := p.Service.GetSavedTracks(ctx, userID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	return spotifyTracks(res.Items), nil
+}
+
+func (p SpotifyProvider) SaveTracks(ctx context.Context, userID, accountID string, trackIDs []string) ([]string, error) {
+	return p.Service.SaveTracks(ctx, userID, accountID, trackIDs)
+}
+
+func (p SpotifyProvider) UnsaveTracks(ctx context.Context, userID, accountID string, trackIDs []string) error {
+	return p.Service.RemoveSavedTracks(ctx, userID, accountID, trackIDs)
+}
+
+func (p SpotifyProvider) LibraryURL() string {
+	return "https://open.spotify.com/collection/tracks"
+}
+
+// Adapts youtube.YouTubeService to Provider
+type YouTubeProvider struct {
+	Service *youtube.YouTubeService
+}
+
+func (p YouTubeProvider) ResolveAccountID(ctx context.Context, userID, selector string) (string, error) {
+	return p.Service.ResolveAccountID(ctx, userID, selector)
+}
+
+func (p YouTubeProvider) GetPlaylistTracks(ctx context.Context, userID, accountID, playlistID string) ([]Track, error) {
+	res, err := p.Service.GetPlaylistItems(ctx, userID, accountID, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	tracks := make([]Track, 0, len(res.Items))
+	for _, item := range res.Items {
+		// Deleted and private videos have no owner channel
+		if item.VideoID == "" || item.VideoOwnerChannelTitle == "" {
+			continue
+		}
+		tracks = append(tracks, youTubeTrack(item.VideoID, item.Title, item.VideoOwnerChannelTitle))
+	}
+	return tracks, nil
+}
+
+// Videos from auto-generated "<artist> - Topic" channels are titled with the song alone, so the channel
+// names the artist. Any other video keeps its artist in the title.
+func youTubeTrack(videoID, title, channelTitle string) Track {
+	artist, isTopic := strings.CutSuffix(channelTitle, " - Topic")
+	if !isTopic {
+		artist = ""
+	}
+	return Track{ID: videoID, Title: title, Artist: artist}
+}
+
+func (p YouTubeProvider) GetPlaylist(ctx context.Context, userID, accountID, playlistID string) (PlaylistInfo, error) {
+	playlist, err := p.Service.GetPlaylist(ctx, userID, accountID, playlistID)
+	if err != nil {
+		return PlaylistInfo{}, err
+	}
+	return PlaylistInfo{
+		PlaylistID:  playlist.ID,
+		Name:        playlist.Title,
+		Description: playlist.Description,
+		Owner:       playlist.ChannelTitle,
+		TrackCount:  int(playlist.VideosCount),
+		ImageURL:    playlist.ImageURL,
+		URL:         p.PlaylistURL(playlist.ID),
+	}, nil
+}
+
+func (p YouTubeProvider) CheckBatchBudget(ctx context.Context, searches, writes int) error {
+	return p.Service.CheckBatchBudget(ctx, searches, writes)
+}
+
+// Searches only on behalf of conversions, so they spend the batch budget and leave the interactive reserve alone
+func (p YouTubeProvider) FindTrack(ctx context.Context, userID, accountID string, track Track) (string, bool, error) {
+	ctx = youtube.WithQuotaPriority(ctx, youtube.QuotaBatch)
+	results, err := p.Service.SearchVideos(ctx, userID, accountID, track.Artist, track.Title)
+	if err != nil || len(results) == 0 {
+		return "", false, err
+	}
+	return results[0].ID, true, nil
+}
+
+func (p YouTubeProvider) CreatePlaylist(ctx context.Context, userID, accountID string, playlist NewPlaylist) (string, error) {
+	created, err := p.Service.CreatePlaylist(ctx, userID, accountID, youtube.CreatePlaylistPayload{
+		Title:         playlist.Name,
+		Description:   playlist.Description,
+		PrivacyStatus: playlist.Visibility,
+	})
+	if err != nil {
+		return "", err
+	}
+	return created.Id, nil
+}
+
+func (p YouTubeProvider) AddTracks(ctx context.Context, userID, accountID, playlistID string, trackIDs []string, position *int) ([]string, error) {
+	payload := youtube.AddItemsToPlaylistPayload{PlaylistID: playlistID, VideoIDs: trackIDs}
+	if position != nil {
+		youTubePosition := int64(*position)
+		payload.Position = &youTubePosition
+	}
+	return p.Service.AddItemsToPlaylist(ctx, userID, accountID, payload)
+}
+
+func (p YouTubeProvider) RemoveItems(ctx context.Context, userID, accountID, playlistID string, itemIDs []string) error {
+	return p.Service.DeletePlaylistItems(ctx, userID, accountID, itemIDs)
+}
+
+func (p YouTubeProvider) DeletePlaylist(ctx context.Context, userID, accountID, playlistID string) error {
+	return p.Service.DeletePlaylist(ctx, userID, accountID, playlistID)
+}
+
+func (p YouTubeProvider) PlaylistURL(playlistID string) string {
+	return "https://www.youtube.com/playlist?list=" + playlistID
+}
+
+// Reads liked videos
+func (p YouTubeProvider) GetLibraryTracks(ctx context.Context, userID, accountID string) ([]Track, error) {
+	videos, err := p.Service.GetLikedVideos(ctx, userID, accountID)
+	if err != nil {
+		return nil, err
+	}
+	tracks := make([]Track, 0, len(videos))
+	for _, video := range videos {
+		tracks = append(tracks, youTubeTrack(video.ID, video.Title, video.ChannelTitle))
+	}
+	return tracks, nil
+}
+
+// Likes the videos
+func (p YouTubeProvider) SaveTracks(ctx context.Context, userID, accountID string, trackIDs []string) ([]string, error) {
+	return p.Service.RateVideos(ctx, userID, accountID, trackIDs, "like")
+}
+
+// Removes the likes of the videos
+func (p YouTubeProvider) UnsaveTracks(ctx context.Context, userID, accountID string, trackIDs []string) error {
+	_, err := p.Service.RateVideos(ctx, userID, accountID, trackIDs, "none")
+	return err
+}
+
+// The playlist of liked videos
+func (p YouTubeProvider) LibraryURL() string {
+	return p.PlaylistURL("LL")
+}
@@ -0,0 +1,488 @@
+package conversion
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrInvalidConversion = errors.New("invalid conversion")
+	ErrNotUndoable       = errors.New("conversion cannot be undone")
+)
+
+// How long recording the outcome of a conversion may take once its request has ended
+const recordTimeout = 10 * time.Second
+
+type ConvertRequest struct {
+	UserID      string             `json:"userId"`
+	Source      SourceRequest      `json:"source"`
+	Destination DestinationRequest `json:"destination"`
+	TriggeredBy string             `json:"-"` // set by the handler from how the request was authenticated
+}
+
+type SourceRequest struct {
+	Provider   string `json:"provider"`
+	AccountID  string `json:"accountId"`
+	PlaylistID string `json:"playlistId"`
+	URL        string `json:"url"` // a playlist link, which sets the provider and playlistId; may be another user's public playlist
+	Name       string `json:"name"`
+	Library    bool   `json:"library"` // reads the user's Liked Songs or liked videos instead of a playlist
+}
+
+// Conversion target modes
+const (
+	ModeNew      = "new"      // creates a playlist
+	ModeExisting = "existing" // adds the tracks that are not already in an existing playlist
+	ModeLibrary  = "library"  // saves or likes the tracks that are not already in the user's library
+)
+
+type DestinationRequest struct {
+	Provider  string `json:"provider"`
+	AccountID string `json:"accountId"`
+	Mode      string `json:"mode"` // new, existing or library; defaults to new
+	// Used when creating a playlist
+	Name        string `json:"name"` // defaults to the source playlist's name
+	Description string `json:"description"`
+	Visibility  string `json:"visibility"` // public, private or unlisted; defaults to private
+	// Used when adding to an existing playlist
+	PlaylistID string `json:"playlistId"`
+	Position   *int   `json:"position"` // zero-based position the tracks are inserted at in source order; appended when omitted
+}
+
+type ConversionService struct {
+	Store      HistoryStore
+	Providers  map[string]Provider // provider name -> provider
+	HTTPClient *http.Client        // downloads playlist artwork
+}
+
+func NewConversionService(store HistoryStore, providers map[string]Provider) *ConversionService {
+	return &ConversionService{
+		Store:      store,
+		Providers:  providers,
+		HTTPClient: &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (s *ConversionService) validate(req *ConvertRequest) error {
+	if req.UserID == "" {
+		return fmt.Errorf("%w: userId is required", ErrInvalidConversion)
+	}
+	if err := s.validateSource(&req.Source); err != nil {
+		return err
+	}
+	// Copying a library into a playlist, or a playlist into the library, works within one provider
+	if req.Source.Provider == req.Destination.Provider && !req.Source.Library && req.Destination.Mode != ModeLibrary {
+		return fmt.Errorf("%w: source and destination providers must differ", ErrInvalidConversion)
+	}
+	return s.validateDestination(&req.Destination, req.Source.Name)
+}
+
+func (s *ConversionService) validateSource(source *SourceRequest) error {
+	if err := parseSourceURL(source); err != nil {
+		return err
+	}
+	if _, ok := s.Providers[source.Provider]; !ok {
+		return fmt.Errorf("%w: unknown provider %q", ErrInvalidConversion, source.Provider)
+	}
+	if source.PlaylistID == "" && !source.Library {
+		return fmt.Errorf("%w: source playlistId or url is required", ErrInvalidConversion)
+	}
+	return nil
+}
+
+// Fills in the destination's defaults. A new playlist is named defaultName unless a name is given.
+func (s *ConversionService) validateDestination(destination *DestinationRequest, defaultName string) error {
+	if _, ok := s.Providers[destination.Provider]; !ok {
+		return fmt.Errorf("%w: unknown provider %q", ErrInvalidConversion, destination.Provider)
+	}
+	switch destination.Mode {
+	case "", ModeNew:
+		destination.Mode = ModeNew
+		if destination.Name == "" {
+			destination.Name = defaultName
+		}
+		if destination.Name == "" {
+			return fmt.Errorf("%w: destination name is required", ErrInvalidConversion)
+		}
+		switch destination.Visibility {
+		case "":
+			destination.Visibility = "private"
+		case "public", "private", "unlisted":
+		default:
+			return fmt.Errorf("%w: visibility must be public, private or unlisted", ErrInvalidConversion)
+		}
+		// A new playlist is empty, so there is no position to insert at
+		destination.Position = nil
+	case ModeExisting:
+		if destination.PlaylistID == "" {
+			return fmt.Errorf("%w: destination playlistId is required", ErrInvalidConversion)
+		}
+		if destination.Position != nil && *destination.Position < 0 {
+			return fmt.Errorf("%w: position must not be negative", ErrInvalidConversion)
+		}
+	case ModeLibrary:
+		destination.PlaylistID = ""
+		destination.Position = nil
+	default:
+		return fmt.Errorf("%w: mode must be new, existing or library", ErrInvalidConversion)
+	}
+	return nil
+}
+
+// Replaces the destination's account selector with the account it picks now, so that the conversion is recorded,
+// and later undone, against that account even if the user changes their default account in between
+func (s *ConversionService) resolveDestinationAccount(ctx context.Context, userID string, destination *DestinationRequest) error {
+	accountID, err := s.Providers[destination.Provider].ResolveAccountID(ctx, userID, destination.AccountID)
+	if err != nil {
+		return fmt.Errorf("error resolving %s account: %w", destination.Provider, err)
+	}
+	destination.AccountID = accountID
+	return nil
+}
+
+// Starts the history record of a conversion into the destination
+func newConversion(userID, triggeredBy string, destination DestinationRequest) *Conversion {
+	if triggeredBy == "" {
+		triggeredBy = TriggerSession
+	}
+	return &Conversion{
+		ID:          primitive.NewObjectID().Hex(),
+		UserID:      userID,
+		TriggeredBy: triggeredBy,
+		Status:      StatusRunning,
+		Destination: PlaylistRef{
+			Provider:   destination.Provider,
+			AccountID:  destination.AccountID,
+			PlaylistID: destination.PlaylistID,
+			Name:       destination.Name,
+			Library:    destination.Mode == ModeLibrary,
+		},
+		UnmatchedTracks: []Track{},
+		AddedItems:      []string{},
+		StartedAt:       time.Now().UTC(),
+	}
+}
+
+func sourceRef(source SourceRequest) PlaylistRef {
+	return PlaylistRef{
+		Provider:   source.Provider,
+		AccountID:  source.AccountID,
+		PlaylistID: source.PlaylistID,
+		Name:       source.Name,
+		Library:    source.Library,
+	}
+}
+
+// Converts the source playlist into a new or existing destination playlist and records the conversion in the user's history.
+// Once recorded, the conversion is returned alongside any error, so that failures can be looked up later.
+func (s *ConversionService) Convert(ctx context.Context, req ConvertRequest) (*Conversion, error) {
+	if req.UserID != "" {
+		if err := s.resolveSourceName(ctx, req.UserID, &req.Source); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.validate(&req); err != nil {
+		return nil, err
+	}
+	if err := s.resolveDestinationAccount(ctx, req.UserID, &req.Destination); err != nil {
+		return nil, err
+	}
+
+	conversion := newConversion(req.UserID, req.TriggeredBy, req.Destination)
+	conversion.Source = sourceRef(req.Source)
+	return s.execute(ctx, conversion, func() error {
+		tracks, err := s.getTracks(ctx, req.UserID, req.Source)
+		if err != nil {
+			return err
+		}
+
+		// A new playlist takes the source playlist's description and cover art
+		var source PlaylistInfo
+		if req.Destination.Mode == ModeNew && !req.Source.Library {
+			source, err = s.Providers[req.Source.Provider].GetPlaylist(ctx, req.UserID, req.Source.AccountID, req.Source.PlaylistID)
+			if err != nil {
+				log.Printf("Error getting details of %s playlist %s: %v", req.Source.Provider, req.Source.PlaylistID, err)
+			}
+			if req.Destination.Description == "" {
+				req.Destination.Description = source.Description
+			}
+		}
+
+		if err := s.write(ctx, conversion, tracks, req.Destination, false); err != nil {
+			return err
+		}
+		if conversion.CreatedPlaylist && source.ImageURL != "" {
+			s.copyArtwork(ctx, conversion, source.ImageURL)
+		}
+		return nil
+	})
+}
+
+// Records the conversion in the user's history, runs it and records its outcome
+func (s *ConversionService) execute(ctx context.Context, conversion *Conversion, run func() error) (*Conversion, error) {
+	if err := s.Store.Create(ctx, conversion); err != nil {
+		return nil, fmt.Errorf("error recording conversion: %w", err)
+	}
+
+	err := run()
+
+	conversion.FinishedAt = time.Now().UTC()
+	conversion.DurationMs = conversion.FinishedAt.Sub(conversion.StartedAt).Milliseconds()
+	conversion.Status = StatusCompleted
+	if err != nil {
+		conversion.Status = StatusFailed
+		conversion.Error = err.Error()
+	}
+	s.record(ctx, conversion)
+	return conversion, err
+}
+
+// Stores the conversion's outcome, even if the request timed out or was cancelled
+func (s *ConversionService) record(ctx context.Context, conversion *Conversion) {
+	recordCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), recordTimeout)
+	defer cancel()
+	if err := s.Store.Update(recordCtx, conversion); err != nil {
+		log.Printf("Error recording outcome of conversion %s: %v", conversion.ID, err)
+	}
+}
+
+// Refuses a write to a quota-metered provider up front if the searches and writes it may take do not fit in the remaining budget.
+// Writes are counted as if every track matched.
+func checkBatchBudget(ctx context.Context, target Provider, provider string, tracks []Track, presentISRCs map[string]bool, createsPlaylist bool) error {
+	metered, ok := target.(QuotaProvider)
+	if !ok {
+		return nil
+	}
+	searches, writes := 0, 0
+	for _, track := range tracks {
+		if track.ISRC != "" && presentISRCs[track.ISRC] {
+			continue
+		}
+		if track.Provider != provider {
+			searches++
+		}
+		writes++
+	}
+	if createsPlaylist {
+		writes++
+	}
+	return metered.CheckBatchBudget(ctx, searches, writes)
+}
+
+// Reads a source playlist or library, marking each track with the provider it came from
+func (s *ConversionService) getTracks(ctx context.Context, userID string, source SourceRequest) ([]Track, error) {
+	provider := s.Providers[source.Provider]
+	if source.Library {
+		tracks, err := provider.GetLibraryTracks(ctx, userID, source.AccountID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting %s library tracks: %w", source.Provider, err)
+		}
+		return markProvider(tracks, source.Provider), nil
+	}
+	tracks, err := provider.GetPlaylistTracks(ctx, userID, source.AccountID, source.PlaylistID)
+	if err != nil {
+		return nil, playlistUnavailable(fmt.Errorf("error getting tracks of %s playlist %s: %w", source.Provider, source.PlaylistID, err), source.Provider, source.PlaylistID)
+	}
+	return markProvider(tracks, source.Provider), nil
+}
+
+func markProvider(tracks []Track, provider string) []Track {
+	for i := range tracks {
+		tracks[i].Provider = provider
+	}
+	return tracks
+}
+
+// Writes the tracks to the destination. Every track is matched before anything is written,
+// so that a failed search leaves nothing behind. Tracks from the destination's own provider need no search.
+// With dedupe, tracks that match the same destination track as an earlier one are left out.
+func (s *ConversionService) write(ctx context.Context, conversion *Conversion, tracks []Track, destination DestinationRequest, dedupe bool) error {
+	target := s.Providers[conversion.Destination.Provider]
+
+	// Tracks written or already in the destination, by track ID and ISRC. Nil unless deduplicating.
+	var presentIDs, presentISRCs map[string]bool
+	if dedupe || destination.Mode != ModeNew {
+		presentIDs, presentISRCs = make(map[string]bool), make(map[string]bool)
+	}
+	if destination.Mode != ModeNew {
+		var existing []Track
+		var err error
+		if destination.Mode == ModeLibrary {
+			existing, err = target.GetLibraryTracks(ctx, conversion.UserID, conversion.Destination.AccountID)
+		} else {
+			existing, err = target.GetPlaylistTracks(ctx, conversion.UserID, conversion.Destination.AccountID, destination.PlaylistID)
+		}
+		if err != nil {
+			return fmt.Errorf("error getting destination tracks: %w", err)
+		}
+		for _, track := range existing {
+			presentIDs[track.ID] = true
+			if track.ISRC != "" {
+				presentISRCs[track.ISRC] = true
+			}
+		}
+	}
+
+	if err := checkBatchBudget(ctx, target, conversion.Destination.Provider, tracks, presentISRCs, destination.Mode == ModeNew); err != nil {
+		return err
+	}
+
+	matchedIDs := make([]string, 0, len(tracks))
+	for _, track := range tracks {
+		// Known duplicates are skipped before searching, which saves the search quota
+		if track.ISRC != "" && presentISRCs[track.ISRC] {
+			conversion.Matched++
+			conversion.Skipped++
+			continue
+		}
+		id, found := track.ID, true
+		if track.Provider != conversion.Destination.Provider {
+			var err error
+			id, found, err = target.FindTrack(ctx, conversion.UserID, conversion.Destination.AccountID, track)
+			if err != nil {
+				return fmt.Errorf("error searching for %q: %w", track.Title, err)
+			}
+		}
+		if !found {
+			conversion.UnmatchedTracks = append(conversion.UnmatchedTracks, track)
+			continue
+		}
+		conversion.Matched++
+		if presentIDs != nil {
+			if presentIDs[id] {
+				conversion.Skipped++
+				continue
+			}
+			presentIDs[id] = true
+			if track.ISRC != "" {
+				presentISRCs[track.ISRC] = true
+			}
+		}
+		matchedIDs = append(matchedIDs, id)
+	}
+	conversion.Unmatched = len(conversion.UnmatchedTracks)
+
+	if destination.Mode == ModeLibrary {
+		conversion.Destination.URL = target.LibraryURL()
+		if len(matchedIDs) == 0 {
+			return nil
+		}
+		savedIDs, err := target.SaveTracks(ctx, conversion.UserID, conversion.Destination.AccountID, matchedIDs)
+		conversion.AddedItems = append(conversion.AddedItems, savedIDs...)
+		if err != nil {
+			return fmt.Errorf("error saving tracks to library: %w", err)
+		}
+		return nil
+	}
+
+	playlistID := destination.PlaylistID
+	if destination.Mode == ModeNew {
+		var err error
+		playlistID, err = target.CreatePlaylist(ctx, conversion.UserID, conversion.Destination.AccountID, NewPlaylist{
+			Name:        destination.Name,
+			Description: SanitizeDescription(conversion.Destination.Provider, destination.Description),
+			Visibility:  destination.Visibility,
+		})
+		if err != nil {
+			return fmt.Errorf("error creating destination playlist: %w", err)
+		}
+		conversion.CreatedPlaylist = true
+	}
+	conversion.Destination.PlaylistID = playlistID
+	conversion.Destination.URL = target.PlaylistURL(playlistID)
+
+	if len(matchedIDs) == 0 {
+		return nil
+	}
+	// Undoing must not take out copies of the tracks the user adds to an existing playlist later
+	var itemIDs []string
+	var err error
+	if positional, ok := target.(PositionalProvider); ok && !conversion.CreatedPlaylist {
+		var added PositionedItems
+		added, err = positional.AddTracksAt(ctx, conversion.UserID, conversion.Destination.AccountID, playlistID, matchedIDs, destination.Position)
+		itemIDs = added.IDs
+		conversion.AddedPositions = added.Positions
+		conversion.AddedSnapshot = added.SnapshotID
+	} else {
+		itemIDs, err = target.AddTracks(ctx, conversion.UserID, conversion.Destination.AccountID, playlistID, matchedIDs, destination.Position)
+	}
+	conversion.AddedItems = append(conversion.AddedItems, itemIDs...)
+	if err != nil {
+		return fmt.Errorf("error adding tracks to destination playlist: %w", err)
+	}
+	return nil
+}
+
+func (s *ConversionService) ListConversions(ctx context.Context, userID string, filter ListFilter) ([]Conversion, int64, error) {
+	return s.Store.List(ctx, userID, filter)
+}
+
+// Returns ErrConversionNotFound unless the user has a conversion with the ID
+func (s *ConversionService) GetConversion(ctx context.Context, userID, id string) (*Conversion, error) {
+	return s.Store.Get(ctx, userID, id)
+}
+
+// Rolls back what a conversion wrote: deletes the playlist it created, removes the items it added to an
+// existing playlist, unsaves the tracks it saved to the library, or removes the albums or artists it transferred.
+// Returns ErrNotUndoable if it is still running, was already undone or wrote nothing.
+func (s *ConversionService) UndoConversion(ctx context.Context, userID, id string) (*Conversion, error) {
+	conversion, err := s.Store.Get(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case conversion.Status == StatusRunning:
+		return conversion, fmt.Errorf("%w: it is still running", ErrNotUndoable)
+	case conversion.Status == StatusUndone:
+		return conversion, fmt.Errorf("%w: it was already undone", ErrNotUndoable)
+	case conversion.Destination.PlaylistID == "" && !conversion.Destination.Library && conversion.Kind == "":
+		return conversion, fmt.Errorf("%w: it did not write to a playlist", ErrNotUndoable)
+	}
+
+	if conversion.Kind != "" {
+		return s.undoTransfer(ctx, conversion)
+	}
+
+	target, ok := s.Providers[conversion.Destination.Provider]
+	if !ok {
+		return conversion, fmt.Errorf("%w: unknown provider %q", ErrNotUndoable, conversion.Destination.Provider)
+	}
+	destination := conversion.Destination
+	if conversion.CreatedPlaylist {
+		err = target.DeletePlaylist(ctx, userID, destination.AccountID, destination.PlaylistID)
+	} else if destination.Library {
+		if len(conversion.AddedItems) > 0 {
+			err = target.UnsaveTracks(ctx, userID, destination.AccountID, conversion.AddedItems)
+		}
+	} else if positional, ok := target.(PositionalProvider); ok && len(conversion.AddedItems) > 0 {
+		if conversion.AddedSnapshot == "" || len(conversion.AddedPositions) != len(conversion.AddedItems) {
+			return conversion, fmt.Errorf("%w: the positions of the tracks it added were not recorded", ErrNotUndoable)
+		}
+		err = positional.RemoveTracksAt(ctx, userID, destination.AccountID, destination.PlaylistID, PositionedItems{
+			IDs:        conversion.AddedItems,
+			Positions:  conversion.AddedPositions,
+			SnapshotID: conversion.AddedSnapshot,
+		})
+	} else if len(conversion.AddedItems) > 0 {
+		err = target.RemoveItems(ctx, userID, destination.AccountID, destination.PlaylistID, conversion.AddedItems)
+	}
+	if err != nil {
+		return conversion, fmt.Errorf("error undoing conversion: %w", err)
+	}
+
+	conversion.Status = StatusUndone
+	conversion.UndoneAt = time.Now().UTC()
+	s.record(ctx, conversion)
+	return conversion, nil
+}
+
+// Deletes the user's conversion history
+func (s *ConversionService) PurgeUserData(ctx context.Context, userID string) error {
+	return s.Store.PurgeUserData(ctx, userID)
+}
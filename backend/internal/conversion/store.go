@@ -0,0 +1,101 @@
+package conversion
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+var ErrConversionNotFound = errors.New("conversion not found")
+
+// Where conversion history is kept
+type HistoryStore interface {
+	Create(ctx context.Context, conversion *Conversion) error
+	// Replaces a stored conversion
+	Update(ctx context.Context, conversion *Conversion) error
+	// Returns ErrConversionNotFound unless the user has a conversion with the ID
+	Get(ctx context.Context, userID, id string) (*Conversion, error)
+	// Returns a page of the user's conversions, newest first, and how many match the filter in total
+	List(ctx context.Context, userID string, filter ListFilter) ([]Conversion, int64, error)
+	PurgeUserData(ctx context.Context, userID string) error
+}
+
+// Conversions kept per user by MemoryHistoryStore; the oldest are dropped beyond this
+const maxMemoryConversionsPerUser = 500
+
+// Keeps conversion history in process memory. Used when MongoDB is not configured, so history is lost on restart.
+type MemoryHistoryStore struct {
+	mu          sync.Mutex
+	conversions map[string][]Conversion // user ID -> conversions, oldest first
+}
+
+func NewMemoryHistoryStore() *MemoryHistoryStore {
+	return &MemoryHistoryStore{conversions: make(map[string][]Conversion)}
+}
+
+func (s *MemoryHistoryStore) Create(ctx context.Context, conversion *Conversion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	conversions := append(s.conversions[conversion.UserID], *conversion)
+	if len(conversions) > maxMemoryConversionsPerUser {
+		conversions = conversions[len(conversions)-maxMemoryConversionsPerUser:]
+	}
+	s.conversions[conversion.UserID] = conversions
+	return nil
+}
+
+func (s *MemoryHistoryStore) Update(ctx context.Context, conversion *Conversion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, stored := range s.conversions[conversion.UserID] {
+		if stored.ID == conversion.ID {
+			s.conversions[conversion.UserID][i] = *conversion
+			return nil
+		}
+	}
+	return ErrConversionNotFound
+}
+
+func (s *MemoryHistoryStore) Get(ctx context.Context, userID, id string) (*Conversion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, stored := range s.conversions[userID] {
+		if stored.ID == id {
+			conversion := stored
+			return &conversion, nil
+		}
+	}
+	return nil, ErrConversionNotFound
+}
+
+func (s *MemoryHistoryStore) List(ctx context.Context, userID string, filter ListFilter) ([]Conversion, int64, error) {
+	s.mu.Lock()
+	matching := []Conversion{}
+	for _, stored := range s.conversions[userID] {
+		if filter.matches(stored) {
+			matching = append(matching, stored)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.SliceStable(matching, func(i, j int) bool {
+		return matching[i].StartedAt.After(matching[j].StartedAt)
+	})
+	total := int64(len(matching))
+	if filter.Offset >= len(matching) {
+		return []Conversion{}, total, nil
+	}
+	matching = matching[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(matching) {
+		matching = matching[:filter.Limit]
+	}
+	return matching, total, nil
+}
+
+func (s *MemoryHistoryStore) PurgeUserData(ctx context.Context, userID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conversions, userID)
+	return nil
+}
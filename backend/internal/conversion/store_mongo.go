@@ -0,0 +1,104 @@
+package conversion
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Keeps conversion history in a MongoDB collection
+type MongoHistoryStore struct {
+	collection *mongo.Collection
+}
+
+func NewMongoHistoryStore(client *mongo.Client, dbName, collectionName string) *MongoHistoryStore {
+	return &MongoHistoryStore{collection: client.Database(dbName).Collection(collectionName)}
+}
+
+// Creates the index the history queries rely on. Safe to call on every startup.
+func (s *MongoHistoryStore) EnsureIndexes(ctx context.Context) error {
+	_, err := s.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "startedAt", Value: -1}},
+		Options: options.Index().SetName("userId_startedAt"),
+	})
+	return err
+}
+
+func (s *MongoHistoryStore) Create(ctx context.Context, conversion *Conversion) error {
+	_, err := s.collection.InsertOne(ctx, conversion)
+	return err
+}
+
+func (s *MongoHistoryStore) Update(ctx context.Context, conversion *Conversion) error {
+	res, err := s.collection.ReplaceOne(ctx, bson.M{"_id": conversion.ID, "userId": conversion.UserID}, conversion)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrConversionNotFound
+	}
+	return nil
+}
+
+func (s *MongoHistoryStore) Get(ctx context.Context, userID, id string) (*Conversion, error) {
+	var conversion Conversion
+	err := s.collection.FindOne(ctx, bson.M{"_id": id, "userId": userID}).Decode(&conversion)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrConversionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return &conversion, nil
+}
+
+func (s *MongoHistoryStore) List(ctx context.Context, userID string, filter ListFilter) ([]Conversion, int64, error) {
+	query := bson.M{"userId": userID}
+	if filter.Source != "" {
+		query["$or"] = bson.A{bson.M{"source.provider": filter.Source}, bson.M{"sources.provider": filter.Source}}
+	}
+	if filter.Destination != "" {
+		query["destination.provider"] = filter.Destination
+	}
+	if filter.Status != "" {
+		query["status"] = filter.Status
+	}
+	startedAt := bson.M{}
+	if !filter.Since.IsZero() {
+		startedAt["$gte"] = filter.Since
+	}
+	if !filter.Until.IsZero() {
+		startedAt["$lt"] = filter.Until
+	}
+	if len(startedAt) > 0 {
+		query["startedAt"] = startedAt
+	}
+
+	total, err := s.collection.CountDocuments(ctx, query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "startedAt", Value: -1}}).SetSkip(int64(filter.Offset))
+	if filter.Limit > 0 {
+		opts.SetLimit(int64(filter.Limit))
+	}
+	cursor, err := s.collection.Find(ctx, query, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	conversions := []Conversion{}
+	if err := cursor.All(ctx, &conversions); err != nil {
+		return nil, 0, err
+	}
+	return conversions, total, nil
+}
+
+func (s *MongoHistoryStore) PurgeUserData(ctx context.Context, userID string) error {
+	_, err := s.collection.DeleteMany(ctx, bson.M{"userId": userID})
+	return err
+}
@@ -0,0 +1,315 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// Refills the token buckets stored in Redis hashes and takes a token from each, but only if every one of them has a token.
+// ARGV holds the current time followed by the capacity and refill rate of each bucket.
+// Returns {allowed, remaining tokens, milliseconds until a token is available, milliseconds until the bucket is full} per bucket.
+var tokenBucketScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local tokens = {}
+local take = true
+for i, key in ipairs(KEYS) do
+    local capacity = tonumber(ARGV[i * 2])
+    local refill_per_ms = tonumber(ARGV[i * 2 + 1])
+    local bucket = redis.call("HMGET", key, "tokens", "ts")
+    local current = tonumber(bucket[1])
+    local ts = tonumber(bucket[2])
+    if current == nil then
+        current = capacity
+        ts = now
+    end
+    tokens[i] = math.min(capacity, current + math.max(0, now - ts) * refill_per_ms)
+    if tokens[i] < 1 then
+        take = false
+    end
+end
+
+local results = {}
+for i, key in ipairs(KEYS) do
+    local capacity = tonumber(ARGV[i * 2])
+    local refill_per_ms = tonumber(ARGV[i * 2 + 1])
+    local allowed = 0
+    local retry_after = 0
+    if tokens[i] >= 1 then
+        allowed = 1
+    else
+        retry_after = math.ceil((1 - tokens[i]) / refill_per_ms)
+    end
+    if take then
+        tokens[i] = tokens[i] - 1
+    end
+
+    redis.call("HSET", key, "tokens", tokens[i], "ts", now)
+    local full_after = math.ceil((capacity - tokens[i]) / refill_per_ms)
+    redis.call("PEXPIRE", key, full_after + 1000)
+    table.insert(results, allowed)
+    table.insert(results, math.floor(tokens[i]))
+    table.insert(results, retry_after)
+    table.insert(results, full_after)
+end
+return results
+`)
+
+// A token bucket: up to Requests requests per Period, with bursts of up to Requests
+type Rate struct {
+    Requests int
+    Period   time.Duration
+}
+
+// Whether the rate actually limits anything. A zero rate disables the limit.
+func (r Rate) enabled() bool {
+    return r.Requests > 0 && r.Period > 0
+}
+
+func (r Rate) refillPerMs() float64 {
+    return float64(r.Requests) / float64(r.Period.Milliseconds())
+}
+
+// Limits applied to a route group. Either limit may be left zero to disable it.
+type RateLimitConfig struct {
+    Group   string  // route group name, used in Redis keys and env variable names
+    PerUser Rate    // applied to each verified user separately, and to each client IP for requests without a verified user
+    Global  Rate    // shared by every user of the route group
+}
+
+// Parses a rate of the form "<requests>/<period>", e.g. "30/m", "500/h" or "100/24h"
+func ParseRate(s string) (Rate, error) {
+    requestsStr, periodStr, found := strings.Cut(strings.TrimSpace(s), "/")
+    if !found {
+        return Rate{}, fmt.Errorf("invalid rate %q: expected <requests>/<period>", s)
+    }
+    requests, err := strconv.Atoi(requestsStr)
+    if err != nil || requests < 0 {
+        return Rate{}, fmt.Errorf("invalid request count in rate %q", s)
+    }
+
+    var period time.Duration
+    switch periodStr {
+    case "s":
+        period = time.Second
+    case "m":
+        period = time.Minute
+    case "h":
+        period = time.Hour
+    case "d":
+        period = 24 * time.Hour
+    default:
+        period, err = time.ParseDuration(periodStr)
+        if err != nil || period <= 0 {
+            return Rate{}, fmt.Errorf("invalid period in rate %q", s)
+        }
+    }
+    return Rate{Requests: requests, Period: period}, nil
+}
+
+// Applies RATE_LIMIT_<GROUP>_USER and RATE_LIMIT_<GROUP>_GLOBAL overrides to a group's default limits
+func LoadRateLimitConfig(defaults RateLimitConfig) RateLimitConfig {
+    envPrefix := "RATE_LIMIT_" + strings.ToUpper(strings.ReplaceAll(defaults.Group, "-", "_"))
+    config := defaults
+
+    if value := os.Getenv(envPrefix + "_USER"); value != "" {
+        if rate, err := ParseRate(value); err != nil {
+            log.Printf("Ignoring %s_USER: %v", envPrefix, err)
+        } else {
+            config.PerUser = rate
+        }
+    }
+    if value := os.Getenv(envPrefix + "_GLOBAL"); value != "" {
+        if rate, err := ParseRate(value); err != nil {
+            log.Printf("Ignoring %s_GLOBAL: %v", envPrefix, err)
+        } else {
+            config.Global = rate
+        }
+    }
+    return config
+}
+
+// A token bucket limiting requests at the given rate
+type Bucket struct {
+    Key  string
+    Rate Rate
+}
+
+type BucketResult struct {
+    Allowed    bool           // whether the bucket had a token
+    Remaining  int
+    RetryAfter time.Duration  // until the bucket has a token, if it had none
+    ResetAfter time.Duration  // until the bucket is full
+}
+
+// Stores token buckets. TakeTokens takes a token from every bucket if each has one, and from none of them otherwise,
+// so a request refused by one limit is not charged to the others. Results are in the order of the buckets.
+type BucketStore interface {
+    TakeTokens(ctx context.Context, buckets []Bucket) ([]BucketResult, error)
+}
+
+// Keeps token buckets in Redis, shared by every server instance
+type RedisBucketStore struct {
+    RedisClient *redis.Client
+}
+
+func (s RedisBucketStore) TakeTokens(ctx context.Context, buckets []Bucket) ([]BucketResult, error) {
+    keys := make([]string, len(buckets))
+    args := []any{time.Now().UnixMilli()}
+    for i, bucket := range buckets {
+        keys[i] = bucket.Key
+        args = append(args, bucket.Rate.Requests, strconv.FormatFloat(bucket.Rate.refillPerMs(), 'g', -1, 64))
+    }
+    values, err := tokenBucketScript.Run(ctx, s.RedisClient, keys, args...).Int64Slice()
+    if err != nil {
+        return nil, err
+    }
+    results := make([]BucketResult, len(buckets))
+    for i := range results {
+        results[i] = BucketResult{
+            Allowed:    values[i*4] == 1,
+            Remaining:  int(values[i*4+1]),
+            RetryAfter: time.Duration(values[i*4+2]) * time.Millisecond,
+            ResetAfter: time.Duration(values[i*4+3]) * time.Millisecond,
+        }
+    }
+    return results, nil
+}
+
+// Keeps token buckets in memory, for a single server instance and for tests
+type MemoryBucketStore struct {
+    mu      sync.Mutex
+    buckets map[string]memoryBucket
+}
+
+type memoryBucket struct {
+    tokens float64
+    ts     time.Time
+}
+
+func NewMemoryBucketStore() *MemoryBucketStore {
+    return &MemoryBucketStore{buckets: make(map[string]memoryBucket)}
+}
+
+func (s *MemoryBucketStore) TakeTokens(ctx context.Context, buckets []Bucket) ([]BucketResult, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    now := time.Now()
+    tokens := make([]float64, len(buckets))
+    take := true
+    for i, bucket := range buckets {
+        capacity := float64(bucket.Rate.Requests)
+        stored, ok := s.buckets[bucket.Key]
+        if !ok {
+            stored = memoryBucket{tokens: capacity, ts: now}
+        }
+        tokens[i] = math.Min(capacity, stored.tokens + float64(now.Sub(stored.ts).Milliseconds()) * bucket.Rate.refillPerMs())
+        if tokens[i] < 1 {
+            take = false
+        }
+    }
+
+    results := make([]BucketResult, len(buckets))
+    for i, bucket := range buckets {
+        refillPerMs := bucket.Rate.refillPerMs()
+        if tokens[i] >= 1 {
+            results[i].Allowed = true
+        } else {
+            results[i].RetryAfter = time.Duration(math.Ceil((1 - tokens[i]) / refillPerMs)) * time.Millisecond
+        }
+        if take {
+            tokens[i]--
+        }
+        s.buckets[bucket.Key] = memoryBucket{tokens: tokens[i], ts: now}
+        results[i].Remaining = int(tokens[i])
+        results[i].ResetAfter = time.Duration(math.Ceil((float64(bucket.Rate.Requests) - tokens[i]) / refillPerMs)) * time.Millisecond
+    }
+    return results, nil
+}
+
+// Rate limits a route group with token buckets, per user and globally.
+// Requests are let through if the store is unavailable rather than failing every request.
+func RateLimiter(store BucketStore, config RateLimitConfig) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        // Per-user first, so a user over both limits is told about their own
+        var buckets []Bucket
+        if config.PerUser.enabled() {
+            buckets = append(buckets, Bucket{Key: fmt.Sprintf("rateLimit:%s:%s", config.Group, rateLimitSubject(c)), Rate: config.PerUser})
+        }
+        if config.Global.enabled() {
+            buckets = append(buckets, Bucket{Key: fmt.Sprintf("rateLimit:%s:global", config.Group), Rate: config.Global})
+        }
+        if len(buckets) == 0 {
+            c.Next()
+            return
+        }
+
+        results, err := store.TakeTokens(c.Request.Context(), buckets)
+        if err != nil {
+            log.Printf("Rate limiter unavailable for %s, letting request through: %v", config.Group, err)
+            c.Next()
+            return
+        }
+
+        // The most restrictive limit is the one reported in the X-RateLimit-* headers
+        reported := 0
+        for i, result := range results {
+            if !result.Allowed {
+                setRateLimitHeaders(c, buckets[i].Rate, result)
+                retryAfterSeconds := int(math.Ceil(result.RetryAfter.Seconds()))
+                c.Header("Retry-After", strconv.Itoa(retryAfterSeconds))
+                c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+                    "error": "rate_limited",
+                    "message": fmt.Sprintf("Too many requests. Try again in %d seconds.", retryAfterSeconds),
+                })
+                return
+            }
+            if result.Remaining < results[reported].Remaining {
+                reported = i
+            }
+        }
+
+        setRateLimitHeaders(c, buckets[reported].Rate, results[reported])
+        c.Next()
+    }
+}
+
+// Sets where the client IP of a request is read from. Gin trusts X-Forwarded-For from any peer by default, which would
+// let a client pick a new IP, and with it a fresh rate limit bucket, on every request. Only the comma-separated proxies
+// given are trusted, and none when empty. A platform header such as CF-Connecting-IP takes precedence when set.
+func ConfigureClientIP(router *gin.Engine, trustedProxies, trustedPlatform string) error {
+    var proxies []string
+    for _, proxy := range strings.Split(trustedProxies, ",") {
+        if proxy = strings.TrimSpace(proxy); proxy != "" {
+            proxies = append(proxies, proxy)
+        }
+    }
+    router.TrustedPlatform = strings.TrimSpace(trustedPlatform)
+    return router.SetTrustedProxies(proxies)
+}
+
+// Names the bucket a request is limited by. Only users verified by an API key or session token get a bucket of their own,
+// since a request that merely names its user could name a different one each time; all others share their client IP's.
+func rateLimitSubject(c *gin.Context) string {
+    if userID := UserID(c); userID != "" && Authenticated(c) {
+        return userID
+    }
+    return "ip:" + c.ClientIP()
+}
+
+func setRateLimitHeaders(c *gin.Context, rate Rate, result BucketResult) {
+    c.Header("X-RateLimit-Limit", strconv.Itoa(rate.Requests))
+    c.Header("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+    c.Header("X-RateLimit-Reset", strconv.Itoa(int(math.Ceil(result.ResetAfter.Seconds()))))
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Context key under which the request context from before any deadline was applied is stored
+const timeoutParentKey = "timeoutParent"
+
+// Sets a deadline on the request context, which every upstream call made for the request inherits.
+// A route-level Timeout replaces the deadline of its group instead of being capped by it.
+func Timeout(timeout time.Duration) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        parent := c.Request.Context()
+        if value, exists := c.Get(timeoutParentKey); exists {
+            parent = value.(context.Context)
+        } else {
+            c.Set(timeoutParentKey, parent)
+        }
+
+        ctx, cancel := context.WithTimeout(parent, timeout)
+        defer cancel()
+        c.Request = c.Request.WithContext(ctx)
+        c.Next()
+    }
+}
+
+// Returns the REQUEST_TIMEOUT_<GROUP> override (a duration such as "45s") or the default
+func LoadTimeout(group string, defaultTimeout time.Duration) time.Duration {
+    envName := "REQUEST_TIMEOUT_" + strings.ToUpper(strings.ReplaceAll(group, "-", "_"))
+    value := os.Getenv(envName)
+    if value == "" {
+        return defaultTimeout
+    }
+    timeout, err := time.ParseDuration(value)
+    if err != nil || timeout <= 0 {
+        log.Printf("Ignoring %s: invalid duration %q", envName, value)
+        return defaultTimeout
+    }
+    return timeout
+}
@@ -0,0 +1,252 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+)
+
+// Context key under which the resolved user ID is stored
+const userIDKey = "userID"
+
+// Context key under which the scopes of the API key used for the request are stored
+const apiKeyScopesKey = "apiKeyScopes"
+
+// Context key set when the user was verified from a session token
+const sessionUserKey = "sessionUser"
+
+// Largest request body inspected when looking for a user ID
+const maxInspectedBodySize = 1 << 20
+
+// Verifies personal API keys sent as `Authorization: Bearer <key>`
+type APIKeyAuthenticator interface {
+    Authenticate(ctx context.Context, rawKey string) (userID string, scopes []string, err error)
+    // Reports whether a bearer token has the format of an API key rather than a session token
+    IsAPIKey(token string) bool
+}
+
+// Verifies the Auth0 access tokens that browser sessions send as `Authorization: Bearer <token>`
+type SessionVerifier interface {
+    VerifySession(ctx context.Context, accessToken string) (userID string, err error)
+}
+
+// Resolves the user a request acts on behalf of and stores it in the request context.
+// Handlers receive the user ID as a `userID` query parameter or as a `userID`/`userId` JSON body field.
+// Requests authenticated with an API key or a session token have the verified user ID written into both, so handlers need no changes.
+// Other requests are trusted to name their own user; endpoints that must not be are guarded with RequireSession.
+func ResolveUser(authenticator APIKeyAuthenticator, sessions SessionVerifier) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); found {
+            token = strings.TrimSpace(token)
+            if authenticator != nil && authenticator.IsAPIKey(token) {
+                resolveAPIKeyUser(c, authenticator, token)
+                return
+            }
+            if sessions != nil {
+                resolveSessionUser(c, sessions, token)
+                return
+            }
+        }
+
+        if userID := c.Query("userID"); userID != "" {
+            c.Set(userIDKey, userID)
+            c.Next()
+            return
+        }
+
+        if userID := userIDFromBody(c.Request); userID != "" {
+            c.Set(userIDKey, userID)
+        }
+        c.Next()
+    }
+}
+
+// Authenticates the request with an API key and pins the request to the key owner
+func resolveAPIKeyUser(c *gin.Context, authenticator APIKeyAuthenticator, rawKey string) {
+    userID, scopes, err := authenticator.Authenticate(c.Request.Context(), rawKey)
+    if err != nil {
+        log.Printf("Rejected API key request to %s: %v", c.Request.URL.Path, err)
+        c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_api_key"})
+        return
+    }
+
+    if !pinUser(c.Request, userID) {
+        c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "API key does not belong to this user"})
+        return
+    }
+
+    c.Set(userIDKey, userID)
+    c.Set(apiKeyScopesKey, scopes)
+    c.Next()
+}
+
+// Verifies a session token and pins the request to the user it was issued to
+func resolveSessionUser(c *gin.Context, sessions SessionVerifier, token string) {
+    userID, err := sessions.VerifySession(c.Request.Context(), token)
+    if errors.Is(err, utils.ErrUnauthorized) {
+        log.Printf("Rejected session request to %s: %v", c.Request.URL.Path, err)
+        c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_session"})
+        return
+    } else if err != nil {
+        log.Printf("Error verifying session for request to %s: %v", c.Request.URL.Path, err)
+        c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Unable to verify session"})
+        return
+    }
+
+    if !pinUser(c.Request, userID) {
+        c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Session does not belong to this user"})
+        return
+    }
+
+    c.Set(userIDKey, userID)
+    c.Set(sessionUserKey, true)
+    c.Next()
+}
+
+// Writes a verified user ID into the query string and JSON body. Returns false if either names a different user.
+func pinUser(req *http.Request, userID string) bool {
+    // The query string is read directly, since c.Query would cache it before it is rewritten
+    query := req.URL.Query()
+    if requested := query.Get("userID"); requested != "" && requested != userID {
+        return false
+    }
+    query.Set("userID", userID)
+    req.URL.RawQuery = query.Encode()
+
+    return setUserIDInBody(req, userID)
+}
+
+// Returns the user ID resolved for the request, or an empty string if there is none
+func UserID(c *gin.Context) string {
+    return c.GetString(userIDKey)
+}
+
+// Reports whether the request was authenticated with an API key rather than a browser session
+func UsesAPIKey(c *gin.Context) bool {
+    _, usesAPIKey := c.Get(apiKeyScopesKey)
+    return usesAPIKey
+}
+
+// Reports whether the user ID was verified, by an API key or a session token, rather than taken from the request as sent
+func Authenticated(c *gin.Context) bool {
+    _, sessionUser := c.Get(sessionUserKey)
+    return sessionUser || UsesAPIKey(c)
+}
+
+// Rejects requests that do not carry a verified session token, e.g. for deleting the account or managing API keys
+func RequireSession() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if UsesAPIKey(c) {
+            c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This endpoint cannot be used with an API key"})
+            return
+        }
+        if _, sessionUser := c.Get(sessionUserKey); !sessionUser {
+            c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "session_required"})
+            return
+        }
+        c.Next()
+    }
+}
+
+// Rejects API key requests whose key was not granted the scope. Requests without an API key are let through.
+func RequireScope(scope string) gin.HandlerFunc {
+    return func(c *gin.Context) {
+        value, usesAPIKey := c.Get(apiKeyScopesKey)
+        if !usesAPIKey {
+            c.Next()
+            return
+        }
+        for _, granted := range value.([]string) {
+            if granted == scope {
+                c.Next()
+                return
+            }
+        }
+        c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient_scope", "requiredScope": scope})
+    }
+}
+
+// Rejects requests authenticated with an API key, e.g. for account management and provider linking
+func RejectAPIKeys() gin.HandlerFunc {
+    return func(c *gin.Context) {
+        if _, usesAPIKey := c.Get(apiKeyScopesKey); usesAPIKey {
+            c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This endpoint cannot be used with an API key"})
+            return
+        }
+        c.Next()
+    }
+}
+
+// Reads the request body, leaving it intact for the handler
+func peekBody(req *http.Request) []byte {
+    if req.Body == nil || req.ContentLength > maxInspectedBodySize {
+        return nil
+    }
+
+    body, err := io.ReadAll(io.LimitReader(req.Body, maxInspectedBodySize))
+    req.Body.Close()
+    req.Body = io.NopCloser(bytes.NewReader(body))
+    if err != nil {
+        return nil
+    }
+    return body
+}
+
+// Reads the user ID from a JSON request body, leaving the body intact for the handler
+func userIDFromBody(req *http.Request) string {
+    body := peekBody(req)
+    if len(body) == 0 {
+        return ""
+    }
+
+    var fields struct {
+        UserID      string `json:"userID"`
+        UserIDLower string `json:"userId"`
+    }
+    if err := json.Unmarshal(body, &fields); err != nil {
+        return ""
+    }
+    if fields.UserID != "" {
+        return fields.UserID
+    }
+    return fields.UserIDLower
+}
+
+// Writes the user ID into a JSON object body. Returns false if the body names a different user.
+func setUserIDInBody(req *http.Request, userID string) bool {
+    body := peekBody(req)
+    if len(body) == 0 {
+        return true
+    }
+
+    // Numbers are kept as written so that re-encoding does not change them
+    decoder := json.NewDecoder(bytes.NewReader(body))
+    decoder.UseNumber()
+    var fields map[string]interface{}
+    if err := decoder.Decode(&fields); err != nil {
+        // Not a JSON object; the handler will reject it on its own
+        return true
+    }
+    for _, key := range []string{"userID", "userId"} {
+        if requested, ok := fields[key].(string); ok && requested != "" && requested != userID {
+            return false
+        }
+        fields[key] = userID
+    }
+
+    rewritten, err := json.Marshal(fields)
+    if err != nil {
+        return true
+    }
+    req.Body = io.NopCloser(bytes.NewReader(rewritten))
+    req.ContentLength = int64(len(rewritten))
+    return true
+}
@@ -2,12 +2,16 @@ package user
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+var ErrUserNotFound = errors.New("user not found")
+
 type DAO struct {
 	collection *mongo.Collection
 }
@@ -17,42 +21,121 @@ func NewDAO(client *mongo.Client, dbName, collectionName string) *DAO {
 	return &DAO{collection: collection}
 }
 
-func (dao *DAO) CreateUser(user *User) error {
-	_, err := dao.collection.InsertOne(context.TODO(), user)
+// Creates the indexes the queries below rely on. Safe to call on every startup.
+func (dao *DAO) EnsureIndexes(ctx context.Context) error {
+	_, err := dao.collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName("email").SetSparse(true),
+		},
+		{
+			// Finds the Luthien user behind a provider account
+			Keys:    bson.D{{Key: "linkedAccounts.party", Value: 1}, {Key: "linkedAccounts.id", Value: 1}},
+			Options: options.Index().SetName("linkedAccounts"),
+		},
+	})
+	return err
+}
+
+// Creates the user or updates their profile. Preferences and linked accounts of an existing user are kept.
+func (dao *DAO) UpsertUser(ctx context.Context, user *User) error {
+	now := time.Now().UTC()
+	set := bson.M{"updatedAt": now}
+	if user.Email != "" {
+		set["email"] = user.Email
+	}
+	if user.Name != "" {
+		set["name"] = user.Name
+	}
+	if user.Picture != "" {
+		set["picture"] = user.Picture
+	}
+	for party, authenticated := range user.AuthenticatedWith {
+		set["authenticatedWith."+party] = authenticated
+	}
+	setOnInsert := bson.M{"createdAt": now, "preferences": user.Preferences}
+	if user.LinkedAccounts != nil {
+		set["linkedAccounts"] = user.LinkedAccounts
+	} else {
+		setOnInsert["linkedAccounts"] = []LinkedAccount{}
+	}
+
+	_, err := dao.collection.UpdateByID(ctx, user.ID, bson.M{"$set": set, "$setOnInsert": setOnInsert}, options.Update().SetUpsert(true))
 	return err
 }
 
-func (dao *DAO) GetUser(id string) (*User, error) {
+func (dao *DAO) GetUser(ctx context.Context, id string) (*User, error) {
 	var user User
-	objID, err := primitive.ObjectIDFromHex(id)
-    if err != nil {
-        return nil, err
-    }
-	filter := bson.M{"_id": objID}
-	err = dao.collection.FindOne(context.TODO(), filter).Decode(&user)
-	if err != nil {
+	err := dao.collection.FindOne(ctx, bson.M{"_id": id}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrUserNotFound
+	} else if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (dao *DAO) GetAllUsers() ([]User,error) {
-	var users []User
-	cursor, err := dao.collection.Find(context.TODO(), bson.D{{}})
+// Returns a page of users, oldest first
+func (dao *DAO) GetAllUsers(ctx context.Context, limit, offset int64) ([]User, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}).SetSkip(offset).SetLimit(limit)
+	cursor, err := dao.collection.Find(ctx, bson.D{}, opts)
 	if err != nil {
 		return nil, err
 	}
-	defer cursor.Close(context.TODO())
+	defer cursor.Close(ctx)
 
-	for cursor.Next(context.TODO()) {
-		var user User
-		if err := cursor.Decode(&user); err != nil {
-			return nil, err
-		}
-		users = append(users, user)
-	}
-	if err := cursor.Err(); err != nil {
+	users := []User{}
+	if err := cursor.All(ctx, &users); err != nil {
 		return nil, err
 	}
 	return users, nil
-}
\ No newline at end of file
+}
+
+// Sets fields of an existing user
+func (dao *DAO) updateUser(ctx context.Context, id string, set bson.M) error {
+	set["updatedAt"] = time.Now().UTC()
+	res, err := dao.collection.UpdateByID(ctx, id, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+func (dao *DAO) UpdatePreferences(ctx context.Context, id string, preferences Preferences) error {
+	return dao.updateUser(ctx, id, bson.M{"preferences": preferences})
+}
+
+// Records whether the user is authenticated with a party, creating the user if they are not stored yet
+func (dao *DAO) SetAuthenticated(ctx context.Context, id, party string, authenticated bool) error {
+	return dao.UpsertUser(ctx, &User{ID: id, AuthenticatedWith: map[string]bool{party: authenticated}})
+}
+
+// Replaces the user's linked accounts of one party.
+// MongoDB cannot pull from and push to the same array in one update, so this takes two.
+func (dao *DAO) SetLinkedAccounts(ctx context.Context, id, party string, accounts []LinkedAccount) error {
+	res, err := dao.collection.UpdateByID(ctx, id, bson.M{
+		"$pull": bson.M{"linkedAccounts": bson.M{"party": party}},
+		"$set":  bson.M{"updatedAt": time.Now().UTC()},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	if len(accounts) == 0 {
+		return nil
+	}
+	_, err = dao.collection.UpdateByID(ctx, id, bson.M{
+		"$push": bson.M{"linkedAccounts": bson.M{"$each": accounts}},
+	})
+	return err
+}
+
+func (dao *DAO) DeleteUser(ctx context.Context, id string) error {
+	_, err := dao.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
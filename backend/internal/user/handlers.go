@@ -1,52 +1,107 @@
 package user
 
 import (
+	"context"
+	"errors"
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/roblieblang/luthien/backend/internal/utils"
 )
 
+type UserServiceInterface interface {
+	GetUser(ctx context.Context, userID string) (*User, error)
+	SyncUser(ctx context.Context, userID string) (*User, error)
+	UpdatePreferences(ctx context.Context, userID string, preferences Preferences) (*User, error)
+}
+
 type UserHandler struct {
-	userService *UserService
+	userService UserServiceInterface
 }
 
-func NewUserHandler(userService *UserService) *UserHandler {
+func NewUserHandler(userService UserServiceInterface) *UserHandler {
 	return &UserHandler{userService: userService}
 }
 
-func (h *UserHandler) CreateUser(c *gin.Context) {
-	var user User
-	if err := c.ShouldBindJSON(&user); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// Responds with 404 if err means the user is not stored. Reports whether it did.
+func userNotFound(c *gin.Context, err error) bool {
+	if !errors.Is(err, ErrUserNotFound) {
+		return false
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "user_not_found", "message": "User not found. Sync the user first."})
+	return true
+}
+
+// Returns the stored user
+func (h *UserHandler) GetCurrentUserHandler(c *gin.Context) {
+	userID := c.Query("userID")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID query parameter is required"})
 		return
 	}
 
-	newUser, err := h.userService.CreateUser(user)
+	user, err := h.userService.GetUser(c.Request.Context(), userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if userNotFound(c, err) {
+			return
+		}
+		log.Printf("Error getting user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, newUser)
+	c.JSON(http.StatusOK, user)
 }
 
-func (h *UserHandler) GetUser(c *gin.Context) {
-	id := c.Param("id")
-	user, err := h.userService.GetUser(id)
+// Creates or refreshes the stored user from their Auth0 profile and linked accounts
+func (h *UserHandler) SyncCurrentUserHandler(c *gin.Context) {
+	userID := c.Query("userID")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID query parameter is required"})
+		return
+	}
+
+	user, err := h.userService.SyncUser(c.Request.Context(), userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Printf("Error syncing user %s: %v", userID, err)
+		if status, ok := utils.UpstreamErrorStatus(err); ok {
+			c.JSON(status, gin.H{"error": "Failed to sync user", "message": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync user"})
 		return
 	}
 
 	c.JSON(http.StatusOK, user)
 }
 
-func (h *UserHandler) GetAllUsers(c *gin.Context) {
-	users, err := h.userService.GetAllUsers()
+// Replaces the user's preferences
+func (h *UserHandler) UpdatePreferencesHandler(c *gin.Context) {
+	userID := c.Query("userID")
+	if userID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "userID query parameter is required"})
+		return
+	}
+	var preferences Preferences
+	if err := c.BindJSON(&preferences); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		return
+	}
+
+	user, err := h.userService.UpdatePreferences(c.Request.Context(), userID, preferences)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		if userNotFound(c, err) {
+			return
+		}
+		if errors.Is(err, ErrInvalidPreferences) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		log.Printf("Error updating preferences of user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update preferences"})
 		return
 	}
 
-	c.JSON(http.StatusOK, users)
-}
\ No newline at end of file
+	c.JSON(http.StatusOK, user)
+}
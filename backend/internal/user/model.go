@@ -2,17 +2,33 @@ package user
 
 import (
 	"time"
-
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// A Luthien user, keyed by their Auth0 subject (e.g. "auth0|65f...")
 type User struct {
-    ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-    Username  string    `json:"username" bson:"username"`
-    Email     string    `json:"email" bson:"email"`
-    Password  string    `json:"-" bson:"password"`
-    FirstName string    `json:"firstName" bson:"firstName,omitempty"`
-    LastName  string    `json:"lastName" bson:"lastName,omitempty"`
-    CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
-    UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
-}
\ No newline at end of file
+	ID                string          `json:"id" bson:"_id"`
+	Email             string          `json:"email" bson:"email,omitempty"`
+	Name              string          `json:"name" bson:"name,omitempty"`
+	Picture           string          `json:"picture" bson:"picture,omitempty"`
+	AuthenticatedWith map[string]bool `json:"authenticatedWith" bson:"authenticatedWith,omitempty"` // party -> whether any account of it is linked
+	LinkedAccounts    []LinkedAccount `json:"linkedAccounts" bson:"linkedAccounts"`
+	Preferences       Preferences     `json:"preferences" bson:"preferences"`
+	CreatedAt         time.Time       `json:"createdAt" bson:"createdAt"`
+	UpdatedAt         time.Time       `json:"updatedAt" bson:"updatedAt"`
+}
+
+// A provider account the user has linked
+type LinkedAccount struct {
+	Party     string    `json:"party" bson:"party"` // spotify or google
+	ID        string    `json:"id" bson:"id"`       // Spotify user ID or YouTube channel ID
+	Label     string    `json:"label" bson:"label"`
+	ImageURL  string    `json:"imageUrl" bson:"imageUrl,omitempty"`
+	LinkedAt  time.Time `json:"linkedAt" bson:"linkedAt"`
+	IsDefault bool      `json:"isDefault" bson:"isDefault"`
+}
+
+type Preferences struct {
+	DefaultSource      string `json:"defaultSource" bson:"defaultSource,omitempty"`           // spotify or youtube
+	DefaultDestination string `json:"defaultDestination" bson:"defaultDestination,omitempty"` // spotify or youtube
+	PlaylistVisibility string `json:"playlistVisibility" bson:"playlistVisibility,omitempty"` // public, private or unlisted
+}
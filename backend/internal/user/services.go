@@ -1,25 +1,151 @@
 package user
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/roblieblang/luthien/backend/internal/auth/auth0"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+)
+
+var ErrInvalidPreferences = errors.New("invalid preferences")
+
+// Parties whose linked accounts and authentication status are kept on the user
+var parties = []string{"spotify", "google"}
+
+// Where user profiles come from; implemented by auth0.Auth0Service
+type ProfileSource interface {
+	GetUserMetadata(ctx context.Context, userID string) (auth0.Auth0UserMetadata, error)
+}
+
 type UserService struct {
-	userDAO *DAO
+	userDAO    *DAO
+	profiles   ProfileSource
+	AppContext *utils.AppContext
+}
+
+func NewUserService(userDAO *DAO, profiles ProfileSource, appCtx *utils.AppContext) *UserService {
+	return &UserService{userDAO: userDAO, profiles: profiles, AppContext: appCtx}
 }
 
-func NewUserService(userDAO *DAO) *UserService {
-	return &UserService{userDAO: userDAO}
+// Returns ErrUserNotFound if the user has never been synced
+func (us *UserService) GetUser(ctx context.Context, userID string) (*User, error) {
+	return us.userDAO.GetUser(ctx, userID)
+}
+
+// Stores the user's Auth0 profile and linked accounts, creating the user on first sync
+func (us *UserService) SyncUser(ctx context.Context, userID string) (*User, error) {
+	metadata, err := us.profiles.GetUserMetadata(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting Auth0 profile: %w", err)
+	}
+	err = us.userDAO.UpsertUser(ctx, &User{
+		ID:      userID,
+		Email:   metadata.Email,
+		Name:    metadata.Name,
+		Picture: metadata.Picture,
+		AuthenticatedWith: map[string]bool{
+			"spotify": metadata.AppMetadata.AuthenticatedWithSpotify,
+			"google":  metadata.AppMetadata.AuthenticatedWithGoogle,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error storing user: %w", err)
+	}
+
+	for _, party := range parties {
+		if err := us.syncLinkedAccounts(ctx, userID, party); err != nil {
+			return nil, err
+		}
+	}
+	return us.userDAO.GetUser(ctx, userID)
 }
 
-func (us *UserService) CreateUser(user User) (*User, error) {
-	err := us.userDAO.CreateUser(&user)
+// Copies the accounts linked with a party from the token store's account index
+func (us *UserService) syncLinkedAccounts(ctx context.Context, userID, party string) error {
+	linked, err := utils.ListLinkedAccounts(ctx, *us.AppContext, userID, party)
 	if err != nil {
+		return fmt.Errorf("error listing linked %s accounts: %w", party, err)
+	}
+	accounts := make([]LinkedAccount, len(linked))
+	for i, account := range linked {
+		accounts[i] = LinkedAccount{
+			Party:     party,
+			ID:        account.ID,
+			Label:     account.Label,
+			ImageURL:  account.ImageURL,
+			LinkedAt:  account.LinkedAt,
+			IsDefault: account.IsDefault,
+		}
+	}
+	if err := us.userDAO.SetLinkedAccounts(ctx, userID, party, accounts); err != nil {
+		return fmt.Errorf("error storing linked %s accounts: %w", party, err)
+	}
+	return nil
+}
+
+func (us *UserService) UpdatePreferences(ctx context.Context, userID string, preferences Preferences) (*User, error) {
+	if err := validatePreferences(preferences); err != nil {
+		return nil, err
+	}
+	if err := us.userDAO.UpdatePreferences(ctx, userID, preferences); err != nil {
 		return nil, err
 	}
-	return &user, nil
+	return us.userDAO.GetUser(ctx, userID)
 }
 
-func (us *UserService) GetUser(userID string) (*User, error) {
-	return us.userDAO.GetUser(userID)
+func validatePreferences(preferences Preferences) error {
+	for name, value := range map[string]string{
+		"defaultSource":      preferences.DefaultSource,
+		"defaultDestination": preferences.DefaultDestination,
+	} {
+		if value != "" && value != "spotify" && value != "youtube" {
+			return fmt.Errorf("%w: %s must be spotify or youtube", ErrInvalidPreferences, name)
+		}
+	}
+	switch preferences.PlaylistVisibility {
+	case "", "public", "private", "unlisted":
+	default:
+		return fmt.Errorf("%w: playlistVisibility must be public, private or unlisted", ErrInvalidPreferences)
+	}
+	return nil
+}
+
+// Mirrors the authenticated_with_<party> flags of an Auth0 app_metadata update onto the stored user,
+// so that they no longer live only in Auth0. Registered with auth0.Auth0Service.RegisterMetadataMirror.
+func (us *UserService) UpdateUserMetadata(ctx context.Context, userID string, updates map[string]interface{}) error {
+	var flags map[string]bool
+	switch appMetadata := updates["app_metadata"].(type) {
+	case map[string]bool:
+		flags = appMetadata
+	case map[string]interface{}:
+		flags = make(map[string]bool)
+		for name, value := range appMetadata {
+			if b, ok := value.(bool); ok {
+				flags[name] = b
+			}
+		}
+	}
+
+	for name, authenticated := range flags {
+		party, ok := strings.CutPrefix(name, "authenticated_with_")
+		if !ok {
+			continue
+		}
+		if err := us.userDAO.SetAuthenticated(ctx, userID, party, authenticated); err != nil {
+			return fmt.Errorf("error storing %s authentication status: %w", party, err)
+		}
+		if err := us.syncLinkedAccounts(ctx, userID, party); err != nil {
+			log.Printf("Error syncing linked %s accounts for user %s: %v", party, userID, err)
+		}
+	}
+	return nil
 }
 
-func (us *UserService) GetAllUsers() ([]User, error) {
-	return us.userDAO.GetAllUsers()
+// Deletes the stored user
+func (us *UserService) PurgeUserData(ctx context.Context, userID string) error {
+	return us.userDAO.DeleteUser(ctx, userID)
 }
@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Returned when an account selector does not match any of the user's linked accounts
+var ErrLinkedAccountNotFound = errors.New("linked account not found")
+
+// A provider account (Spotify user or YouTube channel) linked to a Luthien user
+type LinkedAccount struct {
+    ID          string    `json:"id"`  // Spotify user ID or YouTube channel ID
+    Label       string    `json:"label"`  // display name or channel title
+    ImageURL    string    `json:"imageUrl"`
+    LinkedAt    time.Time `json:"linkedAt"`
+    IsDefault   bool      `json:"isDefault"`
+}
+
+// Returns the identifier under which a user's tokens for a linked account are stored.
+// Tokens stored before accounts were tracked have no account ID and are owned by the user ID alone.
+func TokenOwner(userID, accountID string) string {
+    if accountID == "" {
+        return userID
+    }
+    return fmt.Sprintf("%s:%s", userID, accountID)
+}
+
+func linkedAccountsKey(party, userID string) string {
+    return fmt.Sprintf("%sLinkedAccounts:%s", strings.ToLower(party), userID)
+}
+
+func defaultAccountKey(party, userID string) string {
+    return fmt.Sprintf("%sDefaultAccount:%s", strings.ToLower(party), userID)
+}
+
+// Registers a linked account for a user. The first account linked becomes the default.
+func SaveLinkedAccount(ctx context.Context, appCtx AppContext, userID, party string, account LinkedAccount) error {
+    if account.ID == "" {
+        return errors.New("linked account ID is required")
+    }
+    if account.LinkedAt.IsZero() {
+        account.LinkedAt = time.Now().UTC()
+    }
+    account.IsDefault = false
+
+    if err := appCtx.Tokens.SaveLinkedAccount(ctx, party, userID, account); err != nil {
+        return fmt.Errorf("error saving linked %s account: %w", party, err)
+    }
+    return nil
+}
+
+// Lists a user's linked accounts for a provider, ordered by when they were linked
+func ListLinkedAccounts(ctx context.Context, appCtx AppContext, userID, party string) ([]LinkedAccount, error) {
+    accounts, defaultID, err := appCtx.Tokens.LinkedAccounts(ctx, party, userID)
+    if err != nil {
+        return nil, err
+    }
+    for i := range accounts {
+        accounts[i].IsDefault = accounts[i].ID == defaultID
+    }
+    sort.Slice(accounts, func(i, j int) bool {
+        return accounts[i].LinkedAt.Before(accounts[j].LinkedAt)
+    })
+    return accounts, nil
+}
+
+// Removes a linked account. If it was the default, the oldest remaining account becomes the default.
+func RemoveLinkedAccount(ctx context.Context, appCtx AppContext, userID, party, accountID string) error {
+    _, defaultID, err := appCtx.Tokens.LinkedAccounts(ctx, party, userID)
+    if err != nil {
+        return err
+    }
+    if err := appCtx.Tokens.DeleteLinkedAccount(ctx, party, userID, accountID); err != nil {
+        return err
+    }
+    if defaultID != accountID {
+        return nil
+    }
+
+    remaining, err := ListLinkedAccounts(ctx, appCtx, userID, party)
+    if err != nil {
+        return err
+    }
+    newDefaultID := ""
+    if len(remaining) > 0 {
+        newDefaultID = remaining[0].ID
+    }
+    return appCtx.Tokens.SetDefaultAccount(ctx, party, userID, newDefaultID)
+}
+
+// Makes one of the user's linked accounts the one used when no account is selected
+func SetDefaultAccount(ctx context.Context, appCtx AppContext, userID, party, accountID string) error {
+    if _, err := findLinkedAccount(ctx, appCtx, userID, party, accountID); err != nil {
+        return err
+    }
+    return appCtx.Tokens.SetDefaultAccount(ctx, party, userID, accountID)
+}
+
+// Returns one of the user's linked accounts, or ErrLinkedAccountNotFound
+func findLinkedAccount(ctx context.Context, appCtx AppContext, userID, party, accountID string) (LinkedAccount, error) {
+    accounts, _, err := appCtx.Tokens.LinkedAccounts(ctx, party, userID)
+    if err != nil {
+        return LinkedAccount{}, err
+    }
+    for _, account := range accounts {
+        if account.ID == accountID {
+            return account, nil
+        }
+    }
+    return LinkedAccount{}, ErrLinkedAccountNotFound
+}
+
+// Resolves an account selector to a linked account ID.
+// An empty selector picks the default account, or the legacy single-account tokens if no accounts are linked.
+func ResolveAccountID(ctx context.Context, appCtx AppContext, userID, party, selector string) (string, error) {
+    if selector != "" {
+        if _, err := findLinkedAccount(ctx, appCtx, userID, party, selector); err != nil {
+            return "", err
+        }
+        return selector, nil
+    }
+
+    _, defaultID, err := appCtx.Tokens.LinkedAccounts(ctx, party, userID)
+    if err != nil {
+        return "", err
+    }
+    return defaultID, nil
+}
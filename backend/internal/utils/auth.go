@@ -14,7 +14,7 @@ import (
 	"strings"
 	"time"
 
-	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
 )
 
 type UnifiedTrackSearchResult struct {
@@ -66,13 +66,17 @@ type SetTokenParams struct {
     TokenKind   string
     Party       string
     UserID      string
+    AccountID   string // linked provider account; empty for the user's legacy single-account tokens
     Token       string
     ExpiresIn   int
     AppCtx      AppContext
 }
 
-// Stores a token in Redis
-func SetToken(params SetTokenParams) error {
+// How long a refresh token is kept after it was last issued
+const refreshTokenLifetime = 720 * time.Hour // one month
+
+// Stores a token in the token store
+func SetToken(ctx context.Context, params SetTokenParams) error {
     log.Printf("\nSetToken() params: %v\n", params)
     party := strings.ToLower(params.Party)
     tokenKind := capitalizeFirstLetter(params.TokenKind)
@@ -81,16 +85,16 @@ func SetToken(params SetTokenParams) error {
         return fmt.Errorf("empty %s token passed into SetToken()", params.TokenKind)
     }
 
-    var expiration time.Duration
+    token := StoredToken{Value: params.Token}
     if tokenKind == "Access" {
-        expiration = time.Duration(params.ExpiresIn) * time.Second
+        token.ExpiresAt = time.Now().Add(time.Duration(params.ExpiresIn) * time.Second)
     } else if tokenKind  == "Refresh" {
-        expiration = time.Hour * 720 // one month
+        token.ExpiresAt = time.Now().Add(refreshTokenLifetime)
     }
     log.Printf("Setting %s %s token for user %s with value: %s", party, tokenKind, params.UserID, params.Token)
-    err := params.AppCtx.RedisClient.Set(context.Background(), fmt.Sprintf("%s%sToken:%s", party, tokenKind, params.UserID), params.Token, expiration).Err()
+    err := params.AppCtx.Tokens.Set(ctx, tokenKey(party, tokenKind, TokenOwner(params.UserID, params.AccountID)), token)
     if err != nil {
-        return fmt.Errorf("error storing the access token: %v", err)
+        return fmt.Errorf("error storing the %s token: %w", params.TokenKind, err)
     }
     return nil
 }
@@ -98,20 +102,18 @@ func SetToken(params SetTokenParams) error {
 type ClearTokensParams struct {
     Party       string
     UserID      string
+    AccountID   string
     AppCtx  AppContext
 }
 
-// Delete access and refresh tokens from Redis
-func ClearTokens(params ClearTokensParams) error {
+// Delete access and refresh tokens from the token store
+func ClearTokens(ctx context.Context, params ClearTokensParams) error {
     party := strings.ToLower(params.Party)
+    owner := TokenOwner(params.UserID, params.AccountID)
 
-    _, err := params.AppCtx.RedisClient.Del(context.Background(), fmt.Sprintf("%sAccessToken:%s", party, params.UserID)).Result()
-    if err != nil {
-        return fmt.Errorf("error deleting the access token: %v", err)
-    }
-    _, err = params.AppCtx.RedisClient.Del(context.Background(), fmt.Sprintf("%sRefreshToken:%s", party, params.UserID)).Result()
+    err := params.AppCtx.Tokens.Delete(ctx, tokenKey(party, "access", owner), tokenKey(party, "refresh", owner))
     if err != nil {
-        return fmt.Errorf("error deleting the refresh token: %v", err)
+        return fmt.Errorf("error deleting the tokens: %w", err)
     }
     return nil
 }
@@ -120,17 +122,19 @@ type RetrieveTokenParams struct {
     Party       string
     TokenKind   string
     UserID      string
+    AccountID   string
     AppCtx  AppContext
 }
 
-// Get a token from Redis
-func RetrieveToken(params RetrieveTokenParams) (string, error) {
+// Get a token from the token store. Returns ErrTokenNotFound only if the token is missing, and an ErrStorageUnavailable error if the store cannot be reached.
+func RetrieveToken(ctx context.Context, params RetrieveTokenParams) (string, error) {
     party := strings.ToLower(params.Party)
     tokenKind := capitalizeFirstLetter(params.TokenKind)
 
-    token, err := params.AppCtx.RedisClient.Get(context.Background(), fmt.Sprintf("%s%sToken:%s", party, tokenKind, params.UserID)).Result()
+    stored, err := params.AppCtx.Tokens.Get(ctx, tokenKey(party, tokenKind, TokenOwner(params.UserID, params.AccountID)))
+    token := stored.Value
     // Token not found
-    if err == redis.Nil {
+    if err == ErrTokenNotFound {
         log.Printf("%s %s token not found for user %s", party, tokenKind, params.UserID)
         return "", err
     } else if err != nil {
@@ -145,35 +149,33 @@ func RetrieveToken(params RetrieveTokenParams) (string, error) {
     return token, nil
 }
 
-// Checks if the access token stored in Redis identified by `tokenName` argument has expired
-func IsAccessTokenExpired(appCtx AppContext, tokenName, token string) (bool, error) {
+// Checks if the token stored under the `tokenName` argument has expired
+func IsAccessTokenExpired(ctx context.Context, appCtx AppContext, tokenName, token string) (bool, error) {
     if token == "" {
         log.Printf("Token (%s: %s) is empty\n", tokenName, token)
         return true, nil
     }
 
-    accessTokenTTL, err := appCtx.RedisClient.TTL(context.Background(), tokenName).Result()
-    if err != nil {
-        log.Printf("error retrieving token (%s: %s) time to live: %v\n", tokenName, token, err)
+    stored, err := appCtx.Tokens.Get(ctx, tokenName)
+    if err == ErrTokenNotFound {
+        log.Printf("Token (%s: %s) does not exist\n", tokenName, token)
+        return true, nil
+    } else if err != nil {
+        log.Printf("error retrieving token (%s: %s) expiry: %v\n", tokenName, token, err)
         return true, err
     }
 
-    // Token has a TTL and is soon to expire
-    if accessTokenTTL > 0 && accessTokenTTL < 5 * time.Minute {
+    // Token is soon to expire
+    if stored.ExpiresWithin(5 * time.Minute) {
         log.Printf("Token (%s: %s) is soon to expire\n", tokenName, token)
         return true, nil
     }
 
-    if accessTokenTTL == -2 {
-        log.Printf("Token (%s: %s) does not exist\n", tokenName, token)
-        return true, nil
-    }
-
-    if accessTokenTTL == -1 {
+    if stored.ExpiresAt.IsZero() {
         log.Printf("Token (%s: %s) does not have expiry set\n", tokenName, token)
         return false, nil
     }
-    
+
     // Token is not expired
     return false, nil
 }
@@ -187,12 +189,13 @@ type TokenResponse struct {
 }
 
 type TokenService interface {
-    RequestToken(payload url.Values) (TokenResponse, error)
+    RequestToken(ctx context.Context, payload url.Values) (TokenResponse, error)
 }   
 
 // Must specify spotify or google as "Party"
 type GetValidAccessTokenParams struct {
     UserID      string
+    AccountID   string
     Party       string
     Service     TokenService
     AppCtx      AppContext
@@ -209,59 +212,169 @@ func getClientID(party string, appCtx AppContext) (string, error) {
     return "", errors.New("getClientID() only works for Spotify and Google services")
 }
 
+// Deduplicates concurrent token refreshes for the same user within this instance
+var refreshGroup singleflight.Group
+
 // Attempts to get a valid access token or sends notice that the user must reauthenticate
-func GetValidAccessToken(params GetValidAccessTokenParams) (string, error) {
-    // Try to get an access token directly from Redis first
-    accessToken, err := RetrieveToken(RetrieveTokenParams{
+func GetValidAccessToken(ctx context.Context, params GetValidAccessTokenParams) (string, error) {
+    // Try to get a stored access token first
+    accessToken, isExpired, err := retrieveAccessToken(ctx, params)
+    if err != nil {
+        return "", err
+    }
+    if !isExpired {
+        // The token is valid and not expired
+        log.Printf("Retrieved valid access token for user %s with value: %s", params.UserID, accessToken)
+        return accessToken, nil
+    }
+
+    // Concurrent requests for the same user share a single refresh so that rotated refresh tokens are only used once
+    refreshKey := fmt.Sprintf("%s:%s", strings.ToLower(params.Party), TokenOwner(params.UserID, params.AccountID))
+    refresh := refreshGroup.DoChan(refreshKey, func() (interface{}, error) {
+        // The refresh is shared by every waiting request, so one caller going away must not abort it for the rest
+        refreshCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*refreshLockTTL)
+        defer cancel()
+        return refreshAccessToken(refreshCtx, params)
+    })
+
+    select {
+    case <-ctx.Done():
+        return "", ctx.Err()
+    case result := <-refresh:
+        if result.Err != nil {
+            return "", result.Err
+        }
+        if result.Shared {
+            log.Printf("Reused in-flight %s token refresh for user %s", params.Party, params.UserID)
+        }
+        return result.Val.(string), nil
+    }
+}
+
+// Retrieves the stored access token and reports whether it must be refreshed
+func retrieveAccessToken(ctx context.Context, params GetValidAccessTokenParams) (string, bool, error) {
+    accessToken, err := RetrieveToken(ctx, RetrieveTokenParams{
         Party: params.Party, 
         TokenKind: "access", 
         UserID: params.UserID, 
+        AccountID: params.AccountID,
         AppCtx: params.AppCtx,
     })
     
-    if err != nil && err != redis.Nil {
+    if err != nil && err != ErrTokenNotFound {
         log.Printf("Unexpected error occurred while attempting to retrieve an access token: %v", err)
-        return "", err
+        return "", true, err
     } 
 
-    isExpired, err := IsAccessTokenExpired(params.AppCtx, fmt.Sprintf("%sAccessToken:%s", params.Party, params.UserID), accessToken)
+    isExpired, err := IsAccessTokenExpired(ctx, params.AppCtx, tokenKey(params.Party, "access", TokenOwner(params.UserID, params.AccountID)), accessToken)
     if err != nil {
         log.Printf("Error checking if access token is expired: %v", err)
+        return "", true, err
+    }
+    return accessToken, isExpired, nil
+}
+
+// Refreshes the access token while holding the user's distributed refresh lock.
+// If another instance is already refreshing, waits for it and reuses the token it stored.
+func refreshAccessToken(ctx context.Context, params GetValidAccessTokenParams) (string, error) {
+    // A second attempt covers a lock holder that died without storing new tokens
+    for attempt := 0; attempt < 2; attempt++ {
+        lock, err := AcquireRefreshLock(ctx, params.AppCtx, params.Party, TokenOwner(params.UserID, params.AccountID))
+        if err != nil {
+            log.Printf("Error acquiring %s refresh lock for user %s: %v", params.Party, params.UserID, err)
+            return "", err
+        }
+
+        if lock == nil {
+            log.Printf("%s token refresh for user %s already in progress elsewhere, waiting for it", params.Party, params.UserID)
+            if err := waitForRefreshLock(ctx, params.AppCtx, params.Party, TokenOwner(params.UserID, params.AccountID)); err != nil {
+                return "", err
+            }
+            accessToken, isExpired, err := retrieveAccessToken(ctx, params)
+            if err != nil {
+                return "", err
+            }
+            if !isExpired {
+                return accessToken, nil
+            }
+            // The winner either failed (and logged the user out) or never stored a token
+            hasRefreshToken, err := refreshTokenExists(ctx, params)
+            if err != nil {
+                return "", err
+            }
+            if !hasRefreshToken {
+                return "", fmt.Errorf("reauthentication required with %s", params.Party)
+            }
+            continue
+        }
+
+        accessToken, err := refreshAccessTokenLocked(ctx, params, lock)
+        lock.Release(ctx)
+        return accessToken, err
+    }
+    return "", fmt.Errorf("unable to refresh %s access token for user %s", params.Party, params.UserID)
+}
+
+// Reports whether a refresh token is still stored for the user
+func refreshTokenExists(ctx context.Context, params GetValidAccessTokenParams) (bool, error) {
+    refreshToken, err := RetrieveToken(ctx, RetrieveTokenParams{
+        Party: params.Party,
+        TokenKind: "refresh",
+        UserID: params.UserID,
+        AccountID: params.AccountID,
+        AppCtx: params.AppCtx,
+    })
+    if err == ErrTokenNotFound {
+        return false, nil
+    } else if err != nil {
+        return false, err
+    }
+    return refreshToken != "", nil
+}
+
+// Performs the refresh itself. Must only be called while holding the user's refresh lock.
+func refreshAccessTokenLocked(ctx context.Context, params GetValidAccessTokenParams, lock *RefreshLock) (string, error) {
+    // Another instance may have finished a refresh between our expiry check and acquiring the lock
+    accessToken, isExpired, err := retrieveAccessToken(ctx, params)
+    if err != nil {
         return "", err
     }
     if !isExpired {
-        // The token is valid and not expired
-        log.Printf("Retrieved valid access token for user %s with value: %s", params.UserID, accessToken)
+        log.Printf("Access token for user %s was refreshed by another request", params.UserID)
         return accessToken, nil
     }
 
     // If the access token is expired or not found, attempt to use the refresh token
-    refreshToken, err := RetrieveToken(RetrieveTokenParams{
+    refreshToken, err := RetrieveToken(ctx, RetrieveTokenParams{
         Party: params.Party,
         TokenKind: "refresh",
         UserID: params.UserID,
+        AccountID: params.AccountID,
         AppCtx: params.AppCtx,
     })
 
-    if refreshToken == "" || err == redis.Nil {
+    if err != nil && err != ErrTokenNotFound {
+        // Failing to read the refresh token says nothing about whether it exists; never log the user out over it
+        log.Printf("Error retrieving refresh token: %v", err)
+        return "", err
+    }
+    if refreshToken == "" || err == ErrTokenNotFound {
         // Refresh token is missing or empty; force reauthentication
         log.Printf("Absent refresh token. Logging out user %s", params.UserID)
-        if err := HandleLogout(params.Updater, ClearTokensParams{
+        if err := HandleLogout(ctx, params.Updater, ClearTokensParams{
             Party: params.Party,
             UserID: params.UserID,
+            AccountID: params.AccountID,
             AppCtx: params.AppCtx,
         }); err != nil {
             log.Printf("Error handling forced logout for user %s: %v", params.UserID, err)
-            return "", fmt.Errorf("error forcing logout for user %s: %v", params.UserID, err)
+            return "", fmt.Errorf("error forcing logout for user %s: %w", params.UserID, err)
         }
         return "", fmt.Errorf("reauthentication required with %s", params.Party)
-    } else if err != nil {
-        log.Printf("Error retrieving refresh token: %v", err)
-        return "", err
     }
 
-    isRefreshExpired, err := IsAccessTokenExpired(params.AppCtx, fmt.Sprintf("%sRefreshToken:%s", params.Party, params.UserID), refreshToken)
-    log.Printf("Refresh Token: '%s'\nisExpired?: %v", fmt.Sprintf("%sRefreshToken:%s", params.Party, params.UserID), isRefreshExpired)
+    isRefreshExpired, err := IsAccessTokenExpired(ctx, params.AppCtx, tokenKey(params.Party, "refresh", TokenOwner(params.UserID, params.AccountID)), refreshToken)
+    log.Printf("Refresh Token: '%s'\nisExpired?: %v", tokenKey(params.Party, "refresh", TokenOwner(params.UserID, params.AccountID)), isRefreshExpired)
     if err != nil {
         log.Printf("Error checking if refresh token is expired: %v", err)
         return "", err
@@ -286,16 +399,22 @@ func GetValidAccessToken(params GetValidAccessTokenParams) (string, error) {
 
         log.Printf("%s Access token request payload: %v", params.Party, payload)
 
-        tokenResponse, err := params.Service.RequestToken(payload)
+        tokenResponse, err := params.Service.RequestToken(ctx, payload)
+        if err != nil && !errors.Is(err, ErrUnauthorized) {
+            // Rate limits, upstream outages and network failures say nothing about the grant; keep the user logged in
+            log.Printf("Error refreshing token for user %s with %s: %v", params.UserID, params.Party, err)
+            return "", fmt.Errorf("error refreshing %s access token: %w", params.Party, err)
+        }
         if err != nil {
             log.Printf("Error refreshing token for user %s with %s, forcing reauthentication: %v", params.UserID, params.Party, err)
-            if logoutErr := HandleLogout(params.Updater, ClearTokensParams{
+            if logoutErr := HandleLogout(ctx, params.Updater, ClearTokensParams{
                 Party: params.Party,
                 UserID: params.UserID,
+                AccountID: params.AccountID,
                 AppCtx: params.AppCtx,
             }); logoutErr != nil {
                 log.Printf("Error handling forced logout/reauthentication for user %s: %v", params.UserID, logoutErr)
-                return "", fmt.Errorf("error forcing logout/reauthentication for user %s: %v", params.UserID, logoutErr)
+                return "", fmt.Errorf("error forcing logout/reauthentication for user %s: %w", params.UserID, logoutErr)
             }
             return "", fmt.Errorf("reauthentication required with %s for user %s", params.Party, params.UserID)
         }
@@ -304,79 +423,101 @@ func GetValidAccessToken(params GetValidAccessTokenParams) (string, error) {
 
         if tokenResponse.AccessToken == "" {
             log.Printf("Refreshed access token came in empty for user %s with %s, forcing reauthentication: %v", params.UserID, params.Party, err)
-            if logoutErr := HandleLogout(params.Updater, ClearTokensParams{
+            if logoutErr := HandleLogout(ctx, params.Updater, ClearTokensParams{
                 Party: params.Party,
                 UserID: params.UserID,
+                AccountID: params.AccountID,
                 AppCtx: params.AppCtx,
             }); logoutErr != nil {
                 log.Printf("Error handling forced logout/reauthentication for user %s: %v", params.UserID, logoutErr)
-                return "", fmt.Errorf("error forcing logout/reauthentication for user %s: %v", params.UserID, logoutErr)
+                return "", fmt.Errorf("error forcing logout/reauthentication for user %s: %w", params.UserID, logoutErr)
             }
             return "", fmt.Errorf("reauthentication required with %s for user %s", params.Party, params.UserID)
         }
 
-        // Store the access token
-        if err := SetToken(SetTokenParams{
-            TokenKind: "access",
-            Party: params.Party,
-            UserID: params.UserID, 
-            Token: tokenResponse.AccessToken,
-            ExpiresIn: tokenResponse.ExpiresIn,
-            AppCtx: params.AppCtx,
-        }); err != nil {
-            return "", fmt.Errorf("error storing new access token: %v", err)
-        }
-
-        // Store the new refresh token if present
-        if tokenResponse.RefreshToken != "" {
-            if err := SetToken(SetTokenParams{
-                TokenKind: "refresh",
-                Party: params.Party,
-                UserID: params.UserID,
-                Token: tokenResponse.RefreshToken,
-                ExpiresIn: 0,
-                AppCtx: params.AppCtx,
-            }); err != nil {
-                return "", fmt.Errorf("error storing new refresh token: %v", err)
-            }
+        // Store the access token and, if rotated, the new refresh token
+        if err := lock.StoreTokens(ctx, tokenResponse); err != nil {
+            return "", fmt.Errorf("error storing refreshed tokens: %v", err)
         }
         // Successfully requested a new access token from <party> using the refresh token
         return tokenResponse.AccessToken, nil
     } 
 
     // Refresh token is expired, so user must reauthenticate
-    if err := HandleLogout(params.Updater, ClearTokensParams{
+    if err := HandleLogout(ctx, params.Updater, ClearTokensParams{
         Party: params.Party,
         UserID: params.UserID,
+        AccountID: params.AccountID,
         AppCtx: params.AppCtx,
     }); err != nil {
         log.Printf("Error handling forced logout for user %s: %v", params.UserID, err)
-        return "", fmt.Errorf("error forcing logout for user %s: %v", params.UserID, err)
+        return "", fmt.Errorf("error forcing logout for user %s: %w", params.UserID, err)
     }
     return "", fmt.Errorf("reauthentication required with %s", params.Party)
 }
 
 // To mitigate circular dependency between auth0 and utils packages
 type UserMetadataUpdater interface {
-    UpdateUserMetadata(userID string, updatedAuthStatus map[string]interface{}) error
+    UpdateUserMetadata(ctx context.Context, userID string, updatedAuthStatus map[string]interface{}) error
 }
 
 // Called when user clicks "Log Out of <party>" button on the user interface
-func HandleLogout(updater UserMetadataUpdater, params ClearTokensParams) error {
+func HandleLogout(ctx context.Context, updater UserMetadataUpdater, params ClearTokensParams) error {
     log.Printf("Inside HandleLogout util")
-    if err := ClearTokens(params); err != nil {
-        return fmt.Errorf("error clearing %s tokens from Redis: %v", params.Party, err)
+    if err := ClearTokens(ctx, params); err != nil {
+        return fmt.Errorf("error clearing %s tokens: %w", params.Party, err)
     }
 
     party := strings.ToLower(params.Party)
 
+    if params.AccountID != "" {
+        if err := RemoveLinkedAccount(ctx, params.AppCtx, params.UserID, party, params.AccountID); err != nil {
+            return fmt.Errorf("error removing linked %s account: %v", party, err)
+        }
+    }
+
+    // The user stays authenticated with <party> while any of their linked accounts remain
+    remaining, err := ListLinkedAccounts(ctx, params.AppCtx, params.UserID, party)
+    if err != nil {
+        return fmt.Errorf("error listing linked %s accounts: %v", party, err)
+    }
+    if len(remaining) > 0 {
+        return nil
+    }
+
     updatedAuthStatus := map[string]interface{}{
         "app_metadata": map[string]bool{
             fmt.Sprintf("authenticated_with_%s", party): false,
         },
     }
-    if err := updater.UpdateUserMetadata(params.UserID, updatedAuthStatus); err != nil {
-        return fmt.Errorf("error updating user metadata: %v", err)
+    if err := updater.UpdateUserMetadata(ctx, params.UserID, updatedAuthStatus); err != nil {
+        return fmt.Errorf("error updating user metadata: %w", err)
     }
     return nil
-}
\ No newline at end of file
+}
+
+type UnlinkEvent struct {
+    Party       string    `json:"party"`
+    Revoked     bool      `json:"revoked"`  // whether the grant was also revoked at the provider
+    UnlinkedAt  time.Time `json:"unlinkedAt"`
+}
+
+// Number of unlink events kept per user
+const maxUnlinkHistory = 50
+
+func unlinkHistoryKey(userID string) string {
+    return fmt.Sprintf("unlinkHistory:%s", userID)
+}
+
+// Records that a user unlinked a provider account
+func RecordUnlink(ctx context.Context, appCtx AppContext, userID, party string, revoked bool) error {
+    event := UnlinkEvent{
+        Party: strings.ToLower(party),
+        Revoked: revoked,
+        UnlinkedAt: time.Now().UTC(),
+    }
+    if err := appCtx.Tokens.RecordUnlink(ctx, userID, event, maxUnlinkHistory); err != nil {
+        return fmt.Errorf("error recording %s unlink for user %s: %w", party, userID, err)
+    }
+    return nil
+}
@@ -0,0 +1,231 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Matched with errors.Is when a request was refused because its upstream's circuit breaker is open
+var ErrUpstreamUnavailable = errors.New("upstream unavailable")
+
+const (
+    defaultBreakerThreshold = 5
+    defaultBreakerCooldown  = 30 * time.Second
+)
+
+type BreakerState string
+
+const (
+    BreakerClosed   BreakerState = "closed"
+    BreakerOpen     BreakerState = "open"
+    BreakerHalfOpen BreakerState = "half-open"
+)
+
+// Returned instead of sending a request while an upstream's circuit breaker is open
+type CircuitOpenError struct {
+    Upstream   string
+    RetryAfter time.Duration // until the breaker lets a probe request through
+}
+
+func (e *CircuitOpenError) Error() string {
+    return fmt.Sprintf("%s is unavailable, not sending request for another %v", e.Upstream, e.RetryAfter.Round(time.Second))
+}
+
+func (e *CircuitOpenError) Unwrap() error {
+    return ErrUpstreamUnavailable
+}
+
+// Stops sending requests to an upstream after Threshold consecutive failures. Once Cooldown has passed,
+// a single probe request is let through (half-open): if it succeeds the breaker closes, otherwise it opens again.
+type CircuitBreaker struct {
+    Upstream  string
+    Threshold int
+    Cooldown  time.Duration
+
+    mu            sync.Mutex
+    state         BreakerState
+    failures      int
+    openedAt      time.Time
+    probeInFlight bool
+}
+
+type BreakerStatus struct {
+    Upstream            string       `json:"upstream"`
+    State               BreakerState `json:"state"`
+    ConsecutiveFailures int          `json:"consecutiveFailures"`
+    OpenedAt            *time.Time   `json:"openedAt,omitempty"`
+    RetryAfterSeconds   int          `json:"retryAfterSeconds,omitempty"`
+}
+
+// Creates a closed breaker using CIRCUIT_BREAKER_THRESHOLD and CIRCUIT_BREAKER_COOLDOWN, when set
+func NewCircuitBreaker(upstream string) *CircuitBreaker {
+    breaker := &CircuitBreaker{
+        Upstream: upstream,
+        Threshold: defaultBreakerThreshold,
+        Cooldown: defaultBreakerCooldown,
+        state: BreakerClosed,
+    }
+    if value := os.Getenv("CIRCUIT_BREAKER_THRESHOLD"); value != "" {
+        if n, err := strconv.Atoi(value); err == nil && n > 0 {
+            breaker.Threshold = n
+        } else {
+            log.Printf("Ignoring CIRCUIT_BREAKER_THRESHOLD: invalid value %q", value)
+        }
+    }
+    if value := os.Getenv("CIRCUIT_BREAKER_COOLDOWN"); value != "" {
+        if d, err := time.ParseDuration(value); err == nil && d > 0 {
+            breaker.Cooldown = d
+        } else {
+            log.Printf("Ignoring CIRCUIT_BREAKER_COOLDOWN: invalid duration %q", value)
+        }
+    }
+    return breaker
+}
+
+// Reports whether a request may be sent now. A nil error obliges the caller to report the outcome with Record.
+func (b *CircuitBreaker) Allow() error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    switch b.state {
+    case BreakerOpen:
+        if wait := b.Cooldown - time.Since(b.openedAt); wait > 0 {
+            return &CircuitOpenError{Upstream: b.Upstream, RetryAfter: wait}
+        }
+        log.Printf("%s circuit breaker half-open, sending a probe request", b.Upstream)
+        b.state = BreakerHalfOpen
+        b.probeInFlight = true
+        return nil
+    case BreakerHalfOpen:
+        if b.probeInFlight {
+            return &CircuitOpenError{Upstream: b.Upstream, RetryAfter: time.Second}
+        }
+        b.probeInFlight = true
+        return nil
+    }
+    return nil
+}
+
+// Records the outcome of a request let through by Allow
+func (b *CircuitBreaker) Record(success bool) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    b.probeInFlight = false
+    if success {
+        if b.state != BreakerClosed {
+            log.Printf("%s circuit breaker closed", b.Upstream)
+        }
+        b.state = BreakerClosed
+        b.failures = 0
+        return
+    }
+
+    b.failures++
+    if b.state == BreakerHalfOpen || b.failures >= b.Threshold {
+        if b.state != BreakerOpen {
+            log.Printf("%s circuit breaker opened after %d consecutive failures", b.Upstream, b.failures)
+        }
+        b.state = BreakerOpen
+        b.openedAt = time.Now()
+    }
+}
+
+// Releases a probe slot without recording an outcome, e.g. when the caller gave up on the request
+func (b *CircuitBreaker) Cancel() {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    if b.state == BreakerHalfOpen {
+        b.probeInFlight = false
+    }
+}
+
+func (b *CircuitBreaker) Status() BreakerStatus {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+
+    status := BreakerStatus{Upstream: b.Upstream, State: b.state, ConsecutiveFailures: b.failures}
+    if b.state == "" {
+        // A zero CircuitBreaker starts out closed
+        status.State = BreakerClosed
+    } else if b.state != BreakerClosed {
+        openedAt := b.openedAt
+        status.OpenedAt = &openedAt
+    }
+    if b.state == BreakerOpen {
+        if wait := b.Cooldown - time.Since(b.openedAt); wait > 0 {
+            status.RetryAfterSeconds = int(wait.Round(time.Second).Seconds())
+        }
+    }
+    return status
+}
+
+// One breaker per upstream, shared by every client of that upstream
+var (
+    breakersMu sync.Mutex
+    breakers   = map[string]*CircuitBreaker{}
+)
+
+// Returns the breaker of an upstream, creating it on first use
+func BreakerFor(upstream string) *CircuitBreaker {
+    breakersMu.Lock()
+    defer breakersMu.Unlock()
+
+    breaker, ok := breakers[upstream]
+    if !ok {
+        breaker = NewCircuitBreaker(upstream)
+        breakers[upstream] = breaker
+    }
+    return breaker
+}
+
+// Reports the state of every upstream's breaker, sorted by upstream name
+func BreakerStatuses() []BreakerStatus {
+    breakersMu.Lock()
+    list := make([]*CircuitBreaker, 0, len(breakers))
+    for _, breaker := range breakers {
+        list = append(list, breaker)
+    }
+    breakersMu.Unlock()
+
+    statuses := make([]BreakerStatus, 0, len(list))
+    for _, breaker := range list {
+        statuses = append(statuses, breaker.Status())
+    }
+    sort.Slice(statuses, func(i, j int) bool { return statuses[i].Upstream < statuses[j].Upstream })
+    return statuses
+}
+
+// Fails requests fast while the upstream's breaker is open, and feeds the outcome of the others back to it.
+// Server errors and failed connections count as failures; any other response shows the upstream is up.
+type breakerTransport struct {
+    breaker *CircuitBreaker
+    base    http.RoundTripper
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    if err := t.breaker.Allow(); err != nil {
+        return nil, err
+    }
+
+    res, err := t.base.RoundTrip(req)
+    switch {
+    case err != nil && errors.Is(req.Context().Err(), context.Canceled):
+        // The caller went away; that says nothing about the upstream
+        t.breaker.Cancel()
+    case err != nil:
+        t.breaker.Record(false)
+    default:
+        t.breaker.Record(res.StatusCode < 500)
+    }
+    return res, err
+}
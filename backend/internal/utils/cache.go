@@ -0,0 +1,269 @@
+package utils
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Returned by Cache.Get when there is no entry under the key
+var ErrCacheMiss = errors.New("cache miss")
+
+// Stores cached provider responses. Unlike tokens, cache entries may be lost at any time.
+type Cache interface {
+    Get(ctx context.Context, key string) ([]byte, error)
+    // Returns one value per key, nil for keys that are not cached
+    GetMany(ctx context.Context, keys []string) ([][]byte, error)
+    Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+    Delete(ctx context.Context, keys ...string) error
+    DeletePrefix(ctx context.Context, prefix string) error
+}
+
+// Size of the in-memory cache used while Redis is unavailable
+const defaultMemoryCacheBytes = 64 << 20
+
+// Creates the server's cache: Redis while it is reachable, otherwise an in-memory LRU cache
+// of CACHE_MEMORY_MAX_BYTES (64 MiB by default)
+func NewCache(redisClient *redis.Client, storage *StorageMonitor) Cache {
+    maxBytes := defaultMemoryCacheBytes
+    if value := os.Getenv("CACHE_MEMORY_MAX_BYTES"); value != "" {
+        if n, err := strconv.Atoi(value); err == nil && n > 0 {
+            maxBytes = n
+        } else {
+            log.Printf("Ignoring CACHE_MEMORY_MAX_BYTES: invalid value %q", value)
+        }
+    }
+    return &fallbackCache{
+        redis: &RedisCache{RedisClient: redisClient},
+        memory: NewMemoryCache(maxBytes),
+        storage: storage,
+    }
+}
+
+type RedisCache struct {
+    RedisClient *redis.Client
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
+    value, err := c.RedisClient.Get(ctx, key).Bytes()
+    if err == redis.Nil {
+        return nil, ErrCacheMiss
+    }
+    return value, err
+}
+
+func (c *RedisCache) GetMany(ctx context.Context, keys []string) ([][]byte, error) {
+    if len(keys) == 0 {
+        return nil, nil
+    }
+    values, err := c.RedisClient.MGet(ctx, keys...).Result()
+    if err != nil {
+        return nil, err
+    }
+    result := make([][]byte, len(values))
+    for i, value := range values {
+        if s, ok := value.(string); ok {
+            result[i] = []byte(s)
+        }
+    }
+    return result, nil
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+    return c.RedisClient.Set(ctx, key, value, ttl).Err()
+}
+
+func (c *RedisCache) Delete(ctx context.Context, keys ...string) error {
+    if len(keys) == 0 {
+        return nil
+    }
+    return c.RedisClient.Del(ctx, keys...).Err()
+}
+
+func (c *RedisCache) DeletePrefix(ctx context.Context, prefix string) error {
+    _, err := DeleteKeysMatching(ctx, AppContext{RedisClient: c.RedisClient}, EscapeGlob(prefix)+"*")
+    return err
+}
+
+type memoryEntry struct {
+    key       string
+    value     []byte
+    expiresAt time.Time
+}
+
+// An in-memory cache that evicts the least recently used entries once it holds more than MaxBytes of values
+type MemoryCache struct {
+    MaxBytes int
+
+    mu      sync.Mutex
+    size    int
+    order   *list.List // front is the most recently used
+    entries map[string]*list.Element
+}
+
+func NewMemoryCache(maxBytes int) *MemoryCache {
+    return &MemoryCache{
+        MaxBytes: maxBytes,
+        order: list.New(),
+        entries: make(map[string]*list.Element),
+    }
+}
+
+func (c *MemoryCache) Get(ctx context.Context, key string) ([]byte, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    element, ok := c.entries[key]
+    if !ok {
+        return nil, ErrCacheMiss
+    }
+    entry := element.Value.(*memoryEntry)
+    if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+        c.remove(element)
+        return nil, ErrCacheMiss
+    }
+    c.order.MoveToFront(element)
+    return entry.value, nil
+}
+
+func (c *MemoryCache) GetMany(ctx context.Context, keys []string) ([][]byte, error) {
+    result := make([][]byte, len(keys))
+    for i, key := range keys {
+        if value, err := c.Get(ctx, key); err == nil {
+            result[i] = value
+        }
+    }
+    return result, nil
+}
+
+func (c *MemoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if element, ok := c.entries[key]; ok {
+        c.remove(element)
+    }
+    if len(value) > c.MaxBytes {
+        return nil
+    }
+
+    entry := &memoryEntry{key: key, value: value}
+    if ttl > 0 {
+        entry.expiresAt = time.Now().Add(ttl)
+    }
+    c.entries[key] = c.order.PushFront(entry)
+    c.size += len(value)
+
+    for c.size > c.MaxBytes {
+        c.remove(c.order.Back())
+    }
+    return nil
+}
+
+func (c *MemoryCache) Delete(ctx context.Context, keys ...string) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    for _, key := range keys {
+        if element, ok := c.entries[key]; ok {
+            c.remove(element)
+        }
+    }
+    return nil
+}
+
+func (c *MemoryCache) DeletePrefix(ctx context.Context, prefix string) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    for key, element := range c.entries {
+        if strings.HasPrefix(key, prefix) {
+            c.remove(element)
+        }
+    }
+    return nil
+}
+
+func (c *MemoryCache) remove(element *list.Element) {
+    entry := element.Value.(*memoryEntry)
+    c.order.Remove(element)
+    delete(c.entries, entry.key)
+    c.size -= len(entry.value)
+}
+
+// Uses Redis while it is reachable and the in-memory cache while the server is in degraded mode
+type fallbackCache struct {
+    redis   *RedisCache
+    memory  *MemoryCache
+    storage *StorageMonitor
+}
+
+// Reports whether a Redis call failed, in which case the memory cache is used instead.
+// A call the caller cancelled or ran out of time for did not fail, and its error is returned as is.
+func (c *fallbackCache) failed(ctx context.Context, err error) bool {
+    if err == nil || err == ErrCacheMiss || ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+        return false
+    }
+    log.Printf("Redis cache call failed, using in-memory cache: %v", err)
+    c.storage.ReportError(err)
+    return true
+}
+
+func (c *fallbackCache) Get(ctx context.Context, key string) ([]byte, error) {
+    if !c.storage.Degraded() {
+        value, err := c.redis.Get(ctx, key)
+        if !c.failed(ctx, err) {
+            return value, err
+        }
+    }
+    return c.memory.Get(ctx, key)
+}
+
+func (c *fallbackCache) GetMany(ctx context.Context, keys []string) ([][]byte, error) {
+    if !c.storage.Degraded() {
+        values, err := c.redis.GetMany(ctx, keys)
+        if !c.failed(ctx, err) {
+            return values, err
+        }
+    }
+    return c.memory.GetMany(ctx, keys)
+}
+
+func (c *fallbackCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+    if !c.storage.Degraded() {
+        err := c.redis.Set(ctx, key, value, ttl)
+        if !c.failed(ctx, err) {
+            return err
+        }
+    }
+    return c.memory.Set(ctx, key, value, ttl)
+}
+
+// Deletes from both caches, so that nothing written while degraded outlives a deletion
+func (c *fallbackCache) Delete(ctx context.Context, keys ...string) error {
+    c.memory.Delete(ctx, keys...)
+    if c.storage.Degraded() {
+        return nil
+    }
+    err := c.redis.Delete(ctx, keys...)
+    c.failed(ctx, err)
+    return err
+}
+
+func (c *fallbackCache) DeletePrefix(ctx context.Context, prefix string) error {
+    c.memory.DeletePrefix(ctx, prefix)
+    if c.storage.Degraded() {
+        return nil
+    }
+    err := c.redis.DeletePrefix(ctx, prefix)
+    c.failed(ctx, err)
+    return err
+}
@@ -2,11 +2,14 @@ package utils
 
 import (
 	"github.com/redis/go-redis/v9"
-	// "go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type AppContext struct {
     RedisClient *redis.Client
-    // MongoClient *mongo.Client
+    MongoClient *mongo.Client   // nil when MONGO_URI is not set
     EnvConfig   *EnvConfig
+    Storage     *StorageMonitor // reports whether Redis is reachable; nil means it is assumed to be
+    Cache       Cache           // provider response caches, which fall back to memory while Redis is unavailable
+    Tokens      TokenStore      // OAuth tokens and their refresh locks
 }
\ No newline at end of file
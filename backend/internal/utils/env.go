@@ -9,9 +9,9 @@ import (
 
 type EnvConfig struct {
     RedisAddr                   string
-    // MongoURI                    string
+    MongoURI                    string // the user store is disabled when empty
     Port                        string
-    // DatabaseName                string
+    DatabaseName                string
     SpotifyClientID             string
     SpotifyClientSecret         string
     SpotifyRedirectURI          string
@@ -23,6 +23,8 @@ type EnvConfig struct {
     Auth0Domain                 string
     OpenAIAPIKey                string
     GinMode                     string
+    TokenStore                  string // redis, postgres, sqlite or memory
+    TokenStoreDSN               string // data source name of the postgres or sqlite token store
 }
 
 // Load the necessary ENV values
@@ -35,9 +37,9 @@ func LoadENV() *EnvConfig {
 
     return &EnvConfig{
         RedisAddr:                      os.Getenv("REDIS_ADDR"),
-        // MongoURI:                       os.Getenv("MONGO_URI"),
+        MongoURI:                       os.Getenv("MONGO_URI"),
         Port:                           defaultVal(os.Getenv("PORT"), "8080"),
-        // DatabaseName:                   os.Getenv("MONGO_DB_NAME"),
+        DatabaseName:                   defaultVal(os.Getenv("MONGO_DB_NAME"), "luthien"),
         SpotifyClientID:                os.Getenv("SPOTIFY_CLIENT_ID"),
         SpotifyClientSecret:            os.Getenv("SPOTIFY_CLIENT_SECRET"),
         SpotifyRedirectURI:             os.Getenv("SPOTIFY_REDIRECT_URI"),
@@ -49,6 +51,8 @@ func LoadENV() *EnvConfig {
         Auth0Domain:                    os.Getenv("AUTH0_DOMAIN"),
         OpenAIAPIKey:                   os.Getenv("OPENAI_API_KEY"),
         GinMode:                        os.Getenv("GIN_MODE"),
+        TokenStore:                     defaultVal(os.Getenv("TOKEN_STORE"), "redis"),
+        TokenStoreDSN:                  os.Getenv("TOKEN_STORE_DSN"),
     }
 }
 
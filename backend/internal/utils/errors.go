@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Kinds of upstream failures. Match them with errors.Is.
+var (
+    ErrRateLimited  = errors.New("rate limited by upstream")
+    ErrUnauthorized = errors.New("unauthorized by upstream")
+    ErrForbidden    = errors.New("forbidden by upstream")
+    ErrNotFound     = errors.New("not found upstream")
+    ErrUpstream     = errors.New("upstream request failed")
+)
+
+// Largest error response body kept in an UpstreamError
+const maxErrorBodySize = 4 << 10
+
+// An error response from a provider API
+type UpstreamError struct {
+    Upstream   string
+    StatusCode int
+    Body       string
+    RetryAfter time.Duration // zero unless the upstream sent Retry-After
+    Kind       error         // one of ErrRateLimited, ErrUnauthorized, ErrForbidden, ErrNotFound or ErrUpstream
+}
+
+func (e *UpstreamError) Error() string {
+    return fmt.Sprintf("%s API request failed with status %d: %s", e.Upstream, e.StatusCode, e.Body)
+}
+
+func (e *UpstreamError) Unwrap() error {
+    return e.Kind
+}
+
+// Builds an UpstreamError from an error response, reading (but not closing) its body
+func NewUpstreamError(upstream string, res *http.Response) *UpstreamError {
+    body, _ := io.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+
+    kind := ErrUpstream
+    switch res.StatusCode {
+    case http.StatusTooManyRequests:
+        kind = ErrRateLimited
+    case http.StatusUnauthorized:
+        kind = ErrUnauthorized
+    case http.StatusForbidden:
+        kind = ErrForbidden
+    case http.StatusNotFound:
+        kind = ErrNotFound
+    }
+
+    retryAfter, _ := ParseRetryAfter(res.Header.Get("Retry-After"))
+    return &UpstreamError{
+        Upstream: upstream,
+        StatusCode: res.StatusCode,
+        Body: strings.TrimSpace(string(body)),
+        RetryAfter: retryAfter,
+        Kind: kind,
+    }
+}
+
+// Returns an UpstreamError if the response has an error status, nil otherwise
+func CheckResponse(upstream string, res *http.Response) error {
+    if res.StatusCode < 400 {
+        return nil
+    }
+    return NewUpstreamError(upstream, res)
+}
+
+// Like CheckResponse, for OAuth token endpoints. These answer 400 (e.g. invalid_grant) when a
+// refresh token has been revoked or expired, which means the user has to reauthenticate.
+func CheckTokenResponse(upstream string, res *http.Response) error {
+    if res.StatusCode < 400 {
+        return nil
+    }
+    upstreamErr := NewUpstreamError(upstream, res)
+    if res.StatusCode == http.StatusBadRequest || res.StatusCode == http.StatusForbidden {
+        upstreamErr.Kind = ErrUnauthorized
+    }
+    return upstreamErr
+}
+
+// Parses a Retry-After header given either in seconds or as an HTTP date
+func ParseRetryAfter(value string) (time.Duration, bool) {
+    value = strings.TrimSpace(value)
+    if value == "" {
+        return 0, false
+    }
+    if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+        return time.Duration(seconds) * time.Second, true
+    }
+    if date, err := http.ParseTime(value); err == nil {
+        if wait := time.Until(date); wait > 0 {
+            return wait, true
+        }
+        return 0, true
+    }
+    return 0, false
+}
+
+// Status used when the client went away before we could answer, as popularized by nginx
+const StatusClientClosedRequest = 499
+
+// Seconds a client should wait before retrying after an upstream error, or 0 if the upstream gave no indication
+func RetryAfterSeconds(err error) int {
+    var upstreamErr *UpstreamError
+    if errors.As(err, &upstreamErr) && upstreamErr.RetryAfter > 0 {
+        return int(math.Ceil(upstreamErr.RetryAfter.Seconds()))
+    }
+    var circuitErr *CircuitOpenError
+    if errors.As(err, &circuitErr) && circuitErr.RetryAfter > 0 {
+        return int(math.Ceil(circuitErr.RetryAfter.Seconds()))
+    }
+    return 0
+}
+
+// Maps an upstream error to the HTTP status our API should answer with. Redis being unreachable is treated as an upstream outage.
+// Returns false for errors that did not come from an upstream response or an expired request deadline.
+func UpstreamErrorStatus(err error) (int, bool) {
+    switch {
+    case errors.Is(err, context.DeadlineExceeded):
+        return http.StatusGatewayTimeout, true
+    case errors.Is(err, context.Canceled):
+        return StatusClientClosedRequest, true
+    case errors.Is(err, ErrUpstreamUnavailable), errors.Is(err, ErrStorageUnavailable):
+        return http.StatusServiceUnavailable, true
+    case errors.Is(err, ErrRateLimited):
+        return http.StatusTooManyRequests, true
+    case errors.Is(err, ErrUnauthorized):
+        return http.StatusUnauthorized, true
+    case errors.Is(err, ErrForbidden):
+        return http.StatusForbidden, true
+    case errors.Is(err, ErrNotFound):
+        return http.StatusNotFound, true
+    case errors.Is(err, ErrUpstream):
+        return http.StatusBadGateway, true
+    }
+    return 0, false
+}
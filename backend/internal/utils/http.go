@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// Controls how the shared transport retries failed provider requests
+type RetryPolicy struct {
+    MaxRetries        int
+    BaseDelay         time.Duration // first backoff; doubled on every retry
+    MaxDelay          time.Duration // cap on a single backoff
+    MaxRetryAfter     time.Duration // Retry-After waits longer than this are returned to the caller instead
+    PerAttemptTimeout time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+    MaxRetries: 3,
+    BaseDelay: 250 * time.Millisecond,
+    MaxDelay: 5 * time.Second,
+    MaxRetryAfter: 30 * time.Second,
+    PerAttemptTimeout: 15 * time.Second,
+}
+
+// Methods that are safe to resend after a server error or a failed connection.
+// Any method is resent after a 429, since the upstream did not process the request.
+var idempotentMethods = map[string]bool{
+    http.MethodGet: true,
+    http.MethodHead: true,
+    http.MethodOptions: true,
+    http.MethodPut: true,
+    http.MethodDelete: true,
+}
+
+// Retries provider requests with exponential backoff and jitter, honoring Retry-After
+type retryTransport struct {
+    upstream string
+    base     http.RoundTripper
+    policy   RetryPolicy
+}
+
+// Returns an HTTP client for a provider API that retries 5xx and 429 responses and times out each attempt.
+// Requests fail fast with a CircuitOpenError while the upstream's circuit breaker is open.
+func NewHTTPClient(upstream string, policy RetryPolicy) *http.Client {
+    return &http.Client{
+        Transport: &breakerTransport{
+            breaker: BreakerFor(upstream),
+            base: &retryTransport{
+                upstream: upstream,
+                base: http.DefaultTransport,
+                policy: policy,
+            },
+        },
+    }
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    for attempt := 0; ; attempt++ {
+        attemptReq, cancel, err := t.prepareAttempt(req, attempt)
+        if err != nil {
+            return nil, err
+        }
+
+        res, err := t.base.RoundTrip(attemptReq)
+        delay, retry := t.shouldRetry(req, attempt, res, err)
+        if !retry {
+            if err != nil {
+                cancel()
+                return nil, err
+            }
+            // The attempt's timeout must outlive the round trip until the caller has read the body
+            res.Body = &cancelOnClose{ReadCloser: res.Body, cancel: cancel}
+            return res, nil
+        }
+
+        if err != nil {
+            log.Printf("%s request %s %s failed, retrying in %v: %v", t.upstream, req.Method, req.URL.Path, delay, err)
+        } else {
+            log.Printf("%s request %s %s returned %d, retrying in %v", t.upstream, req.Method, req.URL.Path, res.StatusCode, delay)
+            io.Copy(io.Discard, io.LimitReader(res.Body, maxErrorBodySize))
+            res.Body.Close()
+        }
+        cancel()
+
+        timer := time.NewTimer(delay)
+        select {
+        case <-req.Context().Done():
+            timer.Stop()
+            return nil, req.Context().Err()
+        case <-timer.C:
+        }
+    }
+}
+
+// Clones the request for an attempt with a fresh body and the per-attempt timeout
+func (t *retryTransport) prepareAttempt(req *http.Request, attempt int) (*http.Request, context.CancelFunc, error) {
+    var ctx context.Context
+    var cancel context.CancelFunc
+    if t.policy.PerAttemptTimeout > 0 {
+        ctx, cancel = context.WithTimeout(req.Context(), t.policy.PerAttemptTimeout)
+    } else {
+        ctx, cancel = context.WithCancel(req.Context())
+    }
+    attemptReq := req.Clone(ctx)
+    if attempt > 0 && req.Body != nil && req.Body != http.NoBody {
+        body, err := req.GetBody()
+        if err != nil {
+            cancel()
+            return nil, nil, err
+        }
+        attemptReq.Body = body
+    }
+    return attemptReq, cancel, nil
+}
+
+// Decides whether an attempt's outcome should be retried and how long to wait first
+func (t *retryTransport) shouldRetry(req *http.Request, attempt int, res *http.Response, err error) (time.Duration, bool) {
+    if attempt >= t.policy.MaxRetries || req.Context().Err() != nil {
+        return 0, false
+    }
+    // Requests whose body cannot be replayed are sent only once
+    if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+        return 0, false
+    }
+
+    if err != nil {
+        return t.backoff(attempt), idempotentMethods[req.Method]
+    }
+
+    switch {
+    case res.StatusCode == http.StatusTooManyRequests:
+    case res.StatusCode >= 500 && idempotentMethods[req.Method]:
+    default:
+        return 0, false
+    }
+
+    if retryAfter, ok := ParseRetryAfter(res.Header.Get("Retry-After")); ok {
+        if retryAfter > t.policy.MaxRetryAfter {
+            return 0, false
+        }
+        return retryAfter, true
+    }
+    return t.backoff(attempt), true
+}
+
+// Exponential backoff with full jitter
+func (t *retryTransport) backoff(attempt int) time.Duration {
+    delay := t.policy.BaseDelay << attempt
+    if delay <= 0 || delay > t.policy.MaxDelay {
+        delay = t.policy.MaxDelay
+    }
+    return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+// Cancels an attempt's context once the caller is done with the response body
+type cancelOnClose struct {
+    io.ReadCloser
+    cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+    err := b.ReadCloser.Close()
+    b.cancel()
+    return err
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// How long a token refresh may hold the lock before another instance is allowed to take over
+const refreshLockTTL = 10 * time.Second
+
+// How often waiters check whether the refresh holding the lock has finished
+const refreshLockPollInterval = 100 * time.Millisecond
+
+// Distributed lock guarding a single user's token refresh across server instances
+type RefreshLock struct {
+    Party   string
+    UserID  string // token owner, i.e. the user ID qualified by the linked account ID when one is selected
+    Fence   int64 // monotonically increasing fencing token of the current holder
+    AppCtx  AppContext
+}
+
+// Returns the name under which a token is stored
+func tokenKey(party, tokenKind, userID string) string {
+    return fmt.Sprintf("%s%sToken:%s", strings.ToLower(party), capitalizeFirstLetter(tokenKind), userID)
+}
+
+func refreshLockKey(party, userID string) string {
+    return fmt.Sprintf("%sRefreshLock:%s", strings.ToLower(party), userID)
+}
+
+// Attempts to take the refresh lock for a token owner (see TokenOwner). Returns nil without an error if another instance holds it.
+func AcquireRefreshLock(ctx context.Context, appCtx AppContext, party, userID string) (*RefreshLock, error) {
+    fence, acquired, err := appCtx.Tokens.AcquireLock(ctx, refreshLockKey(party, userID), refreshLockTTL)
+    if err != nil {
+        return nil, fmt.Errorf("error acquiring refresh lock: %w", err)
+    }
+    if !acquired {
+        return nil, nil
+    }
+
+    log.Printf("Acquired %s refresh lock for user %s with fencing token %d", party, userID, fence)
+    return &RefreshLock{Party: party, UserID: userID, Fence: fence, AppCtx: appCtx}, nil
+}
+
+// Stores refreshed tokens, provided this lock has not been taken over by another holder
+func (l *RefreshLock) StoreTokens(ctx context.Context, tokenResponse TokenResponse) error {
+    tokens := map[string]StoredToken{
+        tokenKey(l.Party, "access", l.UserID): {
+            Value: tokenResponse.AccessToken,
+            ExpiresAt: time.Now().Add(time.Duration(tokenResponse.ExpiresIn) * time.Second),
+        },
+    }
+    if tokenResponse.RefreshToken != "" {
+        tokens[tokenKey(l.Party, "refresh", l.UserID)] = StoredToken{
+            Value: tokenResponse.RefreshToken,
+            ExpiresAt: time.Now().Add(refreshTokenLifetime),
+        }
+    }
+    if err := l.AppCtx.Tokens.SetLocked(ctx, refreshLockKey(l.Party, l.UserID), l.Fence, tokens); err != nil {
+        return fmt.Errorf("error storing refreshed tokens: %w", err)
+    }
+    return nil
+}
+
+// Releases the lock if it is still held by this holder
+func (l *RefreshLock) Release(ctx context.Context) {
+    if err := l.AppCtx.Tokens.ReleaseLock(ctx, refreshLockKey(l.Party, l.UserID), l.Fence); err != nil {
+        log.Printf("Error releasing %s refresh lock for user %s: %v", l.Party, l.UserID, err)
+    }
+}
+
+// Blocks until whoever holds the refresh lock for a user has released it or the lock has expired
+func waitForRefreshLock(ctx context.Context, appCtx AppContext, party, userID string) error {
+    deadline := time.Now().Add(refreshLockTTL)
+    for time.Now().Before(deadline) {
+        held, err := appCtx.Tokens.LockHeld(ctx, refreshLockKey(party, userID))
+        if err != nil {
+            return fmt.Errorf("error checking refresh lock: %w", err)
+        }
+        if !held {
+            return nil
+        }
+        select {
+        case <-ctx.Done():
+            return ctx.Err()
+        case <-time.After(refreshLockPollInterval):
+        }
+    }
+    return fmt.Errorf("timed out waiting for %s token refresh for user %s", party, userID)
+}
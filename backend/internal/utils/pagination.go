@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Returned for page parameters that cannot be used
+var ErrInvalidPage = errors.New("invalid page parameters")
+
+// A page of a provider list endpoint as returned to clients, shaped the same for every provider
+type Page[T any] struct {
+    Items []T     `json:"items"`
+    Total int     `json:"total"`  // number of items in the whole list
+    Next  *string `json:"next"`   // cursor of the next page (null if none)
+}
+
+// A page of a provider list endpoint. Cursors are opaque to clients: they pass on the `next` cursor of
+// the previous page, and an empty cursor starts at the first page.
+type PageRequest struct {
+    Cursor string
+    Limit  int
+    All    bool // fetches every page, ignoring Cursor and Limit
+}
+
+// Parses the cursor, limit and all query parameters, applying defaultLimit when no limit is given
+func ParsePageRequest(cursor, limit, all string, defaultLimit, maxLimit int) (PageRequest, error) {
+    page := PageRequest{Cursor: cursor, Limit: defaultLimit}
+    if limit != "" {
+        parsed, err := strconv.Atoi(limit)
+        if err != nil || parsed < 1 || parsed > maxLimit {
+            return PageRequest{}, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidPage, maxLimit)
+        }
+        page.Limit = parsed
+    }
+    if all != "" {
+        parsed, err := strconv.ParseBool(all)
+        if err != nil {
+            return PageRequest{}, fmt.Errorf("%w: all must be true or false", ErrInvalidPage)
+        }
+        page.All = parsed
+    }
+    return page, nil
+}
+
+// Decodes a cursor of an offset-paginated endpoint
+func OffsetFromCursor(cursor string) (int, error) {
+    if cursor == "" {
+        return 0, nil
+    }
+    offset, err := strconv.Atoi(cursor)
+    if err != nil || offset < 0 {
+        return 0, fmt.Errorf("%w: malformed cursor", ErrInvalidPage)
+    }
+    return offset, nil
+}
+
+// Encodes the cursor of an offset-paginated endpoint
+func OffsetCursor(offset int) string {
+    return strconv.Itoa(offset)
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"context"
+	"strings"
+)
+
+// Escapes Redis glob metacharacters so that a user ID only ever matches itself
+func EscapeGlob(s string) string {
+    replacer := strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+    return replacer.Replace(s)
+}
+
+// Deletes every key matching a glob pattern, scanning rather than using KEYS so that Redis is not blocked.
+// Returns the number of keys deleted.
+func DeleteKeysMatching(ctx context.Context, appCtx AppContext, pattern string) (int, error) {
+    iter := appCtx.RedisClient.Scan(ctx, 0, pattern, 100).Iterator()
+    var keys []string
+    for iter.Next(ctx) {
+        keys = append(keys, iter.Val())
+    }
+    if err := iter.Err(); err != nil {
+        return 0, err
+    }
+    if len(keys) == 0 {
+        return 0, nil
+    }
+    n, err := appCtx.RedisClient.Del(ctx, keys...).Result()
+    return int(n), err
+}
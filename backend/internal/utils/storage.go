@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Matched with errors.Is when Redis could not be reached. It never means that a token or entry is missing.
+var ErrStorageUnavailable = errors.New("storage unavailable")
+
+// Tracks whether Redis is reachable. While it is not, the server runs in degraded mode: caches are served
+// from memory and anything that needs stored tokens fails fast with ErrStorageUnavailable.
+type StorageMonitor struct {
+    RedisClient *redis.Client
+
+    mu        sync.RWMutex
+    degraded  bool
+    since     time.Time
+    lastError string
+}
+
+type StorageStatus struct {
+    Backend   string     `json:"backend"`
+    Degraded  bool       `json:"degraded"`
+    Since     *time.Time `json:"since,omitempty"` // when degraded mode began
+    LastError string     `json:"lastError,omitempty"`
+}
+
+func NewStorageMonitor(redisClient *redis.Client) *StorageMonitor {
+    return &StorageMonitor{RedisClient: redisClient}
+}
+
+// Pings Redis every interval until ctx is done, entering or leaving degraded mode accordingly
+func (m *StorageMonitor) Run(ctx context.Context, interval time.Duration) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+        pingCtx, cancel := context.WithTimeout(ctx, interval)
+        err := m.RedisClient.Ping(pingCtx).Err()
+        cancel()
+        if err != nil {
+            m.markDegraded(err)
+        } else {
+            m.markHealthy()
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// Whether the server is running without Redis. A nil monitor reports a healthy Redis.
+func (m *StorageMonitor) Degraded() bool {
+    if m == nil {
+        return false
+    }
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    return m.degraded
+}
+
+func (m *StorageMonitor) Status() StorageStatus {
+    status := StorageStatus{Backend: "redis"}
+    if m == nil {
+        return status
+    }
+    m.mu.RLock()
+    defer m.mu.RUnlock()
+    if m.degraded {
+        since := m.since
+        status.Degraded = true
+        status.Since = &since
+        status.LastError = m.lastError
+    }
+    return status
+}
+
+// Enters degraded mode if err shows that Redis cannot be reached
+func (m *StorageMonitor) ReportError(err error) {
+    if m == nil || !isConnectionError(err) {
+        return
+    }
+    m.markDegraded(err)
+}
+
+func (m *StorageMonitor) markDegraded(err error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if !m.degraded {
+        log.Printf("Redis unavailable, entering degraded mode: %v", err)
+        m.degraded = true
+        m.since = time.Now()
+    }
+    m.lastError = err.Error()
+}
+
+func (m *StorageMonitor) markHealthy() {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.degraded {
+        log.Printf("Redis reachable again after %v, leaving degraded mode", time.Since(m.since).Round(time.Second))
+        m.degraded = false
+        m.lastError = ""
+    }
+}
+
+// Whether a Redis error means the server could not be reached, as opposed to e.g. a wrong type or a script error.
+// context.DeadlineExceeded is a net.Error too, but unless dialing or reading from Redis timed out it only means the
+// caller ran out of time.
+func isConnectionError(err error) bool {
+    var opErr *net.OpError
+    if errors.As(err, &opErr) {
+        return true
+    }
+    if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+        return false
+    }
+    var netErr net.Error
+    return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, redis.ErrClosed)
+}
+
+// Fails fast with ErrStorageUnavailable while in degraded mode
+func CheckStorage(appCtx AppContext) error {
+    if appCtx.Storage.Degraded() {
+        return fmt.Errorf("%w: running in degraded mode", ErrStorageUnavailable)
+    }
+    return nil
+}
+
+// Wraps an error from Redis as ErrStorageUnavailable, so that it is never mistaken for a missing entry.
+// nil, redis.Nil and the caller's own cancellation are returned unchanged.
+func StorageError(appCtx AppContext, err error) error {
+    if err == nil || err == redis.Nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+        return err
+    }
+    appCtx.Storage.ReportError(err)
+    return fmt.Errorf("%w: %v", ErrStorageUnavailable, err)
+}
@@ -0,0 +1,519 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Returned by TokenStore.Get when no token is stored under the name, or the stored one has expired
+var ErrTokenNotFound = errors.New("token not found")
+
+var errRefreshLockLost = errors.New("token refresh lock lost before new tokens could be stored")
+
+// A stored token. A zero ExpiresAt means the token does not expire.
+type StoredToken struct {
+    Value     string
+    ExpiresAt time.Time
+}
+
+// Whether the token expires within d
+func (t StoredToken) ExpiresWithin(d time.Duration) bool {
+    return !t.ExpiresAt.IsZero() && time.Until(t.ExpiresAt) < d
+}
+
+// Persists OAuth tokens, the locks that serialize their refreshes, the provider accounts they were issued for and
+// the history of those accounts being unlinked.
+// Tokens and locks are identified by names built by tokenKey and refreshLockKey.
+type TokenStore interface {
+    Get(ctx context.Context, name string) (StoredToken, error)
+    Set(ctx context.Context, name string, token StoredToken) error
+    Delete(ctx context.Context, names ...string) error
+    // Takes a lock for ttl unless another holder has it. Every attempt draws a new, higher fencing token.
+    AcquireLock(ctx context.Context, name string, ttl time.Duration) (fence int64, acquired bool, err error)
+    // Releases a lock if it is still held under the given fencing token
+    ReleaseLock(ctx context.Context, name string, fence int64) error
+    LockHeld(ctx context.Context, name string) (bool, error)
+    // Stores tokens only if the lock is still held under the given fencing token, failing with errRefreshLockLost otherwise
+    SetLocked(ctx context.Context, lockName string, fence int64, tokens map[string]StoredToken) error
+    // Stores a linked account under its ID, replacing an earlier one. It becomes the default if the user has none for the provider.
+    SaveLinkedAccount(ctx context.Context, party, userID string, account LinkedAccount) error
+    // Returns the user's linked accounts for a provider in no particular order, and the ID of the default one ("" if none)
+    LinkedAccounts(ctx context.Context, party, userID string) ([]LinkedAccount, string, error)
+    DeleteLinkedAccount(ctx context.Context, party, userID, accountID string) error
+    // Makes an account the default for its provider, or clears the default when accountID is empty
+    SetDefaultAccount(ctx context.Context, party, userID, accountID string) error
+    // Adds an event to the user's unlink history, keeping only the most recent `keep` events
+    RecordUnlink(ctx context.Context, userID string, event UnlinkEvent, keep int) error
+    // Returns the user's unlink history, most recent first
+    UnlinkHistory(ctx context.Context, userID string) ([]UnlinkEvent, error)
+}
+
+// Whether a token or lock name belongs to the user, i.e. names the user ID or one of their linked accounts (see TokenOwner)
+func ownedBy(name, userID string) bool {
+    return strings.HasSuffix(name, ":"+userID) || strings.Contains(name, ":"+userID+":")
+}
+
+type memoryLock struct {
+    fence     int64
+    expiresAt time.Time
+}
+
+// Keeps tokens in process memory. Tokens do not survive a restart and are not shared between instances,
+// so this is meant for tests and single-instance development.
+type MemoryTokenStore struct {
+    mu       sync.Mutex
+    tokens   map[string]StoredToken
+    locks    map[string]memoryLock
+    fences   map[string]int64
+    accounts map[string]map[string]LinkedAccount // by linkedAccountsKey, then account ID
+    defaults map[string]string                   // by defaultAccountKey
+    unlinks  map[string][]UnlinkEvent            // by unlinkHistoryKey, most recent first
+}
+
+func NewMemoryTokenStore() *MemoryTokenStore {
+    return &MemoryTokenStore{
+        tokens: make(map[string]StoredToken),
+        locks: make(map[string]memoryLock),
+        fences: make(map[string]int64),
+        accounts: make(map[string]map[string]LinkedAccount),
+        defaults: make(map[string]string),
+        unlinks: make(map[string][]UnlinkEvent),
+    }
+}
+
+func (s *MemoryTokenStore) Get(ctx context.Context, name string) (StoredToken, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    token, ok := s.tokens[name]
+    if !ok {
+        return StoredToken{}, ErrTokenNotFound
+    }
+    if token.ExpiresWithin(0) {
+        delete(s.tokens, name)
+        return StoredToken{}, ErrTokenNotFound
+    }
+    return token, nil
+}
+
+func (s *MemoryTokenStore) Set(ctx context.Context, name string, token StoredToken) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.tokens[name] = token
+    return nil
+}
+
+func (s *MemoryTokenStore) Delete(ctx context.Context, names ...string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for _, name := range names {
+        delete(s.tokens, name)
+    }
+    return nil
+}
+
+func (s *MemoryTokenStore) AcquireLock(ctx context.Context, name string, ttl time.Duration) (int64, bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    s.fences[name]++
+    fence := s.fences[name]
+    if lock, ok := s.locks[name]; ok && time.Now().Before(lock.expiresAt) {
+        return fence, false, nil
+    }
+    s.locks[name] = memoryLock{fence: fence, expiresAt: time.Now().Add(ttl)}
+    return fence, true, nil
+}
+
+func (s *MemoryTokenStore) ReleaseLock(ctx context.Context, name string, fence int64) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if lock, ok := s.locks[name]; ok && lock.fence == fence {
+        delete(s.locks, name)
+    }
+    return nil
+}
+
+func (s *MemoryTokenStore) LockHeld(ctx context.Context, name string) (bool, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    lock, ok := s.locks[name]
+    return ok && time.Now().Before(lock.expiresAt), nil
+}
+
+func (s *MemoryTokenStore) SetLocked(ctx context.Context, lockName string, fence int64, tokens map[string]StoredToken) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    lock, ok := s.locks[lockName]
+    if !ok || lock.fence != fence || !time.Now().Before(lock.expiresAt) {
+        return errRefreshLockLost
+    }
+    for name, token := range tokens {
+        s.tokens[name] = token
+    }
+    return nil
+}
+
+func (s *MemoryTokenStore) SaveLinkedAccount(ctx context.Context, party, userID string, account LinkedAccount) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    key := linkedAccountsKey(party, userID)
+    if s.accounts[key] == nil {
+        s.accounts[key] = make(map[string]LinkedAccount)
+    }
+    s.accounts[key][account.ID] = account
+    if _, ok := s.defaults[defaultAccountKey(party, userID)]; !ok {
+        s.defaults[defaultAccountKey(party, userID)] = account.ID
+    }
+    return nil
+}
+
+func (s *MemoryTokenStore) LinkedAccounts(ctx context.Context, party, userID string) ([]LinkedAccount, string, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    accounts := make([]LinkedAccount, 0, len(s.accounts[linkedAccountsKey(party, userID)]))
+    for _, account := range s.accounts[linkedAccountsKey(party, userID)] {
+        accounts = append(accounts, account)
+    }
+    return accounts, s.defaults[defaultAccountKey(party, userID)], nil
+}
+
+func (s *MemoryTokenStore) DeleteLinkedAccount(ctx context.Context, party, userID, accountID string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    delete(s.accounts[linkedAccountsKey(party, userID)], accountID)
+    return nil
+}
+
+func (s *MemoryTokenStore) SetDefaultAccount(ctx context.Context, party, userID, accountID string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    if accountID == "" {
+        delete(s.defaults, defaultAccountKey(party, userID))
+        return nil
+    }
+    s.defaults[defaultAccountKey(party, userID)] = accountID
+    return nil
+}
+
+func (s *MemoryTokenStore) RecordUnlink(ctx context.Context, userID string, event UnlinkEvent, keep int) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    key := unlinkHistoryKey(userID)
+    history := append([]UnlinkEvent{event}, s.unlinks[key]...)
+    s.unlinks[key] = history[:min(len(history), keep)]
+    return nil
+}
+
+func (s *MemoryTokenStore) UnlinkHistory(ctx context.Context, userID string) ([]UnlinkEvent, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    return append([]UnlinkEvent{}, s.unlinks[unlinkHistoryKey(userID)]...), nil
+}
+
+// Deletes every token, lock, linked account and unlink event of a user
+func (s *MemoryTokenStore) PurgeUserData(ctx context.Context, userID string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+
+    for name := range s.tokens {
+        if ownedBy(name, userID) {
+            delete(s.tokens, name)
+        }
+    }
+    for name := range s.locks {
+        if ownedBy(name, userID) {
+            delete(s.locks, name)
+            delete(s.fences, name)
+        }
+    }
+    for name := range s.accounts {
+        if ownedBy(name, userID) {
+            delete(s.accounts, name)
+        }
+    }
+    for name := range s.defaults {
+        if ownedBy(name, userID) {
+            delete(s.defaults, name)
+        }
+    }
+    delete(s.unlinks, unlinkHistoryKey(userID))
+    return nil
+}
+
+// Releases the lock only if it is still held by the given fencing token
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Stores tokens only if the lock in KEYS[1] is still held by the fencing token in ARGV[1].
+// Each further key is set to the value in ARGV[2i] with a TTL of ARGV[2i+1] milliseconds, or none when it is 0.
+var setLockedScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] then
+    return 0
+end
+for i = 2, #KEYS do
+    local ttl = tonumber(ARGV[2 * i - 1])
+    if ttl > 0 then
+        redis.call("SET", KEYS[i], ARGV[2 * i - 2], "PX", ttl)
+    else
+        redis.call("SET", KEYS[i], ARGV[2 * i - 2])
+    end
+end
+return 1
+`)
+
+// Keeps tokens in Redis under their names, with expiry as the key's TTL. Errors while Redis is unreachable
+// are reported as ErrStorageUnavailable, and calls fail fast while the server is in degraded mode.
+type RedisTokenStore struct {
+    RedisClient *redis.Client
+    Storage     *StorageMonitor
+}
+
+func NewRedisTokenStore(redisClient *redis.Client, storage *StorageMonitor) *RedisTokenStore {
+    return &RedisTokenStore{RedisClient: redisClient, Storage: storage}
+}
+
+func (s *RedisTokenStore) appCtx() AppContext {
+    return AppContext{RedisClient: s.RedisClient, Storage: s.Storage}
+}
+
+// Fails fast while in degraded mode
+func (s *RedisTokenStore) check() error {
+    return CheckStorage(s.appCtx())
+}
+
+func (s *RedisTokenStore) wrap(err error) error {
+    return StorageError(s.appCtx(), err)
+}
+
+// Converts an expiry time to a Redis TTL, 0 meaning none
+func redisTTL(expiresAt time.Time) time.Duration {
+    if expiresAt.IsZero() {
+        return 0
+    }
+    ttl := time.Until(expiresAt)
+    if ttl < time.Millisecond {
+        // Already expired; Redis would treat 0 as "no expiry"
+        ttl = time.Millisecond
+    }
+    return ttl
+}
+
+// Lock fencing tokens are counted under a key next to the lock, so that they are deleted with the user's other keys
+func redisFenceKey(lockName string) string {
+    return lockName + ":fence"
+}
+
+func (s *RedisTokenStore) Get(ctx context.Context, name string) (StoredToken, error) {
+    if err := s.check(); err != nil {
+        return StoredToken{}, err
+    }
+    pipe := s.RedisClient.Pipeline()
+    valueCmd := pipe.Get(ctx, name)
+    ttlCmd := pipe.PTTL(ctx, name)
+    pipe.Exec(ctx)
+
+    value, err := valueCmd.Result()
+    if err == redis.Nil {
+        return StoredToken{}, ErrTokenNotFound
+    } else if err != nil {
+        return StoredToken{}, s.wrap(err)
+    }
+    ttl, err := ttlCmd.Result()
+    if err != nil {
+        return StoredToken{}, s.wrap(err)
+    }
+
+    token := StoredToken{Value: value}
+    if ttl > 0 {
+        token.ExpiresAt = time.Now().Add(ttl)
+    }
+    return token, nil
+}
+
+func (s *RedisTokenStore) Set(ctx context.Context, name string, token StoredToken) error {
+    if err := s.check(); err != nil {
+        return err
+    }
+    return s.wrap(s.RedisClient.Set(ctx, name, token.Value, redisTTL(token.ExpiresAt)).Err())
+}
+
+func (s *RedisTokenStore) Delete(ctx context.Context, names ...string) error {
+    if len(names) == 0 {
+        return nil
+    }
+    if err := s.check(); err != nil {
+        return err
+    }
+    return s.wrap(s.RedisClient.Del(ctx, names...).Err())
+}
+
+func (s *RedisTokenStore) AcquireLock(ctx context.Context, name string, ttl time.Duration) (int64, bool, error) {
+    if err := s.check(); err != nil {
+        return 0, false, err
+    }
+    fence, err := s.RedisClient.Incr(ctx, redisFenceKey(name)).Result()
+    if err != nil {
+        return 0, false, fmt.Errorf("error generating fencing token: %w", s.wrap(err))
+    }
+    acquired, err := s.RedisClient.SetNX(ctx, name, fence, ttl).Result()
+    if err != nil {
+        return 0, false, s.wrap(err)
+    }
+    return fence, acquired, nil
+}
+
+func (s *RedisTokenStore) ReleaseLock(ctx context.Context, name string, fence int64) error {
+    if err := s.check(); err != nil {
+        return err
+    }
+    return s.wrap(releaseLockScript.Run(ctx, s.RedisClient, []string{name}, fence).Err())
+}
+
+func (s *RedisTokenStore) LockHeld(ctx context.Context, name string) (bool, error) {
+    if err := s.check(); err != nil {
+        return false, err
+    }
+    exists, err := s.RedisClient.Exists(ctx, name).Result()
+    if err != nil {
+        return false, s.wrap(err)
+    }
+    return exists > 0, nil
+}
+
+func (s *RedisTokenStore) SetLocked(ctx context.Context, lockName string, fence int64, tokens map[string]StoredToken) error {
+    if err := s.check(); err != nil {
+        return err
+    }
+    keys := []string{lockName}
+    args := []interface{}{fence}
+    for name, token := range tokens {
+        keys = append(keys, name)
+        args = append(args, token.Value, redisTTL(token.ExpiresAt).Milliseconds())
+    }
+    stored, err := setLockedScript.Run(ctx, s.RedisClient, keys, args...).Int()
+    if err != nil {
+        return s.wrap(err)
+    }
+    if stored == 0 {
+        return errRefreshLockLost
+    }
+    return nil
+}
+
+// Linked accounts are kept as JSON in a hash per user and provider, with the default account's ID in a key next to it
+func (s *RedisTokenStore) SaveLinkedAccount(ctx context.Context, party, userID string, account LinkedAccount) error {
+    if err := s.check(); err != nil {
+        return err
+    }
+    data, err := json.Marshal(account)
+    if err != nil {
+        return err
+    }
+    if err := s.RedisClient.HSet(ctx, linkedAccountsKey(party, userID), account.ID, data).Err(); err != nil {
+        return s.wrap(err)
+    }
+    return s.wrap(s.RedisClient.SetNX(ctx, defaultAccountKey(party, userID), account.ID, 0).Err())
+}
+
+func (s *RedisTokenStore) LinkedAccounts(ctx context.Context, party, userID string) ([]LinkedAccount, string, error) {
+    if err := s.check(); err != nil {
+        return nil, "", err
+    }
+    pipe := s.RedisClient.Pipeline()
+    entriesCmd := pipe.HGetAll(ctx, linkedAccountsKey(party, userID))
+    defaultCmd := pipe.Get(ctx, defaultAccountKey(party, userID))
+    pipe.Exec(ctx)
+
+    entries, err := entriesCmd.Result()
+    if err != nil {
+        return nil, "", s.wrap(err)
+    }
+    defaultID, err := defaultCmd.Result()
+    if err != nil && err != redis.Nil {
+        return nil, "", s.wrap(err)
+    }
+
+    accounts := make([]LinkedAccount, 0, len(entries))
+    for _, data := range entries {
+        var account LinkedAccount
+        if err := json.Unmarshal([]byte(data), &account); err != nil {
+            return nil, "", fmt.Errorf("error decoding linked %s account: %v", party, err)
+        }
+        accounts = append(accounts, account)
+    }
+    return accounts, defaultID, nil
+}
+
+func (s *RedisTokenStore) DeleteLinkedAccount(ctx context.Context, party, userID, accountID string) error {
+    if err := s.check(); err != nil {
+        return err
+    }
+    return s.wrap(s.RedisClient.HDel(ctx, linkedAccountsKey(party, userID), accountID).Err())
+}
+
+func (s *RedisTokenStore) SetDefaultAccount(ctx context.Context, party, userID, accountID string) error {
+    if err := s.check(); err != nil {
+        return err
+    }
+    if accountID == "" {
+        return s.wrap(s.RedisClient.Del(ctx, defaultAccountKey(party, userID)).Err())
+    }
+    return s.wrap(s.RedisClient.Set(ctx, defaultAccountKey(party, userID), accountID, 0).Err())
+}
+
+func (s *RedisTokenStore) RecordUnlink(ctx context.Context, userID string, event UnlinkEvent, keep int) error {
+    if err := s.check(); err != nil {
+        return err
+    }
+    data, err := json.Marshal(event)
+    if err != nil {
+        return err
+    }
+    pipe := s.RedisClient.TxPipeline()
+    pipe.LPush(ctx, unlinkHistoryKey(userID), data)
+    pipe.LTrim(ctx, unlinkHistoryKey(userID), 0, int64(keep-1))
+    _, err = pipe.Exec(ctx)
+    return s.wrap(err)
+}
+
+func (s *RedisTokenStore) UnlinkHistory(ctx context.Context, userID string) ([]UnlinkEvent, error) {
+    if err := s.check(); err != nil {
+        return nil, err
+    }
+    entries, err := s.RedisClient.LRange(ctx, unlinkHistoryKey(userID), 0, -1).Result()
+    if err != nil {
+        return nil, s.wrap(err)
+    }
+    history := make([]UnlinkEvent, 0, len(entries))
+    for _, data := range entries {
+        var event UnlinkEvent
+        if err := json.Unmarshal([]byte(data), &event); err != nil {
+            return nil, fmt.Errorf("error decoding unlink event: %v", err)
+        }
+        history = append(history, event)
+    }
+    return history, nil
+}
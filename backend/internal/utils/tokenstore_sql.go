@@ -0,0 +1,344 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQL dialects supported by SQLTokenStore
+const (
+    DialectPostgres = "postgres"
+    DialectSQLite   = "sqlite"
+)
+
+// Expiry times are stored as Unix milliseconds, NULL meaning none, so that both dialects compare them the same way
+var tokenStoreSchema = []string{
+    `CREATE TABLE IF NOT EXISTS tokens (
+        name TEXT PRIMARY KEY,
+        value TEXT NOT NULL,
+        expires_at BIGINT
+    )`,
+    `CREATE TABLE IF NOT EXISTS token_locks (
+        name TEXT PRIMARY KEY,
+        fence BIGINT NOT NULL,
+        held_by BIGINT,
+        expires_at BIGINT
+    )`,
+    // Accounts are stored as JSON under linkedAccountsKey, and defaults under defaultAccountKey
+    `CREATE TABLE IF NOT EXISTS linked_accounts (
+        name TEXT NOT NULL,
+        account_id TEXT NOT NULL,
+        data TEXT NOT NULL,
+        PRIMARY KEY (name, account_id)
+    )`,
+    `CREATE TABLE IF NOT EXISTS default_accounts (
+        name TEXT PRIMARY KEY,
+        account_id TEXT NOT NULL
+    )`,
+    // Events are stored as JSON under unlinkHistoryKey and ordered by unlinked_at, in Unix nanoseconds
+    `CREATE TABLE IF NOT EXISTS unlink_events (
+        name TEXT NOT NULL,
+        unlinked_at BIGINT NOT NULL,
+        data TEXT NOT NULL
+    )`,
+}
+
+// Keeps tokens in a Postgres or SQLite database. The tables are created by Migrate.
+type SQLTokenStore struct {
+    DB      *sql.DB
+    Dialect string
+}
+
+func NewSQLTokenStore(db *sql.DB, dialect string) (*SQLTokenStore, error) {
+    if dialect != DialectPostgres && dialect != DialectSQLite {
+        return nil, fmt.Errorf("unsupported token store dialect %q", dialect)
+    }
+    return &SQLTokenStore{DB: db, Dialect: dialect}, nil
+}
+
+// Creates the token tables if they do not exist yet
+func (s *SQLTokenStore) Migrate(ctx context.Context) error {
+    for _, statement := range tokenStoreSchema {
+        if _, err := s.DB.ExecContext(ctx, statement); err != nil {
+            return fmt.Errorf("error creating token tables: %w", err)
+        }
+    }
+    return nil
+}
+
+// Rewrites ? placeholders as $1, $2, ... for Postgres
+func (s *SQLTokenStore) rebind(query string) string {
+    if s.Dialect != DialectPostgres {
+        return query
+    }
+    var b strings.Builder
+    n := 0
+    for _, r := range query {
+        if r == '?' {
+            n++
+            b.WriteString("$" + strconv.Itoa(n))
+            continue
+        }
+        b.WriteRune(r)
+    }
+    return b.String()
+}
+
+// Wraps a database error as ErrStorageUnavailable. nil and the caller's own cancellation are returned unchanged.
+func sqlStorageError(err error) error {
+    if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+        return err
+    }
+    return fmt.Errorf("%w: %v", ErrStorageUnavailable, err)
+}
+
+func unixMillis(t time.Time) sql.NullInt64 {
+    if t.IsZero() {
+        return sql.NullInt64{}
+    }
+    return sql.NullInt64{Int64: t.UnixMilli(), Valid: true}
+}
+
+// Shared by Set and SetLocked
+const upsertTokenQuery = `INSERT INTO tokens (name, value, expires_at) VALUES (?, ?, ?)
+    ON CONFLICT (name) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`
+
+func (s *SQLTokenStore) Get(ctx context.Context, name string) (StoredToken, error) {
+    var token StoredToken
+    var expiresAt sql.NullInt64
+    err := s.DB.QueryRowContext(ctx, s.rebind(`SELECT value, expires_at FROM tokens WHERE name = ?`), name).Scan(&token.Value, &expiresAt)
+    if err == sql.ErrNoRows {
+        return StoredToken{}, ErrTokenNotFound
+    } else if err != nil {
+        return StoredToken{}, sqlStorageError(err)
+    }
+    if expiresAt.Valid {
+        token.ExpiresAt = time.UnixMilli(expiresAt.Int64)
+    }
+    if token.ExpiresWithin(0) {
+        return StoredToken{}, ErrTokenNotFound
+    }
+    return token, nil
+}
+
+func (s *SQLTokenStore) Set(ctx context.Context, name string, token StoredToken) error {
+    _, err := s.DB.ExecContext(ctx, s.rebind(upsertTokenQuery), name, token.Value, unixMillis(token.ExpiresAt))
+    return sqlStorageError(err)
+}
+
+func (s *SQLTokenStore) Delete(ctx context.Context, names ...string) error {
+    for _, name := range names {
+        if _, err := s.DB.ExecContext(ctx, s.rebind(`DELETE FROM tokens WHERE name = ?`), name); err != nil {
+            return sqlStorageError(err)
+        }
+    }
+    return nil
+}
+
+// A lock row outlives its holder so that its fence keeps increasing; held_by is NULL while nobody holds it
+func (s *SQLTokenStore) AcquireLock(ctx context.Context, name string, ttl time.Duration) (int64, bool, error) {
+    now := time.Now()
+    var fence int64
+    err := s.DB.QueryRowContext(ctx, s.rebind(`INSERT INTO token_locks (name, fence) VALUES (?, 1)
+        ON CONFLICT (name) DO UPDATE SET fence = token_locks.fence + 1
+        RETURNING fence`), name).Scan(&fence)
+    if err != nil {
+        return 0, false, fmt.Errorf("error generating fencing token: %w", sqlStorageError(err))
+    }
+
+    res, err := s.DB.ExecContext(ctx, s.rebind(`UPDATE token_locks SET held_by = ?, expires_at = ?
+        WHERE name = ? AND (held_by IS NULL OR expires_at <= ?)`),
+        fence, now.Add(ttl).UnixMilli(), name, now.UnixMilli())
+    if err != nil {
+        return 0, false, sqlStorageError(err)
+    }
+    n, err := res.RowsAffected()
+    if err != nil {
+        return 0, false, sqlStorageError(err)
+    }
+    return fence, n > 0, nil
+}
+
+func (s *SQLTokenStore) ReleaseLock(ctx context.Context, name string, fence int64) error {
+    _, err := s.DB.ExecContext(ctx, s.rebind(`UPDATE token_locks SET held_by = NULL, expires_at = NULL WHERE name = ? AND held_by = ?`), name, fence)
+    return sqlStorageError(err)
+}
+
+func (s *SQLTokenStore) LockHeld(ctx context.Context, name string) (bool, error) {
+    var held int
+    err := s.DB.QueryRowContext(ctx, s.rebind(`SELECT COUNT(*) FROM token_locks WHERE name = ? AND held_by IS NOT NULL AND expires_at > ?`),
+        name, time.Now().UnixMilli()).Scan(&held)
+    if err != nil {
+        return false, sqlStorageError(err)
+    }
+    return held > 0, nil
+}
+
+func (s *SQLTokenStore) SetLocked(ctx context.Context, lockName string, fence int64, tokens map[string]StoredToken) error {
+    tx, err := s.DB.BeginTx(ctx, nil)
+    if err != nil {
+        return sqlStorageError(err)
+    }
+    defer tx.Rollback()
+
+    // Touching the lock row locks it until the transaction ends, so that it cannot be taken over in between
+    res, err := tx.ExecContext(ctx, s.rebind(`UPDATE token_locks SET held_by = held_by WHERE name = ? AND held_by = ? AND expires_at > ?`),
+        lockName, fence, time.Now().UnixMilli())
+    if err != nil {
+        return sqlStorageError(err)
+    }
+    if n, err := res.RowsAffected(); err != nil {
+        return sqlStorageError(err)
+    } else if n == 0 {
+        return errRefreshLockLost
+    }
+
+    for name, token := range tokens {
+        if _, err := tx.ExecContext(ctx, s.rebind(upsertTokenQuery), name, token.Value, unixMillis(token.ExpiresAt)); err != nil {
+            return sqlStorageError(err)
+        }
+    }
+    return sqlStorageError(tx.Commit())
+}
+
+// Escapes LIKE metacharacters so that a user ID only ever matches itself
+func escapeLike(s string) string {
+    return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (s *SQLTokenStore) SaveLinkedAccount(ctx context.Context, party, userID string, account LinkedAccount) error {
+    data, err := json.Marshal(account)
+    if err != nil {
+        return err
+    }
+
+    tx, err := s.DB.BeginTx(ctx, nil)
+    if err != nil {
+        return sqlStorageError(err)
+    }
+    defer tx.Rollback()
+
+    _, err = tx.ExecContext(ctx, s.rebind(`INSERT INTO linked_accounts (name, account_id, data) VALUES (?, ?, ?)
+        ON CONFLICT (name, account_id) DO UPDATE SET data = excluded.data`), linkedAccountsKey(party, userID), account.ID, string(data))
+    if err != nil {
+        return sqlStorageError(err)
+    }
+    _, err = tx.ExecContext(ctx, s.rebind(`INSERT INTO default_accounts (name, account_id) VALUES (?, ?)
+        ON CONFLICT (name) DO NOTHING`), defaultAccountKey(party, userID), account.ID)
+    if err != nil {
+        return sqlStorageError(err)
+    }
+    return sqlStorageError(tx.Commit())
+}
+
+func (s *SQLTokenStore) LinkedAccounts(ctx context.Context, party, userID string) ([]LinkedAccount, string, error) {
+    rows, err := s.DB.QueryContext(ctx, s.rebind(`SELECT data FROM linked_accounts WHERE name = ?`), linkedAccountsKey(party, userID))
+    if err != nil {
+        return nil, "", sqlStorageError(err)
+    }
+    defer rows.Close()
+
+    accounts := []LinkedAccount{}
+    for rows.Next() {
+        var data string
+        if err := rows.Scan(&data); err != nil {
+            return nil, "", sqlStorageError(err)
+        }
+        var account LinkedAccount
+        if err := json.Unmarshal([]byte(data), &account); err != nil {
+            return nil, "", fmt.Errorf("error decoding linked %s account: %v", party, err)
+        }
+        accounts = append(accounts, account)
+    }
+    if err := rows.Err(); err != nil {
+        return nil, "", sqlStorageError(err)
+    }
+
+    var defaultID string
+    err = s.DB.QueryRowContext(ctx, s.rebind(`SELECT account_id FROM default_accounts WHERE name = ?`), defaultAccountKey(party, userID)).Scan(&defaultID)
+    if err != nil && err != sql.ErrNoRows {
+        return nil, "", sqlStorageError(err)
+    }
+    return accounts, defaultID, nil
+}
+
+func (s *SQLTokenStore) DeleteLinkedAccount(ctx context.Context, party, userID, accountID string) error {
+    _, err := s.DB.ExecContext(ctx, s.rebind(`DELETE FROM linked_accounts WHERE name = ? AND account_id = ?`), linkedAccountsKey(party, userID), accountID)
+    return sqlStorageError(err)
+}
+
+func (s *SQLTokenStore) SetDefaultAccount(ctx context.Context, party, userID, accountID string) error {
+    if accountID == "" {
+        _, err := s.DB.ExecContext(ctx, s.rebind(`DELETE FROM default_accounts WHERE name = ?`), defaultAccountKey(party, userID))
+        return sqlStorageError(err)
+    }
+    _, err := s.DB.ExecContext(ctx, s.rebind(`INSERT INTO default_accounts (name, account_id) VALUES (?, ?)
+        ON CONFLICT (name) DO UPDATE SET account_id = excluded.account_id`), defaultAccountKey(party, userID), accountID)
+    return sqlStorageError(err)
+}
+
+func (s *SQLTokenStore) RecordUnlink(ctx context.Context, userID string, event UnlinkEvent, keep int) error {
+    data, err := json.Marshal(event)
+    if err != nil {
+        return err
+    }
+
+    tx, err := s.DB.BeginTx(ctx, nil)
+    if err != nil {
+        return sqlStorageError(err)
+    }
+    defer tx.Rollback()
+
+    name := unlinkHistoryKey(userID)
+    _, err = tx.ExecContext(ctx, s.rebind(`INSERT INTO unlink_events (name, unlinked_at, data) VALUES (?, ?, ?)`), name, event.UnlinkedAt.UnixNano(), string(data))
+    if err != nil {
+        return sqlStorageError(err)
+    }
+    _, err = tx.ExecContext(ctx, s.rebind(`DELETE FROM unlink_events WHERE name = ? AND unlinked_at < (
+        SELECT MIN(unlinked_at) FROM (SELECT unlinked_at FROM unlink_events WHERE name = ? ORDER BY unlinked_at DESC LIMIT ?) AS recent
+    )`), name, name, keep)
+    if err != nil {
+        return sqlStorageError(err)
+    }
+    return sqlStorageError(tx.Commit())
+}
+
+func (s *SQLTokenStore) UnlinkHistory(ctx context.Context, userID string) ([]UnlinkEvent, error) {
+    rows, err := s.DB.QueryContext(ctx, s.rebind(`SELECT data FROM unlink_events WHERE name = ? ORDER BY unlinked_at DESC`), unlinkHistoryKey(userID))
+    if err != nil {
+        return nil, sqlStorageError(err)
+    }
+    defer rows.Close()
+
+    history := []UnlinkEvent{}
+    for rows.Next() {
+        var data string
+        if err := rows.Scan(&data); err != nil {
+            return nil, sqlStorageError(err)
+        }
+        var event UnlinkEvent
+        if err := json.Unmarshal([]byte(data), &event); err != nil {
+            return nil, fmt.Errorf("error decoding unlink event: %v", err)
+        }
+        history = append(history, event)
+    }
+    return history, sqlStorageError(rows.Err())
+}
+
+// Deletes every token, lock, linked account and unlink event of a user
+func (s *SQLTokenStore) PurgeUserData(ctx context.Context, userID string) error {
+    escapedID := escapeLike(userID)
+    for _, table := range []string{"tokens", "token_locks", "linked_accounts", "default_accounts", "unlink_events"} {
+        query := fmt.Sprintf(`DELETE FROM %s WHERE name LIKE ? ESCAPE '\' OR name LIKE ? ESCAPE '\'`, table)
+        if _, err := s.DB.ExecContext(ctx, s.rebind(query), "%:"+escapedID, "%:"+escapedID+":%"); err != nil {
+            return sqlStorageError(err)
+        }
+    }
+    return nil
+}
@@ -1,62 +1,79 @@
 package main
 
-// This file contains the seed script for populating the database with fake user data.
-// Currently, the database is not being used, so this script is inactive. 
-// Uncomment and use this script when the database is integrated into the project.
-
-// import (
-// 	"context"
-// 	"log"
-// 	"time"
-
-// 	"github.com/brianvoe/gofakeit/v6"
-// 	"github.com/roblieblang/luthien/backend/internal/config"
-// 	"github.com/roblieblang/luthien/backend/internal/user"
-// 	"github.com/roblieblang/luthien/backend/internal/utils"
-// 	"go.mongodb.org/mongo-driver/bson/primitive"
-// )
-
-// func main() {
-//     // envConfig := utils.LoadENV()
-
-//     // mongoClient := config.DBConnect(envConfig.MongoURI)
-
-// 	// defer func() {
-//         // if err := mongoClient.Disconnect(context.Background()); err != nil {
-//     //         log.Fatalf("Failed to disconnect MongoDB client: %v", err)
-//     //     }
-//     // }()
-
-// 	// dropCtx, dropCancel := context.WithTimeout(context.Background(), 5*time.Second)
-//     // defer dropCancel()
-
-//     // usersCollection := mongoClient.Database(envConfig.DatabaseName).Collection("users")
-
-// 	// if err := usersCollection.Drop(dropCtx); err != nil {
-// 	// 	log.Fatalf("Failed to drop users collection: %v", err)
-// 	// }
-
-// 	// numUsers := 10
-// 	// users := make([]interface{}, numUsers)
-
-// 	// for i := 0; i < numUsers; i++ {
-// 	// 	gofakeit.Seed(0)
-// 	// 	users[i] = user.User {
-// 	// 		ID:        primitive.NewObjectID(),
-// 	// 		Username:  gofakeit.Username(),
-// 	// 		Email:     gofakeit.Email(),
-// 	// 		Password:  gofakeit.Password(true, true, true, false, false, 12),
-// 	// 		FirstName: gofakeit.FirstName(),
-// 	// 		LastName:  gofakeit.LastName(),
-// 	// 		CreatedAt: time.Now(),
-// 	// 		UpdatedAt: time.Now(),
-// 	// 	}
-// 	// }
-
-//     // res, err := usersCollection.InsertMany(dropCtx, users)
-//     // if err != nil {
-//     //     log.Fatalf("Failed to insert users: %v", err)
-//     // }
-
-//     // log.Printf("Database seeded successfully: %v", res)
-// }
\ No newline at end of file
+// Seeds the user store with fake users for local development.
+// Usage: go run ./scripts/seed.go [-n 10] [-drop]
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/roblieblang/luthien/backend/internal/config"
+	"github.com/roblieblang/luthien/backend/internal/user"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+)
+
+var (
+	firstNames = []string{"Ada", "Bilbo", "Celebrimbor", "Doriath", "Elwing", "Finrod", "Galadriel", "Hurin", "Idril", "Luthien"}
+	lastNames  = []string{"Baggins", "Brandybuck", "Gamgee", "Took", "Underhill", "Oakenshield", "Greenleaf", "Elessar"}
+	services   = []string{"spotify", "youtube"}
+)
+
+func main() {
+	numUsers := flag.Int("n", 10, "number of users to create")
+	drop := flag.Bool("drop", false, "drop the users collection first")
+	flag.Parse()
+
+	envConfig := utils.LoadENV()
+	if envConfig.MongoURI == "" {
+		log.Fatalf("MONGO_URI is not set")
+	}
+	mongoClient := config.DBConnect(envConfig.MongoURI)
+	defer func() {
+		if err := mongoClient.Disconnect(context.Background()); err != nil {
+			log.Printf("Failed to disconnect MongoDB client: %v", err)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if *drop {
+		if err := mongoClient.Database(envConfig.DatabaseName).Collection("users").Drop(ctx); err != nil {
+			log.Fatalf("Failed to drop users collection: %v", err)
+		}
+	}
+	userDAO := user.NewDAO(mongoClient, envConfig.DatabaseName, "users")
+	if err := userDAO.EnsureIndexes(ctx); err != nil {
+		log.Fatalf("Failed to create user indexes: %v", err)
+	}
+
+	for i := 0; i < *numUsers; i++ {
+		firstName := firstNames[rand.Intn(len(firstNames))]
+		lastName := lastNames[rand.Intn(len(lastNames))]
+		seeded := &user.User{
+			ID:      fmt.Sprintf("auth0|seed%06d", i),
+			Email:   fmt.Sprintf("%s.%s%d@example.com", strings.ToLower(firstName), strings.ToLower(lastName), i),
+			Name:    firstName + " " + lastName,
+			Picture: fmt.Sprintf("https://i.pravatar.cc/150?u=seed%d", i),
+			AuthenticatedWith: map[string]bool{
+				"spotify": rand.Intn(2) == 0,
+				"google":  rand.Intn(2) == 0,
+			},
+			Preferences: user.Preferences{
+				DefaultSource:      services[i%2],
+				DefaultDestination: services[(i+1)%2],
+				PlaylistVisibility: "private",
+			},
+		}
+		if err := userDAO.UpsertUser(ctx, seeded); err != nil {
+			log.Fatalf("Failed to insert user %s: %v", seeded.ID, err)
+		}
+	}
+
+	log.Printf("Database seeded successfully with %d users", *numUsers)
+}
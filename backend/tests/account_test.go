@@ -0,0 +1,223 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roblieblang/luthien/backend/internal/account"
+	"github.com/roblieblang/luthien/backend/internal/auth/auth0"
+	"github.com/roblieblang/luthien/backend/internal/auth/youtube"
+	"github.com/roblieblang/luthien/backend/internal/middleware"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A fake of Google's revocation endpoint and the Auth0 Management API
+type fakeGoogleAndAuth0 struct {
+	mu           sync.Mutex
+	revokeStatus int
+	revoked      []string
+	metadata     []string
+}
+
+func (f *fakeGoogleAndAuth0) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/revoke":
+		if f.revokeStatus != http.StatusOK {
+			w.WriteHeader(f.revokeStatus)
+			return
+		}
+		f.revoked = append(f.revoked, r.FormValue("token"))
+	case strings.HasPrefix(r.URL.Path, "/api/v2/users/") && r.Method == "PATCH":
+		body, _ := io.ReadAll(r.Body)
+		f.metadata = append(f.metadata, string(body))
+		writeJSON(w, map[string]any{})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newYouTubeServiceForLogout(t *testing.T, upstream *fakeGoogleAndAuth0) (*youtube.YouTubeService, *utils.AppContext) {
+	client := newFakeUpstream(t, upstream.handle)
+	appCtx := &utils.AppContext{
+		EnvConfig: &utils.EnvConfig{Auth0Domain: "auth0.test"},
+		Tokens:    utils.NewMemoryTokenStore(),
+	}
+	// A valid management token keeps Auth0 from being asked for one
+	require.NoError(t, appCtx.Tokens.Set(context.Background(), "auth0ManagementAPIAccessToken", utils.StoredToken{Value: "management", ExpiresAt: time.Now().Add(time.Hour)}))
+
+	auth0Service := auth0.NewAuth0Service(&auth0.Auth0Client{AppContext: appCtx, HTTPClient: client}, appCtx)
+	youTubeClient := &youtube.YouTubeClient{AppContext: appCtx, HTTPClient: client}
+	return youtube.NewYouTubeService(youTubeClient, auth0Service), appCtx
+}
+
+func linkChannel(t *testing.T, appCtx *utils.AppContext, userID, channelID string) {
+	ctx := context.Background()
+	require.NoError(t, utils.SaveLinkedAccount(ctx, *appCtx, userID, "google", utils.LinkedAccount{ID: channelID}))
+	require.NoError(t, utils.SetToken(ctx, utils.SetTokenParams{TokenKind: "refresh", Party: "google", UserID: userID, AccountID: channelID, Token: "refresh-" + channelID, AppCtx: *appCtx}))
+	require.NoError(t, utils.SetToken(ctx, utils.SetTokenParams{TokenKind: "access", Party: "google", UserID: userID, AccountID: channelID, Token: "access-" + channelID, ExpiresIn: 3600, AppCtx: *appCtx}))
+}
+
+func TestYouTubeLogout(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("revokes the grant, clears the tokens and records the unlink", func(t *testing.T) {
+		upstream := &fakeGoogleAndAuth0{revokeStatus: http.StatusOK}
+		service, appCtx := newYouTubeServiceForLogout(t, upstream)
+		linkChannel(t, appCtx, "user1", "channel1")
+
+		require.NoError(t, service.Logout(ctx, "user1", ""))
+
+		// The refresh token is revoked, which invalidates the access token with it
+		assert.Equal(t, []string{"refresh-channel1"}, upstream.revoked)
+		_, err := utils.RetrieveToken(ctx, utils.RetrieveTokenParams{Party: "google", TokenKind: "refresh", UserID: "user1", AccountID: "channel1", AppCtx: *appCtx})
+		assert.Equal(t, utils.ErrTokenNotFound, err)
+		accounts, err := utils.ListLinkedAccounts(ctx, *appCtx, "user1", "google")
+		require.NoError(t, err)
+		assert.Empty(t, accounts)
+		require.Len(t, upstream.metadata, 1)
+		assert.Contains(t, upstream.metadata[0], `"authenticated_with_google":false`)
+
+		history, err := appCtx.Tokens.UnlinkHistory(ctx, "user1")
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+		assert.Equal(t, "google", history[0].Party)
+		assert.True(t, history[0].Revoked)
+	})
+
+	t.Run("keeps the tokens when the grant cannot be revoked", func(t *testing.T) {
+		upstream := &fakeGoogleAndAuth0{revokeStatus: http.StatusServiceUnavailable}
+		service, appCtx := newYouTubeServiceForLogout(t, upstream)
+		linkChannel(t, appCtx, "user1", "channel1")
+
+		require.Error(t, service.Logout(ctx, "user1", "channel1"))
+
+		token, err := utils.RetrieveToken(ctx, utils.RetrieveTokenParams{Party: "google", TokenKind: "refresh", UserID: "user1", AccountID: "channel1", AppCtx: *appCtx})
+		require.NoError(t, err)
+		assert.Equal(t, "refresh-channel1", token)
+		accounts, err := utils.ListLinkedAccounts(ctx, *appCtx, "user1", "google")
+		require.NoError(t, err)
+		assert.Len(t, accounts, 1)
+		history, err := appCtx.Tokens.UnlinkHistory(ctx, "user1")
+		require.NoError(t, err)
+		assert.Empty(t, history)
+	})
+
+	t.Run("does not revoke once the request is cancelled", func(t *testing.T) {
+		upstream := &fakeGoogleAndAuth0{revokeStatus: http.StatusOK}
+		service, appCtx := newYouTubeServiceForLogout(t, upstream)
+		linkChannel(t, appCtx, "user1", "channel1")
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		err := service.YouTubeClient.RevokeToken(cancelled, "refresh-channel1")
+		assert.True(t, errors.Is(err, context.Canceled))
+		_, err = service.YouTubeClient.RequestToken(cancelled, url.Values{"grant_type": {"refresh_token"}})
+		assert.True(t, errors.Is(err, context.Canceled))
+		assert.Empty(t, upstream.revoked)
+	})
+
+	t.Run("unlink history keeps the most recent events", func(t *testing.T) {
+		appCtx := &utils.AppContext{Tokens: utils.NewMemoryTokenStore()}
+		for i := 0; i < 60; i++ {
+			require.NoError(t, utils.RecordUnlink(ctx, *appCtx, "user1", "Spotify", false))
+		}
+		require.NoError(t, utils.RecordUnlink(ctx, *appCtx, "user1", "Google", true))
+
+		history, err := appCtx.Tokens.UnlinkHistory(ctx, "user1")
+		require.NoError(t, err)
+		assert.Len(t, history, 50)
+		assert.Equal(t, utils.UnlinkEvent{Party: "google", Revoked: true, UnlinkedAt: history[0].UnlinkedAt}, history[0])
+		assert.Equal(t, "spotify", history[1].Party)
+	})
+}
+
+type fakeUnlinker struct {
+	err      error
+	unlinked []string
+}
+
+func (u *fakeUnlinker) Logout(ctx context.Context, userID, accountID string) error {
+	u.unlinked = append(u.unlinked, userID)
+	return u.err
+}
+
+type fakePurger struct {
+	purged []string
+}
+
+func (p *fakePurger) PurgeUserData(ctx context.Context, userID string) error {
+	p.purged = append(p.purged, userID)
+	return nil
+}
+
+func TestDeleteMeHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setup := func(unlinkErr error) (*gin.Engine, *fakeUnlinker, *fakePurger) {
+		unlinker := &fakeUnlinker{err: unlinkErr}
+		purger := &fakePurger{}
+		service := account.NewAccountService([]account.ProviderUnlinker{unlinker})
+		service.RegisterPurger(purger)
+
+		router := gin.New()
+		router.Use(middleware.ResolveUser(fakeAPIKeyAuthenticator{}, fakeSessionVerifier{}))
+		router.DELETE("/me", middleware.RequireSession(), account.NewAccountHandler(service).DeleteMeHandler)
+		return router, unlinker, purger
+	}
+	send := func(router *gin.Engine, path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("DELETE", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("deletes the signed-in user's data", func(t *testing.T) {
+		router, unlinker, purger := setup(nil)
+		w := send(router, "/me", "session-user1")
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"user1"}, unlinker.unlinked)
+		assert.Equal(t, []string{"user1"}, purger.purged)
+	})
+
+	t.Run("a user named in the request is not enough", func(t *testing.T) {
+		router, unlinker, purger := setup(nil)
+		w := send(router, "/me?userID=user1", "")
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Empty(t, unlinker.unlinked)
+		assert.Empty(t, purger.purged)
+	})
+
+	t.Run("cannot delete another user", func(t *testing.T) {
+		router, _, purger := setup(nil)
+		w := send(router, "/me?userID=user2", "session-user1")
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Empty(t, purger.purged)
+	})
+
+	t.Run("keeps the data when a provider cannot be unlinked", func(t *testing.T) {
+		router, _, purger := setup(errors.New("revocation failed"))
+		w := send(router, "/me", "session-user1")
+
+		assert.Equal(t, http.StatusBadGateway, w.Code)
+		assert.Empty(t, purger.purged)
+	})
+}
@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roblieblang/luthien/backend/internal/apikey"
+	"github.com/roblieblang/luthien/backend/internal/middleware"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIKeyRoutesRequireSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// None of these requests may reach the key store, which is left without Redis
+	handler := apikey.NewAPIKeyHandler(apikey.NewAPIKeyService(&utils.AppContext{}))
+	router := gin.New()
+	router.Use(middleware.ResolveUser(fakeAPIKeyAuthenticator{}, fakeSessionVerifier{}))
+	routes := router.Group("/api-keys", middleware.RequireSession())
+	routes.POST("", handler.CreateKeyHandler)
+	routes.GET("", handler.ListKeysHandler)
+	routes.DELETE("/:id", handler.RevokeKeyHandler)
+
+	send := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("a user named in the body cannot create keys", func(t *testing.T) {
+		w := send("POST", "/api-keys", "", `{"userId":"user1","name":"cli"}`)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("a user named in the query cannot list or revoke keys", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, send("GET", "/api-keys?userID=user1", "", "").Code)
+		assert.Equal(t, http.StatusUnauthorized, send("DELETE", "/api-keys/key1?userID=user1", "", "").Code)
+	})
+
+	t.Run("an API key cannot mint further keys", func(t *testing.T) {
+		w := send("POST", "/api-keys", "luth_key1_secret", `{"name":"cli"}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("a session cannot create keys for another user", func(t *testing.T) {
+		w := send("POST", "/api-keys", "session-user1", `{"userId":"user2","name":"cli"}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("a session still needs a key name", func(t *testing.T) {
+		w := send("POST", "/api-keys", "session-user1", `{"scopes":["read-playlists"]}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
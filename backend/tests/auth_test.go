@@ -0,0 +1,194 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/roblieblang/luthien/backend/internal/auth/spotify"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Sends every request to the test server whatever host it names, so that clients with hard-coded provider URLs can be pointed at a fake
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (t redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme = t.target.Scheme
+	req.URL.Host = t.target.Host
+	req.Host = t.target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// Starts a fake provider and returns an HTTP client whose requests all reach it
+func newFakeUpstream(t *testing.T, handler http.HandlerFunc) *http.Client {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	target, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	return &http.Client{Transport: redirectTransport{target: target}}
+}
+
+func writeJSON(w http.ResponseWriter, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// Holds every lock for ttl instead of the duration asked for, so that tests can let a refresh outlive its lock
+type shortLockStore struct {
+	*utils.MemoryTokenStore
+	ttl time.Duration
+}
+
+func (s shortLockStore) AcquireLock(ctx context.Context, name string, ttl time.Duration) (int64, bool, error) {
+	return s.MemoryTokenStore.AcquireLock(ctx, name, s.ttl)
+}
+
+// Stores a refresh token and an access token about to expire, so that the next GetValidAccessToken refreshes
+func seedExpiringTokens(t *testing.T, appCtx *utils.AppContext, userID string) {
+	ctx := context.Background()
+	require.NoError(t, utils.SetToken(ctx, utils.SetTokenParams{TokenKind: "access", Party: "Spotify", UserID: userID, Token: "access1", ExpiresIn: 60, AppCtx: *appCtx}))
+	require.NoError(t, utils.SetToken(ctx, utils.SetTokenParams{TokenKind: "refresh", Party: "Spotify", UserID: userID, Token: "refresh1", AppCtx: *appCtx}))
+}
+
+func storedToken(t *testing.T, appCtx *utils.AppContext, kind, userID string) string {
+	token, err := utils.RetrieveToken(context.Background(), utils.RetrieveTokenParams{Party: "Spotify", TokenKind: kind, UserID: userID, AppCtx: *appCtx})
+	require.NoError(t, err)
+	return token
+}
+
+func TestGetValidAccessToken(t *testing.T) {
+	ctx := context.Background()
+	newAppCtx := func(store utils.TokenStore) *utils.AppContext {
+		return &utils.AppContext{
+			EnvConfig: &utils.EnvConfig{SpotifyClientID: "client", SpotifyClientSecret: "secret"},
+			Tokens:    store,
+		}
+	}
+	newParams := func(appCtx *utils.AppContext, client *http.Client, userID string) utils.GetValidAccessTokenParams {
+		return utils.GetValidAccessTokenParams{
+			UserID:  userID,
+			Party:   "Spotify",
+			Service: &spotify.SpotifyClient{AppContext: appCtx, HTTPClient: client},
+			AppCtx:  *appCtx,
+		}
+	}
+
+	t.Run("returns a stored token that is not about to expire", func(t *testing.T) {
+		appCtx := newAppCtx(utils.NewMemoryTokenStore())
+		client := newFakeUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("unexpected token request")
+		})
+		require.NoError(t, utils.SetToken(ctx, utils.SetTokenParams{TokenKind: "access", Party: "Spotify", UserID: "user1", Token: "access1", ExpiresIn: 3600, AppCtx: *appCtx}))
+
+		token, err := utils.GetValidAccessToken(ctx, newParams(appCtx, client, "user1"))
+		require.NoError(t, err)
+		assert.Equal(t, "access1", token)
+	})
+
+	t.Run("concurrent requests share one refresh", func(t *testing.T) {
+		appCtx := newAppCtx(utils.NewMemoryTokenStore())
+		var calls int32
+		client := newFakeUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			assert.Equal(t, "/api/token", r.URL.Path)
+			assert.Equal(t, "refresh_token", r.FormValue("grant_type"))
+			assert.Equal(t, "refresh1", r.FormValue("refresh_token"))
+			// Keeps the refresh in flight while the other requests arrive
+			time.Sleep(50 * time.Millisecond)
+			writeJSON(w, utils.TokenResponse{AccessToken: "access2", ExpiresIn: 3600, RefreshToken: "refresh2"})
+		})
+		seedExpiringTokens(t, appCtx, "user2")
+
+		var wg sync.WaitGroup
+		tokens := make([]string, 10)
+		errs := make([]error, 10)
+		for i := range tokens {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				tokens[i], errs[i] = utils.GetValidAccessToken(ctx, newParams(appCtx, client, "user2"))
+			}(i)
+		}
+		wg.Wait()
+
+		for i := range tokens {
+			require.NoError(t, errs[i])
+			assert.Equal(t, "access2", tokens[i])
+		}
+		// The rotated refresh token must only be redeemed once
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+		assert.Equal(t, "refresh2", storedToken(t, appCtx, "refresh", "user2"))
+	})
+
+	t.Run("reuses the token stored by a refresh in progress elsewhere", func(t *testing.T) {
+		appCtx := newAppCtx(utils.NewMemoryTokenStore())
+		client := newFakeUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("unexpected token request")
+		})
+		seedExpiringTokens(t, appCtx, "user3")
+
+		// Another instance is refreshing
+		lock, err := utils.AcquireRefreshLock(ctx, *appCtx, "Spotify", "user3")
+		require.NoError(t, err)
+		require.NotNil(t, lock)
+
+		result := make(chan string)
+		go func() {
+			token, err := utils.GetValidAccessToken(ctx, newParams(appCtx, client, "user3"))
+			assert.NoError(t, err)
+			result <- token
+		}()
+
+		time.Sleep(50 * time.Millisecond)
+		require.NoError(t, lock.StoreTokens(ctx, utils.TokenResponse{AccessToken: "access2", ExpiresIn: 3600}))
+		lock.Release(ctx)
+
+		assert.Equal(t, "access2", <-result)
+	})
+
+	t.Run("discards the refreshed tokens once the lock was taken over", func(t *testing.T) {
+		appCtx := newAppCtx(shortLockStore{MemoryTokenStore: utils.NewMemoryTokenStore(), ttl: 20 * time.Millisecond})
+		client := newFakeUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			// The refresh outlives its lock and another instance takes over and stores its own tokens
+			time.Sleep(40 * time.Millisecond)
+			other, err := utils.AcquireRefreshLock(ctx, *appCtx, "Spotify", "user4")
+			if assert.NoError(t, err) && assert.NotNil(t, other) {
+				assert.NoError(t, other.StoreTokens(ctx, utils.TokenResponse{AccessToken: "access3", ExpiresIn: 3600, RefreshToken: "refresh3"}))
+			}
+			writeJSON(w, utils.TokenResponse{AccessToken: "access2", ExpiresIn: 3600, RefreshToken: "refresh2"})
+		})
+		seedExpiringTokens(t, appCtx, "user4")
+
+		_, err := utils.GetValidAccessToken(ctx, newParams(appCtx, client, "user4"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "lock lost")
+
+		// The tokens of the newer holder survive
+		assert.Equal(t, "access3", storedToken(t, appCtx, "access", "user4"))
+		assert.Equal(t, "refresh3", storedToken(t, appCtx, "refresh", "user4"))
+	})
+
+	t.Run("keeps the user logged in when the refresh fails upstream", func(t *testing.T) {
+		appCtx := newAppCtx(utils.NewMemoryTokenStore())
+		client := newFakeUpstream(t, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+		seedExpiringTokens(t, appCtx, "user5")
+
+		_, err := utils.GetValidAccessToken(ctx, newParams(appCtx, client, "user5"))
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "reauthentication required")
+		assert.Equal(t, "refresh1", storedToken(t, appCtx, "refresh", "user5"))
+	})
+}
@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/roblieblang/luthien/backend/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Run("opens after consecutive failures and fails fast", func(t *testing.T) {
+		breaker := &utils.CircuitBreaker{Upstream: "Test", Threshold: 3, Cooldown: time.Minute}
+
+		for i := 0; i < 3; i++ {
+			require.NoError(t, breaker.Allow())
+			breaker.Record(false)
+		}
+
+		err := breaker.Allow()
+		var circuitErr *utils.CircuitOpenError
+		require.ErrorAs(t, err, &circuitErr)
+		assert.True(t, errors.Is(err, utils.ErrUpstreamUnavailable))
+		assert.Greater(t, circuitErr.RetryAfter, 59*time.Second)
+		assert.Equal(t, utils.BreakerOpen, breaker.Status().State)
+	})
+
+	t.Run("a success resets the failure count", func(t *testing.T) {
+		breaker := &utils.CircuitBreaker{Upstream: "Test", Threshold: 2, Cooldown: time.Minute}
+
+		breaker.Allow()
+		breaker.Record(false)
+		breaker.Allow()
+		breaker.Record(true)
+		breaker.Allow()
+		breaker.Record(false)
+
+		assert.NoError(t, breaker.Allow())
+		assert.Equal(t, 1, breaker.Status().ConsecutiveFailures)
+	})
+
+	t.Run("lets a single probe through when half-open", func(t *testing.T) {
+		breaker := &utils.CircuitBreaker{Upstream: "Test", Threshold: 1, Cooldown: 10 * time.Millisecond}
+		breaker.Allow()
+		breaker.Record(false)
+
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, breaker.Allow())
+		assert.Equal(t, utils.BreakerHalfOpen, breaker.Status().State)
+		assert.ErrorIs(t, breaker.Allow(), utils.ErrUpstreamUnavailable)
+
+		breaker.Record(true)
+		assert.Equal(t, utils.BreakerClosed, breaker.Status().State)
+		assert.NoError(t, breaker.Allow())
+	})
+
+	t.Run("a failed probe opens the breaker again", func(t *testing.T) {
+		breaker := &utils.CircuitBreaker{Upstream: "Test", Threshold: 1, Cooldown: 10 * time.Millisecond}
+		breaker.Allow()
+		breaker.Record(false)
+
+		time.Sleep(20 * time.Millisecond)
+		require.NoError(t, breaker.Allow())
+		breaker.Record(false)
+
+		assert.Equal(t, utils.BreakerOpen, breaker.Status().State)
+		assert.ErrorIs(t, breaker.Allow(), utils.ErrUpstreamUnavailable)
+	})
+}
+
+func TestHTTPClientCircuitBreaker(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	policy := testRetryPolicy
+	policy.MaxRetries = 0
+	client := utils.NewHTTPClient("BreakerTest", policy)
+	utils.BreakerFor("BreakerTest").Threshold = 2
+
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(server.URL)
+		require.NoError(t, err)
+		res.Body.Close()
+	}
+
+	_, err := client.Get(server.URL)
+	assert.ErrorIs(t, err, utils.ErrUpstreamUnavailable)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	status, ok := utils.UpstreamErrorStatus(err)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+}
@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryCache(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("evicts the least recently used entries", func(t *testing.T) {
+		cache := utils.NewMemoryCache(10)
+		cache.Set(ctx, "a", []byte("aaaa"), 0)
+		cache.Set(ctx, "b", []byte("bbbb"), 0)
+		cache.Get(ctx, "a")
+		cache.Set(ctx, "c", []byte("cccc"), 0)
+
+		_, err := cache.Get(ctx, "b")
+		assert.Equal(t, utils.ErrCacheMiss, err)
+		value, err := cache.Get(ctx, "a")
+		require.NoError(t, err)
+		assert.Equal(t, "aaaa", string(value))
+	})
+
+	t.Run("expires entries after their TTL", func(t *testing.T) {
+		cache := utils.NewMemoryCache(100)
+		cache.Set(ctx, "a", []byte("a"), 10*time.Millisecond)
+
+		time.Sleep(20 * time.Millisecond)
+		_, err := cache.Get(ctx, "a")
+		assert.Equal(t, utils.ErrCacheMiss, err)
+	})
+
+	t.Run("deletes by prefix", func(t *testing.T) {
+		cache := utils.NewMemoryCache(100)
+		cache.Set(ctx, "spotifyCache:user1:a", []byte("1"), 0)
+		cache.Set(ctx, "spotifyCache:user2:a", []byte("2"), 0)
+		cache.DeletePrefix(ctx, "spotifyCache:user1:")
+
+		values, err := cache.GetMany(ctx, []string{"spotifyCache:user1:a", "spotifyCache:user2:a"})
+		require.NoError(t, err)
+		assert.Nil(t, values[0])
+		assert.Equal(t, "2", string(values[1]))
+	})
+}
+
+func TestCacheFallsBackToMemoryWithoutRedis(t *testing.T) {
+	ctx := context.Background()
+	// Nothing listens on port 1
+	redisClient := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer redisClient.Close()
+	storage := utils.NewStorageMonitor(redisClient)
+	cache := utils.NewCache(redisClient, storage)
+
+	require.NoError(t, cache.Set(ctx, "key", []byte("value"), time.Minute))
+	assert.True(t, storage.Degraded())
+	assert.True(t, storage.Status().Degraded)
+
+	value, err := cache.Get(ctx, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "value", string(value))
+
+	appCtx := utils.AppContext{RedisClient: redisClient, Storage: storage}
+	assert.ErrorIs(t, utils.CheckStorage(appCtx), utils.ErrStorageUnavailable)
+}
+
+func TestCacheDeadlineKeepsStorageHealthy(t *testing.T) {
+	redisClient := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer redisClient.Close()
+	storage := utils.NewStorageMonitor(redisClient)
+	cache := utils.NewCache(redisClient, storage)
+
+	// A request that ran out of time says nothing about whether Redis is reachable
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err := cache.Get(expired, "key")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorIs(t, cache.Set(expired, "key", []byte("value"), time.Minute), context.DeadlineExceeded)
+	assert.False(t, storage.Degraded())
+
+	storage.ReportError(context.DeadlineExceeded)
+	storage.ReportError(context.Canceled)
+	assert.False(t, storage.Degraded())
+}
+
+func TestStorageError(t *testing.T) {
+	appCtx := utils.AppContext{}
+
+	assert.NoError(t, utils.StorageError(appCtx, nil))
+	assert.Equal(t, redis.Nil, utils.StorageError(appCtx, redis.Nil))
+	assert.Equal(t, context.Canceled, utils.StorageError(appCtx, context.Canceled))
+
+	err := utils.StorageError(appCtx, errors.New("connection refused"))
+	assert.ErrorIs(t, err, utils.ErrStorageUnavailable)
+	assert.NoError(t, utils.CheckStorage(appCtx))
+}
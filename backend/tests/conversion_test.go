@@ -0,0 +1,1278 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roblieblang/luthien/backend/internal/auth/spotify"
+	"github.com/roblieblang/luthien/backend/internal/conversion"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A provider whose playlists live in memory. Tracks are matched by title.
+type fakeProvider struct {
+	name      string
+	playlists map[string][]conversion.Track
+	catalog   map[string]string // title -> track ID
+	library   []conversion.Track
+	// Saved albums and followed artists by kind, prefixed by "<accountID>:" for any account but the default.
+	// Only the "spotify" fake has albums.
+	collections map[string][]conversion.Track
+	// Details GetPlaylist reports beyond the name, and the cover images uploaded by playlist ID
+	description string
+	imageURL    string
+	images      map[string][]byte
+	searchErr   error
+	searches    int
+	// The account an empty selector picks, and the account the last playlist was deleted from
+	defaultAccount string
+	deletedFrom    string
+	// The searches and writes of each budget check, and the error refusing them
+	budgetChecks [][2]int
+	budgetErr    error
+	created      int
+	added        int
+	// The last playlist created
+	createdPlaylist conversion.NewPlaylist
+	// Playlists as checked for duplicates, by playlist ID
+	entries map[string][]conversion.PlaylistEntry
+}
+
+func newFakeProvider(name string) *fakeProvider {
+	return &fakeProvider{
+		name:        name,
+		playlists:   make(map[string][]conversion.Track),
+		catalog:     make(map[string]string),
+		collections: make(map[string][]conversion.Track),
+		images:      make(map[string][]byte),
+		entries:     make(map[string][]conversion.PlaylistEntry),
+	}
+}
+
+func (p *fakeProvider) ResolveAccountID(ctx context.Context, userID, selector string) (string, error) {
+	if selector == "" {
+		return p.defaultAccount, nil
+	}
+	return selector, nil
+}
+
+func (p *fakeProvider) GetPlaylistTracks(ctx context.Context, userID, accountID, playlistID string) ([]conversion.Track, error) {
+	tracks, ok := p.playlists[playlistID]
+	if !ok {
+		return nil, fmt.Errorf("%w: playlist %s", utils.ErrNotFound, playlistID)
+	}
+	return tracks, nil
+}
+
+func (p *fakeProvider) GetPlaylist(ctx context.Context, userID, accountID, playlistID string) (conversion.PlaylistInfo, error) {
+	tracks, ok := p.playlists[playlistID]
+	if !ok {
+		return conversion.PlaylistInfo{}, fmt.Errorf("%w: playlist %s", utils.ErrNotFound, playlistID)
+	}
+	return conversion.PlaylistInfo{
+		PlaylistID:  playlistID,
+		Name:        "Shared " + playlistID,
+		Description: p.description,
+		TrackCount:  len(tracks),
+		ImageURL:    p.imageURL,
+		URL:         p.PlaylistURL(playlistID),
+	}, nil
+}
+
+func (p *fakeProvider) SetPlaylistImage(ctx context.Context, userID, accountID, playlistID string, jpegData []byte) error {
+	p.images[playlistID] = jpegData
+	return nil
+}
+
+func (p *fakeProvider) MaxPlaylistImageSize() int {
+	return 256 << 10
+}
+
+func (p *fakeProvider) FindTrack(ctx context.Context, userID, accountID string, track conversion.Track) (string, bool, error) {
+	p.searches++
+	if p.searchErr != nil {
+		return "", false, p.searchErr
+	}
+	id, ok := p.catalog[track.Title]
+	return id, ok, nil
+}
+
+func (p *fakeProvider) CheckBatchBudget(ctx context.Context, searches, writes int) error {
+	p.budgetChecks = append(p.budgetChecks, [2]int{searches, writes})
+	return p.budgetErr
+}
+
+func (p *fakeProvider) CreatePlaylist(ctx context.Context, userID, accountID string, playlist conversion.NewPlaylist) (string, error) {
+	p.created++
+	p.createdPlaylist = playlist
+	id := fmt.Sprintf("%s-playlist-%d", p.name, p.created)
+	p.playlists[id] = []conversion.Track{}
+	return id, nil
+}
+
+// Playlist items are numbered in the order they were added
+func (p *fakeProvider) AddTracks(ctx context.Context, userID, accountID, playlistID string, trackIDs []string, position *int) ([]string, error) {
+	at := len(p.playlists[playlistID])
+	if position != nil {
+		at = *position
+	}
+	itemIDs := []string{}
+	added := []conversion.Track{}
+	for _, id := range trackIDs {
+		p.added++
+		itemID := fmt.Sprintf("item-%d", p.added)
+		itemIDs = append(itemIDs, itemID)
+		added = append(added, conversion.Track{ID: id, Album: itemID})
+	}
+	tracks := p.playlists[playlistID]
+	p.playlists[playlistID] = append(append(append([]conversion.Track{}, tracks[:at]...), added...), tracks[at:]...)
+	return itemIDs, nil
+}
+
+// Removes the items with the given IDs, which AddTracks stores as the album of the track
+func (p *fakeProvider) RemoveItems(ctx context.Context, userID, accountID, playlistID string, itemIDs []string) error {
+	removed := make(map[string]bool)
+	for _, itemID := range itemIDs {
+		removed[itemID] = true
+	}
+	kept := []conversion.Track{}
+	for _, track := range p.playlists[playlistID] {
+		if !removed[track.Album] {
+			kept = append(kept, track)
+		}
+	}
+	p.playlists[playlistID] = kept
+	return nil
+}
+
+func (p *fakeProvider) DeletePlaylist(ctx context.Context, userID, accountID, playlistID string) error {
+	p.deletedFrom = accountID
+	delete(p.playlists, playlistID)
+	return nil
+}
+
+func (p *fakeProvider) PlaylistURL(playlistID string) string {
+	return "https://" + p.name + ".example/" + playlistID
+}
+
+func (p *fakeProvider) GetLibraryTracks(ctx context.Context, userID, accountID string) ([]conversion.Track, error) {
+	return p.library, nil
+}
+
+func (p *fakeProvider) SaveTracks(ctx context.Context, userID, accountID string, trackIDs []string) ([]string, error) {
+	for _, id := range trackIDs {
+		p.library = append([]conversion.Track{{ID: id}}, p.library...)
+	}
+	return trackIDs, nil
+}
+
+func (p *fakeProvider) UnsaveTracks(ctx context.Context, userID, accountID string, trackIDs []string) error {
+	removed := make(map[string]bool)
+	for _, id := range trackIDs {
+		removed[id] = true
+	}
+	kept := []conversion.Track{}
+	for _, track := range p.library {
+		if !removed[track.ID] {
+			kept = append(kept, track)
+		}
+	}
+	p.library = kept
+	return nil
+}
+
+func (p *fakeProvider) LibraryURL() string {
+	return "https://" + p.name + ".example/library"
+}
+
+func (p *fakeProvider) SupportsCollection(kind string) bool {
+	return kind == conversion.KindArtists || p.name == "spotify"
+}
+
+func collectionKey(accountID, kind string) string {
+	if accountID == "" {
+		return kind
+	}
+	return accountID + ":" + kind
+}
+
+func (p *fakeProvider) GetCollection(ctx context.Context, userID, accountID, kind string) ([]conversion.Track, error) {
+	return p.collections[collectionKey(accountID, kind)], nil
+}
+
+func (p *fakeProvider) FindItem(ctx context.Context, userID, accountID, kind string, item conversion.Track) (string, bool, error) {
+	p.searches++
+	id, ok := p.catalog[item.Title]
+	return id, ok, nil
+}
+
+func (p *fakeProvider) AddToCollection(ctx context.Context, userID, accountID, kind string, itemIDs []string) ([]string, error) {
+	key := collectionKey(accountID, kind)
+	for _, id := range itemIDs {
+		p.collections[key] = append(p.collections[key], conversion.Track{ID: id})
+	}
+	return itemIDs, nil
+}
+
+func (p *fakeProvider) RemoveFromCollection(ctx context.Context, userID, accountID, kind string, itemIDs []string) error {
+	removed := make(map[string]bool)
+	for _, id := range itemIDs {
+		removed[id] = true
+	}
+	key := collectionKey(accountID, kind)
+	kept := []conversion.Track{}
+	for _, item := range p.collections[key] {
+		if !removed[item.ID] {
+			kept = append(kept, item)
+		}
+	}
+	p.collections[key] = kept
+	return nil
+}
+
+func (p *fakeProvider) CollectionURL(kind string) string {
+	return "https://" + p.name + ".example/" + kind
+}
+
+func (p *fakeProvider) GetPlaylistEntries(ctx context.Context, userID, accountID, playlistID string) ([]conversion.PlaylistEntry, string, error) {
+	entries, ok := p.entries[playlistID]
+	if !ok {
+		return nil, "", fmt.Errorf("%w: playlist %s", utils.ErrNotFound, playlistID)
+	}
+	return append([]conversion.PlaylistEntry{}, entries...), "", nil
+}
+
+// Removes the entries at the given positions and renumbers the rest
+func (p *fakeProvider) RemoveEntries(ctx context.Context, userID, accountID, playlistID, snapshotID string, remove []conversion.PlaylistEntry) error {
+	removed := make(map[int]bool)
+	for _, entry := range remove {
+		removed[entry.Position] = true
+	}
+	kept := []conversion.PlaylistEntry{}
+	for _, entry := range p.entries[playlistID] {
+		if !removed[entry.Position] {
+			entry.Position = len(kept)
+			kept = append(kept, entry)
+		}
+	}
+	p.entries[playlistID] = kept
+	return nil
+}
+
+func newConversionService() (*conversion.ConversionService, *fakeProvider, *fakeProvider) {
+	spotify := newFakeProvider("spotify")
+	youTube := newFakeProvider("youtube")
+	service := conversion.NewConversionService(conversion.NewMemoryHistoryStore(), map[string]conversion.Provider{
+		"spotify": spotify,
+		"youtube": youTube,
+	})
+	return service, spotify, youTube
+}
+
+func convertRequest(userID string) conversion.ConvertRequest {
+	return conversion.ConvertRequest{
+		UserID:      userID,
+		Source:      conversion.SourceRequest{Provider: "spotify", PlaylistID: "workout", Name: "Workout"},
+		Destination: conversion.DestinationRequest{Provider: "youtube"},
+	}
+}
+
+func TestConvert(t *testing.T) {
+	t.Run("creates the playlist and records the outcome", func(t *testing.T) {
+		service, spotify, youTube := newConversionService()
+		spotify.playlists["workout"] = []conversion.Track{{ID: "spotify:track:1", Title: "Song A"}, {ID: "spotify:track:2", Title: "Song B"}}
+		youTube.catalog["Song A"] = "video-a"
+
+		result, err := service.Convert(context.Background(), convertRequest("auth0|1"))
+		require.NoError(t, err)
+		assert.Equal(t, conversion.StatusCompleted, result.Status)
+		assert.Equal(t, conversion.TriggerSession, result.TriggeredBy)
+		assert.Equal(t, 1, result.Matched)
+		assert.Equal(t, 1, result.Unmatched)
+		assert.Equal(t, "Song B", result.UnmatchedTracks[0].Title)
+		assert.Equal(t, "Workout", result.Destination.Name)
+		assert.Equal(t, "https://youtube.example/youtube-playlist-1", result.Destination.URL)
+		assert.Equal(t, "video-a", youTube.playlists["youtube-playlist-1"][0].ID)
+
+		stored, err := service.GetConversion(context.Background(), "auth0|1", result.ID)
+		require.NoError(t, err)
+		assert.Equal(t, conversion.StatusCompleted, stored.Status)
+		assert.Equal(t, "youtube-playlist-1", stored.Destination.PlaylistID)
+	})
+
+	t.Run("records a failed search without creating a playlist", func(t *testing.T) {
+		service, spotify, youTube := newConversionService()
+		spotify.playlists["workout"] = []conversion.Track{{ID: "spotify:track:1", Title: "Song A"}}
+		youTube.searchErr = fmt.Errorf("%w: quota exceeded", utils.ErrRateLimited)
+
+		result, err := service.Convert(context.Background(), convertRequest("auth0|1"))
+		assert.True(t, errors.Is(err, utils.ErrRateLimited))
+		require.NotNil(t, result)
+		assert.Equal(t, conversion.StatusFailed, result.Status)
+		assert.Contains(t, result.Error, "quota exceeded")
+		assert.Equal(t, 0, youTube.created)
+
+		stored, err := service.GetConversion(context.Background(), "auth0|1", result.ID)
+		require.NoError(t, err)
+		assert.Equal(t, conversion.StatusFailed, stored.Status)
+	})
+
+	t.Run("refuses a conversion the quota cannot cover before searching", func(t *testing.T) {
+		service, spotify, youTube := newConversionService()
+		spotify.playlists["workout"] = []conversion.Track{{ID: "spotify:track:1", Title: "Song A"}, {ID: "spotify:track:2", Title: "Song B"}}
+		youTube.catalog["Song A"] = "video-a"
+		youTube.budgetErr = errors.New("YouTube API quota exceeded")
+
+		result, err := service.Convert(context.Background(), convertRequest("auth0|1"))
+		require.Error(t, err)
+		assert.Equal(t, conversion.StatusFailed, result.Status)
+		// Both tracks need a search, and the playlist and both tracks a write
+		assert.Equal(t, [][2]int{{2, 3}}, youTube.budgetChecks)
+		assert.Equal(t, 0, youTube.searches)
+		assert.Equal(t, 0, youTube.created)
+	})
+
+	t.Run("adds only new tracks to an existing playlist", func(t *testing.T) {
+		service, spotify, youTube := newConversionService()
+		spotify.playlists["workout"] = []conversion.Track{
+			{ID: "spotify:track:1", Title: "Song A"},
+			{ID: "spotify:track:2", Title: "Song B", ISRC: "USRC1"},
+			{ID: "spotify:track:3", Title: "Song C"},
+			{ID: "spotify:track:4", Title: "Song C (Remastered)"},
+		}
+		youTube.catalog = map[string]string{"Song A": "video-a", "Song C": "video-c", "Song C (Remastered)": "video-c"}
+		youTube.playlists["gym"] = []conversion.Track{{ID: "video-a"}, {ID: "video-b", ISRC: "USRC1"}, {ID: "video-z"}}
+		position := 1
+
+		req := convertRequest("auth0|1")
+		req.Destination = conversion.DestinationRequest{Provider: "youtube", Mode: conversion.ModeExisting, PlaylistID: "gym", Position: &position}
+		result, err := service.Convert(context.Background(), req)
+		require.NoError(t, err)
+		assert.False(t, result.CreatedPlaylist)
+		assert.Equal(t, 4, result.Matched)
+		assert.Equal(t, 3, result.Skipped)
+		// Song B is known to be present by its ISRC and is not searched for
+		assert.Equal(t, 3, youTube.searches)
+		assert.Equal(t, 0, youTube.created)
+
+		ids := []string{}
+		for _, track := range youTube.playlists["gym"] {
+			ids = append(ids, track.ID)
+		}
+		assert.Equal(t, []string{"video-a", "video-c", "video-b", "video-z"}, ids)
+	})
+
+	t.Run("rejects an existing target without a playlist", func(t *testing.T) {
+		service, _, _ := newConversionService()
+		req := convertRequest("auth0|1")
+		req.Destination.Mode = conversion.ModeExisting
+
+		_, err := service.Convert(context.Background(), req)
+		assert.True(t, errors.Is(err, conversion.ErrInvalidConversion))
+	})
+
+	t.Run("rejects converting within one provider", func(t *testing.T) {
+		service, _, _ := newConversionService()
+		req := convertRequest("auth0|1")
+		req.Destination.Provider = "spotify"
+
+		_, err := service.Convert(context.Background(), req)
+		assert.True(t, errors.Is(err, conversion.ErrInvalidConversion))
+	})
+}
+
+func setupConversionRouter(service *conversion.ConversionService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	handler := conversion.NewConversionHandler(service)
+	router := gin.New()
+	router.POST("/conversions", handler.ConvertHandler)
+	router.GET("/conversions", handler.ListConversionsHandler)
+	router.GET("/conversions/resolve", handler.ResolvePlaylistHandler)
+	router.GET("/conversions/duplicates", handler.FindDuplicatesHandler)
+	router.POST("/conversions/duplicates/remove", handler.RemoveDuplicatesHandler)
+	router.GET("/conversions/:id", handler.GetConversionHandler)
+	router.POST("/conversions/:id/undo", handler.UndoConversionHandler)
+	return router
+}
+
+func TestConversionHistoryHandlers(t *testing.T) {
+	service, spotify, youTube := newConversionService()
+	spotify.playlists["workout"] = []conversion.Track{{ID: "spotify:track:1", Title: "Song A"}}
+	youTube.playlists["gym"] = []conversion.Track{{ID: "video-a", Title: "Song A"}}
+	youTube.catalog["Song A"] = "video-a"
+	spotify.catalog["Song A"] = "spotify:track:1"
+	router := setupConversionRouter(service)
+
+	for _, body := range []string{
+		`{"userId":"auth0|1","source":{"provider":"spotify","playlistId":"workout","name":"Workout"},"destination":{"provider":"youtube"}}`,
+		`{"userId":"auth0|1","source":{"provider":"youtube","playlistId":"gym"},"destination":{"provider":"spotify","name":"Gym"}}`,
+		`{"userId":"auth0|1","source":{"provider":"youtube","playlistId":"missing"},"destination":{"provider":"spotify","name":"Missing"}}`,
+		`{"userId":"auth0|2","source":{"provider":"spotify","playlistId":"workout","name":"Workout"},"destination":{"provider":"youtube"}}`,
+	} {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/conversions", strings.NewReader(body))
+		router.ServeHTTP(w, req)
+		// Makes the start times of the conversions distinct
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Run("lists the user's conversions newest first", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/conversions?userID=auth0|1&limit=2", nil)
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var page struct {
+			Conversions []conversion.Conversion `json:"conversions"`
+			Total       int                     `json:"total"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		assert.Equal(t, 3, page.Total)
+		require.Len(t, page.Conversions, 2)
+		assert.Equal(t, conversion.StatusFailed, page.Conversions[0].Status)
+		assert.Equal(t, "Gym", page.Conversions[1].Destination.Name)
+	})
+
+	t.Run("filters by provider and status", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/conversions?userID=auth0|1&source=youtube&status=completed", nil)
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		var page struct {
+			Conversions []conversion.Conversion `json:"conversions"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &page))
+		require.Len(t, page.Conversions, 1)
+		assert.Equal(t, "Gym", page.Conversions[0].Destination.Name)
+
+		id := page.Conversions[0].ID
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/conversions/"+id+"?userID=auth0|1", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		// Another user's conversion is not found
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/conversions/"+id+"?userID=auth0|2", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("rejects an invalid time range", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/conversions?userID=auth0|1&since=yesterday", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
+func TestUndoConversion(t *testing.T) {
+	service, spotify, youTube := newConversionService()
+	spotify.playlists["workout"] = []conversion.Track{{ID: "spotify:track:1", Title: "Song A"}}
+	youTube.catalog["Song A"] = "video-a"
+	router := setupConversionRouter(service)
+
+	converted, err := service.Convert(context.Background(), convertRequest("auth0|1"))
+	require.NoError(t, err)
+	assert.True(t, converted.CreatedPlaylist)
+	assert.Equal(t, []string{"item-1"}, converted.AddedItems)
+
+	t.Run("deletes the created playlist", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/conversions/"+converted.ID+"/undo?userID=auth0|1", nil)
+		router.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"status":"undone"`)
+		assert.NotContains(t, youTube.playlists, "youtube-playlist-1")
+	})
+
+	t.Run("refuses to undo twice", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/conversions/"+converted.ID+"/undo?userID=auth0|1", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusConflict, w.Code)
+	})
+
+	t.Run("removes only the added items from an existing playlist", func(t *testing.T) {
+		youTube.playlists["gym"] = []conversion.Track{{ID: "video-z"}}
+		itemIDs, err := youTube.AddTracks(context.Background(), "auth0|1", "", "gym", []string{"video-a"}, nil)
+		require.NoError(t, err)
+		appended := &conversion.Conversion{
+			ID:          "appended",
+			UserID:      "auth0|1",
+			Status:      conversion.StatusCompleted,
+			Destination: conversion.PlaylistRef{Provider: "youtube", PlaylistID: "gym"},
+			AddedItems:  itemIDs,
+		}
+		require.NoError(t, service.Store.Create(context.Background(), appended))
+
+		_, err = service.UndoConversion(context.Background(), "auth0|1", "appended")
+		require.NoError(t, err)
+		assert.Equal(t, []conversion.Track{{ID: "video-z"}}, youTube.playlists["gym"])
+	})
+
+	t.Run("undoes on the account that was the default at the time", func(t *testing.T) {
+		youTube.defaultAccount = "channel-1"
+		converted, err := service.Convert(context.Background(), convertRequest("auth0|1"))
+		require.NoError(t, err)
+		assert.Equal(t, "channel-1", converted.Destination.AccountID)
+
+		youTube.defaultAccount = "channel-2"
+		_, err = service.UndoConversion(context.Background(), "auth0|1", converted.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "channel-1", youTube.deletedFrom)
+	})
+
+	t.Run("answers 404 for another user's conversion", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/conversions/"+converted.ID+"/undo?userID=auth0|2", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestMerge(t *testing.T) {
+	service, spotify, youTube := newConversionService()
+	spotify.playlists["workout"] = []conversion.Track{
+		{ID: "spotify:track:1", Title: "Song A", Artist: "Band", ISRC: "USRC1"},
+		{ID: "spotify:track:2", Title: "Song B", Artist: "Band"},
+	}
+	spotify.playlists["run"] = []conversion.Track{
+		{ID: "spotify:track:3", Title: "Song A (Remastered)", Artist: "Band", ISRC: "USRC2"},
+		{ID: "spotify:track:4", Title: "Song C", Artist: "Band"},
+	}
+	youTube.playlists["gym"] = []conversion.Track{{ID: "video-b", Title: "Band - Song B"}, {ID: "video-d", Title: "Song D"}}
+	youTube.catalog = map[string]string{"Song A": "video-a", "Song C": "video-c"}
+
+	result, err := service.Merge(context.Background(), conversion.MergeRequest{
+		UserID: "auth0|1",
+		Sources: []conversion.SourceRequest{
+			{Provider: "spotify", PlaylistID: "workout"},
+			{Provider: "spotify", PlaylistID: "run"},
+			{Provider: "youtube", PlaylistID: "gym"},
+		},
+		Destination: conversion.DestinationRequest{Provider: "youtube", Name: "Everything"},
+		Order:       conversion.OrderInterleave,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, conversion.StatusCompleted, result.Status)
+	assert.Len(t, result.Sources, 3)
+	assert.Equal(t, 6, result.Matched)
+	assert.Equal(t, 2, result.Skipped)
+	// Tracks read from YouTube are written as they are
+	assert.Equal(t, 2, youTube.searches)
+
+	ids := []string{}
+	for _, track := range youTube.playlists[result.Destination.PlaylistID] {
+		ids = append(ids, track.ID)
+	}
+	assert.Equal(t, []string{"video-a", "video-b", "video-c", "video-d"}, ids)
+
+	_, err = service.Merge(context.Background(), conversion.MergeRequest{
+		UserID:      "auth0|1",
+		Sources:     []conversion.SourceRequest{{Provider: "spotify", PlaylistID: "workout"}},
+		Destination: conversion.DestinationRequest{Provider: "youtube", Name: "Everything"},
+		Order:       "alphabetical",
+	})
+	assert.True(t, errors.Is(err, conversion.ErrInvalidConversion))
+}
+
+func TestTrackKey(t *testing.T) {
+	assert.Equal(t, conversion.TrackKey("Back In Black", "AC/DC"), conversion.TrackKey("AC/DC - Back in Black [Official Video]", ""))
+	assert.Equal(t, conversion.TrackKey("Song (Remastered 2011)", "Band"), conversion.TrackKey("Song", "Band"))
+	assert.Equal(t, "live", conversion.TrackKey("(Live)", ""))
+}
+
+func TestConvertLibrary(t *testing.T) {
+	t.Run("likes the tracks not already liked and undoes the likes", func(t *testing.T) {
+		service, spotify, youTube := newConversionService()
+		spotify.library = []conversion.Track{{ID: "spotify:track:1", Title: "Song A"}, {ID: "spotify:track:2", Title: "Song B"}}
+		youTube.catalog = map[string]string{"Song A": "video-a", "Song B": "video-b"}
+		youTube.library = []conversion.Track{{ID: "video-b"}}
+
+		result, err := service.Convert(context.Background(), conversion.ConvertRequest{
+			UserID:      "auth0|1",
+			Source:      conversion.SourceRequest{Provider: "spotify", Library: true},
+			Destination: conversion.DestinationRequest{Provider: "youtube", Mode: conversion.ModeLibrary},
+		})
+		require.NoError(t, err)
+		assert.True(t, result.Destination.Library)
+		assert.Equal(t, 1, result.Skipped)
+		assert.Equal(t, []string{"video-a"}, result.AddedItems)
+		assert.Len(t, youTube.library, 2)
+
+		_, err = service.UndoConversion(context.Background(), "auth0|1", result.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []conversion.Track{{ID: "video-b"}}, youTube.library)
+	})
+
+	t.Run("copies a library into a playlist of the same provider", func(t *testing.T) {
+		service, spotify, _ := newConversionService()
+		spotify.library = []conversion.Track{{ID: "spotify:track:1", Title: "Song A"}}
+
+		result, err := service.Convert(context.Background(), conversion.ConvertRequest{
+			UserID:      "auth0|1",
+			Source:      conversion.SourceRequest{Provider: "spotify", Library: true},
+			Destination: conversion.DestinationRequest{Provider: "spotify", Name: "Liked Songs copy"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 0, spotify.searches)
+		assert.Equal(t, "spotify:track:1", spotify.playlists[result.Destination.PlaylistID][0].ID)
+	})
+}
+
+func TestTransfer(t *testing.T) {
+	t.Run("follows the matched artists not already followed and undoes the follows", func(t *testing.T) {
+		service, spotify, youTube := newConversionService()
+		spotify.collections[conversion.KindArtists] = []conversion.Track{
+			{ID: "spotify:artist:1", Title: "Artist A"},
+			{ID: "spotify:artist:2", Title: "Artist B"},
+			{ID: "spotify:artist:3", Title: "Artist C"},
+		}
+		youTube.catalog = map[string]string{"Artist A": "channel-a"}
+		youTube.collections[conversion.KindArtists] = []conversion.Track{{ID: "channel-b", Title: "Artist B"}}
+
+		result, err := service.Transfer(context.Background(), conversion.TransferRequest{
+			UserID:      "auth0|1",
+			Kind:        conversion.KindArtists,
+			Source:      conversion.CollectionRequest{Provider: "spotify"},
+			Destination: conversion.CollectionRequest{Provider: "youtube"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, conversion.KindArtists, result.Kind)
+		assert.Equal(t, 2, result.Matched)
+		assert.Equal(t, 1, result.Skipped)
+		assert.Equal(t, "Artist C", result.UnmatchedTracks[0].Title)
+		assert.Equal(t, []string{"channel-a"}, result.AddedItems)
+		assert.Equal(t, "https://youtube.example/artists", result.Destination.URL)
+		// The artist already followed is recognized by name without a search
+		assert.Equal(t, 2, youTube.searches)
+
+		_, err = service.UndoConversion(context.Background(), "auth0|1", result.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []conversion.Track{{ID: "channel-b", Title: "Artist B"}}, youTube.collections[conversion.KindArtists])
+	})
+
+	t.Run("refuses a transfer the quota cannot cover before searching", func(t *testing.T) {
+		service, spotify, youTube := newConversionService()
+		spotify.collections[conversion.KindArtists] = []conversion.Track{
+			{ID: "spotify:artist:1", Title: "Artist A"},
+			{ID: "spotify:artist:2", Title: "Artist B"},
+			{ID: "spotify:artist:3", Title: "Artist C"},
+		}
+		youTube.collections[conversion.KindArtists] = []conversion.Track{{ID: "channel-b", Title: "Artist B"}}
+		youTube.budgetErr = errors.New("YouTube API quota exceeded")
+
+		result, err := service.Transfer(context.Background(), conversion.TransferRequest{
+			UserID:      "auth0|1",
+			Kind:        conversion.KindArtists,
+			Source:      conversion.CollectionRequest{Provider: "spotify"},
+			Destination: conversion.CollectionRequest{Provider: "youtube"},
+		})
+		require.Error(t, err)
+		assert.Equal(t, conversion.StatusFailed, result.Status)
+		// The artist already followed needs neither a search nor a write
+		assert.Equal(t, [][2]int{{2, 2}}, youTube.budgetChecks)
+		assert.Equal(t, 0, youTube.searches)
+	})
+
+	t.Run("copies albums between accounts of one provider without searching", func(t *testing.T) {
+		service, spotify, _ := newConversionService()
+		spotify.collections["old:"+conversion.KindAlbums] = []conversion.Track{{ID: "spotify:album:1", Title: "Album A", UPC: "0001"}}
+
+		result, err := service.Transfer(context.Background(), conversion.TransferRequest{
+			UserID:      "auth0|1",
+			Kind:        conversion.KindAlbums,
+			Source:      conversion.CollectionRequest{Provider: "spotify", AccountID: "old"},
+			Destination: conversion.CollectionRequest{Provider: "spotify", AccountID: "new"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 0, spotify.searches)
+		assert.Equal(t, []string{"spotify:album:1"}, result.AddedItems)
+		assert.Len(t, spotify.collections["new:"+conversion.KindAlbums], 1)
+	})
+
+	t.Run("rejects albums on YouTube and transfers within one account", func(t *testing.T) {
+		service, _, _ := newConversionService()
+
+		_, err := service.Transfer(context.Background(), conversion.TransferRequest{
+			UserID:      "auth0|1",
+			Kind:        conversion.KindAlbums,
+			Source:      conversion.CollectionRequest{Provider: "spotify"},
+			Destination: conversion.CollectionRequest{Provider: "youtube"},
+		})
+		assert.True(t, errors.Is(err, conversion.ErrInvalidConversion))
+
+		_, err = service.Transfer(context.Background(), conversion.TransferRequest{
+			UserID:      "auth0|1",
+			Kind:        conversion.KindArtists,
+			Source:      conversion.CollectionRequest{Provider: "spotify"},
+			Destination: conversion.CollectionRequest{Provider: "spotify"},
+		})
+		assert.True(t, errors.Is(err, conversion.ErrInvalidConversion))
+	})
+}
+
+func TestParsePlaylistURL(t *testing.T) {
+	for _, tc := range []struct {
+		url, provider, id string
+	}{
+		{"https://open.spotify.com/playlist/37i9dQZF1DXcBWIGoYBM5M?si=abc", "spotify", "37i9dQZF1DXcBWIGoYBM5M"},
+		{"open.spotify.com/intl-de/playlist/37i9dQZF1DXcBWIGoYBM5M", "spotify", "37i9dQZF1DXcBWIGoYBM5M"},
+		{"spotify:playlist:37i9dQZF1DXcBWIGoYBM5M", "spotify", "37i9dQZF1DXcBWIGoYBM5M"},
+		{"spotify:user:someone:playlist:37i9dQZF1DXcBWIGoYBM5M", "spotify", "37i9dQZF1DXcBWIGoYBM5M"},
+		{"https://www.youtube.com/playlist?list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI", "youtube", "PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI"},
+		{"https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI", "youtube", "PLFgquLnL59alCl_2TQvOiD5Vgm1hCaGSI"},
+		{"https://music.youtube.com/playlist?list=OLAK5uy_kRrpI0Sp6sCrdFDgSE6vB4Y9fQqpEIM5E", "youtube", "OLAK5uy_kRrpI0Sp6sCrdFDgSE6vB4Y9fQqpEIM5E"},
+	} {
+		provider, id, err := conversion.ParsePlaylistURL(tc.url)
+		require.NoError(t, err, tc.url)
+		assert.Equal(t, tc.provider, provider, tc.url)
+		assert.Equal(t, tc.id, id, tc.url)
+	}
+
+	for _, url := range []string{
+		"",
+		"https://open.spotify.com/album/37i9dQZF1DXcBWIGoYBM5M",
+		"https://open.spotify.com/playlist/short",
+		"spotify:track:37i9dQZF1DXcBWIGoYBM5M",
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+		"https://www.youtube.com/watch?v=dQw4w9WgXcQ&list=RDdQw4w9WgXcQ",
+		"https://www.youtube.com/playlist?list=PL<script>alert(1)",
+		"https://soundcloud.com/someone/sets/playlist",
+	} {
+		_, _, err := conversion.ParsePlaylistURL(url)
+		assert.True(t, errors.Is(err, conversion.ErrInvalidPlaylistURL), url)
+	}
+}
+
+func TestConvertByURL(t *testing.T) {
+	const playlistURL = "https://open.spotify.com/playlist/37i9dQZF1DXcBWIGoYBM5M"
+
+	t.Run("converts a playlist by its link, named after it", func(t *testing.T) {
+		service, spotify, youTube := newConversionService()
+		spotify.playlists["37i9dQZF1DXcBWIGoYBM5M"] = []conversion.Track{{ID: "spotify:track:1", Title: "Song A"}}
+		youTube.catalog["Song A"] = "video-a"
+
+		result, err := service.Convert(context.Background(), conversion.ConvertRequest{
+			UserID:      "auth0|1",
+			Source:      conversion.SourceRequest{URL: playlistURL},
+			Destination: conversion.DestinationRequest{Provider: "youtube"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "spotify", result.Source.Provider)
+		assert.Equal(t, "37i9dQZF1DXcBWIGoYBM5M", result.Source.PlaylistID)
+		assert.Equal(t, "Shared 37i9dQZF1DXcBWIGoYBM5M", result.Destination.Name)
+		assert.Equal(t, 1, result.Matched)
+	})
+
+	t.Run("reports private or missing playlists", func(t *testing.T) {
+		service, _, _ := newConversionService()
+		router := setupConversionRouter(service)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/conversions/resolve?userID=auth0|1&url="+playlistURL, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), "playlist_unavailable")
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/conversions/resolve?userID=auth0|1&url=https://example.com/playlist", nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid_playlist_url")
+	})
+
+	t.Run("resolves a visible playlist", func(t *testing.T) {
+		service, spotify, _ := newConversionService()
+		spotify.playlists["37i9dQZF1DXcBWIGoYBM5M"] = []conversion.Track{{ID: "spotify:track:1", Title: "Song A"}}
+		router := setupConversionRouter(service)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/conversions/resolve?userID=auth0|1&url="+playlistURL, nil)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var info conversion.PlaylistInfo
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &info))
+		assert.Equal(t, "spotify", info.Provider)
+		assert.Equal(t, 1, info.TrackCount)
+	})
+}
+
+// Returns an image of random noise, which compresses poorly
+func noiseImage(width, height int) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	seed := uint32(1)
+	for i := range img.Pix {
+		seed = seed*1664525 + 1013904223
+		img.Pix[i] = uint8(seed >> 24)
+	}
+	return img
+}
+
+func TestEncodeCoverArt(t *testing.T) {
+	const maxSize = 256 << 10
+
+	jpegData, err := conversion.EncodeCoverArt(noiseImage(1280, 720), maxSize)
+	require.NoError(t, err)
+	assert.LessOrEqual(t, len(jpegData)*4/3, maxSize)
+
+	decoded, err := jpeg.Decode(strings.NewReader(string(jpegData)))
+	require.NoError(t, err)
+	bounds := decoded.Bounds()
+	assert.Equal(t, bounds.Dx(), bounds.Dy())
+	assert.LessOrEqual(t, bounds.Dx(), 640)
+
+	// Tiny artwork keeps its own size
+	jpegData, err = conversion.EncodeCoverArt(noiseImage(40, 30), maxSize)
+	require.NoError(t, err)
+	decoded, err = jpeg.Decode(strings.NewReader(string(jpegData)))
+	require.NoError(t, err)
+	assert.Equal(t, 30, decoded.Bounds().Dx())
+}
+
+// A valid PNG whose header declares the given dimensions, though its data holds a single pixel
+func pngDeclaring(t *testing.T, width, height uint32) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 1, 1))))
+	data := buf.Bytes()
+	// The IHDR chunk follows the 8-byte signature: length, type, width, height, ..., CRC
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestConvertSkipsOversizedArtwork(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(pngDeclaring(t, 50000, 50000))
+	}))
+	defer server.Close()
+
+	service, spotify, youTube := newConversionService()
+	youTube.playlists["gym"] = []conversion.Track{{ID: "video-a", Title: "Song A"}}
+	youTube.imageURL = server.URL + "/maxresdefault.png"
+	spotify.catalog["Song A"] = "spotify:track:1"
+
+	result, err := service.Convert(context.Background(), conversion.ConvertRequest{
+		UserID:      "auth0|1",
+		Source:      conversion.SourceRequest{Provider: "youtube", PlaylistID: "gym", Name: "Gym"},
+		Destination: conversion.DestinationRequest{Provider: "spotify"},
+	})
+	require.NoError(t, err)
+	assert.False(t, result.CopiedArtwork)
+	assert.Empty(t, spotify.images)
+}
+
+func TestSanitizeDescription(t *testing.T) {
+	spotifyDescription := `Songs for the <a href="spotify:genre:gym">gym</a> &amp; more`
+	assert.Equal(t, "Songs for the gym & more", conversion.SanitizeDescription("youtube", spotifyDescription))
+	assert.Equal(t, "First line Second line", conversion.SanitizeDescription("spotify", "First line\nSecond line"))
+	assert.Equal(t, "1 is less than 2", conversion.SanitizeDescription("youtube", "1 is less than 2"))
+
+	long := conversion.SanitizeDescription("spotify", strings.Repeat("word ", 100))
+	assert.Equal(t, 300, len([]rune(long)))
+	assert.True(t, strings.HasSuffix(long, "…"))
+}
+
+func TestConvertCopiesArtwork(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		img := image.NewRGBA(image.Rect(0, 0, 480, 360))
+		for i := range img.Pix {
+			img.Pix[i] = 200
+		}
+		img.Set(0, 0, color.Black)
+		w.Header().Set("Content-Type", "image/png")
+		png.Encode(w, img)
+	}))
+	defer server.Close()
+
+	service, spotify, youTube := newConversionService()
+	youTube.playlists["gym"] = []conversion.Track{{ID: "video-a", Title: "Song A"}}
+	youTube.description = "Gym <3\nUpdated weekly"
+	youTube.imageURL = server.URL + "/maxresdefault.png"
+	spotify.catalog["Song A"] = "spotify:track:1"
+
+	result, err := service.Convert(context.Background(), conversion.ConvertRequest{
+		UserID:      "auth0|1",
+		Source:      conversion.SourceRequest{Provider: "youtube", PlaylistID: "gym", Name: "Gym"},
+		Destination: conversion.DestinationRequest{Provider: "spotify"},
+	})
+	require.NoError(t, err)
+	assert.True(t, result.CopiedArtwork)
+	assert.Equal(t, "Gym <3 Updated weekly", spotify.createdPlaylist.Description)
+
+	cover, err := jpeg.Decode(strings.NewReader(string(spotify.images[result.Destination.PlaylistID])))
+	require.NoError(t, err)
+	assert.Equal(t, 360, cover.Bounds().Dx())
+	assert.Equal(t, 360, cover.Bounds().Dy())
+}
+
+// A playlist with a track saved twice plus another release of it, a remaster of another track, a live
+// version of it too long to be the same, and a video added twice
+func duplicateEntries() []conversion.PlaylistEntry {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	return []conversion.PlaylistEntry{
+		{Position: 0, ItemID: "a", Track: conversion.Track{ID: "a", Title: "Song A", Artist: "Artist", ISRC: "ISRC1"}, DurationMs: 200000, Popularity: 10, AddedAt: day(3)},
+		{Position: 1, ItemID: "b", Track: conversion.Track{ID: "b", Title: "Song B", Artist: "Other"}, DurationMs: 180000, AddedAt: day(2)},
+		{Position: 2, ItemID: "a", Track: conversion.Track{ID: "a", Title: "Song A", Artist: "Artist", ISRC: "ISRC1"}, DurationMs: 200000, Popularity: 10, AddedAt: day(1)},
+		{Position: 4, ItemID: "a2", Track: conversion.Track{ID: "a2", Title: "Song A", Artist: "Artist", ISRC: "ISRC1"}, DurationMs: 201000, Popularity: 80, AddedAt: day(5)},
+		{Position: 5, ItemID: "b2", Track: conversion.Track{ID: "b2", Title: "Song B (Remastered 2011)", Artist: "Other"}, DurationMs: 183000, AddedAt: day(2)},
+		{Position: 6, ItemID: "b3", Track: conversion.Track{ID: "b3", Title: "Song B (Live)", Artist: "Other"}, DurationMs: 240000, AddedAt: day(2)},
+		{Position: 7, ItemID: "c", Track: conversion.Track{ID: "c", Title: "Other - Song C"}, AddedAt: day(6)},
+		{Position: 8, ItemID: "c", Track: conversion.Track{ID: "c", Title: "Other - Song C"}, AddedAt: day(7)},
+	}
+}
+
+func TestGroupDuplicates(t *testing.T) {
+	positions := func(entries []conversion.PlaylistEntry) []int {
+		result := []int{}
+		for _, entry := range entries {
+			result = append(result, entry.Position)
+		}
+		return result
+	}
+
+	t.Run("keeps the earliest copy", func(t *testing.T) {
+		groups := conversion.GroupDuplicates(duplicateEntries(), conversion.KeepEarliest)
+		require.Len(t, groups, 3)
+
+		assert.Equal(t, conversion.MatchISRC, groups[0].Match)
+		assert.Equal(t, 2, groups[0].Keep.Position)
+		assert.Equal(t, []int{0, 4}, positions(groups[0].Duplicates))
+
+		assert.Equal(t, conversion.MatchFuzzy, groups[1].Match)
+		assert.Equal(t, 1, groups[1].Keep.Position, "ties are broken by position")
+		assert.Equal(t, []int{5}, positions(groups[1].Duplicates))
+
+		assert.Equal(t, conversion.MatchExact, groups[2].Match)
+		assert.Equal(t, 7, groups[2].Keep.Position)
+	})
+
+	t.Run("keeps the most popular copy", func(t *testing.T) {
+		groups := conversion.GroupDuplicates(duplicateEntries(), conversion.KeepPopular)
+		require.Len(t, groups, 3)
+		assert.Equal(t, 4, groups[0].Keep.Position)
+		assert.Equal(t, []int{0, 2}, positions(groups[0].Duplicates))
+	})
+}
+
+func TestRemoveDuplicates(t *testing.T) {
+	request := func() conversion.DuplicatesRequest {
+		return conversion.DuplicatesRequest{UserID: "user1", Provider: "youtube", PlaylistID: "mix"}
+	}
+
+	t.Run("removes every duplicate when none are chosen", func(t *testing.T) {
+		service, _, youTube := newConversionService()
+		youTube.entries["mix"] = duplicateEntries()
+
+		report, err := service.RemoveDuplicates(context.Background(), request())
+		require.NoError(t, err)
+		assert.Len(t, report.Removed, 4)
+		assert.Len(t, youTube.entries["mix"], 4)
+
+		report, err = service.FindDuplicates(context.Background(), request())
+		require.NoError(t, err)
+		assert.Empty(t, report.Groups)
+	})
+
+	t.Run("removes only the chosen duplicates", func(t *testing.T) {
+		service, _, youTube := newConversionService()
+		youTube.entries["mix"] = duplicateEntries()
+
+		req := request()
+		req.Remove = []conversion.DuplicateRef{{Position: 8, ItemID: "c"}}
+		report, err := service.RemoveDuplicates(context.Background(), req)
+		require.NoError(t, err)
+		require.Len(t, report.Removed, 1)
+		assert.Equal(t, 8, report.Removed[0].Position)
+		assert.Len(t, youTube.entries["mix"], 7)
+	})
+
+	t.Run("refuses to remove a kept copy", func(t *testing.T) {
+		service, _, youTube := newConversionService()
+		youTube.entries["mix"] = duplicateEntries()
+
+		req := request()
+		req.Remove = []conversion.DuplicateRef{{Position: 2, ItemID: "a"}}
+		_, err := service.RemoveDuplicates(context.Background(), req)
+		assert.True(t, errors.Is(err, conversion.ErrInvalidConversion))
+		assert.Len(t, youTube.entries["mix"], 8)
+	})
+
+	t.Run("responds with a conflict once the playlist changed", func(t *testing.T) {
+		service, _, youTube := newConversionService()
+		youTube.entries["mix"] = duplicateEntries()
+		router := setupConversionRouter(service)
+
+		body := `{"userId": "user1", "provider": "youtube", "playlistId": "mix", "remove": [{"position": 3, "itemId": "a2"}]}`
+		req := httptest.NewRequest(http.MethodPost, "/conversions/duplicates/remove", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.Len(t, youTube.entries["mix"], 8)
+	})
+
+	t.Run("reports duplicates over HTTP", func(t *testing.T) {
+		service, spotify, _ := newConversionService()
+		spotify.entries["mix"] = duplicateEntries()
+		router := setupConversionRouter(service)
+
+		req := httptest.NewRequest(http.MethodGet, "/conversions/duplicates?userID=user1&provider=spotify&playlistID=mix&keep=popular", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var report conversion.DuplicateReport
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		assert.Equal(t, conversion.KeepPopular, report.Keep)
+		assert.Equal(t, 8, report.TrackCount)
+		assert.Len(t, report.Groups, 3)
+
+		req = httptest.NewRequest(http.MethodGet, "/conversions/duplicates?userID=user1&provider=spotify&playlistID=missing", nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
+
+func TestSpotifyAddTracksReportsAddedChunks(t *testing.T) {
+	var posts int
+	service := newFakeSpotifyService(t, func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		// The second chunk fails
+		if posts == 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]any{"snapshot_id": "s1"})
+	})
+	trackIDs := make([]string, 150)
+	for i := range trackIDs {
+		trackIDs[i] = fmt.Sprintf("spotify:track:%d", i)
+	}
+
+	added, err := conversion.SpotifyProvider{Service: service}.AddTracks(context.Background(), "user1", "", "playlist1", trackIDs, nil)
+	require.Error(t, err)
+	// The first chunk of 100 can still be undone
+	assert.Equal(t, trackIDs[:100], added)
+	assert.Equal(t, 2, posts)
+}
+
+func TestSpotifySaveTracksReportsSavedChunks(t *testing.T) {
+	var puts int
+	service := newFakeSpotifyService(t, func(w http.ResponseWriter, r *http.Request) {
+		puts++
+		// The second chunk fails
+		if puts == 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	trackIDs := make([]string, 75)
+	for i := range trackIDs {
+		trackIDs[i] = fmt.Sprintf("spotify:track:%d", i)
+	}
+
+	saved, err := conversion.SpotifyProvider{Service: service}.SaveTracks(context.Background(), "user1", "", trackIDs)
+	require.Error(t, err)
+	// The first chunk of 50 can still be undone
+	assert.Equal(t, trackIDs[:50], saved)
+	assert.Equal(t, 2, puts)
+}
+
+func TestSpotifyUndoRemovesAddedPositions(t *testing.T) {
+	var posts []spotify.AddItemsToPlaylistPayload
+	var deletes []spotify.RemoveItemsFromPlaylistPayload
+	snapshot := "snap1"
+	api := func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, map[string]any{"id": "mix", "snapshot_id": snapshot, "tracks": map[string]any{"total": 2}})
+		case http.MethodPost:
+			var payload spotify.AddItemsToPlaylistPayload
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+			posts = append(posts, payload)
+			snapshot = "snap2"
+			writeJSON(w, map[string]any{"snapshot_id": snapshot})
+		case http.MethodDelete:
+			var payload spotify.RemoveItemsFromPlaylistPayload
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+			deletes = append(deletes, payload)
+			writeJSON(w, map[string]any{"snapshot_id": "snap3"})
+		}
+	}
+	// The playlist already holds a copy of track a, which the undo must keep
+	appendTracks := func(t *testing.T, service *conversion.ConversionService) *conversion.Conversion {
+		added, err := service.Providers["spotify"].(conversion.PositionalProvider).AddTracksAt(context.Background(), "user1", "", "mix", []string{"spotify:track:a", "spotify:track:b"}, nil)
+		require.NoError(t, err)
+		appended := &conversion.Conversion{
+			ID:             "appended",
+			UserID:         "user1",
+			Status:         conversion.StatusCompleted,
+			Destination:    conversion.PlaylistRef{Provider: "spotify", PlaylistID: "mix"},
+			AddedItems:     added.IDs,
+			AddedPositions: added.Positions,
+			AddedSnapshot:  added.SnapshotID,
+		}
+		require.NoError(t, service.Store.Create(context.Background(), appended))
+		return appended
+	}
+	newService := func() *conversion.ConversionService {
+		posts, deletes, snapshot = nil, nil, "snap1"
+		service, _, _ := newConversionService()
+		service.Providers["spotify"] = conversion.SpotifyProvider{Service: newFakeSpotifyService(t, api)}
+		return service
+	}
+
+	t.Run("removes the tracks at the positions they were added at", func(t *testing.T) {
+		service := newService()
+		appended := appendTracks(t, service)
+		// Appended tracks are inserted explicitly at the end
+		require.Len(t, posts, 1)
+		require.NotNil(t, posts[0].Position)
+		assert.Equal(t, 2, *posts[0].Position)
+		assert.Equal(t, []int{2, 3}, appended.AddedPositions)
+
+		_, err := service.UndoConversion(context.Background(), "user1", "appended")
+		require.NoError(t, err)
+		require.Len(t, deletes, 1)
+		assert.Equal(t, "snap2", deletes[0].SnapshotID)
+		assert.ElementsMatch(t, []spotify.PlaylistItemURI{
+			{URI: "spotify:track:a", Positions: []int{2}},
+			{URI: "spotify:track:b", Positions: []int{3}},
+		}, deletes[0].Tracks)
+	})
+
+	t.Run("refuses once the playlist has changed", func(t *testing.T) {
+		service := newService()
+		appendTracks(t, service)
+		snapshot = "snap-edited"
+
+		_, err := service.UndoConversion(context.Background(), "user1", "appended")
+		assert.ErrorIs(t, err, conversion.ErrNotUndoable)
+		assert.Empty(t, deletes)
+	})
+
+	t.Run("refuses without recorded positions", func(t *testing.T) {
+		service := newService()
+		require.NoError(t, service.Store.Create(context.Background(), &conversion.Conversion{
+			ID:          "legacy",
+			UserID:      "user1",
+			Status:      conversion.StatusCompleted,
+			Destination: conversion.PlaylistRef{Provider: "spotify", PlaylistID: "mix"},
+			AddedItems:  []string{"spotify:track:a"},
+		}))
+
+		_, err := service.UndoConversion(context.Background(), "user1", "legacy")
+		assert.ErrorIs(t, err, conversion.ErrNotUndoable)
+		assert.Empty(t, deletes)
+	})
+}
+
+func TestSpotifyRemoveDuplicatesByPosition(t *testing.T) {
+	var deletes []spotify.RemoveItemsFromPlaylistPayload
+	deleteStatus := http.StatusOK
+	api := func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodDelete:
+			var payload spotify.RemoveItemsFromPlaylistPayload
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+			deletes = append(deletes, payload)
+			w.WriteHeader(deleteStatus)
+			writeJSON(w, map[string]any{"snapshot_id": "snap2"})
+		case r.Method != http.MethodGet:
+			t.Errorf("unexpected %s %s", r.Method, r.URL.Path)
+		case r.URL.Path == "/v1/playlists/mix":
+			writeJSON(w, map[string]any{"snapshot_id": "snap1"})
+		default:
+			track := func(uri, name, addedAt string) map[string]any {
+				return map[string]any{"added_at": addedAt, "track": map[string]any{"uri": uri, "name": name, "artists": []any{map[string]any{"name": "Band"}}}}
+			}
+			writeJSON(w, map[string]any{"items": []any{
+				track("spotify:track:a", "Song A", "2020-01-01T00:00:00Z"),
+				track("spotify:track:b", "Song B", "2020-01-01T00:00:00Z"),
+				track("spotify:track:a", "Song A", "2019-01-01T00:00:00Z"),
+				track("spotify:track:a", "Song A", "2021-01-01T00:00:00Z"),
+			}})
+		}
+	}
+	removeDuplicates := func() (*conversion.DuplicateReport, error) {
+		service, _, _ := newConversionService()
+		service.Providers["spotify"] = conversion.SpotifyProvider{Service: newFakeSpotifyService(t, api)}
+		return service.RemoveDuplicates(context.Background(), conversion.DuplicatesRequest{UserID: "user1", Provider: "spotify", PlaylistID: "mix"})
+	}
+
+	t.Run("removes only the duplicate copies, without adding the kept one back", func(t *testing.T) {
+		deletes = nil
+		report, err := removeDuplicates()
+		require.NoError(t, err)
+		require.Len(t, report.Removed, 2)
+
+		require.Len(t, deletes, 1)
+		assert.Equal(t, "snap1", deletes[0].SnapshotID)
+		assert.Equal(t, []spotify.PlaylistItemURI{
+			{URI: "spotify:track:a", Positions: []int{3}},
+			{URI: "spotify:track:a", Positions: []int{0}},
+		}, deletes[0].Tracks)
+	})
+
+	t.Run("leaves the playlist alone when the removal is refused", func(t *testing.T) {
+		deletes = nil
+		deleteStatus = http.StatusBadRequest
+		defer func() { deleteStatus = http.StatusOK }()
+
+		_, err := removeDuplicates()
+		require.Error(t, err)
+		assert.Len(t, deletes, 1)
+	})
+}
+
+func TestSpotifyFindAlbumByName(t *testing.T) {
+	var results []map[string]any
+	service := newFakeSpotifyService(t, func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"albums": map[string]any{"items": results}})
+	})
+	provider := conversion.SpotifyProvider{Service: service}
+	album := conversion.Track{Title: "Album A", Artist: "Band"}
+
+	t.Run("skips results of another name", func(t *testing.T) {
+		results = []map[string]any{
+			{"uri": "spotify:album:live", "name": "Album A Live", "artists": []any{map[string]any{"name": "Band"}}},
+			{"uri": "spotify:album:a", "name": "Album A (Remastered)", "artists": []any{map[string]any{"name": "Band"}}},
+		}
+		id, found, err := provider.FindItem(context.Background(), "user1", "", conversion.KindAlbums, album)
+		require.NoError(t, err)
+		assert.True(t, found)
+		assert.Equal(t, "spotify:album:a", id)
+	})
+
+	t.Run("reports no match rather than a different album", func(t *testing.T) {
+		results = []map[string]any{{"uri": "spotify:album:b", "name": "Album A", "artists": []any{map[string]any{"name": "Tribute Band"}}}}
+		_, found, err := provider.FindItem(context.Background(), "user1", "", conversion.KindAlbums, album)
+		require.NoError(t, err)
+		assert.False(t, found)
+	})
+}
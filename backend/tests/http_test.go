@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/roblieblang/luthien/backend/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRetryPolicy = utils.RetryPolicy{
+	MaxRetries:        2,
+	BaseDelay:         time.Millisecond,
+	MaxDelay:          5 * time.Millisecond,
+	MaxRetryAfter:     time.Second,
+	PerAttemptTimeout: time.Second,
+}
+
+func TestRetryTransport(t *testing.T) {
+	t.Run("retries server errors on idempotent requests", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			w.Write([]byte("ok"))
+		}))
+		defer server.Close()
+
+		res, err := utils.NewHTTPClient("Test", testRetryPolicy).Get(server.URL)
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("retries rate limited POSTs with their body after Retry-After", func(t *testing.T) {
+		var calls int32
+		var lastBody string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			lastBody = string(body)
+			if atomic.AddInt32(&calls, 1) == 1 {
+				w.Header().Set("Retry-After", "0")
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer server.Close()
+
+		res, err := utils.NewHTTPClient("Test", testRetryPolicy).Post(server.URL, "text/plain", strings.NewReader("payload"))
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		assert.Equal(t, http.StatusCreated, res.StatusCode)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+		assert.Equal(t, "payload", lastBody)
+	})
+
+	t.Run("does not retry server errors on POSTs", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+		defer server.Close()
+
+		res, err := utils.NewHTTPClient("Test", testRetryPolicy).Post(server.URL, "text/plain", strings.NewReader("payload"))
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("returns long Retry-After waits to the caller", func(t *testing.T) {
+		var calls int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			w.Header().Set("Retry-After", "120")
+			w.WriteHeader(http.StatusTooManyRequests)
+		}))
+		defer server.Close()
+
+		res, err := utils.NewHTTPClient("Test", testRetryPolicy).Get(server.URL)
+		require.NoError(t, err)
+		defer res.Body.Close()
+
+		upstreamErr := utils.CheckResponse("Test", res)
+		assert.True(t, errors.Is(upstreamErr, utils.ErrRateLimited))
+		var typed *utils.UpstreamError
+		require.True(t, errors.As(upstreamErr, &typed))
+		assert.Equal(t, 120*time.Second, typed.RetryAfter)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+}
+
+func TestUpstreamErrorStatus(t *testing.T) {
+	tests := []struct {
+		upstreamStatus int
+		expected       int
+	}{
+		{http.StatusTooManyRequests, http.StatusTooManyRequests},
+		{http.StatusUnauthorized, http.StatusUnauthorized},
+		{http.StatusForbidden, http.StatusForbidden},
+		{http.StatusNotFound, http.StatusNotFound},
+		{http.StatusBadGateway, http.StatusBadGateway},
+		{http.StatusBadRequest, http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		res := httptest.NewRecorder()
+		res.WriteHeader(tt.upstreamStatus)
+		err := utils.CheckResponse("Test", res.Result())
+
+		status, ok := utils.UpstreamErrorStatus(err)
+		assert.True(t, ok)
+		assert.Equal(t, tt.expected, status, "upstream status %d", tt.upstreamStatus)
+	}
+
+	_, ok := utils.UpstreamErrorStatus(errors.New("some other error"))
+	assert.False(t, ok)
+}
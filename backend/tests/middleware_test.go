@@ -0,0 +1,345 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roblieblang/luthien/backend/internal/middleware"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRate(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected middleware.Rate
+		wantErr  bool
+	}{
+		{"30/m", middleware.Rate{Requests: 30, Period: time.Minute}, false},
+		{"500/h", middleware.Rate{Requests: 500, Period: time.Hour}, false},
+		{"100/d", middleware.Rate{Requests: 100, Period: 24 * time.Hour}, false},
+		{"10/90s", middleware.Rate{Requests: 10, Period: 90 * time.Second}, false},
+		{"0/m", middleware.Rate{Requests: 0, Period: time.Minute}, false},
+		{"30", middleware.Rate{}, true},
+		{"x/m", middleware.Rate{}, true},
+		{"30/fortnight", middleware.Rate{}, true},
+	}
+
+	for _, tt := range tests {
+		rate, err := middleware.ParseRate(tt.input)
+		if tt.wantErr {
+			assert.Error(t, err, tt.input)
+			continue
+		}
+		assert.NoError(t, err, tt.input)
+		assert.Equal(t, tt.expected, rate, tt.input)
+	}
+}
+
+func TestLoadRateLimitConfigOverrides(t *testing.T) {
+	t.Setenv("RATE_LIMIT_YOUTUBE_SEARCH_USER", "5/m")
+	t.Setenv("RATE_LIMIT_YOUTUBE_SEARCH_GLOBAL", "not-a-rate")
+
+	defaults := middleware.RateLimitConfig{
+		Group:   "youtube-search",
+		PerUser: middleware.Rate{Requests: 30, Period: time.Minute},
+		Global:  middleware.Rate{Requests: 600, Period: time.Hour},
+	}
+	config := middleware.LoadRateLimitConfig(defaults)
+
+	assert.Equal(t, middleware.Rate{Requests: 5, Period: time.Minute}, config.PerUser)
+	// Invalid overrides keep the default
+	assert.Equal(t, defaults.Global, config.Global)
+}
+
+func TestResolveUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.ResolveUser(nil, nil))
+	handler := func(c *gin.Context) {
+		var body []byte
+		if c.Request.Body != nil {
+			body, _ = io.ReadAll(c.Request.Body)
+		}
+		c.JSON(http.StatusOK, gin.H{"userID": middleware.UserID(c), "body": string(body)})
+	}
+	router.GET("/query", handler)
+	router.POST("/body", handler)
+
+	t.Run("query parameter", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/query?userID=user123", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.JSONEq(t, `{"userID": "user123", "body": ""}`, w.Body.String())
+	})
+
+	t.Run("JSON body is left intact for the handler", func(t *testing.T) {
+		body := `{"userId": "user456", "payload": {"name": "Road Trip"}}`
+		req, _ := http.NewRequest("POST", "/body", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"userID":"user456"`)
+		assert.Contains(t, w.Body.String(), `Road Trip`)
+	})
+
+	t.Run("no user", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/query", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.JSONEq(t, `{"userID": "", "body": ""}`, w.Body.String())
+	})
+}
+
+type fakeAPIKeyAuthenticator struct{}
+
+func (fakeAPIKeyAuthenticator) Authenticate(ctx context.Context, rawKey string) (string, []string, error) {
+	if rawKey != "luth_key1_secret" {
+		return "", nil, errors.New("invalid API key")
+	}
+	return "keyOwner", []string{"read-playlists"}, nil
+}
+
+func (fakeAPIKeyAuthenticator) IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, "luth_")
+}
+
+// Accepts "session-<user ID>" tokens and fails as if Auth0 were down for "outage"
+type fakeSessionVerifier struct{}
+
+func (fakeSessionVerifier) VerifySession(ctx context.Context, accessToken string) (string, error) {
+	if accessToken == "outage" {
+		return "", errors.New("Auth0 unreachable")
+	}
+	userID, found := strings.CutPrefix(accessToken, "session-")
+	if !found {
+		return "", fmt.Errorf("invalid token: %w", utils.ErrUnauthorized)
+	}
+	return userID, nil
+}
+
+func TestResolveUserWithAPIKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.ResolveUser(fakeAPIKeyAuthenticator{}, nil))
+	handler := func(c *gin.Context) {
+		var body []byte
+		if c.Request.Body != nil {
+			body, _ = io.ReadAll(c.Request.Body)
+		}
+		c.JSON(http.StatusOK, gin.H{"userID": middleware.UserID(c), "query": c.Query("userID"), "body": string(body)})
+	}
+	router.GET("/read", middleware.RequireScope("read-playlists"), handler)
+	router.POST("/write", middleware.RequireScope("write-playlists"), handler)
+	router.POST("/read", middleware.RequireScope("read-playlists"), handler)
+
+	t.Run("key owner is written into the query string", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/read", nil)
+		req.Header.Set("Authorization", "Bearer luth_key1_secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.JSONEq(t, `{"userID": "keyOwner", "query": "keyOwner", "body": ""}`, w.Body.String())
+	})
+
+	t.Run("key owner is written into the JSON body", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/read", strings.NewReader(`{"playlistId": "abc"}`))
+		req.Header.Set("Authorization", "Bearer luth_key1_secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `\"userId\":\"keyOwner\"`)
+		assert.Contains(t, w.Body.String(), `\"playlistId\":\"abc\"`)
+	})
+
+	t.Run("key cannot act on another user", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/read?userID=someoneElse", nil)
+		req.Header.Set("Authorization", "Bearer luth_key1_secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("missing scope", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/write", strings.NewReader(`{}`))
+		req.Header.Set("Authorization", "Bearer luth_key1_secret")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "insufficient_scope")
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/read", nil)
+		req.Header.Set("Authorization", "Bearer luth_key1_wrong")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	t.Run("browser requests are not scope restricted", func(t *testing.T) {
+		req, _ := http.NewRequest("POST", "/write", strings.NewReader(`{"userId": "user123"}`))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestResolveUserWithSession(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.ResolveUser(fakeAPIKeyAuthenticator{}, fakeSessionVerifier{}))
+	handler := func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"userID": middleware.UserID(c), "query": c.Query("userID"), "authenticated": middleware.Authenticated(c)})
+	}
+	router.GET("/read", handler)
+	router.DELETE("/me", middleware.RequireSession(), handler)
+
+	send := func(method, path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("verified user is written into the query string", func(t *testing.T) {
+		w := send("GET", "/read", "session-user1")
+		assert.JSONEq(t, `{"userID": "user1", "query": "user1", "authenticated": true}`, w.Body.String())
+	})
+
+	t.Run("session cannot act on another user", func(t *testing.T) {
+		w := send("GET", "/read?userID=user2", "session-user1")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("rejected token", func(t *testing.T) {
+		w := send("GET", "/read", "forged")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Contains(t, w.Body.String(), "invalid_session")
+	})
+
+	t.Run("Auth0 outage is not reported as a bad token", func(t *testing.T) {
+		w := send("GET", "/read", "outage")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+
+	t.Run("requests without a token name their own user but are not authenticated", func(t *testing.T) {
+		w := send("GET", "/read?userID=user2", "")
+		assert.JSONEq(t, `{"userID": "user2", "query": "user2", "authenticated": false}`, w.Body.String())
+	})
+
+	t.Run("session endpoints require a session token", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, send("DELETE", "/me?userID=user1", "").Code)
+		assert.Equal(t, http.StatusForbidden, send("DELETE", "/me", "luth_key1_secret").Code)
+		assert.Equal(t, http.StatusOK, send("DELETE", "/me", "session-user1").Code)
+	})
+}
+
+func TestTimeout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handler := func(c *gin.Context) {
+		deadline, ok := c.Request.Context().Deadline()
+		assert.True(t, ok)
+		c.JSON(http.StatusOK, gin.H{"remaining": time.Until(deadline).Seconds()})
+	}
+	group := router.Group("/group", middleware.Timeout(time.Second))
+	group.GET("/default", handler)
+	group.GET("/long", middleware.Timeout(time.Hour), handler)
+
+	t.Run("group deadline applies", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/group/default", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Regexp(t, `"remaining":0\.\d+`, w.Body.String())
+	})
+
+	t.Run("route deadline replaces the group deadline", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/group/long", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Regexp(t, `"remaining":35\d\d\.\d+`, w.Body.String())
+	})
+}
+
+func TestRateLimiter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	newRouter := func(config middleware.RateLimitConfig, trustedProxies string) *gin.Engine {
+		router := gin.New()
+		assert.NoError(t, middleware.ConfigureClientIP(router, trustedProxies, ""))
+		router.GET("/limited", middleware.RateLimiter(middleware.NewMemoryBucketStore(), config), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		return router
+	}
+	send := func(router *gin.Engine, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/limited", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	perClient := middleware.RateLimitConfig{Group: "test", PerUser: middleware.Rate{Requests: 2, Period: time.Hour}}
+
+	t.Run("a spoofed X-Forwarded-For does not reset the bucket", func(t *testing.T) {
+		router := newRouter(perClient, "")
+		assert.Equal(t, http.StatusOK, send(router, "203.0.113.1").Code)
+		assert.Equal(t, http.StatusOK, send(router, "203.0.113.2").Code)
+		assert.Equal(t, http.StatusTooManyRequests, send(router, "203.0.113.3").Code)
+	})
+
+	t.Run("clients behind a trusted proxy get buckets of their own", func(t *testing.T) {
+		router := newRouter(perClient, "192.0.2.1")
+		for i := 0; i < 2; i++ {
+			assert.Equal(t, http.StatusOK, send(router, "203.0.113.1").Code)
+		}
+		assert.Equal(t, http.StatusTooManyRequests, send(router, "203.0.113.1").Code)
+		assert.Equal(t, http.StatusOK, send(router, "203.0.113.2").Code)
+	})
+
+	t.Run("a request refused by the global limit is not charged to the user", func(t *testing.T) {
+		store := middleware.NewMemoryBucketStore()
+		buckets := []middleware.Bucket{
+			{Key: "user", Rate: middleware.Rate{Requests: 2, Period: time.Hour}},
+			{Key: "global", Rate: middleware.Rate{Requests: 1, Period: time.Hour}},
+		}
+
+		results, err := store.TakeTokens(context.Background(), buckets)
+		assert.NoError(t, err)
+		assert.True(t, results[0].Allowed && results[1].Allowed)
+
+		results, err = store.TakeTokens(context.Background(), buckets)
+		assert.NoError(t, err)
+		assert.True(t, results[0].Allowed)
+		assert.False(t, results[1].Allowed)
+		assert.Equal(t, 1, results[0].Remaining)
+
+		results, err = store.TakeTokens(context.Background(), buckets[:1])
+		assert.NoError(t, err)
+		assert.True(t, results[0].Allowed)
+		assert.Equal(t, 0, results[0].Remaining)
+	})
+}
@@ -0,0 +1,111 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/roblieblang/luthien/backend/internal/auth/youtube"
+	"github.com/roblieblang/luthien/backend/internal/conversion"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/googleapi"
+)
+
+// A ledger of 10,000 units a day, 1,000 of them reserved for interactive calls
+func newMemoryQuotaLedger() *youtube.QuotaLedger {
+	ledger := youtube.NewQuotaLedger(&utils.AppContext{})
+	ledger.Counter = youtube.NewMemoryQuotaCounter()
+	ledger.DailyLimit = 10000
+	ledger.InteractiveReserve = 1000
+	return ledger
+}
+
+func TestQuotaLedger(t *testing.T) {
+	ctx := context.Background()
+	batch := youtube.WithQuotaPriority(ctx, youtube.QuotaBatch)
+
+	t.Run("batch calls cannot spend the interactive reserve", func(t *testing.T) {
+		ledger := newMemoryQuotaLedger()
+		require.NoError(t, ledger.Charge(batch, 8950))
+
+		assert.ErrorIs(t, ledger.Charge(batch, youtube.QuotaCostSearch), youtube.ErrQuotaExceeded)
+		require.NoError(t, ledger.Charge(ctx, youtube.QuotaCostSearch))
+
+		status, err := ledger.Status(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 9050, status.Used)
+		assert.Equal(t, 950, status.Remaining)
+		assert.Equal(t, 0, status.BatchRemaining)
+	})
+
+	t.Run("interactive calls stop at the daily limit", func(t *testing.T) {
+		ledger := newMemoryQuotaLedger()
+		require.NoError(t, ledger.Charge(ctx, 9990))
+
+		assert.ErrorIs(t, ledger.Charge(ctx, youtube.QuotaCostInsert), youtube.ErrQuotaExceeded)
+		require.NoError(t, ledger.Charge(ctx, youtube.QuotaCostList))
+	})
+
+	t.Run("refuses a batch job estimated above the batch budget", func(t *testing.T) {
+		ledger := newMemoryQuotaLedger()
+		require.NoError(t, ledger.Charge(ctx, 4000))
+
+		require.NoError(t, ledger.CheckBudget(ctx, 5000))
+		assert.ErrorIs(t, ledger.CheckBudget(ctx, 5001), youtube.ErrQuotaExceeded)
+	})
+
+	t.Run("stops calls once Google reports the quota exhausted", func(t *testing.T) {
+		ledger := newMemoryQuotaLedger()
+		forbidden := &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}
+		ledger.RecordExhausted(ctx, forbidden)
+		require.NoError(t, ledger.Charge(ctx, youtube.QuotaCostList))
+
+		exhausted := &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "quotaExceeded"}}}
+		ledger.RecordExhausted(ctx, exhausted)
+		assert.ErrorIs(t, ledger.Charge(ctx, youtube.QuotaCostList), youtube.ErrQuotaExceeded)
+	})
+
+	t.Run("lets calls through when the counter is unavailable", func(t *testing.T) {
+		// Nothing listens on port 1
+		redisClient := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+		defer redisClient.Close()
+		ledger := newMemoryQuotaLedger()
+		ledger.Counter = youtube.RedisQuotaCounter{RedisClient: redisClient}
+
+		require.NoError(t, ledger.Charge(batch, 20000))
+		require.NoError(t, ledger.CheckBudget(ctx, 20000))
+	})
+}
+
+func TestYouTubeConversionSearchesUseBatchBudget(t *testing.T) {
+	ctx := context.Background()
+	var searches int
+	service := newFakeYouTubeService(t, func(w http.ResponseWriter, r *http.Request) {
+		searches++
+		writeJSON(w, map[string]any{"items": []any{map[string]any{"id": map[string]any{"videoId": "video-a"}, "snippet": map[string]any{"title": "Song A"}}}})
+	})
+	ledger := service.YouTubeClient.Quota
+	provider := conversion.YouTubeProvider{Service: service}
+
+	t.Run("the estimate counts every search", func(t *testing.T) {
+		require.NoError(t, ledger.Charge(ctx, 8000))
+		require.NoError(t, provider.CheckBatchBudget(ctx, 9, 2))
+		assert.ErrorIs(t, provider.CheckBatchBudget(ctx, 10, 1), youtube.ErrQuotaExceeded)
+	})
+
+	t.Run("searches stop at the batch ceiling while the page can still search", func(t *testing.T) {
+		require.NoError(t, ledger.Charge(ctx, 950))
+
+		_, _, err := provider.FindTrack(ctx, "user1", "", conversion.Track{Title: "Song A", Artist: "Artist A"})
+		assert.ErrorIs(t, err, youtube.ErrQuotaExceeded)
+		assert.Equal(t, 0, searches)
+
+		results, err := provider.Service.SearchVideos(ctx, "user1", "", "Artist A", "Song A")
+		require.NoError(t, err)
+		assert.Equal(t, "video-a", results[0].ID)
+		assert.Equal(t, 1, searches)
+	})
+}
@@ -0,0 +1,170 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/roblieblang/luthien/backend/internal/auth/spotify"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// A fake Spotify API serving a profile and one playlist, answering 304 when the If-None-Match header is current
+type fakeSpotifyAPI struct {
+	mu          sync.Mutex
+	displayName string
+	snapshotID  string
+	trackName   string
+	// Full responses sent, by path; 304s are not counted
+	served map[string]int
+	// The If-None-Match headers received, by path
+	conditional map[string][]string
+}
+
+func (f *fakeSpotifyAPI) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.conditional[r.URL.Path] = append(f.conditional[r.URL.Path], r.Header.Get("If-None-Match"))
+	var etag string
+	var body any
+	switch r.URL.Path {
+	case "/v1/me":
+		etag = `"profile-` + f.displayName + `"`
+		body = map[string]any{"id": "spotify-user", "display_name": f.displayName}
+	case "/v1/playlists/playlist1":
+		etag = `"snapshot-` + f.snapshotID + `"`
+		body = map[string]any{"snapshot_id": f.snapshotID}
+	case "/v1/playlists/playlist1/tracks":
+		body = map[string]any{"items": []any{map[string]any{"track": map[string]any{"name": f.trackName, "uri": "spotify:track:1"}}}}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if etag != "" {
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+	}
+	f.served[r.URL.Path]++
+	writeJSON(w, body)
+}
+
+// Creates a service for "user1", linked to the Spotify account "spotify-user", whose requests reach the handler
+func newFakeSpotifyService(t *testing.T, handler http.HandlerFunc) *spotify.SpotifyService {
+	ctx := context.Background()
+	appCtx := &utils.AppContext{Tokens: utils.NewMemoryTokenStore(), Cache: utils.NewMemoryCache(1 << 20)}
+	require.NoError(t, utils.SaveLinkedAccount(ctx, *appCtx, "user1", "spotify", utils.LinkedAccount{ID: "spotify-user"}))
+	require.NoError(t, utils.SetToken(ctx, utils.SetTokenParams{TokenKind: "access", Party: "spotify", UserID: "user1", AccountID: "spotify-user", Token: "access1", ExpiresIn: 3600, AppCtx: *appCtx}))
+	client := &spotify.SpotifyClient{AppContext: appCtx, HTTPClient: newFakeUpstream(t, handler)}
+	return spotify.NewSpotifyService(client, nil, appCtx)
+}
+
+func TestSpotifyResponseCache(t *testing.T) {
+	ctx := context.Background()
+	newAPI := func() *fakeSpotifyAPI {
+		return &fakeSpotifyAPI{displayName: "Ada", snapshotID: "s1", trackName: "Song A", served: make(map[string]int), conditional: make(map[string][]string)}
+	}
+
+	t.Run("revalidates the profile with its ETag", func(t *testing.T) {
+		api := newAPI()
+		service := newFakeSpotifyService(t, api.handle)
+
+		profile, err := service.GetCurrentUserProfile(ctx, "user1", "")
+		require.NoError(t, err)
+		assert.Equal(t, "Ada", profile.DisplayName)
+
+		// Unchanged: Spotify answers 304 and the cached body is used
+		profile, err = service.GetCurrentUserProfile(ctx, "user1", "")
+		require.NoError(t, err)
+		assert.Equal(t, "Ada", profile.DisplayName)
+		assert.Equal(t, []string{"", `"profile-Ada"`}, api.conditional["/v1/me"])
+		assert.Equal(t, 1, api.served["/v1/me"])
+
+		// Changed: the new body replaces the cached one
+		api.displayName = "Grace"
+		profile, err = service.GetCurrentUserProfile(ctx, "user1", "")
+		require.NoError(t, err)
+		assert.Equal(t, "Grace", profile.DisplayName)
+		_, err = service.GetCurrentUserProfile(ctx, "user1", "")
+		require.NoError(t, err)
+		assert.Equal(t, `"profile-Grace"`, api.conditional["/v1/me"][3])
+		assert.Equal(t, 2, api.served["/v1/me"])
+	})
+
+	t.Run("reuses the track list while the snapshot is unchanged", func(t *testing.T) {
+		api := newAPI()
+		service := newFakeSpotifyService(t, api.handle)
+
+		tracks, err := service.GetPlaylistTracks(ctx, "user1", "", "playlist1")
+		require.NoError(t, err)
+		require.Len(t, tracks.Items, 1)
+		assert.Equal(t, "Song A", tracks.Items[0].Track.Name)
+
+		// Only the snapshot lookup is sent, and answered with 304
+		api.trackName = "Song B"
+		tracks, err = service.GetPlaylistTracks(ctx, "user1", "", "playlist1")
+		require.NoError(t, err)
+		assert.Equal(t, "Song A", tracks.Items[0].Track.Name)
+		assert.Equal(t, []string{"", `"snapshot-s1"`}, api.conditional["/v1/playlists/playlist1"])
+		assert.Equal(t, 1, api.served["/v1/playlists/playlist1/tracks"])
+
+		// A new snapshot means the tracks are read again
+		api.snapshotID = "s2"
+		tracks, err = service.GetPlaylistTracks(ctx, "user1", "", "playlist1")
+		require.NoError(t, err)
+		assert.Equal(t, "Song B", tracks.Items[0].Track.Name)
+		assert.Equal(t, 2, api.served["/v1/playlists/playlist1/tracks"])
+	})
+
+	t.Run("keeps the responses of each linked account apart", func(t *testing.T) {
+		api := newAPI()
+		service := newFakeSpotifyService(t, api.handle)
+		appCtx := service.AppContext
+		require.NoError(t, utils.SaveLinkedAccount(ctx, *appCtx, "user1", "spotify", utils.LinkedAccount{ID: "second-user"}))
+		require.NoError(t, utils.SetToken(ctx, utils.SetTokenParams{TokenKind: "access", Party: "spotify", UserID: "user1", AccountID: "second-user", Token: "access2", ExpiresIn: 3600, AppCtx: *appCtx}))
+
+		_, err := service.GetCurrentUserProfile(ctx, "user1", "spotify-user")
+		require.NoError(t, err)
+		_, err = service.GetCurrentUserProfile(ctx, "user1", "second-user")
+		require.NoError(t, err)
+		// The second account has no cached copy to revalidate
+		assert.Equal(t, []string{"", ""}, api.conditional["/v1/me"])
+	})
+}
+
+func TestSpotifyRemovePlaylistItemsByPosition(t *testing.T) {
+	var requests []spotify.RemoveItemsFromPlaylistPayload
+	service := newFakeSpotifyService(t, func(w http.ResponseWriter, r *http.Request) {
+		var payload spotify.RemoveItemsFromPlaylistPayload
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+		requests = append(requests, payload)
+		writeJSON(w, map[string]any{"snapshot_id": fmt.Sprintf("snap%d", len(requests)+1)})
+	})
+	positions := make([]int, 150)
+	for i := range positions {
+		positions[i] = i
+	}
+	items := []spotify.PlaylistItemURI{{URI: "spotify:track:a", Positions: positions}, {URI: "spotify:track:b"}}
+
+	snapshotID, err := service.RemovePlaylistItems(context.Background(), "user1", "", "playlist1", "snap1", items)
+	require.NoError(t, err)
+	assert.Equal(t, "snap3", snapshotID)
+	require.Len(t, requests, 2)
+	// Each chunk names the snapshot the previous one produced
+	assert.Equal(t, "snap1", requests[0].SnapshotID)
+	assert.Equal(t, "snap2", requests[1].SnapshotID)
+	// The highest positions go first, so the later chunk's positions still hold
+	assert.Equal(t, []int{149}, requests[0].Tracks[0].Positions)
+	assert.Equal(t, []int{50}, requests[0].Tracks[99].Positions)
+	assert.Equal(t, []int{0}, requests[1].Tracks[49].Positions)
+	// Items given without positions lose every occurrence, after the positioned ones
+	assert.Equal(t, spotify.PlaylistItemURI{URI: "spotify:track:b"}, requests[1].Tracks[50])
+}
@@ -15,53 +15,83 @@ type MockSpotifyService struct {
 	mock.Mock
 }
 
-func (m *MockSpotifyService) StartLoginFlow() (string, string, error) {
-	args := m.Called()
+func (m *MockSpotifyService) StartLoginFlow(ctx context.Context) (string, string, error) {
+	args := m.Called(ctx)
 	return args.String(0), args.String(1), args.Error(2)
 }
 
-func (m *MockSpotifyService) HandleCallback(code, userID, sessionID string) error {
-	args := m.Called(code, userID, sessionID)
+func (m *MockSpotifyService) HandleCallback(ctx context.Context, code, userID, sessionID string) error {
+	args := m.Called(ctx, code, userID, sessionID)
+	return args.Error(0)
+}
+
+func (m *MockSpotifyService) Logout(ctx context.Context, userID, accountID string) error {
+	args := m.Called(ctx, userID, accountID)
+	return args.Error(0)
+}
+
+func (m *MockSpotifyService) ListLinkedAccounts(ctx context.Context, userID string) ([]utils.LinkedAccount, error) {
+	args := m.Called(ctx, userID)
+	return args.Get(0).([]utils.LinkedAccount), args.Error(1)
+}
+
+func (m *MockSpotifyService) SetDefaultAccount(ctx context.Context, userID, accountID string) error {
+	args := m.Called(ctx, userID, accountID)
 	return args.Error(0)
 }
 
-func (m *MockSpotifyService) GetCurrentUserProfile(userID string) (spotify.SpotifyUserProfile, error) {
-	args := m.Called(userID)
+func (m *MockSpotifyService) GetCurrentUserProfile(ctx context.Context, userID, accountID string) (spotify.SpotifyUserProfile, error) {
+	args := m.Called(ctx, userID, accountID)
 	return args.Get(0).(spotify.SpotifyUserProfile), args.Error(1)
 }
 
-func (m *MockSpotifyService) GetCurrentUserPlaylists(userID string, offset int) (spotify.SpotifyPlaylistsResponse, error) {
-	args := m.Called(userID, offset)
+func (m *MockSpotifyService) GetCurrentUserPlaylists(ctx context.Context, userID, accountID string, page utils.PageRequest) (spotify.SpotifyPlaylistsResponse, error) {
+	args := m.Called(ctx, userID, accountID, page)
 	return args.Get(0).(spotify.SpotifyPlaylistsResponse), args.Error(1)
 }
 
-func (m *MockSpotifyService) GetPlaylistTracks(userID, playlistID string) (spotify.SpotifyPlaylistTracksResponse, error) {
-	args := m.Called(userID, playlistID)
+func (m *MockSpotifyService) GetPlaylistTracks(ctx context.Context, userID, accountID, playlistID string) (spotify.SpotifyPlaylistTracksResponse, error) {
+	args := m.Called(ctx, userID, accountID, playlistID)
 	return args.Get(0).(spotify.SpotifyPlaylistTracksResponse), args.Error(1)
 }
 
-func (m *MockSpotifyService) CreatePlaylist(userID, spotifyUserID string, payload spotify.CreatePlaylistPayload) (string, error) {
-	args := m.Called(userID, spotifyUserID, payload)
+func (m *MockSpotifyService) CreatePlaylist(ctx context.Context, userID, accountID, spotifyUserID string, payload spotify.CreatePlaylistPayload) (string, error) {
+	args := m.Called(ctx, userID, accountID, spotifyUserID, payload)
 	return args.String(0), args.Error(1)
 }
 
-func (m *MockSpotifyService) AddItemsToPlaylist(userID, playlistID string, payload spotify.AddItemsToPlaylistPayload) error {
-	args := m.Called(userID, playlistID, payload)
+func (m *MockSpotifyService) AddItemsToPlaylist(ctx context.Context, userID, accountID, playlistID string, payload spotify.AddItemsToPlaylistPayload) (spotify.AddedPlaylistItems, error) {
+	args := m.Called(ctx, userID, accountID, playlistID, payload)
+	return args.Get(0).(spotify.AddedPlaylistItems), args.Error(1)
+}
+
+func (m *MockSpotifyService) RemovePlaylistItems(ctx context.Context, userID, accountID, playlistID, snapshotID string, items []spotify.PlaylistItemURI) (string, error) {
+	args := m.Called(ctx, userID, accountID, playlistID, snapshotID, items)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockSpotifyService) UpdatePlaylist(ctx context.Context, userID, accountID, playlistID string, payload spotify.UpdatePlaylistPayload) error {
+	args := m.Called(ctx, userID, accountID, playlistID, payload)
 	return args.Error(0)
 }
 
-func (m *MockSpotifyService) SearchTracksUsingArtistAndTrack(userID, artistName, trackTitle string, limit, offset int) ([]utils.UnifiedTrackSearchResult, error) {
-	args := m.Called(userID, artistName, trackTitle, limit, offset)
+func (m *MockSpotifyService) ReorderPlaylistItems(ctx context.Context, userID, accountID, playlistID string, payload spotify.ReorderPlaylistItemsPayload) (string, error) {
+	args := m.Called(ctx, userID, accountID, playlistID, payload)
+	return args.String(0), args.Error(1)
+}
+
+func (m *MockSpotifyService) SearchTracksUsingArtistAndTrack(ctx context.Context, userID, accountID, artistName, trackTitle string, limit, offset int) ([]utils.UnifiedTrackSearchResult, error) {
+	args := m.Called(ctx, userID, accountID, artistName, trackTitle, limit, offset)
 	return args.Get(0).([]utils.UnifiedTrackSearchResult), args.Error(1)
 }
 
-func (m *MockSpotifyService) SearchTracksUsingVideoTitle(userID, videoTitle string) ([]utils.UnifiedTrackSearchResult, error) {
-	args := m.Called(userID, videoTitle)
+func (m *MockSpotifyService) SearchTracksUsingVideoTitle(ctx context.Context, userID, accountID, videoTitle string) ([]utils.UnifiedTrackSearchResult, error) {
+	args := m.Called(ctx, userID, accountID, videoTitle)
 	return args.Get(0).([]utils.UnifiedTrackSearchResult), args.Error(1)
 }
 
-func (m *MockSpotifyService) DeletePlaylist(userID, playlistID string) error {
-	args := m.Called(userID, playlistID)
+func (m *MockSpotifyService) DeletePlaylist(ctx context.Context, userID, accountID, playlistID string) error {
+	args := m.Called(ctx, userID, accountID, playlistID)
 	return args.Error(0)
 }
 
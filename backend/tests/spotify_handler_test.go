@@ -1,15 +1,18 @@
 package tests
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/roblieblang/luthien/backend/internal/auth/spotify"
 	"github.com/roblieblang/luthien/backend/internal/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 )
 
 func NewTestSpotifyHandler() *spotify.SpotifyHandler {
@@ -24,6 +27,11 @@ func setupRouter(handler *spotify.SpotifyHandler) *gin.Engine {
 	router.GET("/spotify/current-profile", handler.GetCurrentUserProfileHandler)
 	router.POST("/auth/spotify/logout", handler.LogoutHandler)
 	router.GET("/spotify/search-for-track", handler.SearchTracksUsingArtistAndTrackhandler)
+	router.GET("/auth/spotify/accounts", handler.ListLinkedAccountsHandler)
+	router.GET("/spotify/current-user-playlists", handler.GetCurrentUserPlaylistsHandler)
+	router.PUT("/spotify/update-playlist", handler.UpdatePlaylistHandler)
+	router.POST("/spotify/remove-items-from-playlist", handler.RemovePlaylistItemsHandler)
+	router.PUT("/spotify/reorder-playlist-items", handler.ReorderPlaylistItemsHandler)
 	return router
 }
 
@@ -34,7 +42,7 @@ func TestSpotifyLoginHandler(t *testing.T) {
 	router := setupRouter(handler)
 
 	mockSpotifyService := handler.SpotifyService.(*MockSpotifyService)
-	mockSpotifyService.On("StartLoginFlow").Return("https://example.com/auth", "mockSessionID", nil)
+	mockSpotifyService.On("StartLoginFlow", mock.Anything).Return("https://example.com/auth", "mockSessionID", nil)
 
 	req, _ := http.NewRequest("GET", "/auth/spotify/login", nil)
 	w := httptest.NewRecorder()
@@ -53,7 +61,7 @@ func TestSpotifyCallbackHandler(t *testing.T) {
 		router := setupRouter(handler)
 
 		mockSpotifyService := handler.SpotifyService.(*MockSpotifyService)
-		mockSpotifyService.On("HandleCallback", "authCode", "user123", "session123").Return(nil)
+		mockSpotifyService.On("HandleCallback", mock.Anything, "authCode", "user123", "session123").Return(nil)
 
 		validBody := `{"code": "authCode", "userID": "user123", "sessionID": "session123"}`
 		req, _ := http.NewRequest("POST", "/auth/spotify/callback", strings.NewReader(validBody))
@@ -102,7 +110,7 @@ func TestSpotifyGetCurrentUserProfileHandler(t *testing.T) {
 			Type:           "",
 			URI:            "",
 		}
-		mockSpotifyService.On("GetCurrentUserProfile", "user123").Return(expectedProfile, nil)
+		mockSpotifyService.On("GetCurrentUserProfile", mock.Anything, "user123", "").Return(expectedProfile, nil)
 
 		req, _ := http.NewRequest("GET", "/spotify/current-profile?userID=user123", nil)
 		w := httptest.NewRecorder()
@@ -158,7 +166,7 @@ func TestSpotifySearchTracksUsingArtistAndTrackHandler(t *testing.T) {
 		mockSpotifyService := handler.SpotifyService.(*MockSpotifyService)
 
 		expectedTracks := []utils.UnifiedTrackSearchResult{{ID: "track123", Title: "TrackName", Album: "", Artist: "", Thumbnail: ""}}
-		mockSpotifyService.On("SearchTracksUsingArtistAndTrack", "user123", "artist", "track", 20, 0).Return(expectedTracks, nil)
+		mockSpotifyService.On("SearchTracksUsingArtistAndTrack", mock.Anything, "user123", "", "artist", "track", 20, 0).Return(expectedTracks, nil)
 
 		req, _ := http.NewRequest("GET", "/spotify/search-for-track?userID=user123&artistName=artist&trackTitle=track", nil)
 		w := httptest.NewRecorder()
@@ -182,14 +190,222 @@ func TestSpotifySearchTracksUsingArtistAndTrackHandler(t *testing.T) {
 	})
 }
 
+func TestSpotifyListLinkedAccountsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewTestSpotifyHandler()
+
+	t.Run("valid request", func(t *testing.T) {
+		router := setupRouter(handler)
+		mockSpotifyService := handler.SpotifyService.(*MockSpotifyService)
+
+		linkedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+		accounts := []utils.LinkedAccount{
+			{ID: "personal", Label: "Me", LinkedAt: linkedAt, IsDefault: true},
+			{ID: "family", Label: "Family", LinkedAt: linkedAt.Add(time.Hour)},
+		}
+		mockSpotifyService.On("ListLinkedAccounts", mock.Anything, "user123").Return(accounts, nil)
+
+		req, _ := http.NewRequest("GET", "/auth/spotify/accounts?userID=user123", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		expectedResponse := `{"accounts": [
+			{"id": "personal", "label": "Me", "imageUrl": "", "linkedAt": "2024-03-01T12:00:00Z", "isDefault": true},
+			{"id": "family", "label": "Family", "imageUrl": "", "linkedAt": "2024-03-01T13:00:00Z", "isDefault": false}
+		]}`
+		assert.JSONEq(t, expectedResponse, w.Body.String())
+		mockSpotifyService.AssertExpectations(t)
+	})
+
+	t.Run("missing userID", func(t *testing.T) {
+		router := setupRouter(handler)
+
+		req, _ := http.NewRequest("GET", "/auth/spotify/accounts", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.JSONEq(t, `{"error": "userID query parameter is required"}`, w.Body.String())
+	})
+}
+
+func TestSpotifyGetCurrentUserPlaylistsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewTestSpotifyHandler()
+	mockSpotifyService := handler.SpotifyService.(*MockSpotifyService)
+
+	for _, tc := range []struct {
+		name  string
+		query string
+		page  utils.PageRequest
+	}{
+		{"first page", "", utils.PageRequest{Limit: 20}},
+		{"page after a cursor", "&cursor=40&limit=50", utils.PageRequest{Cursor: "40", Limit: 50}},
+		{"legacy offset", "&offset=20", utils.PageRequest{Cursor: "20", Limit: 20}},
+		{"every playlist", "&all=true", utils.PageRequest{Limit: 20, All: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setupRouter(handler)
+			next := "60"
+			mockSpotifyService.On("GetCurrentUserPlaylists", mock.Anything, "user123", "", tc.page).Return(spotify.SpotifyPlaylistsResponse{Total: 300, Next: &next}, nil).Once()
+
+			req, _ := http.NewRequest("GET", "/spotify/current-user-playlists?userID=user123"+tc.query, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Contains(t, w.Body.String(), `"next":"60"`)
+			var body map[string]any
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+			assert.ElementsMatch(t, []string{"items", "total", "next"}, keysOf(body))
+		})
+	}
+
+	t.Run("limit out of range", func(t *testing.T) {
+		router := setupRouter(handler)
+
+		req, _ := http.NewRequest("GET", "/spotify/current-user-playlists?userID=user123&limit=500", nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	mockSpotifyService.AssertExpectations(t)
+}
+
+func TestSpotifyUpdatePlaylistHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewTestSpotifyHandler()
+	mockSpotifyService := handler.SpotifyService.(*MockSpotifyService)
+
+	t.Run("clears the description and makes the playlist private", func(t *testing.T) {
+		router := setupRouter(handler)
+		public, description := false, ""
+		payload := spotify.UpdatePlaylistPayload{Name: "Renamed", Public: &public, Description: &description}
+		mockSpotifyService.On("UpdatePlaylist", mock.Anything, "user123", "", "playlist1", payload).Return(nil).Once()
+
+		body := `{"userId": "user123", "spotifyPlaylistId": "playlist1", "payload": {"name": "Renamed", "public": false, "description": ""}}`
+		req, _ := http.NewRequest("PUT", "/spotify/update-playlist", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		mockSpotifyService.AssertExpectations(t)
+	})
+
+	for _, tc := range []struct {
+		name string
+		body string
+	}{
+		{"nothing to change", `{"userId": "user123", "spotifyPlaylistId": "playlist1", "payload": {}}`},
+		{"collaborative and public", `{"userId": "user123", "spotifyPlaylistId": "playlist1", "payload": {"collaborative": true}}`},
+		{"missing playlist", `{"userId": "user123", "payload": {"name": "Renamed"}}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			router := setupRouter(handler)
+
+			req, _ := http.NewRequest("PUT", "/spotify/update-playlist", strings.NewReader(tc.body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestSpotifyRemovePlaylistItemsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewTestSpotifyHandler()
+	router := setupRouter(handler)
+	mockSpotifyService := handler.SpotifyService.(*MockSpotifyService)
+
+	t.Run("removes every occurrence of uris", func(t *testing.T) {
+		items := []spotify.PlaylistItemURI{{URI: "spotify:track:a"}, {URI: "spotify:track:b"}}
+		mockSpotifyService.On("RemovePlaylistItems", mock.Anything, "user123", "acct", "playlist1", "snap1", items).Return("snap2", nil).Once()
+
+		body := `{"userId": "user123", "accountId": "acct", "spotifyPlaylistId": "playlist1", "snapshotId": "snap1", "uris": ["spotify:track:a", "spotify:track:b"]}`
+		req, _ := http.NewRequest("POST", "/spotify/remove-items-from-playlist", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"message": "Successfully removed items from playlist", "snapshotId": "snap2"}`, w.Body.String())
+		mockSpotifyService.AssertExpectations(t)
+	})
+
+	t.Run("removes only the given positions", func(t *testing.T) {
+		items := []spotify.PlaylistItemURI{{URI: "spotify:track:a", Positions: []int{0, 7}}}
+		mockSpotifyService.On("RemovePlaylistItems", mock.Anything, "user123", "", "playlist1", "snap1", items).Return("snap3", nil).Once()
+
+		body := `{"userId": "user123", "spotifyPlaylistId": "playlist1", "snapshotId": "snap1", "items": [{"uri": "spotify:track:a", "positions": [0, 7]}]}`
+		req, _ := http.NewRequest("POST", "/spotify/remove-items-from-playlist", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"snapshotId":"snap3"`)
+		mockSpotifyService.AssertExpectations(t)
+	})
+
+	invalid := map[string]string{
+		"positions without snapshot": `{"userId": "user123", "spotifyPlaylistId": "playlist1", "items": [{"uri": "spotify:track:a", "positions": [0]}]}`,
+		"item without positions":     `{"userId": "user123", "spotifyPlaylistId": "playlist1", "snapshotId": "snap1", "items": [{"uri": "spotify:track:a"}]}`,
+		"negative position":          `{"userId": "user123", "spotifyPlaylistId": "playlist1", "snapshotId": "snap1", "items": [{"uri": "spotify:track:a", "positions": [-1]}]}`,
+		"uris and items":             `{"userId": "user123", "spotifyPlaylistId": "playlist1", "snapshotId": "snap1", "uris": ["spotify:track:b"], "items": [{"uri": "spotify:track:a", "positions": [0]}]}`,
+	}
+	for name, body := range invalid {
+		t.Run(name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/spotify/remove-items-from-playlist", strings.NewReader(body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestSpotifyReorderPlaylistItemsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewTestSpotifyHandler()
+	mockSpotifyService := handler.SpotifyService.(*MockSpotifyService)
+
+	t.Run("moves a single item by default", func(t *testing.T) {
+		router := setupRouter(handler)
+		payload := spotify.ReorderPlaylistItemsPayload{RangeStart: 5, RangeLength: 1, InsertBefore: 0}
+		mockSpotifyService.On("ReorderPlaylistItems", mock.Anything, "user123", "", "playlist1", payload).Return("snap2", nil).Once()
+
+		body := `{"userId": "user123", "spotifyPlaylistId": "playlist1", "payload": {"range_start": 5, "insert_before": 0}}`
+		req, _ := http.NewRequest("PUT", "/spotify/reorder-playlist-items", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"snapshotId":"snap2"`)
+		mockSpotifyService.AssertExpectations(t)
+	})
+
+	t.Run("negative position", func(t *testing.T) {
+		router := setupRouter(handler)
+
+		body := `{"userId": "user123", "spotifyPlaylistId": "playlist1", "payload": {"range_start": -1, "insert_before": 0}}`
+		req, _ := http.NewRequest("PUT", "/spotify/reorder-playlist-items", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+}
+
 func TestIntegrationSpotifyLoginFlow(t *testing.T) {
 	gin.SetMode(gin.TestMode)
 	handler := NewTestSpotifyHandler()
 	router := setupRouter(handler)
 
 	mockSpotifyService := handler.SpotifyService.(*MockSpotifyService)
-	mockSpotifyService.On("StartLoginFlow").Return("https://example.com/auth", "mockSessionID", nil)
-	mockSpotifyService.On("HandleCallback", "authCode", "user123", "session123").Return(nil)
+	mockSpotifyService.On("StartLoginFlow", mock.Anything).Return("https://example.com/auth", "mockSessionID", nil)
+	mockSpotifyService.On("HandleCallback", mock.Anything, "authCode", "user123", "session123").Return(nil)
 
 	// Simulate Spotify login flow
 	req, _ := http.NewRequest("GET", "/auth/spotify/login", nil)
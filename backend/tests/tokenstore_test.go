@@ -0,0 +1,255 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/roblieblang/luthien/backend/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+)
+
+func newSQLiteTokenStore(t *testing.T) *utils.SQLTokenStore {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "tokens.db"))
+	require.NoError(t, err)
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	store, err := utils.NewSQLTokenStore(db, utils.DialectSQLite)
+	require.NoError(t, err)
+	require.NoError(t, store.Migrate(context.Background()))
+	return store
+}
+
+// Runs the same checks against every backend that does not need a server
+func TestTokenStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) utils.TokenStore{
+		"memory": func(t *testing.T) utils.TokenStore { return utils.NewMemoryTokenStore() },
+		"sqlite": func(t *testing.T) utils.TokenStore { return newSQLiteTokenStore(t) },
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			t.Run("stores tokens with their expiry", func(t *testing.T) {
+				store := newStore(t)
+				expiresAt := time.Now().Add(time.Hour)
+				require.NoError(t, store.Set(ctx, "spotifyAccessToken:user1", utils.StoredToken{Value: "abc", ExpiresAt: expiresAt}))
+				require.NoError(t, store.Set(ctx, "auth0ManagementAPIAccessToken", utils.StoredToken{Value: "def"}))
+
+				token, err := store.Get(ctx, "spotifyAccessToken:user1")
+				require.NoError(t, err)
+				assert.Equal(t, "abc", token.Value)
+				assert.WithinDuration(t, expiresAt, token.ExpiresAt, time.Millisecond)
+
+				token, err = store.Get(ctx, "auth0ManagementAPIAccessToken")
+				require.NoError(t, err)
+				assert.True(t, token.ExpiresAt.IsZero())
+			})
+
+			t.Run("treats expired and deleted tokens as missing", func(t *testing.T) {
+				store := newStore(t)
+				store.Set(ctx, "expired", utils.StoredToken{Value: "a", ExpiresAt: time.Now().Add(-time.Second)})
+				store.Set(ctx, "deleted", utils.StoredToken{Value: "b"})
+				require.NoError(t, store.Delete(ctx, "deleted", "neverStored"))
+
+				_, err := store.Get(ctx, "expired")
+				assert.Equal(t, utils.ErrTokenNotFound, err)
+				_, err = store.Get(ctx, "deleted")
+				assert.Equal(t, utils.ErrTokenNotFound, err)
+			})
+
+			t.Run("fences refresh locks", func(t *testing.T) {
+				store := newStore(t)
+				first, acquired, err := store.AcquireLock(ctx, "spotifyRefreshLock:user1", 20*time.Millisecond)
+				require.NoError(t, err)
+				require.True(t, acquired)
+
+				_, acquired, err = store.AcquireLock(ctx, "spotifyRefreshLock:user1", time.Minute)
+				require.NoError(t, err)
+				assert.False(t, acquired)
+				held, err := store.LockHeld(ctx, "spotifyRefreshLock:user1")
+				require.NoError(t, err)
+				assert.True(t, held)
+
+				// The first holder's lock expires and is taken over
+				time.Sleep(30 * time.Millisecond)
+				second, acquired, err := store.AcquireLock(ctx, "spotifyRefreshLock:user1", time.Minute)
+				require.NoError(t, err)
+				require.True(t, acquired)
+				assert.Greater(t, second, first)
+
+				tokens := map[string]utils.StoredToken{"spotifyAccessToken:user1": {Value: "stale"}}
+				assert.Error(t, store.SetLocked(ctx, "spotifyRefreshLock:user1", first, tokens))
+				_, err = store.Get(ctx, "spotifyAccessToken:user1")
+				assert.Equal(t, utils.ErrTokenNotFound, err)
+
+				tokens = map[string]utils.StoredToken{"spotifyAccessToken:user1": {Value: "fresh"}}
+				require.NoError(t, store.SetLocked(ctx, "spotifyRefreshLock:user1", second, tokens))
+				token, err := store.Get(ctx, "spotifyAccessToken:user1")
+				require.NoError(t, err)
+				assert.Equal(t, "fresh", token.Value)
+
+				// Releasing with a stale fence leaves the current holder's lock alone
+				require.NoError(t, store.ReleaseLock(ctx, "spotifyRefreshLock:user1", first))
+				held, _ = store.LockHeld(ctx, "spotifyRefreshLock:user1")
+				assert.True(t, held)
+				require.NoError(t, store.ReleaseLock(ctx, "spotifyRefreshLock:user1", second))
+				held, _ = store.LockHeld(ctx, "spotifyRefreshLock:user1")
+				assert.False(t, held)
+			})
+
+			t.Run("keeps linked accounts and the default", func(t *testing.T) {
+				store := newStore(t)
+				require.NoError(t, store.SaveLinkedAccount(ctx, "spotify", "user1", utils.LinkedAccount{ID: "first", Label: "First"}))
+				require.NoError(t, store.SaveLinkedAccount(ctx, "spotify", "user1", utils.LinkedAccount{ID: "second"}))
+				require.NoError(t, store.SaveLinkedAccount(ctx, "spotify", "user1", utils.LinkedAccount{ID: "first", Label: "Renamed"}))
+
+				accounts, defaultID, err := store.LinkedAccounts(ctx, "spotify", "user1")
+				require.NoError(t, err)
+				assert.ElementsMatch(t, []utils.LinkedAccount{{ID: "first", Label: "Renamed"}, {ID: "second"}}, accounts)
+				assert.Equal(t, "first", defaultID)
+
+				require.NoError(t, store.SetDefaultAccount(ctx, "spotify", "user1", "second"))
+				require.NoError(t, store.DeleteLinkedAccount(ctx, "spotify", "user1", "first"))
+				accounts, defaultID, err = store.LinkedAccounts(ctx, "spotify", "user1")
+				require.NoError(t, err)
+				assert.Len(t, accounts, 1)
+				assert.Equal(t, "second", defaultID)
+
+				require.NoError(t, store.SetDefaultAccount(ctx, "spotify", "user1", ""))
+				_, defaultID, err = store.LinkedAccounts(ctx, "spotify", "user1")
+				require.NoError(t, err)
+				assert.Empty(t, defaultID)
+
+				accounts, _, err = store.LinkedAccounts(ctx, "google", "user1")
+				require.NoError(t, err)
+				assert.Empty(t, accounts)
+			})
+
+			t.Run("keeps the most recent unlink events", func(t *testing.T) {
+				store := newStore(t)
+				start := time.Now().UTC()
+				for i, party := range []string{"spotify", "google", "spotify"} {
+					event := utils.UnlinkEvent{Party: party, Revoked: party == "google", UnlinkedAt: start.Add(time.Duration(i) * time.Second)}
+					require.NoError(t, store.RecordUnlink(ctx, "user1", event, 2))
+				}
+
+				history, err := store.UnlinkHistory(ctx, "user1")
+				require.NoError(t, err)
+				require.Len(t, history, 2)
+				assert.Equal(t, "spotify", history[0].Party)
+				assert.Equal(t, "google", history[1].Party)
+				assert.True(t, history[1].Revoked)
+
+				history, err = store.UnlinkHistory(ctx, "user2")
+				require.NoError(t, err)
+				assert.Empty(t, history)
+			})
+
+			t.Run("purges only the user's tokens", func(t *testing.T) {
+				store := newStore(t)
+				store.Set(ctx, "spotifyAccessToken:user1", utils.StoredToken{Value: "a"})
+				store.Set(ctx, "googleRefreshToken:user1:channel", utils.StoredToken{Value: "b"})
+				store.Set(ctx, "spotifyAccessToken:user10", utils.StoredToken{Value: "c"})
+				store.SaveLinkedAccount(ctx, "google", "user1", utils.LinkedAccount{ID: "channel"})
+				store.SaveLinkedAccount(ctx, "google", "user10", utils.LinkedAccount{ID: "channel"})
+				store.RecordUnlink(ctx, "user1", utils.UnlinkEvent{Party: "spotify", UnlinkedAt: time.Now()}, 10)
+
+				purger, ok := store.(interface {
+					PurgeUserData(ctx context.Context, userID string) error
+				})
+				require.True(t, ok)
+				require.NoError(t, purger.PurgeUserData(ctx, "user1"))
+
+				_, err := store.Get(ctx, "spotifyAccessToken:user1")
+				assert.Equal(t, utils.ErrTokenNotFound, err)
+				_, err = store.Get(ctx, "googleRefreshToken:user1:channel")
+				assert.Equal(t, utils.ErrTokenNotFound, err)
+				_, err = store.Get(ctx, "spotifyAccessToken:user10")
+				assert.NoError(t, err)
+
+				accounts, defaultID, err := store.LinkedAccounts(ctx, "google", "user1")
+				require.NoError(t, err)
+				assert.Empty(t, accounts)
+				assert.Empty(t, defaultID)
+				accounts, _, err = store.LinkedAccounts(ctx, "google", "user10")
+				require.NoError(t, err)
+				assert.Len(t, accounts, 1)
+				history, err := store.UnlinkHistory(ctx, "user1")
+				require.NoError(t, err)
+				assert.Empty(t, history)
+			})
+		})
+	}
+}
+
+func TestTokenHelpersWithoutRedis(t *testing.T) {
+	ctx := context.Background()
+	appCtx := utils.AppContext{Tokens: utils.NewMemoryTokenStore()}
+
+	require.NoError(t, utils.SetToken(ctx, utils.SetTokenParams{
+		TokenKind: "access", Party: "Spotify", UserID: "user1", AccountID: "acct", Token: "abc", ExpiresIn: 3600, AppCtx: appCtx,
+	}))
+	require.NoError(t, utils.SetToken(ctx, utils.SetTokenParams{
+		TokenKind: "access", Party: "Google", UserID: "user1", Token: "soon", ExpiresIn: 60, AppCtx: appCtx,
+	}))
+
+	token, err := utils.RetrieveToken(ctx, utils.RetrieveTokenParams{
+		Party: "spotify", TokenKind: "access", UserID: "user1", AccountID: "acct", AppCtx: appCtx,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "abc", token)
+
+	expired, err := utils.IsAccessTokenExpired(ctx, appCtx, "spotifyAccessToken:user1:acct", token)
+	require.NoError(t, err)
+	assert.False(t, expired)
+	expired, err = utils.IsAccessTokenExpired(ctx, appCtx, "googleAccessToken:user1", "soon")
+	require.NoError(t, err)
+	assert.True(t, expired, "tokens expiring within five minutes count as expired")
+
+	require.NoError(t, utils.ClearTokens(ctx, utils.ClearTokensParams{Party: "Spotify", UserID: "user1", AccountID: "acct", AppCtx: appCtx}))
+	_, err = utils.RetrieveToken(ctx, utils.RetrieveTokenParams{
+		Party: "spotify", TokenKind: "access", UserID: "user1", AccountID: "acct", AppCtx: appCtx,
+	})
+	assert.Equal(t, utils.ErrTokenNotFound, err)
+}
+
+func TestLinkedAccountsWithoutRedis(t *testing.T) {
+	ctx := context.Background()
+	appCtx := utils.AppContext{Tokens: utils.NewMemoryTokenStore()}
+
+	linkedAt := time.Now().UTC()
+	require.NoError(t, utils.SaveLinkedAccount(ctx, appCtx, "user1", "google", utils.LinkedAccount{ID: "older", LinkedAt: linkedAt}))
+	require.NoError(t, utils.SaveLinkedAccount(ctx, appCtx, "user1", "google", utils.LinkedAccount{ID: "newer", LinkedAt: linkedAt.Add(time.Minute)}))
+
+	accounts, err := utils.ListLinkedAccounts(ctx, appCtx, "user1", "google")
+	require.NoError(t, err)
+	require.Len(t, accounts, 2)
+	assert.Equal(t, "older", accounts[0].ID)
+	assert.True(t, accounts[0].IsDefault, "the first account linked becomes the default")
+
+	accountID, err := utils.ResolveAccountID(ctx, appCtx, "user1", "google", "")
+	require.NoError(t, err)
+	assert.Equal(t, "older", accountID)
+	_, err = utils.ResolveAccountID(ctx, appCtx, "user1", "google", "unknown")
+	assert.ErrorIs(t, err, utils.ErrLinkedAccountNotFound)
+	assert.ErrorIs(t, utils.SetDefaultAccount(ctx, appCtx, "user1", "google", "unknown"), utils.ErrLinkedAccountNotFound)
+
+	// Removing the default promotes the oldest remaining account
+	require.NoError(t, utils.RemoveLinkedAccount(ctx, appCtx, "user1", "google", "older"))
+	accountID, err = utils.ResolveAccountID(ctx, appCtx, "user1", "google", "")
+	require.NoError(t, err)
+	assert.Equal(t, "newer", accountID)
+
+	// Without linked accounts the legacy single-account tokens are used
+	require.NoError(t, utils.RemoveLinkedAccount(ctx, appCtx, "user1", "google", "newer"))
+	accountID, err = utils.ResolveAccountID(ctx, appCtx, "user1", "google", "")
+	require.NoError(t, err)
+	assert.Empty(t, accountID)
+}
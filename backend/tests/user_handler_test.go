@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/roblieblang/luthien/backend/internal/user"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockUserService struct {
+	mock.Mock
+}
+
+func (m *MockUserService) GetUser(ctx context.Context, userID string) (*user.User, error) {
+	args := m.Called(ctx, userID)
+	u, _ := args.Get(0).(*user.User)
+	return u, args.Error(1)
+}
+
+func (m *MockUserService) SyncUser(ctx context.Context, userID string) (*user.User, error) {
+	args := m.Called(ctx, userID)
+	u, _ := args.Get(0).(*user.User)
+	return u, args.Error(1)
+}
+
+func (m *MockUserService) UpdatePreferences(ctx context.Context, userID string, preferences user.Preferences) (*user.User, error) {
+	args := m.Called(ctx, userID, preferences)
+	u, _ := args.Get(0).(*user.User)
+	return u, args.Error(1)
+}
+
+func setupUserRouter(service *MockUserService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	handler := user.NewUserHandler(service)
+	router := gin.New()
+	router.GET("/users/me", handler.GetCurrentUserHandler)
+	router.PUT("/users/me/preferences", handler.UpdatePreferencesHandler)
+	return router
+}
+
+func TestGetCurrentUserHandler(t *testing.T) {
+	t.Run("returns the stored user", func(t *testing.T) {
+		service := new(MockUserService)
+		service.On("GetUser", mock.Anything, "auth0|1").Return(&user.User{ID: "auth0|1", Name: "Luthien"}, nil)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/users/me?userID=auth0|1", nil)
+		setupUserRouter(service).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"name":"Luthien"`)
+	})
+
+	t.Run("answers 404 for an unknown user", func(t *testing.T) {
+		service := new(MockUserService)
+		service.On("GetUser", mock.Anything, "auth0|2").Return(nil, user.ErrUserNotFound)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/users/me?userID=auth0|2", nil)
+		setupUserRouter(service).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+		assert.Contains(t, w.Body.String(), "user_not_found")
+	})
+}
+
+func TestUpdatePreferencesHandler(t *testing.T) {
+	t.Run("rejects invalid preferences", func(t *testing.T) {
+		service := new(MockUserService)
+		preferences := user.Preferences{DefaultSource: "tidal"}
+		service.On("UpdatePreferences", mock.Anything, "auth0|1", preferences).
+			Return(nil, fmt.Errorf("%w: defaultSource must be spotify or youtube", user.ErrInvalidPreferences))
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/users/me/preferences?userID=auth0|1", strings.NewReader(`{"defaultSource":"tidal"}`))
+		setupUserRouter(service).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("answers 404 for an unknown user", func(t *testing.T) {
+		service := new(MockUserService)
+		service.On("UpdatePreferences", mock.Anything, "auth0|2", mock.Anything).Return(nil, user.ErrUserNotFound)
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("PUT", "/users/me/preferences?userID=auth0|2", strings.NewReader(`{"playlistVisibility":"private"}`))
+		setupUserRouter(service).ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
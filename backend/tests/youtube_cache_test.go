@@ -0,0 +1,27 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/roblieblang/luthien/backend/internal/auth/youtube"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeSearchQuery(t *testing.T) {
+	tests := []struct {
+		artistName string
+		songTitle  string
+		expected   string
+	}{
+		{"AC/DC", "Back In Black", "ac dc back in black"},
+		{"  ac dc ", "back  in\tblack", "ac dc back in black"},
+		{"The Beatles", "Don't Let Me Down", "the beatles dont let me down"},
+		{"Sigur Rós", "Hoppípolla", "sigur rós hoppípolla"},
+		{"", "Song 2", "song 2"},
+		{"!!!", "", "!!!"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, youtube.NormalizeSearchQuery(tt.artistName, tt.songTitle))
+	}
+}
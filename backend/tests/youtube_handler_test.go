@@ -0,0 +1,261 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+	"github.com/roblieblang/luthien/backend/internal/auth/youtube"
+	"github.com/roblieblang/luthien/backend/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Creates a service for "user1", linked to the channel "channel1", whose requests to Google reach the handler.
+// Quota is kept in memory and the caches fall back to memory, since nothing listens on port 1.
+func newFakeYouTubeService(t *testing.T, handler http.HandlerFunc) *youtube.YouTubeService {
+	redisClient := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	t.Cleanup(func() { redisClient.Close() })
+	storage := utils.NewStorageMonitor(redisClient)
+	appCtx := &utils.AppContext{RedisClient: redisClient, Storage: storage, Cache: utils.NewCache(redisClient, storage), Tokens: utils.NewMemoryTokenStore()}
+	linkChannel(t, appCtx, "user1", "channel1")
+	client := &youtube.YouTubeClient{AppContext: appCtx, HTTPClient: newFakeUpstream(t, handler), Quota: newMemoryQuotaLedger(), Cache: youtube.NewYouTubeCache(appCtx)}
+	return youtube.NewYouTubeService(client, nil)
+}
+
+func TestYouTubeGetCurrentUserPlaylistsHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var pageSizes []string
+	service := newFakeYouTubeService(t, func(w http.ResponseWriter, r *http.Request) {
+		pageSizes = append(pageSizes, r.URL.Query().Get("maxResults"))
+		playlist := map[string]any{"id": "playlist-" + r.URL.Query().Get("pageToken"), "snippet": map[string]any{"title": "Mix"}, "contentDetails": map[string]any{"itemCount": 3}}
+		response := map[string]any{"items": []any{playlist}, "pageInfo": map[string]any{"totalResults": 45}}
+		if r.URL.Query().Get("pageToken") == "" {
+			response["nextPageToken"] = "token2"
+		}
+		writeJSON(w, response)
+	})
+	router := gin.New()
+	router.GET("/youtube/current-user-playlists", youtube.NewYouTubeHandler(service).GetCurrentUserPlaylistsHandler)
+	get := func(query string) map[string]any {
+		req, _ := http.NewRequest("GET", "/youtube/current-user-playlists?userID=user1"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+		var body map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+		return body
+	}
+
+	t.Run("returns the first page of 20 by default, shaped like Spotify's", func(t *testing.T) {
+		pageSizes = nil
+		body := get("")
+		assert.Equal(t, []string{"20"}, pageSizes)
+		assert.ElementsMatch(t, []string{"items", "total", "next"}, keysOf(body))
+		assert.Equal(t, float64(45), body["total"])
+		assert.Equal(t, "token2", body["next"])
+		assert.Len(t, body["items"], 1)
+	})
+
+	t.Run("returns every playlist with all", func(t *testing.T) {
+		pageSizes = nil
+		body := get("&all=true")
+		assert.Equal(t, []string{"50", "50"}, pageSizes)
+		assert.Len(t, body["items"], 2)
+		assert.Nil(t, body["next"])
+	})
+}
+
+func keysOf(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Writes an error the way the YouTube API does, with the reason naming what went wrong
+func writeGoogleError(w http.ResponseWriter, code int, reason string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]any{"error": map[string]any{"code": code, "message": reason, "errors": []any{map[string]any{"reason": reason}}}})
+}
+
+func setupYouTubeWriteRouter(service *youtube.YouTubeService) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	handler := youtube.NewYouTubeHandler(service)
+	router := gin.New()
+	router.PUT("/youtube/update-playlist", handler.UpdatePlaylistHandler)
+	router.POST("/youtube/remove-items-from-playlist", handler.RemovePlaylistItemsHandler)
+	router.PUT("/youtube/move-playlist-item", handler.MovePlaylistItemHandler)
+	return router
+}
+
+func sendJSON(router *gin.Engine, method, path, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestYouTubeUpdatePlaylistHandler(t *testing.T) {
+	t.Run("keeps the fields left out of the payload", func(t *testing.T) {
+		var sent map[string]any
+		router := setupYouTubeWriteRouter(newFakeYouTubeService(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPut {
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&sent))
+				writeJSON(w, sent)
+				return
+			}
+			playlist := map[string]any{"id": "playlist1", "snippet": map[string]any{"title": "Old", "description": "Mine"}, "status": map[string]any{"privacyStatus": "private"}}
+			writeJSON(w, map[string]any{"items": []any{playlist}})
+		}))
+
+		w := sendJSON(router, "PUT", "/youtube/update-playlist", `{"userId": "user1", "payload": {"playlistId": "playlist1", "title": "New"}}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "New", sent["snippet"].(map[string]any)["title"])
+		assert.Equal(t, "Mine", sent["snippet"].(map[string]any)["description"])
+		assert.Equal(t, "private", sent["status"].(map[string]any)["privacyStatus"])
+	})
+
+	t.Run("rejects an unknown privacy status", func(t *testing.T) {
+		router := setupYouTubeWriteRouter(newFakeYouTubeService(t, func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}))
+
+		w := sendJSON(router, "PUT", "/youtube/update-playlist", `{"userId": "user1", "payload": {"playlistId": "playlist1", "privacyStatus": "friends"}}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("tells a permission error from exhausted quota", func(t *testing.T) {
+		reason := "forbidden"
+		router := setupYouTubeWriteRouter(newFakeYouTubeService(t, func(w http.ResponseWriter, r *http.Request) {
+			writeGoogleError(w, http.StatusForbidden, reason)
+		}))
+
+		w := sendJSON(router, "PUT", "/youtube/update-playlist", `{"userId": "user1", "payload": {"playlistId": "playlist1", "title": "New"}}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), `"error":"forbidden"`)
+
+		reason = "quotaExceeded"
+		w = sendJSON(router, "PUT", "/youtube/update-playlist", `{"userId": "user1", "payload": {"playlistId": "playlist1", "title": "New"}}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "quota_exceeded")
+	})
+}
+
+func TestYouTubeRemovePlaylistItemsHandler(t *testing.T) {
+	t.Run("skips items that no longer exist", func(t *testing.T) {
+		var deleted []string
+		router := setupYouTubeWriteRouter(newFakeYouTubeService(t, func(w http.ResponseWriter, r *http.Request) {
+			require.Equal(t, http.MethodDelete, r.Method)
+			deleted = append(deleted, r.URL.Query().Get("id"))
+			if r.URL.Query().Get("id") == "gone" {
+				writeGoogleError(w, http.StatusNotFound, "playlistItemNotFound")
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		w := sendJSON(router, "POST", "/youtube/remove-items-from-playlist", `{"userId": "user1", "itemIds": ["item1", "gone", "item2"]}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, []string{"item1", "gone", "item2"}, deleted)
+	})
+
+	t.Run("requires item IDs", func(t *testing.T) {
+		router := setupYouTubeWriteRouter(newFakeYouTubeService(t, func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}))
+
+		w := sendJSON(router, "POST", "/youtube/remove-items-from-playlist", `{"userId": "user1", "itemIds": []}`)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("does not report a permission error as exhausted quota", func(t *testing.T) {
+		router := setupYouTubeWriteRouter(newFakeYouTubeService(t, func(w http.ResponseWriter, r *http.Request) {
+			writeGoogleError(w, http.StatusForbidden, "playlistItemsNotAccessible")
+		}))
+
+		w := sendJSON(router, "POST", "/youtube/remove-items-from-playlist", `{"userId": "user1", "itemIds": ["item1"]}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), `"error":"forbidden"`)
+	})
+}
+
+func TestYouTubeMovePlaylistItemHandler(t *testing.T) {
+	t.Run("sends position 0 with the item's playlist and video", func(t *testing.T) {
+		var sent map[string]any
+		router := setupYouTubeWriteRouter(newFakeYouTubeService(t, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPut {
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&sent))
+				writeJSON(w, sent)
+				return
+			}
+			item := map[string]any{"id": "item1", "snippet": map[string]any{"playlistId": "playlist1", "position": 4, "resourceId": map[string]any{"kind": "youtube#video", "videoId": "video1"}}}
+			writeJSON(w, map[string]any{"items": []any{item}})
+		}))
+
+		w := sendJSON(router, "PUT", "/youtube/move-playlist-item", `{"userId": "user1", "itemId": "item1", "position": 0}`)
+		require.Equal(t, http.StatusOK, w.Code)
+		snippet := sent["snippet"].(map[string]any)
+		assert.Equal(t, float64(0), snippet["position"])
+		assert.Equal(t, "playlist1", snippet["playlistId"])
+		assert.Equal(t, "video1", snippet["resourceId"].(map[string]any)["videoId"])
+	})
+
+	t.Run("requires a position", func(t *testing.T) {
+		router := setupYouTubeWriteRouter(newFakeYouTubeService(t, func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}))
+
+		assert.Equal(t, http.StatusBadRequest, sendJSON(router, "PUT", "/youtube/move-playlist-item", `{"userId": "user1", "itemId": "item1"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, sendJSON(router, "PUT", "/youtube/move-playlist-item", `{"userId": "user1", "itemId": "item1", "position": -1}`).Code)
+	})
+
+	t.Run("reports an item that does not exist", func(t *testing.T) {
+		router := setupYouTubeWriteRouter(newFakeYouTubeService(t, func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, map[string]any{"items": []any{}})
+		}))
+
+		w := sendJSON(router, "PUT", "/youtube/move-playlist-item", `{"userId": "user1", "itemId": "gone", "position": 1}`)
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+
+	t.Run("does not report a permission error as exhausted quota", func(t *testing.T) {
+		router := setupYouTubeWriteRouter(newFakeYouTubeService(t, func(w http.ResponseWriter, r *http.Request) {
+			writeGoogleError(w, http.StatusForbidden, "forbidden")
+		}))
+
+		w := sendJSON(router, "PUT", "/youtube/move-playlist-item", `{"userId": "user1", "itemId": "item1", "position": 1}`)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), `"error":"forbidden"`)
+	})
+}
+
+func TestYouTubeAddItemsToPlaylistHandlerErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	add := func(reason string) *httptest.ResponseRecorder {
+		service := newFakeYouTubeService(t, func(w http.ResponseWriter, r *http.Request) {
+			writeGoogleError(w, http.StatusForbidden, reason)
+		})
+		router := gin.New()
+		router.POST("/youtube/add-items-to-playlist", youtube.NewYouTubeHandler(service).AddItemsToPlaylistHandler)
+		return sendJSON(router, "POST", "/youtube/add-items-to-playlist", `{"userId": "user1", "payload": {"playlistId": "someone-elses", "videoIds": ["video1"]}}`)
+	}
+
+	t.Run("a playlist the user does not own is forbidden", func(t *testing.T) {
+		w := add("playlistItemsNotAccessible")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), `"error":"forbidden"`)
+	})
+
+	t.Run("exhausted quota is reported as such", func(t *testing.T) {
+		w := add("quotaExceeded")
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), `"error":"quota_exceeded"`)
+	})
+}
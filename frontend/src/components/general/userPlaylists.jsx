@@ -14,7 +14,7 @@ const services = {
   },
   youtube: {
     api: ({ userID }) =>
-      `${config.backendUrl}/youtube/current-user-playlists?userID=${userID}`,
+      `${config.backendUrl}/youtube/current-user-playlists?userID=${userID}&all=true`,
     component: YouTubePlaylist,
   },
 };
@@ -67,13 +67,11 @@ export default function UserPlaylists({ serviceType }) {
           if (serviceType === "spotify") {
             setSpotifyPlaylistCount(data.total);
           } else {
-            setYouTubePlaylistCount(data.totalCount);
+            setYouTubePlaylistCount(data.total);
             // setNextPageToken(data.nextPageToken || "");
             // setPrevPageToken(data.prevPageToken || "");
           }
-          const playlistsData =
-            serviceType === "spotify" ? data.items : data.playlists;
-          setPlaylists(playlistsData);
+          setPlaylists(data.items);
         })
         .catch((error) => {
           console.error(`Error fetching ${serviceType} user playlists:`, error);